@@ -1,10 +1,31 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+
 	"cartapi/internal/app"
+	"cartapi/internal/buildinfo"
 )
 
 func main() {
+	migrate := flag.String("migrate", "", "run migrations (up, down or status) and exit without starting the server")
+	steps := flag.Int("steps", 1, "number of migrations to roll back with -migrate=down")
+	version := flag.Bool("version", false, "print the build version and exit")
+	flag.Parse()
+
+	if *version {
+		fmt.Println(buildinfo.Get())
+		return
+	}
+
+	if *migrate != "" {
+		if err := app.Migrate(*migrate, *steps); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	if err := app.Run(); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,70 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"cartapi/internal/requestid"
+)
+
+// Code is the machine-readable error code sent to clients.
+type Code string
+
+const (
+	CartNotFound     Code = "CART_NOT_FOUND"
+	ItemNotFound     Code = "ITEM_NOT_FOUND"
+	InvalidCartID    Code = "INVALID_CART_ID"
+	InvalidItemID    Code = "INVALID_ITEM_ID"
+	ValidationFailed Code = "VALIDATION_FAILED"
+	Conflict         Code = "CONFLICT"
+	Timeout          Code = "TIMEOUT"
+	Canceled         Code = "CANCELED"
+	Internal         Code = "INTERNAL"
+	Unavailable      Code = "UNAVAILABLE"
+	Forbidden        Code = "FORBIDDEN"
+	CartFull         Code = "CART_FULL"
+	UnknownProduct   Code = "UNKNOWN_PRODUCT"
+	CartNotEmpty     Code = "CART_NOT_EMPTY"
+	// PreconditionFailed is sent when If-Match no longer matches.
+	PreconditionFailed Code = "PRECONDITION_FAILED"
+	// RouteNotFound is sent for paths and methods that no route serves.
+	RouteNotFound Code = "ROUTE_NOT_FOUND"
+	ReadOnly      Code = "READ_ONLY"
+	Unauthorized  Code = "UNAUTHORIZED"
+	URITooLong    Code = "URI_TOO_LONG"
+	BodyTooLarge  Code = "BODY_TOO_LARGE"
+	// MethodNotAllowed and NotImplemented are only used by the admin API.
+	MethodNotAllowed Code = "METHOD_NOT_ALLOWED"
+	NotImplemented   Code = "NOT_IMPLEMENTED"
+)
+
+type Body struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	// Fields maps each invalid request field to what is wrong with it.
+	Fields map[string]string `json:"fields,omitempty"`
+	// RequestID is the id the request was given by middleware.RequestID,
+	// for clients to quote when reporting the error.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Response is the envelope every error response is sent in:
+// {"error":{"code":...,"message":...}}.
+type Response struct {
+	Error Body `json:"error"`
+}
+
+// Write sends an error response with the given status.
+func Write(w http.ResponseWriter, status int, code Code, message string) {
+	WriteFields(w, status, code, message, nil)
+}
+
+// WriteFields sends an error response listing the invalid request fields.
+// The request id is taken from the X-Request-Id response header.
+func WriteFields(w http.ResponseWriter, status int, code Code, message string, fields map[string]string) {
+	body := Body{Code: code, Message: message, Fields: fields, RequestID: w.Header().Get(requestid.Header)}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(Response{Error: body})
+}
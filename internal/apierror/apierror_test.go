@@ -0,0 +1,47 @@
+package apierror_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/apierror"
+	"cartapi/internal/requestid"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	ww := httptest.NewRecorder()
+	apierror.Write(ww, http.StatusNotFound, apierror.CartNotFound, "Cart not found")
+
+	assert.Equal(t, http.StatusNotFound, ww.Code)
+	assert.Equal(t, "application/json", ww.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":{"code":"CART_NOT_FOUND","message":"Cart not found"}}`, ww.Body.String())
+
+	var resp apierror.Response
+	require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+	assert.Equal(t, apierror.CartNotFound, resp.Error.Code)
+}
+
+func TestWriteFields(t *testing.T) {
+	ww := httptest.NewRecorder()
+	apierror.WriteFields(ww, http.StatusBadRequest, apierror.ValidationFailed, "Product field is required", map[string]string{
+		"product":  "Product field is required",
+		"quantity": "Quantity must be greater than zero",
+	})
+
+	assert.Equal(t, http.StatusBadRequest, ww.Code)
+	assert.JSONEq(t, `{"error":{"code":"VALIDATION_FAILED","message":"Product field is required",`+
+		`"fields":{"product":"Product field is required","quantity":"Quantity must be greater than zero"}}}`, ww.Body.String())
+}
+
+func TestWrite_RequestID(t *testing.T) {
+	ww := httptest.NewRecorder()
+	ww.Header().Set(requestid.Header, "req-1")
+	apierror.Write(ww, http.StatusNotFound, apierror.CartNotFound, "Cart not found")
+
+	assert.JSONEq(t, `{"error":{"code":"CART_NOT_FOUND","message":"Cart not found","request_id":"req-1"}}`, ww.Body.String())
+}
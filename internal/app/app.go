@@ -1,15 +1,29 @@
 package app
 
 import (
+	"cartapi/internal/buildinfo"
+	"cartapi/internal/cleanup"
+	"cartapi/internal/clientip"
+	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/database/memory"
 	"cartapi/internal/database/psql"
+	"cartapi/internal/database/sqlite"
+	"cartapi/internal/events"
+	adminhandler "cartapi/internal/handlers/admin"
 	carthandler "cartapi/internal/handlers/cart"
+	readyhandler "cartapi/internal/handlers/ready"
+	versionhandler "cartapi/internal/handlers/version"
+	"cartapi/internal/middleware"
+	"cartapi/internal/models"
 	"cartapi/internal/routes"
 	cartservice "cartapi/internal/service/cart"
+	"cartapi/internal/tracing"
 	"cartapi/pkg/config"
 	"cartapi/pkg/lib/logger"
 	"cartapi/pkg/lib/logger/sl"
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -25,40 +39,176 @@ func Run() error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	log, err := logger.SetupLogger(cfg.HTTP.Env)
+	log, err := logger.SetupLogger(cfg.HTTP)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	storage, err := psql.New(log, cfg.ConnectionString())
+	if cfg.JSONNaming == config.JSONNamingCamel {
+		models.SetJSONNaming(models.CamelCase)
+	}
+
+	tracerProvider, shutdownTracing, err := tracing.NewProvider(cfg.Tracing.Exporter, cfg.Tracing.ServiceName)
 	if err != nil {
+		log.Error("Invalid tracing configuration", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	defer func() {
+		// Flush the spans of the last requests before exiting.
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error("Failed to flush traces", sl.Err(err))
+		}
+	}()
+	tracer := tracerProvider.Tracer(tracing.Name)
 
-	cartItemService := cartservice.New(log, storage)
+	storage, err := newStorage(log, cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// Every return before the graceful shutdown at the end closes the
+	// storage here.
+	closeStorage := true
+	defer func() {
+		if !closeStorage {
+			return
+		}
+		if err := storage.Close(); err != nil {
+			log.Error("Failed to close database connection", sl.Err(err))
+		}
+	}()
+	storage.SetSoftDelete(cfg.SoftDelete)
+	storage.SetQueryTimeout(cfg.QueryTimeout)
+	storage.SetSlowQueryThreshold(cfg.SlowQueryThreshold)
+	storage.SetSQLLog(cfg.SQLLog.Enabled, cfg.SQLLog.RedactProducts)
+	storage.SetMaxItems(cfg.MaxItemsPerCart)
+	storage.SetUniqueItems(cfg.UniqueItems)
+	storage.SetTracer(tracer)
+
+	serviceOpts := []cartservice.Option{
+		cartservice.WithTracer(tracer),
+		cartservice.WithProductNormalization(cartservice.ProductNormalization(cfg.ProductNormalization)),
+	}
+	var webhooks *events.WebhookPublisher
+	if cfg.Webhook.URL != "" {
+		webhooks, err = newWebhookPublisher(log, cfg.Webhook)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		serviceOpts = append(serviceOpts, cartservice.WithPublisher(webhooks))
+	}
+	cartItemService := cartservice.New(log, storage, serviceOpts...)
 	cartItemHandler := carthandler.New(log, cartItemService)
+	cartItemHandler.SetEnforceOwnership(cfg.EnforceOwnership)
+	cartItemHandler.SetDefaultQuantity(cfg.DefaultQuantity)
+	cartItemHandler.SetIdempotentRemove(cfg.IdempotentRemove)
+
+	readOnly := middleware.NewReadOnly(cfg.HTTP.ReadOnly)
+
+	var adminHandler *adminhandler.Handler
+	if cfg.Admin.Enabled {
+		// Connection pool stats are only available for SQL backends.
+		stats, _ := storage.(adminhandler.StatsProvider)
+		adminHandler = adminhandler.New(log, stats, storage, readOnly)
+	}
 
-	router := routes.New(cartItemHandler)
+	versionHandler := versionhandler.New(log, buildinfo.Get())
+
+	expectedVersion, err := latestMigrationVersion(cfg)
+	if err != nil {
+		log.Error("Failed to read embedded migrations", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if indexer, ok := storage.(UniqueItemsIndexer); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+		err := indexer.SyncUniqueItemsIndex(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if err := SelfCheck(context.Background(), log, cfg, storage, expectedVersion); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if adminHandler != nil {
+		adminHandler.SetSchema(storage, expectedVersion)
+	}
+	readyHandler := readyhandler.New(log, storage, expectedVersion)
+
+	router := routes.New(cartItemHandler, adminHandler, versionHandler, readyHandler, cfg.HTTP.BasePath)
+	router.SetMaxPathLength(cfg.HTTP.MaxPathLength)
 	router.Register()
 
+	inFlight := middleware.NewInFlight()
+
+	clientIPs, err := clientip.NewResolver(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	bodyLog := middleware.BodyLog(log, cfg.BodyLog.MaxBytes, cfg.BodyLog.RedactFields)
+	timeout := middleware.Timeout(func(r *http.Request) time.Duration {
+		return cfg.Timeouts[routes.Operation(r)]
+	})
+
+	middlewares := []func(http.Handler) http.Handler{
+		middleware.RequestID,
+		middleware.ClientIP(clientIPs),
+		middleware.ConcurrencyLimit(cfg.HTTP.MaxConcurrentRequests),
+		inFlight.Middleware,
+		middleware.Recover(log),
+		middleware.Trace(tracer),
+	}
+	if len(cfg.Auth.Tokens) > 0 {
+		middlewares = append(middlewares, middleware.Auth(cfg.Auth.Tokens))
+	} else {
+		log.Warn("Authentication is disabled, no auth tokens configured")
+	}
+	if cfg.Signature.Enabled {
+		middlewares = append(middlewares, middleware.Signature([]byte(cfg.Signature.Secret)))
+	}
+	middlewares = append(middlewares, bodyLog, readOnly.Middleware, timeout)
+
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.HTTP.Port),
-		Handler: nil,
+		Addr:    cfg.HTTP.Addr(),
+		Handler: router.Handler(middlewares...),
 	}
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Error("Server failed to start", sl.Err(err))
-		}
-	}()
+	log.Info("Starting server", slog.String("addr", server.Addr), slog.Bool("tls", cfg.HTTP.TLSEnabled()))
+	serverErr := startServer(server, cfg.HTTP)
+
+	go toggleReadOnlyOnSignal(log, readOnly)
+
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	cleanupDone := make(chan struct{})
+	if cfg.Cleanup.CartTTL > 0 {
+		job := cleanup.New(log, storage, cfg.Cleanup.CartTTL, cfg.Cleanup.Interval)
+		go func() {
+			defer close(cleanupDone)
+			job.Run(cleanupCtx)
+		}()
+	} else {
+		close(cleanupDone)
+	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, syscall.SIGTERM, syscall.SIGINT)
-	<-done
+	select {
+	case <-done:
+	case err := <-serverErr:
+		log.Error("Server failed", sl.Err(err))
+		stopCleanup()
+		<-cleanupDone
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
 	defer cancel()
 
+	log.Info("Shutting down server", slog.Int64("in_flight", inFlight.Count()))
+
+	stopCleanup()
+	<-cleanupDone
+
 	if err := server.Shutdown(ctx); err != nil {
 		log.Error("Failed to shutdown server", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
@@ -66,7 +216,17 @@ func Run() error {
 		log.Info("Server shutdown gracefully")
 	}
 
-	if err := storage.Close(); err != nil {
+	remaining := inFlight.Wait(ctx)
+	log.Info("In-flight requests drained", slog.Int64("in_flight", remaining))
+
+	if webhooks != nil {
+		if err := webhooks.Wait(ctx); err != nil {
+			log.Warn("Shutting down with webhooks pending", sl.Err(err))
+		}
+	}
+
+	closeStorage = false
+	if err := storage.CloseGraceful(ctx); err != nil {
 		log.Error("Failed to close database connection", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	} else {
@@ -75,3 +235,62 @@ func Run() error {
 
 	return nil
 }
+
+func newWebhookPublisher(log *slog.Logger, cfg config.WebhookConfig) (*events.WebhookPublisher, error) {
+	types := make([]events.Type, 0, len(cfg.Events))
+	for _, name := range cfg.Events {
+		t := events.Type(name)
+		if !t.Valid() {
+			return nil, fmt.Errorf("unknown webhook event %q", name)
+		}
+		types = append(types, t)
+	}
+	return events.NewWebhookPublisher(log, events.WebhookConfig{
+		URL:        cfg.URL,
+		Secret:     cfg.Secret,
+		Types:      types,
+		Timeout:    cfg.Timeout,
+		Attempts:   cfg.Attempts,
+		Backoff:    cfg.Backoff,
+		MaxPending: cfg.MaxPending,
+	}), nil
+}
+
+// toggleReadOnlyOnSignal flips read-only mode on every SIGUSR1.
+func toggleReadOnlyOnSignal(log *slog.Logger, readOnly *middleware.ReadOnly) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	for range sig {
+		log.Info("Read-only mode toggled", slog.Bool("enabled", readOnly.Toggle()))
+	}
+}
+
+// latestMigrationVersion is the schema version the binary expects for the
+// configured storage; the memory storage has no schema.
+func latestMigrationVersion(cfg *config.Config) (int64, error) {
+	switch cfg.Storage {
+	case config.StoragePostgres:
+		return psql.LatestMigrationVersion()
+	case config.StorageSQLite:
+		return sqlite.LatestMigrationVersion()
+	default:
+		return 0, nil
+	}
+}
+
+func newStorage(log *slog.Logger, cfg *config.Config) (databaseerrors.Storage, error) {
+	switch cfg.Storage {
+	case config.StoragePostgres:
+		return psql.New(log, cfg.ConnectionString(), psql.Retry{
+			Attempts: cfg.Psql.ConnectAttempts,
+			Backoff:  cfg.Psql.ConnectBackoff,
+		})
+	case config.StorageSQLite:
+		return sqlite.New(log, cfg.SQLite.Path)
+	case config.StorageMemory:
+		log.Warn("Using in-memory storage, carts will be lost on restart")
+		return memory.New(log), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"cartapi/internal/database/psql"
+	"cartapi/internal/database/sqlite"
+	"cartapi/pkg/config"
+	"database/sql"
+	"fmt"
+)
+
+// Migrate runs the requested migration command against the configured
+// database without starting the HTTP server.
+func Migrate(command string, steps int) error {
+	const op = "app.Migrate"
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	driver, dsn := "postgres", cfg.ConnectionString()
+	migrateUp, migrateDown, migrateStatus := psql.MigrateUp, psql.MigrateDown, psql.MigrateStatus
+	switch cfg.Storage {
+	case config.StoragePostgres:
+	case config.StorageSQLite:
+		driver, dsn = "sqlite", cfg.SQLite.Path
+		migrateUp, migrateDown, migrateStatus = sqlite.MigrateUp, sqlite.MigrateDown, sqlite.MigrateStatus
+	default:
+		return fmt.Errorf("%s: storage %q has no migrations", op, cfg.Storage)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		err = migrateUp(db)
+	case "down":
+		err = migrateDown(db, steps)
+	case "status":
+		err = migrateStatus(db)
+	default:
+		err = fmt.Errorf("unknown migrate command %q, expected up, down or status", command)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
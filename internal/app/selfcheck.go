@@ -0,0 +1,84 @@
+package app
+
+import (
+	"cartapi/pkg/config"
+	"cartapi/pkg/lib/logger/sl"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// selfCheckTimeout bounds the database checks run before serving.
+const selfCheckTimeout = 10 * time.Second
+
+// SelfCheckStorage is the part of the storage the startup self-check uses.
+type SelfCheckStorage interface {
+	Ping(ctx context.Context) error
+	CurrentMigrationVersion(ctx context.Context) (int64, error)
+}
+
+// UniqueItemsIndexer is implemented by the storages that need an index to
+// merge items of the same product. Run syncs the index with unique_items
+// before SelfCheck verifies it.
+type UniqueItemsIndexer interface {
+	SyncUniqueItemsIndex(ctx context.Context) error
+	HasUniqueItemsIndex(ctx context.Context) (bool, error)
+}
+
+// SelfCheck verifies before serving that the config is usable, the database
+// answers, its schema is at least at expectedVersion and the index merging
+// relies on exists exactly when unique_items is on. It logs a single "Ready" line with
+// the results, or the check that failed.
+func SelfCheck(ctx context.Context, log *slog.Logger, cfg *config.Config, storage SelfCheckStorage, expectedVersion int64) error {
+	const op = "app.SelfCheck"
+	log = log.With("op", op)
+
+	fail := func(check string, err error) error {
+		log.Error("Self-check failed", slog.String("check", check), sl.Err(err))
+		return fmt.Errorf("%s: %s: %w", op, check, err)
+	}
+
+	if err := validateTLSFiles(cfg.HTTP); err != nil {
+		return fail("config", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := storage.Ping(ctx); err != nil {
+		return fail("database", err)
+	}
+	latency := time.Since(start)
+
+	version, err := storage.CurrentMigrationVersion(ctx)
+	if err != nil {
+		return fail("migrations", err)
+	}
+	if version < expectedVersion {
+		return fail("migrations", fmt.Errorf("schema is at version %d, expected %d", version, expectedVersion))
+	}
+
+	if indexer, ok := storage.(UniqueItemsIndexer); ok {
+		indexed, err := indexer.HasUniqueItemsIndex(ctx)
+		if err != nil {
+			return fail("unique_items", err)
+		}
+		if cfg.UniqueItems && !indexed {
+			return fail("unique_items", errors.New("index item_cart_product_key is missing, remove the duplicate items of each cart"))
+		}
+		if !cfg.UniqueItems && indexed {
+			return fail("unique_items", errors.New("index item_cart_product_key rejects duplicate products although unique_items is off"))
+		}
+	}
+
+	log.Info("Ready",
+		slog.String("storage", cfg.Storage),
+		slog.Group("config", slog.Bool("ok", true), slog.Bool("tls", cfg.HTTP.TLSEnabled())),
+		slog.Group("database", slog.Bool("ok", true), slog.Duration("ping", latency)),
+		slog.Group("migrations", slog.Bool("ok", true), slog.Int64("version", version), slog.Int64("expected", expectedVersion)),
+	)
+	return nil
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cartapi/pkg/config"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSelfCheckStorage struct {
+	pingErr error
+	version int64
+}
+
+func (f fakeSelfCheckStorage) Ping(context.Context) error {
+	return f.pingErr
+}
+
+func (f fakeSelfCheckStorage) CurrentMigrationVersion(context.Context) (int64, error) {
+	return f.version, nil
+}
+
+func TestSelfCheck(t *testing.T) {
+	const expected = 20251014130000
+
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		storage   fakeSelfCheckStorage
+		wantCheck string
+	}{
+		{name: "Ready", storage: fakeSelfCheckStorage{version: expected}},
+		{
+			name:      "Database unreachable",
+			storage:   fakeSelfCheckStorage{pingErr: errors.New("connection refused"), version: expected},
+			wantCheck: "database",
+		},
+		{name: "Schema behind", storage: fakeSelfCheckStorage{version: expected - 1}, wantCheck: "migrations"},
+		{
+			name:      "Invalid config",
+			cfg:       config.Config{HTTP: config.HTTPConfig{TLSCertFile: "cert.pem"}},
+			storage:   fakeSelfCheckStorage{version: expected},
+			wantCheck: "config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SelfCheck(context.Background(), slogdiscard.NewDiscardLogger(), &tt.cfg, tt.storage, expected)
+			if tt.wantCheck == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, "app.SelfCheck: "+tt.wantCheck+":")
+			if tt.storage.pingErr != nil {
+				assert.ErrorIs(t, err, tt.storage.pingErr)
+			}
+		})
+	}
+}
+
+type fakeIndexedStorage struct {
+	fakeSelfCheckStorage
+	indexed bool
+}
+
+func (f fakeIndexedStorage) SyncUniqueItemsIndex(context.Context) error {
+	return nil
+}
+
+func (f fakeIndexedStorage) HasUniqueItemsIndex(context.Context) (bool, error) {
+	return f.indexed, nil
+}
+
+func TestSelfCheck_UniqueItemsIndex(t *testing.T) {
+	const expected = 20251014130000
+
+	tests := []struct {
+		name        string
+		uniqueItems bool
+		storage     SelfCheckStorage
+		wantErr     bool
+	}{
+		{name: "Index present", uniqueItems: true, storage: fakeIndexedStorage{fakeSelfCheckStorage{version: expected}, true}},
+		{name: "Index missing", uniqueItems: true, storage: fakeIndexedStorage{fakeSelfCheckStorage{version: expected}, false}, wantErr: true},
+		{name: "Index missing, merging off", storage: fakeIndexedStorage{fakeSelfCheckStorage{version: expected}, false}},
+		{name: "Index present, merging off", storage: fakeIndexedStorage{fakeSelfCheckStorage{version: expected}, true}, wantErr: true},
+		{name: "Storage without the index", uniqueItems: true, storage: fakeSelfCheckStorage{version: expected}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{UniqueItems: tt.uniqueItems}
+			err := SelfCheck(context.Background(), slogdiscard.NewDiscardLogger(), &cfg, tt.storage, expected)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, "app.SelfCheck: unique_items:")
+		})
+	}
+}
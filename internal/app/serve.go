@@ -0,0 +1,63 @@
+package app
+
+import (
+	"cartapi/pkg/config"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// validateTLSFiles checks at startup that the configured certificate and key
+// exist and form a valid pair, instead of failing on the first handshake.
+func validateTLSFiles(cfg config.HTTPConfig) error {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		return nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return errors.New("both tls_cert_file and tls_key_file must be set to enable TLS")
+	}
+
+	for _, path := range []string{cfg.TLSCertFile, cfg.TLSKeyFile} {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("tls file %q: %w", path, err)
+		}
+	}
+	if _, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+		return fmt.Errorf("invalid tls key pair: %w", err)
+	}
+
+	return nil
+}
+
+// serve serves on ln, over TLS (and HTTP/2) when a certificate is configured
+// and in plaintext otherwise.
+func serve(server *http.Server, ln net.Listener, cfg config.HTTPConfig) error {
+	if cfg.TLSEnabled() {
+		return server.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	return server.Serve(ln)
+}
+
+func listenAndServe(server *http.Server, cfg config.HTTPConfig) error {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	return serve(server, ln, cfg)
+}
+
+// startServer runs listenAndServe in the background. Any failure other than
+// the server being shut down, e.g. the port already being in use, is sent on
+// the returned channel.
+func startServer(server *http.Server, cfg config.HTTPConfig) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		if err := listenAndServe(server, cfg); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+	return errCh
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cartapi/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSelfSignedCert(t *testing.T) (certFile string, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
+func TestValidateTLSFiles(t *testing.T) {
+	certFile, keyFile, _ := writeSelfSignedCert(t)
+
+	assert.NoError(t, validateTLSFiles(config.HTTPConfig{}))
+	assert.NoError(t, validateTLSFiles(config.HTTPConfig{TLSCertFile: certFile, TLSKeyFile: keyFile}))
+	assert.Error(t, validateTLSFiles(config.HTTPConfig{TLSCertFile: certFile}))
+	assert.Error(t, validateTLSFiles(config.HTTPConfig{TLSCertFile: certFile, TLSKeyFile: filepath.Join(t.TempDir(), "missing.pem")}))
+	assert.Error(t, validateTLSFiles(config.HTTPConfig{TLSCertFile: keyFile, TLSKeyFile: certFile}))
+}
+
+func TestServe_TLS(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t)
+	cfg := config.HTTPConfig{TLSCertFile: certFile, TLSKeyFile: keyFile}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	})}
+	go func() { _ = serve(server, ln, cfg) }()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+	assert.NotNil(t, resp.TLS)
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
+
+func TestStartServer_AddrInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	server := &http.Server{Addr: ln.Addr().String(), Handler: http.NotFoundHandler()}
+	defer server.Close()
+
+	select {
+	case err := <-startServer(server, config.HTTPConfig{}):
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("startServer did not report the occupied port")
+	}
+}
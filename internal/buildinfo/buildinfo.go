@@ -0,0 +1,28 @@
+// Package buildinfo holds the build details injected at link time, e.g.
+//
+//	go build -ldflags "-X cartapi/internal/buildinfo.Version=v1.2.0 \
+//		-X cartapi/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//		-X cartapi/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/app
+package buildinfo
+
+import "fmt"
+
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+}
+
+func Get() Info {
+	return Info{Version: Version, Commit: Commit, Date: Date}
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("cartapi %s (commit %s, built %s)", i.Version, i.Commit, i.Date)
+}
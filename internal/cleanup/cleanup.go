@@ -0,0 +1,60 @@
+package cleanup
+
+import (
+	"cartapi/pkg/lib/logger/sl"
+	"context"
+	"log/slog"
+	"time"
+)
+
+type ExpiredCartDeleter interface {
+	DeleteExpiredCarts(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Job periodically deletes carts that are older than the configured TTL.
+type Job struct {
+	log      *slog.Logger
+	storage  ExpiredCartDeleter
+	ttl      time.Duration
+	interval time.Duration
+}
+
+func New(log *slog.Logger, storage ExpiredCartDeleter, ttl time.Duration, interval time.Duration) *Job {
+	return &Job{
+		log:      log,
+		storage:  storage,
+		ttl:      ttl,
+		interval: interval,
+	}
+}
+
+// Run deletes expired carts every interval until ctx is canceled.
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = j.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce deletes the carts created more than ttl ago.
+func (j *Job) RunOnce(ctx context.Context) (int64, error) {
+	const op = "cleanup.RunOnce"
+	log := j.log.With("op", op)
+
+	cutoff := time.Now().Add(-j.ttl)
+	deleted, err := j.storage.DeleteExpiredCarts(ctx, cutoff)
+	if err != nil {
+		log.Error("Failed to delete expired carts", sl.Err(err))
+		return 0, err
+	}
+
+	log.Info("Expired carts deleted", slog.Int64("deleted", deleted), slog.Time("cutoff", cutoff))
+	return deleted, nil
+}
@@ -0,0 +1,48 @@
+package cleanup_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cartapi/internal/cleanup"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubDeleter struct {
+	cutoff  time.Time
+	deleted int64
+	err     error
+}
+
+func (s *stubDeleter) DeleteExpiredCarts(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.cutoff = cutoff
+	return s.deleted, s.err
+}
+
+func TestJob_RunOnce(t *testing.T) {
+	t.Run("Deletes carts older than ttl", func(t *testing.T) {
+		storage := &stubDeleter{deleted: 3}
+		job := cleanup.New(slogdiscard.NewDiscardLogger(), storage, 24*time.Hour, time.Hour)
+
+		before := time.Now().Add(-24 * time.Hour)
+		deleted, err := job.RunOnce(context.Background())
+		after := time.Now().Add(-24 * time.Hour)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), deleted)
+		assert.False(t, storage.cutoff.Before(before))
+		assert.False(t, storage.cutoff.After(after))
+	})
+
+	t.Run("Storage error", func(t *testing.T) {
+		storage := &stubDeleter{err: errors.New("db down")}
+		job := cleanup.New(slogdiscard.NewDiscardLogger(), storage, time.Hour, time.Hour)
+
+		_, err := job.RunOnce(context.Background())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,110 @@
+// Package clientip finds the address of the client behind the reverse
+// proxies the API is deployed behind.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	ForwardedForHeader = "X-Forwarded-For"
+	RealIPHeader       = "X-Real-IP"
+)
+
+// Resolver trusts the forwarding headers only when they were set by one of
+// the configured proxies, since any client can send them.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver trusts the proxies in the given CIDR ranges; a bare address
+// trusts that one proxy. With none, the peer address is always the client.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, cidr := range trustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// ClientIP returns the address of the client of req. When the peer is a
+// trusted proxy, X-Forwarded-For is walked from the right, past the other
+// trusted proxies, to the first address they don't vouch for; X-Real-IP is
+// used when there is no X-Forwarded-For. Otherwise the peer is the client.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	peer, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	if forwarded := req.Header.Values(ForwardedForHeader); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseAddr(strings.TrimSpace(hops[i]))
+			if !ok {
+				break
+			}
+			client = addr
+			if !r.isTrusted(addr) {
+				break
+			}
+		}
+		return client.String()
+	}
+
+	if addr, ok := parseAddr(strings.TrimSpace(req.Header.Get(RealIPHeader))); ok {
+		return addr.String()
+	}
+	return peer.String()
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr accepts an address with or without a port.
+func parseAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the client address ip.
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, ip)
+}
+
+// FromContext returns the client address stored by NewContext, or "".
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ctxKey{}).(string)
+	return ip
+}
@@ -0,0 +1,69 @@
+package clientip_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/clientip"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_ClientIP(t *testing.T) {
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{name: "Untrusted peer without headers", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "Untrusted peer headers are ignored", remoteAddr: "203.0.113.7:1234", forwardedFor: []string{"198.51.100.1"}, realIP: "198.51.100.2", want: "203.0.113.7"},
+		{name: "Trusted peer without headers", remoteAddr: "10.1.2.3:1234", want: "10.1.2.3"},
+		{name: "Trusted peer forwarded for", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "Trusted single address", remoteAddr: "192.168.1.1:1234", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "Chain stops at the first untrusted hop", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"1.2.3.4, 198.51.100.1, 10.9.9.9"}, want: "198.51.100.1"},
+		{name: "Repeated headers are one chain", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"198.51.100.1", "10.9.9.9"}, want: "198.51.100.1"},
+		{name: "Chain of trusted proxies only", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"10.8.8.8, 10.9.9.9"}, want: "10.8.8.8"},
+		{name: "Malformed hop stops the walk", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"198.51.100.1, garbage"}, want: "10.1.2.3"},
+		{name: "Trusted peer real IP", remoteAddr: "10.1.2.3:1234", realIP: "198.51.100.2", want: "198.51.100.2"},
+		{name: "Forwarded for wins over real IP", remoteAddr: "10.1.2.3:1234", forwardedFor: []string{"198.51.100.1"}, realIP: "198.51.100.2", want: "198.51.100.1"},
+		{name: "IPv6 peer", remoteAddr: "[2001:db8::1]:1234", want: "2001:db8::1"},
+		{name: "IPv4-mapped peer is trusted", remoteAddr: "[::ffff:10.1.2.3]:1234", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add(clientip.ForwardedForHeader, v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set(clientip.RealIPHeader, tt.realIP)
+			}
+
+			assert.Equal(t, tt.want, resolver.ClientIP(r))
+		})
+	}
+}
+
+func TestResolver_NoTrustedProxies(t *testing.T) {
+	resolver, err := clientip.NewResolver(nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set(clientip.ForwardedForHeader, "198.51.100.1")
+	assert.Equal(t, "10.1.2.3", resolver.ClientIP(r))
+}
+
+func TestNewResolver_Invalid(t *testing.T) {
+	_, err := clientip.NewResolver([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
@@ -1,7 +1,37 @@
 package databaseerrors
 
-import "errors"
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
 
 var (
 	ErrNotFound = errors.New("not found")
+	// ErrCartNotFound and ErrItemNotFound tell which resource is missing;
+	// both still match ErrNotFound.
+	ErrCartNotFound = fmt.Errorf("cart %w", ErrNotFound)
+	ErrItemNotFound = fmt.Errorf("item %w", ErrNotFound)
+	ErrConflict     = errors.New("conflict")
+	// ErrCartFull means adding the item would exceed the items limit of
+	// the cart.
+	ErrCartFull = errors.New("cart full")
+	// ErrCartNotEmpty means the cart still holds live items.
+	ErrCartNotEmpty = errors.New("cart not empty")
+	// ErrQuantityOverflow means merging the item into the live item of the
+	// same product would take its quantity past models.MaxQuantity.
+	ErrQuantityOverflow = errors.New("quantity overflow")
 )
+
+// IsUnavailable reports whether err means the database couldn't be reached:
+// a broken or closed connection, or a network error such as a refused dial.
+func IsUnavailable(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.As(err, &opErr)
+}
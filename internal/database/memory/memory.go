@@ -0,0 +1,1001 @@
+package memory
+
+import (
+	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/models"
+	"cartapi/internal/tracing"
+	"cartapi/pkg/lib/logger/sl"
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cart struct {
+	createdAt   time.Time
+	userId      string
+	externalKey string
+	itemIds     []int
+	metadata    map[string]string
+}
+
+// Storage keeps carts in process memory. It is meant for local development
+// and tests; nothing survives a restart.
+type Storage struct {
+	log *slog.Logger
+
+	mu    sync.RWMutex
+	carts map[int]*cart
+	items map[int]models.CartItem
+	// deleted holds the soft-deleted items, out of the way of every live
+	// lookup.
+	deleted     map[int]models.CartItem
+	nextCartId  int
+	nextItemId  int
+	softDelete  bool
+	maxItems    int
+	uniqueItems bool
+	tracer      trace.Tracer
+}
+
+func New(log *slog.Logger) *Storage {
+	return &Storage{
+		log:        log,
+		carts:      make(map[int]*cart),
+		items:      make(map[int]models.CartItem),
+		deleted:    make(map[int]models.CartItem),
+		nextCartId: 1,
+		nextItemId: 1,
+		tracer:     tracing.Noop(),
+	}
+}
+
+// SetSoftDelete makes removed items be kept aside instead of dropped. It must
+// be called before the storage is used.
+func (s *Storage) SetSoftDelete(enabled bool) {
+	s.softDelete = enabled
+}
+
+// SetQueryTimeout does nothing; there are no queries to bound.
+func (s *Storage) SetQueryTimeout(time.Duration) {}
+
+// SetSlowQueryThreshold does nothing; there are no queries to time.
+func (s *Storage) SetSlowQueryThreshold(time.Duration) {}
+
+// SetSQLLog does nothing; there is no SQL to log.
+func (s *Storage) SetSQLLog(bool, bool) {}
+
+// SetMaxItems makes every write that adds items to a cart (AddToCart,
+// AddItems, ReplaceItems, CopyCart, MoveItem and RestoreItem) refuse to grow
+// it past n items; zero means no limit. It must be called before the
+// storage is used.
+func (s *Storage) SetMaxItems(n int) {
+	s.maxItems = n
+}
+
+// SetUniqueItems makes AddToCart merge an item into the live item of the
+// same product, adding up their quantities and weights, instead of adding a
+// duplicate. AddItems, ReplaceItems and RestoreItem don't merge; they fail
+// with ErrConflict when they would leave a cart holding a product twice, as
+// do UpdateItem and MoveItem. It must be called before the storage is used.
+func (s *Storage) SetUniqueItems(enabled bool) {
+	s.uniqueItems = enabled
+}
+
+// SetTracer sets the tracer recording a span per storage call. It must be
+// called before the storage is used.
+func (s *Storage) SetTracer(tracer trace.Tracer) {
+	s.tracer = tracer
+}
+
+func (s *Storage) Close() error {
+	return nil
+}
+
+// CloseGraceful is Close; there is no connection pool to drain.
+func (s *Storage) CloseGraceful(context.Context) error {
+	return s.Close()
+}
+
+// Ping never fails; there is no database to reach.
+func (s *Storage) Ping(context.Context) error {
+	return nil
+}
+
+// CurrentMigrationVersion is always 0; there is no schema to migrate.
+func (s *Storage) CurrentMigrationVersion(context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (s *Storage) CreateCart(ctx context.Context, userId string) (models.Cart, error) {
+	const op = "database.memory.CreateCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.createCart()
+	s.carts[id].userId = userId
+	return models.Cart{Id: id, UserId: userId}, nil
+}
+
+// GetOrCreateCart returns the cart created for the external key, creating
+// it for userId if there is none yet; created tells which happened.
+func (s *Storage) GetOrCreateCart(ctx context.Context, key string, userId string) (models.Cart, bool, error) {
+	const op = "database.memory.GetOrCreateCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, false, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, c := range s.carts {
+		if c.externalKey == key {
+			return models.Cart{Id: id, UserId: c.userId}, false, nil
+		}
+	}
+
+	id := s.createCart()
+	s.carts[id].userId = userId
+	s.carts[id].externalKey = key
+	return models.Cart{Id: id, UserId: userId}, true, nil
+}
+
+func (s *Storage) CartOwner(ctx context.Context, cartId int) (string, error) {
+	const op = "database.memory.CartOwner"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return "", fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return "", fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	return c.userId, nil
+}
+
+func (s *Storage) CreateCarts(ctx context.Context, n int) ([]int, error) {
+	const op = "database.memory.CreateCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = s.createCart()
+	}
+	return ids, nil
+}
+
+func (s *Storage) AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error) {
+	const op = "database.memory.AddToCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	if s.uniqueItems {
+		for _, id := range c.itemIds {
+			existing := s.items[id]
+			if existing.Product != item.Product {
+				continue
+			}
+			if existing.Quantity+item.Quantity > models.MaxQuantity {
+				log.Warn("Merged quantity overflows", sl.Err(databaseerrors.ErrQuantityOverflow))
+				return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrQuantityOverflow)
+			}
+			existing.Quantity += item.Quantity
+			existing.Weight += item.Weight
+			s.items[id] = existing
+			return existing, nil
+		}
+	}
+
+	if s.maxItems > 0 && len(c.itemIds) >= s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+	return s.addItem(cartId, item), nil
+}
+
+func (s *Storage) RemoveFromCart(ctx context.Context, cartId int, itemId int) error {
+	const op = "database.memory.RemoveFromCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.carts[cartId]; !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	if item, ok := s.items[itemId]; !ok || item.CartId != cartId {
+		log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
+
+	s.removeItem(itemId)
+	return nil
+}
+
+// GetItem returns a live item of the cart.
+func (s *Storage) GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "database.memory.GetItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.carts[cartId]; !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	item, ok := s.items[itemId]
+	if !ok || item.CartId != cartId {
+		log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
+
+	return item, nil
+}
+
+// ItemIds returns the ids of the live items of the cart in id order.
+func (s *Storage) ItemIds(ctx context.Context, cartId int) ([]int, error) {
+	const op = "database.memory.ItemIds"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	return append([]int{}, c.itemIds...), nil
+}
+
+func (s *Storage) UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error) {
+	const op = "database.memory.UpdateItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	item, ok := s.items[itemId]
+	if !ok || item.CartId != cartId {
+		log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
+
+	if patch.Product != nil {
+		item.Product = *patch.Product
+		others := slices.DeleteFunc(slices.Clone(c.itemIds), func(id int) bool { return id == itemId })
+		if s.uniqueItems && s.duplicatesProduct(others, []models.CartItem{item}) {
+			log.Warn("Product already in cart", sl.Err(databaseerrors.ErrConflict))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+		}
+	}
+	if patch.Quantity != nil {
+		item.Quantity = *patch.Quantity
+	}
+	s.items[itemId] = item
+
+	return item, nil
+}
+
+// UpdateQuantities sets the quantity of every item in updates. Nothing is
+// changed when any of the items is not a live item of the cart.
+func (s *Storage) UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error) {
+	const op = "database.memory.UpdateQuantities"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.carts[cartId]; !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	for _, update := range updates {
+		if item, ok := s.items[update.ItemId]; !ok || item.CartId != cartId {
+			log.Warn("Cart item doesn't exist", slog.Int("item_id", update.ItemId), sl.Err(databaseerrors.ErrItemNotFound))
+			return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+	}
+
+	items := make([]models.CartItem, 0, len(updates))
+	for _, update := range updates {
+		item := s.items[update.ItemId]
+		item.Quantity = update.Quantity
+		s.items[update.ItemId] = item
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (s *Storage) ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error) {
+	const op = "database.memory.ViewCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	product := strings.ToLower(opts.Product)
+	var matched []models.CartItem
+	for _, id := range c.itemIds {
+		item := s.items[id]
+		if product != "" && !strings.Contains(strings.ToLower(item.Product), product) {
+			continue
+		}
+		matched = append(matched, item)
+	}
+
+	total := len(matched)
+	start := min(opts.Offset, total)
+	end := total
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, total)
+	}
+
+	var items []models.CartItem
+	if start < end {
+		items = slices.Clone(matched[start:end])
+	}
+
+	return models.Cart{
+		Id:       cartId,
+		Items:    items,
+		Total:    total,
+		Metadata: maps.Clone(c.metadata),
+	}, nil
+}
+
+func (s *Storage) ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error) {
+	const op = "database.memory.ViewCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	carts := make([]models.Cart, 0, len(cartIds))
+	seen := make(map[int]bool, len(cartIds))
+	for _, cartId := range cartIds {
+		c, ok := s.carts[cartId]
+		if !ok || seen[cartId] {
+			continue
+		}
+		seen[cartId] = true
+
+		cart := models.Cart{Id: cartId, UserId: c.userId, Total: len(c.itemIds), Metadata: maps.Clone(c.metadata)}
+		for _, id := range c.itemIds {
+			cart.Items = append(cart.Items, s.items[id])
+		}
+		carts = append(carts, cart)
+	}
+	return carts, nil
+}
+
+// StreamCartItems yields a snapshot of the matching items taken under the
+// lock, so yield may call back into the storage.
+func (s *Storage) StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error) {
+	const op = "database.memory.StreamCartItems"
+
+	cart, err := s.ViewCart(ctx, cartId, models.ViewCartOptions{Product: product})
+	if err != nil {
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, item := range cart.Items {
+		if err := yield(item); err != nil {
+			return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	cart.Items = nil
+
+	return cart, nil
+}
+
+func (s *Storage) MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error) {
+	const op = "database.memory.MoveItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range []int{cartId, targetCartId} {
+		if _, ok := s.carts[id]; !ok {
+			log.Warn("Cart doesn't exist", slog.Int("cart_id", id), sl.Err(databaseerrors.ErrCartNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+	}
+
+	item, ok := s.items[itemId]
+	if !ok || item.CartId != cartId {
+		log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
+	if s.maxItems > 0 && targetCartId != cartId && len(s.carts[targetCartId].itemIds) >= s.maxItems {
+		log.Warn("Target cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+	if s.uniqueItems && targetCartId != cartId && s.duplicatesProduct(s.carts[targetCartId].itemIds, []models.CartItem{item}) {
+		log.Warn("Product already in target cart", sl.Err(databaseerrors.ErrConflict))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+	}
+
+	s.removeItem(itemId)
+	item.CartId = targetCartId
+	s.items[itemId] = item
+	target := s.carts[targetCartId]
+	target.itemIds = insertSorted(target.itemIds, itemId)
+
+	return item, nil
+}
+
+func (s *Storage) CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error) {
+	const op = "database.memory.CopyCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	if s.maxItems > 0 && len(source.itemIds) > s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+
+	newCartId := s.createCart()
+	s.carts[newCartId].userId = userId
+	var copiedItems []models.CartItem
+	for _, id := range slices.Clone(source.itemIds) {
+		copiedItems = append(copiedItems, s.addItem(newCartId, s.items[id]))
+	}
+
+	return models.Cart{
+		Id:     newCartId,
+		UserId: userId,
+		Items:  copiedItems,
+	}, nil
+}
+
+// RemoveItems deletes the given items from the cart and returns the ids that
+// were actually deleted. Ids that don't belong to the cart are ignored.
+func (s *Storage) RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error) {
+	const op = "database.memory.RemoveItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.carts[cartId]; !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	deletedIds := []int{}
+	for _, id := range itemIds {
+		if item, ok := s.items[id]; ok && item.CartId == cartId {
+			s.removeItem(id)
+			deletedIds = append(deletedIds, id)
+		}
+	}
+
+	return deletedIds, nil
+}
+
+// AddItems adds all given items to the cart.
+func (s *Storage) AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error) {
+	const op = "database.memory.AddItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	if s.maxItems > 0 && len(c.itemIds)+len(items) > s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+	if s.uniqueItems && s.duplicatesProduct(c.itemIds, items) {
+		log.Warn("Product already in cart", sl.Err(databaseerrors.ErrConflict))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+	}
+
+	addedItems := make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		addedItems = append(addedItems, s.addItem(cartId, item))
+	}
+
+	return addedItems, nil
+}
+
+// ReplaceItems replaces every item of the cart with the given ones.
+func (s *Storage) ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error) {
+	const op = "database.memory.ReplaceItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	if s.maxItems > 0 && len(items) > s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+	if s.uniqueItems && s.duplicatesProduct(nil, items) {
+		log.Warn("Product given twice", sl.Err(databaseerrors.ErrConflict))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+	}
+
+	for _, id := range slices.Clone(c.itemIds) {
+		s.removeItem(id)
+	}
+	addedItems := make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		addedItems = append(addedItems, s.addItem(cartId, item))
+	}
+
+	return models.Cart{
+		Id:       cartId,
+		Items:    addedItems,
+		Total:    len(addedItems),
+		Metadata: maps.Clone(c.metadata),
+	}, nil
+}
+
+// DeleteExpiredCarts deletes the carts created before cutoff together with
+// their items and returns how many carts were removed.
+func (s *Storage) DeleteExpiredCarts(ctx context.Context, cutoff time.Time) (int64, error) {
+	const op = "database.memory.DeleteExpiredCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return 0, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, c := range s.carts {
+		if !c.createdAt.Before(cutoff) {
+			continue
+		}
+		for _, itemId := range c.itemIds {
+			delete(s.items, itemId)
+		}
+		for itemId, item := range s.deleted {
+			if item.CartId == id {
+				delete(s.deleted, itemId)
+			}
+		}
+		delete(s.carts, id)
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// PurgeCart deletes the cart together with its soft-deleted items, but only
+// while it holds no live item.
+func (s *Storage) PurgeCart(ctx context.Context, cartId int) error {
+	const op = "database.memory.PurgeCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	if len(c.itemIds) > 0 {
+		log.Warn("Cart is not empty", slog.Int("items", len(c.itemIds)), sl.Err(databaseerrors.ErrCartNotEmpty))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotEmpty)
+	}
+
+	for itemId, item := range s.deleted {
+		if item.CartId == cartId {
+			delete(s.deleted, itemId)
+		}
+	}
+	delete(s.carts, cartId)
+	return nil
+}
+
+// CartStats counts the carts and live items, and the top products with the
+// most items, ties broken by product name.
+func (s *Storage) CartStats(ctx context.Context, top int) (models.CartStats, error) {
+	const op = "database.memory.CartStats"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byProduct := make(map[string]*models.ProductStats)
+	for _, item := range s.items {
+		product, ok := byProduct[item.Product]
+		if !ok {
+			product = &models.ProductStats{Product: item.Product}
+			byProduct[item.Product] = product
+		}
+		product.Items++
+		product.Quantity += item.Quantity
+	}
+
+	products := make([]models.ProductStats, 0, len(byProduct))
+	for _, product := range byProduct {
+		products = append(products, *product)
+	}
+	slices.SortFunc(products, func(a, b models.ProductStats) int {
+		if a.Items != b.Items {
+			return b.Items - a.Items
+		}
+		return strings.Compare(a.Product, b.Product)
+	})
+
+	return models.CartStats{
+		Carts:       len(s.carts),
+		Items:       len(s.items),
+		TopProducts: products[:min(top, len(products))],
+	}, nil
+}
+
+// PatchCartMetadata applies patch to the cart metadata as a JSON merge
+// patch: keys mapped to nil are removed, the others are set.
+func (s *Storage) PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error) {
+	const op = "database.memory.PatchCartMetadata"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(c.metadata, key)
+			continue
+		}
+		if c.metadata == nil {
+			c.metadata = make(map[string]string)
+		}
+		c.metadata[key] = *value
+	}
+	if len(c.metadata) == 0 {
+		c.metadata = nil
+	}
+
+	return models.Cart{Id: cartId, Metadata: maps.Clone(c.metadata)}, nil
+}
+
+// RestoreItem puts a soft-deleted item back into its cart.
+func (s *Storage) RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "database.memory.RestoreItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[cartId]
+	if !ok {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	item, ok := s.deleted[itemId]
+	if !ok || item.CartId != cartId {
+		log.Warn("Deleted cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
+	if s.maxItems > 0 && len(c.itemIds) >= s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+	if s.uniqueItems && s.duplicatesProduct(c.itemIds, []models.CartItem{item}) {
+		log.Warn("Product already in cart", sl.Err(databaseerrors.ErrConflict))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+	}
+
+	delete(s.deleted, itemId)
+	s.items[itemId] = item
+	c.itemIds = insertSorted(c.itemIds, itemId)
+	return item, nil
+}
+
+// createCart must be called with mu held.
+func (s *Storage) createCart() int {
+	id := s.nextCartId
+	s.nextCartId++
+	s.carts[id] = &cart{createdAt: time.Now()}
+	return id
+}
+
+// addItem must be called with mu held and an existing cartId.
+func (s *Storage) addItem(cartId int, item models.CartItem) models.CartItem {
+	added := models.CartItem{
+		Id:       s.nextItemId,
+		CartId:   cartId,
+		Product:  item.Product,
+		Quantity: item.Quantity,
+		Measure:  item.Measure,
+		Weight:   item.Weight,
+		Unit:     item.Unit,
+	}
+	s.nextItemId++
+	s.items[added.Id] = added
+	c := s.carts[cartId]
+	c.itemIds = append(c.itemIds, added.Id)
+	return added
+}
+
+// duplicatesProduct reports whether adding items next to the live items with
+// the given ids would leave a product twice. It must be called with mu held.
+func (s *Storage) duplicatesProduct(itemIds []int, items []models.CartItem) bool {
+	products := make(map[string]bool, len(itemIds)+len(items))
+	for _, id := range itemIds {
+		products[s.items[id].Product] = true
+	}
+	for _, item := range items {
+		if products[item.Product] {
+			return true
+		}
+		products[item.Product] = true
+	}
+	return false
+}
+
+// removeItem must be called with mu held and an existing itemId.
+func (s *Storage) removeItem(itemId int) {
+	c := s.carts[s.items[itemId].CartId]
+	c.itemIds = slices.DeleteFunc(c.itemIds, func(id int) bool { return id == itemId })
+	if s.softDelete {
+		s.deleted[itemId] = s.items[itemId]
+	}
+	delete(s.items, itemId)
+}
+
+func insertSorted(ids []int, id int) []int {
+	i, _ := slices.BinarySearch(ids, id)
+	return slices.Insert(ids, i, id)
+}
+
+var _ databaseerrors.Storage = (*Storage)(nil)
@@ -0,0 +1,716 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/database/memory"
+	"cartapi/internal/models"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage() *memory.Storage {
+	return memory.New(slogdiscard.NewDiscardLogger())
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestCreateCart(t *testing.T) {
+	storage := newTestStorage()
+
+	first, err := storage.CreateCart(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cart{Id: 1}, first)
+
+	second, err := storage.CreateCart(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cart{Id: 2}, second)
+
+	_, err = storage.CreateCart(canceledContext(), "")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCartOwner(t *testing.T) {
+	storage := newTestStorage()
+
+	owned, err := storage.CreateCart(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", owned.UserId)
+	unowned, err := storage.CreateCart(context.Background(), "")
+	require.NoError(t, err)
+
+	owner, err := storage.CartOwner(context.Background(), owned.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", owner)
+
+	owner, err = storage.CartOwner(context.Background(), unowned.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, owner)
+
+	_, err = storage.CartOwner(context.Background(), 999)
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+}
+
+func TestAddToCart(t *testing.T) {
+	storage := newTestStorage()
+	cart, _ := storage.CreateCart(context.Background(), "")
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		cartId   int
+		wantItem models.CartItem
+		wantErr  error
+	}{
+		{
+			name:     "Success",
+			ctx:      context.Background(),
+			cartId:   cart.Id,
+			wantItem: models.CartItem{Id: 1, CartId: cart.Id, Product: "product", Quantity: 2},
+		},
+		{
+			name:    "Cart not found",
+			ctx:     context.Background(),
+			cartId:  42,
+			wantErr: databaseerrors.ErrNotFound,
+		},
+		{
+			name:    "Context canceled",
+			ctx:     canceledContext(),
+			cartId:  cart.Id,
+			wantErr: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := storage.AddToCart(tt.ctx, tt.cartId, models.CartItem{Product: "product", Quantity: 2})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantItem, item)
+		})
+	}
+}
+
+func TestRemoveFromCart(t *testing.T) {
+	storage := newTestStorage()
+	cart, _ := storage.CreateCart(context.Background(), "")
+	other, _ := storage.CreateCart(context.Background(), "")
+	item, _ := storage.AddToCart(context.Background(), cart.Id, models.CartItem{Product: "product", Quantity: 1})
+	otherItem, _ := storage.AddToCart(context.Background(), other.Id, models.CartItem{Product: "product", Quantity: 1})
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		cartId  int
+		itemId  int
+		wantErr error
+	}{
+		{name: "Cart not found", ctx: context.Background(), cartId: 42, itemId: item.Id, wantErr: databaseerrors.ErrCartNotFound},
+		{name: "Item of another cart", ctx: context.Background(), cartId: cart.Id, itemId: otherItem.Id, wantErr: databaseerrors.ErrItemNotFound},
+		{name: "Context canceled", ctx: canceledContext(), cartId: cart.Id, itemId: item.Id, wantErr: context.Canceled},
+		{name: "Success", ctx: context.Background(), cartId: cart.Id, itemId: item.Id},
+		{name: "Already removed", ctx: context.Background(), cartId: cart.Id, itemId: item.Id, wantErr: databaseerrors.ErrItemNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := storage.RemoveFromCart(tt.ctx, tt.cartId, tt.itemId)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestViewCart(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	empty, _ := storage.CreateCart(ctx, "")
+	apple, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "Apple", Quantity: 1})
+	pear, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 2})
+	pineapple, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pineapple", Quantity: 3})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		cartId   int
+		opts     models.ViewCartOptions
+		wantCart models.Cart
+		wantErr  error
+	}{
+		{
+			name:     "All items",
+			ctx:      ctx,
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 50},
+			wantCart: models.Cart{Id: cart.Id, Items: []models.CartItem{apple, pear, pineapple}, Total: 3},
+		},
+		{
+			name:     "Paginated",
+			ctx:      ctx,
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 1, Offset: 1},
+			wantCart: models.Cart{Id: cart.Id, Items: []models.CartItem{pear}, Total: 3},
+		},
+		{
+			name:     "Offset past the end",
+			ctx:      ctx,
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 50, Offset: 10},
+			wantCart: models.Cart{Id: cart.Id, Total: 3},
+		},
+		{
+			name:     "Product filter is case-insensitive",
+			ctx:      ctx,
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 50, Product: "APPLE"},
+			wantCart: models.Cart{Id: cart.Id, Items: []models.CartItem{apple, pineapple}, Total: 2},
+		},
+		{
+			name:     "Empty cart",
+			ctx:      ctx,
+			cartId:   empty.Id,
+			opts:     models.ViewCartOptions{Limit: 50},
+			wantCart: models.Cart{Id: empty.Id},
+		},
+		{
+			name:    "Cart not found",
+			ctx:     ctx,
+			cartId:  42,
+			opts:    models.ViewCartOptions{Limit: 50},
+			wantErr: databaseerrors.ErrNotFound,
+		},
+		{
+			name:    "Context canceled",
+			ctx:     canceledContext(),
+			cartId:  cart.Id,
+			opts:    models.ViewCartOptions{Limit: 50},
+			wantErr: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storage.ViewCart(tt.ctx, tt.cartId, tt.opts)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCart, got)
+		})
+	}
+}
+
+func TestViewCarts(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+	first, _ := storage.CreateCart(ctx, "")
+	second, _ := storage.CreateCart(ctx, "user-1")
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "apples", Quantity: 1})
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "pears", Quantity: 2})
+	removed, _ := storage.AddToCart(ctx, first.Id, models.CartItem{Product: "plums", Quantity: 3})
+	require.NoError(t, storage.RemoveFromCart(ctx, first.Id, removed.Id))
+	channel := "web"
+	_, err := storage.PatchCartMetadata(ctx, second.Id, map[string]*string{"channel": &channel})
+	require.NoError(t, err)
+
+	carts, err := storage.ViewCarts(ctx, []int{second.Id, 999, first.Id})
+	require.NoError(t, err)
+	require.Len(t, carts, 2)
+
+	assert.Equal(t, second.Id, carts[0].Id)
+	assert.Equal(t, "user-1", carts[0].UserId)
+	assert.Empty(t, carts[0].Items)
+	assert.Equal(t, map[string]string{"channel": "web"}, carts[0].Metadata)
+
+	assert.Equal(t, first.Id, carts[1].Id)
+	assert.Equal(t, 2, carts[1].Total)
+	require.Len(t, carts[1].Items, 2)
+	assert.Equal(t, "apples", carts[1].Items[0].Product)
+	assert.Equal(t, "pears", carts[1].Items[1].Product)
+}
+
+func TestMoveItem(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+	source, _ := storage.CreateCart(ctx, "")
+	target, _ := storage.CreateCart(ctx, "")
+	item, _ := storage.AddToCart(ctx, source.Id, models.CartItem{Product: "product", Quantity: 1})
+
+	_, err := storage.MoveItem(ctx, target.Id, item.Id, source.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+
+	moved, err := storage.MoveItem(ctx, source.Id, item.Id, target.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, target.Id, moved.CartId)
+
+	viewed, _ := storage.ViewCart(ctx, target.Id, models.ViewCartOptions{Limit: 50})
+	assert.Equal(t, []models.CartItem{moved}, viewed.Items)
+	viewed, _ = storage.ViewCart(ctx, source.Id, models.ViewCartOptions{Limit: 50})
+	assert.Empty(t, viewed.Items)
+}
+
+func TestCopyCartAndRemoveItems(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	added, err := storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 2}})
+	assert.NoError(t, err)
+
+	copied, err := storage.CopyCart(ctx, cart.Id, "user-1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, cart.Id, copied.Id)
+	owner, err := storage.CartOwner(ctx, copied.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", owner)
+	assert.Len(t, copied.Items, 2)
+	assert.Equal(t, "b", copied.Items[1].Product)
+
+	deleted, err := storage.RemoveItems(ctx, cart.Id, []int{added[0].Id, copied.Items[0].Id, 999})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{added[0].Id}, deleted)
+}
+
+func TestAddToCart_UniqueItems(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetSoftDelete(true)
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	first, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 2})
+	require.NoError(t, err)
+	merged, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 3})
+	require.NoError(t, err)
+	assert.Equal(t, first.Id, merged.Id)
+	assert.Equal(t, 5, merged.Quantity)
+
+	other, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Id, other.Id)
+
+	// A removed item no longer takes additions.
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, other.Id))
+	readded, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 4})
+	require.NoError(t, err)
+	assert.NotEqual(t, other.Id, readded.Id)
+	assert.Equal(t, 4, readded.Quantity)
+
+	got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	require.Len(t, got.Items, 2)
+	assert.Equal(t, 5, got.Items[0].Quantity)
+}
+
+func TestUniqueItems_BulkWritesConflict(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetSoftDelete(true)
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	_, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+
+	_, err = storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "pears", Quantity: 1}, {Product: "apples", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	_, err = storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "pears", Quantity: 1}, {Product: "pears", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Len(t, got.Items, 1, "a rejected batch adds nothing")
+
+	_, err = storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "plums", Quantity: 1}, {Product: "plums", Quantity: 2}})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	replaced, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "apples", Quantity: 2}, {Product: "pears", Quantity: 1}})
+	require.NoError(t, err)
+	require.Len(t, replaced.Items, 2)
+
+	// Restoring pears conflicts once another live item holds the product.
+	pears := replaced.Items[1]
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, pears.Id))
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 3})
+	require.NoError(t, err)
+	_, err = storage.RestoreItem(ctx, cart.Id, pears.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+}
+
+func TestUniqueItems_RenameAndMoveConflict(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	other, _ := storage.CreateCart(ctx, "")
+	apples, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+	pears, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+	require.NoError(t, err)
+
+	product := "apples"
+	_, err = storage.UpdateItem(ctx, cart.Id, pears.Id, models.ItemPatch{Product: &product})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	got, err := storage.GetItem(ctx, cart.Id, pears.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "pears", got.Product)
+
+	// Keeping the product of the item itself is no conflict.
+	quantity := 2
+	_, err = storage.UpdateItem(ctx, cart.Id, apples.Id, models.ItemPatch{Product: &product, Quantity: &quantity})
+	assert.NoError(t, err)
+
+	_, err = storage.AddToCart(ctx, other.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+	_, err = storage.MoveItem(ctx, cart.Id, apples.Id, other.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	got, err = storage.GetItem(ctx, cart.Id, apples.Id)
+	require.NoError(t, err)
+	assert.Equal(t, cart.Id, got.CartId)
+
+	_, err = storage.MoveItem(ctx, cart.Id, pears.Id, other.Id)
+	assert.NoError(t, err)
+}
+
+func TestAddToCart_UniqueItemsFullCart(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetUniqueItems(true)
+	storage.SetMaxItems(1)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	first, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 2})
+	require.NoError(t, err)
+	merged, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 3})
+	require.NoError(t, err, "merging doesn't grow a full cart")
+	assert.Equal(t, first.Id, merged.Id)
+	assert.Equal(t, 5, merged.Quantity)
+
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+}
+
+func TestAddToCart_UniqueItemsOverflow(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	nearMax := models.CartItem{Product: "apples", Quantity: models.MaxQuantity - 1}
+	first, err := storage.AddToCart(ctx, cart.Id, nearMax)
+	require.NoError(t, err)
+	_, err = storage.AddToCart(ctx, cart.Id, nearMax)
+	assert.ErrorIs(t, err, databaseerrors.ErrQuantityOverflow)
+
+	got, err := storage.GetItem(ctx, cart.Id, first.Id)
+	require.NoError(t, err)
+	assert.Equal(t, models.MaxQuantity-1, got.Quantity)
+}
+
+func TestDeleteExpiredCarts(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 1})
+
+	deleted, err := storage.DeleteExpiredCarts(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	deleted, err = storage.DeleteExpiredCarts(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+}
+
+func TestPurgeCart(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+
+	t.Run("Empty cart is deleted", func(t *testing.T) {
+		cart, _ := storage.CreateCart(ctx, "")
+		removed, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 1})
+		require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, removed.Id))
+
+		require.NoError(t, storage.PurgeCart(ctx, cart.Id))
+
+		_, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		_, err = storage.RestoreItem(ctx, cart.Id, removed.Id)
+		assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+	})
+
+	t.Run("Cart with items is kept", func(t *testing.T) {
+		cart, _ := storage.CreateCart(ctx, "")
+		_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 1})
+
+		err := storage.PurgeCart(ctx, cart.Id)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotEmpty)
+
+		got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+		require.NoError(t, err)
+		assert.Len(t, got.Items, 1)
+	})
+
+	t.Run("Missing cart", func(t *testing.T) {
+		err := storage.PurgeCart(ctx, 999)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+	})
+}
+
+func TestCartStats(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+	first, _ := storage.CreateCart(ctx, "")
+	second, _ := storage.CreateCart(ctx, "")
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "pears", Quantity: 1})
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "apples", Quantity: 2})
+	_, _ = storage.AddToCart(ctx, second.Id, models.CartItem{Product: "apples", Quantity: 3})
+	_, _ = storage.AddToCart(ctx, second.Id, models.CartItem{Product: "plums", Quantity: 1})
+	removed, _ := storage.AddToCart(ctx, second.Id, models.CartItem{Product: "plums", Quantity: 5})
+	assert.NoError(t, storage.RemoveFromCart(ctx, second.Id, removed.Id))
+
+	stats, err := storage.CartStats(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, models.CartStats{
+		Carts: 2,
+		Items: 4,
+		TopProducts: []models.ProductStats{
+			{Product: "apples", Items: 2, Quantity: 5},
+			{Product: "pears", Items: 1, Quantity: 1},
+		},
+	}, stats)
+}
+
+func TestWeightedItem(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	added, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1, Measure: models.MeasureWeight, Weight: 1.25, Unit: "kg"})
+	assert.NoError(t, err)
+
+	viewed, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.CartItem{added}, viewed.Items)
+	assert.Equal(t, 1.25, viewed.Items[0].Weight)
+}
+
+func TestPatchCartMetadata(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	channel := "web"
+
+	patched, err := storage.PatchCartMetadata(ctx, cart.Id, map[string]*string{"channel": &channel})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"channel": "web"}, patched.Metadata)
+
+	viewed, _ := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.Equal(t, map[string]string{"channel": "web"}, viewed.Metadata)
+
+	patched, err = storage.PatchCartMetadata(ctx, cart.Id, map[string]*string{"channel": nil})
+	assert.NoError(t, err)
+	assert.Nil(t, patched.Metadata)
+
+	_, err = storage.PatchCartMetadata(ctx, 42, map[string]*string{"channel": &channel})
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+}
+
+func TestSoftDelete(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	kept, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 1})
+	removed, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 1})
+
+	assert.NoError(t, storage.RemoveFromCart(ctx, cart.Id, removed.Id))
+
+	viewed, _ := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.Equal(t, []models.CartItem{kept}, viewed.Items)
+	assert.ErrorIs(t, storage.RemoveFromCart(ctx, cart.Id, removed.Id), databaseerrors.ErrNotFound)
+}
+
+func TestRestoreItem(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	first, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 1})
+	second, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 1})
+
+	assert.NoError(t, storage.RemoveFromCart(ctx, cart.Id, first.Id))
+	restored, err := storage.RestoreItem(ctx, cart.Id, first.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, first, restored)
+
+	viewed, _ := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.Equal(t, []models.CartItem{first, second}, viewed.Items)
+
+	_, err = storage.RestoreItem(ctx, cart.Id, first.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+	_, err = storage.RestoreItem(ctx, cart.Id, 99)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+}
+
+func TestCreateCarts(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+
+	ids, err := storage.CreateCarts(ctx, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ids)
+
+	for _, id := range ids {
+		_, err := storage.ViewCart(ctx, id, models.ViewCartOptions{Limit: 50})
+		assert.NoError(t, err)
+	}
+}
+
+func TestReplaceItems(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+
+	cart, _ := storage.CreateCart(ctx, "")
+	other, _ := storage.CreateCart(ctx, "")
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "old", Quantity: 1, Measure: models.MeasureCount})
+	kept, _ := storage.AddToCart(ctx, other.Id, models.CartItem{Product: "other", Quantity: 1, Measure: models.MeasureCount})
+
+	replaced, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{
+		{Product: "milk", Quantity: 2, Measure: models.MeasureCount},
+		{Product: "bread", Quantity: 1, Measure: models.MeasureCount},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, replaced.Total)
+	require.Len(t, replaced.Items, 2)
+	assert.Equal(t, "milk", replaced.Items[0].Product)
+	assert.Equal(t, "bread", replaced.Items[1].Product)
+
+	viewed, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, replaced.Items, viewed.Items)
+
+	viewed, err = storage.ViewCart(ctx, other.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, []models.CartItem{kept}, viewed.Items)
+
+	emptied, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{})
+	require.NoError(t, err)
+	assert.Empty(t, emptied.Items)
+
+	_, err = storage.ReplaceItems(ctx, 42, []models.CartItem{})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+}
+
+func TestAddToCart_MaxItems(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetMaxItems(2)
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "a", Quantity: 1})
+	require.NoError(t, err)
+	second, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "b", Quantity: 1})
+	require.NoError(t, err)
+
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "c", Quantity: 1})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+
+	// Removed items no longer count against the limit.
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, second.Id))
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "c", Quantity: 1})
+	assert.NoError(t, err)
+}
+
+func TestAddItems_MaxItems(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetMaxItems(3)
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "a", Quantity: 1})
+	require.NoError(t, err)
+
+	_, err = storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}, {Product: "d", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+	got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Len(t, got.Items, 1, "a rejected batch adds nothing")
+
+	added, err := storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}})
+	require.NoError(t, err)
+	assert.Len(t, added, 2)
+
+	_, err = storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}, {Product: "d", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+	replaced, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}})
+	require.NoError(t, err)
+	assert.Len(t, replaced.Items, 3)
+}
+
+func TestMoveItem_MaxItems(t *testing.T) {
+	storage := newTestStorage()
+	storage.SetMaxItems(1)
+	ctx := context.Background()
+	source, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	target, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	item, err := storage.AddToCart(ctx, source.Id, models.CartItem{Product: "a", Quantity: 1})
+	require.NoError(t, err)
+
+	moved, err := storage.MoveItem(ctx, source.Id, item.Id, target.Id)
+	require.NoError(t, err)
+	assert.Equal(t, target.Id, moved.CartId)
+
+	other, err := storage.AddToCart(ctx, source.Id, models.CartItem{Product: "b", Quantity: 1})
+	require.NoError(t, err)
+	_, err = storage.MoveItem(ctx, source.Id, other.Id, target.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+	got, err := storage.GetItem(ctx, source.Id, other.Id)
+	require.NoError(t, err)
+	assert.Equal(t, source.Id, got.CartId)
+}
+
+func TestGetItem(t *testing.T) {
+	storage := newTestStorage()
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	other, _ := storage.CreateCart(ctx, "")
+	item, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 3})
+
+	got, err := storage.GetItem(ctx, cart.Id, item.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, item, got)
+
+	_, err = storage.GetItem(ctx, other.Id, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+
+	_, err = storage.GetItem(ctx, 999, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, item.Id))
+	_, err = storage.GetItem(ctx, cart.Id, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+}
@@ -0,0 +1,33 @@
+package psql
+
+import (
+	databaseerrors "cartapi/internal/database"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+const (
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+)
+
+// translateError maps constraint violations reported by Postgres to the
+// storage-level sentinel errors. Any other error is returned unchanged.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code {
+	case pqUniqueViolation:
+		return databaseerrors.ErrConflict
+	case pqForeignKeyViolation:
+		// item_cart_id_fkey is the only foreign key: the cart of an item
+		// was deleted while the item was written.
+		return databaseerrors.ErrCartNotFound
+	default:
+		return err
+	}
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE item
+    ADD COLUMN measure VARCHAR(16) NOT NULL DEFAULT 'count',
+    ADD COLUMN weight NUMERIC(12, 3) NOT NULL DEFAULT 0,
+    ADD COLUMN unit VARCHAR(16) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE item
+    DROP COLUMN unit,
+    DROP COLUMN weight,
+    DROP COLUMN measure;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Carts that already hold the same product twice keep working without the
+-- index. 20251014170000_item_cart_product_optional drops it again; the
+-- service manages it at startup instead.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM item
+        WHERE deleted_at IS NULL
+        GROUP BY cart_id, product
+        HAVING COUNT(*) > 1
+    ) THEN
+        RAISE NOTICE 'duplicate items found, item_cart_product_key not created';
+    ELSE
+        CREATE UNIQUE INDEX item_cart_product_key ON item (cart_id, product) WHERE deleted_at IS NULL;
+    END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS item_cart_product_key;
+-- +goose StatementEnd
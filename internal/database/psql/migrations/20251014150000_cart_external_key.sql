@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE cart ADD COLUMN external_key TEXT;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE UNIQUE INDEX cart_external_key_key ON cart (external_key);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX cart_external_key_key;
+-- +goose StatementEnd
+-- +goose StatementBegin
+ALTER TABLE cart DROP COLUMN external_key;
+-- +goose StatementEnd
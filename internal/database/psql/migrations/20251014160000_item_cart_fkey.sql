@@ -0,0 +1,11 @@
+-- +goose Up
+-- NOT VALID leaves items of carts deleted before this migration alone;
+-- every item written from now on must point at an existing cart.
+-- +goose StatementBegin
+ALTER TABLE item ADD CONSTRAINT item_cart_id_fkey FOREIGN KEY (cart_id) REFERENCES cart (id) NOT VALID;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE item DROP CONSTRAINT item_cart_id_fkey;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- item_cart_product_key rejects a second item of the same product even with
+-- unique_items off. The service now creates it at startup only when
+-- unique_items is on, see Storage.SyncUniqueItemsIndex.
+-- +goose StatementBegin
+DROP INDEX IF EXISTS item_cart_product_key;
+-- +goose StatementEnd
+
+-- +goose Down
+-- Nothing to undo: the service recreates the index when unique_items is on.
@@ -0,0 +1,70 @@
+package psql_test
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cartapi/internal/database/psql"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsEmbedded(t *testing.T) {
+	files, err := fs.Glob(psql.Migrations, "migrations/*.sql")
+	assert.NoError(t, err)
+	assert.Contains(t, files, "migrations/20250806081559_cartapi_db_schema.sql")
+
+	content, err := fs.ReadFile(psql.Migrations, "migrations/20250806081559_cartapi_db_schema.sql")
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "-- +goose Up")
+}
+
+func TestMigrateStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock database: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version_id, is_applied from goose_db_version ORDER BY id DESC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"version_id", "is_applied"}).
+			AddRow(20250806081559, true).
+			AddRow(0, true))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT tstamp, is_applied FROM goose_db_version WHERE version_id=$1 ORDER BY tstamp DESC LIMIT 1`)).
+		WithArgs(20250806081559).
+		WillReturnRows(sqlmock.NewRows([]string{"tstamp", "is_applied"}).AddRow(time.Now(), true))
+	// Every later migration is reported as pending.
+	files, err := fs.Glob(psql.Migrations, "migrations/*.sql")
+	assert.NoError(t, err)
+	for _, file := range files[1:] {
+		prefix, _, _ := strings.Cut(path.Base(file), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		assert.NoError(t, err)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT tstamp, is_applied FROM goose_db_version WHERE version_id=$1 ORDER BY tstamp DESC LIMIT 1`)).
+			WithArgs(version).
+			WillReturnRows(sqlmock.NewRows([]string{"tstamp", "is_applied"}))
+	}
+
+	assert.NoError(t, psql.MigrateStatus(db))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateDown_NothingToRollBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open sqlmock database: %s", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version_id, is_applied from goose_db_version ORDER BY id DESC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"version_id", "is_applied"}).AddRow(0, true))
+
+	assert.NoError(t, psql.MigrateDown(db, 3))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
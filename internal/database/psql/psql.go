@@ -2,58 +2,272 @@ package psql
 
 import (
 	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/database/sqllog"
 	"cartapi/internal/models"
+	"cartapi/internal/tracing"
 	"cartapi/pkg/lib/logger/sl"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
-
-	_ "github.com/lib/pq"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pressly/goose/v3"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Storage struct {
-	log *slog.Logger
-	db  *sqlx.DB
+	log          *slog.Logger
+	db           *sqlx.DB
+	softDelete   bool
+	queryTimeout time.Duration
+	maxItems     int
+	uniqueItems  bool
+	slowQuery    time.Duration
+	sqlLog       bool
+	redactSQL    bool
+	tracer       trace.Tracer
+}
+
+// Retry bounds the connection attempts made at startup. Attempts below one
+// mean a single attempt; the wait starts at Backoff and doubles after every
+// failed attempt, up to maxConnectBackoff.
+type Retry struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+const maxConnectBackoff = 30 * time.Second
+
+// Opener opens and pings a database.
+type Opener func(connStr string) (*sqlx.DB, error)
+
+func openPostgres(connStr string) (*sqlx.DB, error) {
+	return sqlx.Connect("postgres", connStr)
 }
 
-func New(log *slog.Logger, connStr string) (*Storage, error) {
+func New(log *slog.Logger, connStr string, retry Retry) (*Storage, error) {
 	const op = "database.psql.New"
-	db, err := sqlx.Connect("postgres", connStr)
+	db, err := Connect(log, openPostgres, connStr, retry)
 	if err != nil {
 		log.With("op", op).Error("Error connect to database", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	wd, err := os.Getwd()
-	if err != nil {
-		log.With("op", op).Error("Error getting work dir", sl.Err(err))
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
-	migrationsPath := filepath.Join(wd, "migrations")
-
-	if err := goose.Up(db.DB, migrationsPath); err != nil {
+	if err := MigrateUp(db.DB); err != nil {
 		log.With("op", op).Error("Error applying migrations", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
 	return &Storage{
-		log: log,
-		db:  db,
+		log:    log,
+		db:     db,
+		tracer: tracing.Noop(),
 	}, nil
 }
 
+// Connect calls open until it succeeds or retry runs out of attempts, so
+// that the app can start before the database is ready to accept connections.
+func Connect(log *slog.Logger, open Opener, connStr string, retry Retry) (*sqlx.DB, error) {
+	const op = "database.psql.Connect"
+	log = log.With("op", op)
+
+	attempts := max(retry.Attempts, 1)
+	backoff := retry.Backoff
+	for attempt := 1; ; attempt++ {
+		db, err := open(connStr)
+		if err == nil {
+			return db, nil
+		}
+		if attempt == attempts {
+			return nil, fmt.Errorf("%s: %d attempts failed: %w", op, attempts, err)
+		}
+
+		log.Warn("Database is not reachable, retrying",
+			slog.Int("attempt", attempt),
+			slog.Int("attempts", attempts),
+			slog.Duration("backoff", backoff),
+			sl.Err(err),
+		)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
+
 func NewWithParams(log *slog.Logger, db *sqlx.DB) *Storage {
 	return &Storage{
-		log: log,
-		db:  db,
+		log:    log,
+		db:     db,
+		tracer: tracing.Noop(),
+	}
+}
+
+// SetSoftDelete makes removed items get a deleted_at timestamp instead of
+// being deleted. It must be called before the storage is used.
+func (s *Storage) SetSoftDelete(enabled bool) {
+	s.softDelete = enabled
+}
+
+// SetQueryTimeout bounds every storage call by d on top of the caller's
+// context, so queries are capped even for requests without a deadline. Zero
+// leaves them unbounded. It must be called before the storage is used.
+func (s *Storage) SetQueryTimeout(d time.Duration) {
+	s.queryTimeout = d
+}
+
+// SetMaxItems makes every write that adds items to a cart (AddToCart,
+// AddItems, ReplaceItems, CopyCart, MoveItem and RestoreItem) refuse to grow
+// it past n items; zero means no limit. It must be called before the
+// storage is used.
+func (s *Storage) SetMaxItems(n int) {
+	s.maxItems = n
+}
+
+// SetUniqueItems makes AddToCart merge an item into the live item of the
+// same product, adding up their quantities and weights, instead of inserting
+// a duplicate. The other writes don't merge: AddItems, ReplaceItems,
+// RestoreItem, UpdateItem and MoveItem fail with ErrConflict when they would
+// leave a cart holding a product twice. It relies on the item_cart_product_key index, which
+// SyncUniqueItemsIndex creates. It must be called before the storage is
+// used.
+func (s *Storage) SetUniqueItems(enabled bool) {
+	s.uniqueItems = enabled
+}
+
+// SetSlowQueryThreshold makes every storage call taking at least d log a
+// warning. Zero disables the warning. It must be called before the storage
+// is used.
+func (s *Storage) SetSlowQueryThreshold(d time.Duration) {
+	s.slowQuery = d
+}
+
+// logSlow warns when the call that started at start took at least the slow
+// query threshold.
+func (s *Storage) logSlow(log *slog.Logger, start time.Time) {
+	if s.slowQuery <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= s.slowQuery {
+		log.Warn("Slow query", slog.Duration("elapsed", elapsed), slog.Duration("threshold", s.slowQuery))
+	}
+}
+
+// withQueryTimeout derives the context the queries of one call run under.
+// Canceling ctx still cancels them.
+func (s *Storage) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// SetSQLLog makes every statement be logged with its arguments at debug
+// level; with redactProducts the text arguments are hidden. It must be
+// called before the storage is used.
+func (s *Storage) SetSQLLog(enabled bool, redactProducts bool) {
+	s.sqlLog = enabled
+	s.redactSQL = redactProducts
+}
+
+// logged is q, logging its statements to log when SetSQLLog enabled it.
+func (s *Storage) logged(log *slog.Logger, q sqllog.Queryer) sqllog.Queryer {
+	if !s.sqlLog {
+		return q
+	}
+	return sqllog.Wrap(log, q, s.redactSQL)
+}
+
+// SetTracer sets the tracer recording a span per storage call. It must be
+// called before the storage is used.
+func (s *Storage) SetTracer(tracer trace.Tracer) {
+	s.tracer = tracer
+}
+
+// Ping checks that the database answers.
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "database.psql.Ping"
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		s.log.With("op", op).Error("Database is not reachable", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// CurrentMigrationVersion returns the version of the last migration applied
+// to the database.
+func (s *Storage) CurrentMigrationVersion(ctx context.Context) (int64, error) {
+	const op = "database.psql.CurrentMigrationVersion"
+	log := s.log.With("op", op)
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	if err := setupMigrations(); err != nil {
+		log.Error("Failed to set up migrations", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	version, err := goose.GetDBVersionContext(ctx, s.db.DB)
+	if err != nil {
+		log.Error("Failed to get migration version", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return version, nil
+}
+
+// SyncUniqueItemsIndex creates the item_cart_product_key index when unique
+// items are on and drops it otherwise, so that duplicate products are only
+// rejected when they should be. Creating it fails while a cart holds the
+// same product twice.
+func (s *Storage) SyncUniqueItemsIndex(ctx context.Context) error {
+	const op = "database.psql.SyncUniqueItemsIndex"
+	log := s.log.With("op", op)
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	query := `DROP INDEX IF EXISTS item_cart_product_key;`
+	if s.uniqueItems {
+		query = `CREATE UNIQUE INDEX IF NOT EXISTS item_cart_product_key ON item (cart_id, product) WHERE deleted_at IS NULL;`
+	}
+	if _, err := s.logged(log, s.db).ExecContext(ctx, query); err != nil {
+		if errors.Is(translateError(err), databaseerrors.ErrConflict) {
+			log.Error("Carts hold duplicate products, remove them before enabling unique items", sl.Err(err))
+			return fmt.Errorf("%s: carts hold duplicate products: %w", op, err)
+		}
+		log.Error("Failed to set up the unique items index", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// HasUniqueItemsIndex reports whether the item_cart_product_key index that
+// SetUniqueItems relies on exists.
+func (s *Storage) HasUniqueItemsIndex(ctx context.Context) (bool, error) {
+	const op = "database.psql.HasUniqueItemsIndex"
+	log := s.log.With("op", op)
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var exists bool
+	if err := s.db.QueryRowxContext(ctx, `SELECT to_regclass('item_cart_product_key') IS NOT NULL;`).Scan(&exists); err != nil {
+		log.Error("Failed to look up the unique items index", sl.Err(err))
+		return false, fmt.Errorf("%s: %w", op, err)
 	}
+	return exists, nil
 }
 
 func (s *Storage) Close() error {
@@ -63,9 +277,39 @@ func (s *Storage) Close() error {
 	return nil
 }
 
-func (s *Storage) CreateCart(ctx context.Context) (models.Cart, error) {
+// closePollInterval is how often CloseGraceful checks for queries still
+// holding a connection.
+const closePollInterval = 10 * time.Millisecond
+
+// CloseGraceful waits for the connections in use to be returned to the pool,
+// so that running queries can finish, then closes it. When ctx is done first
+// the pool is closed anyway.
+func (s *Storage) CloseGraceful(ctx context.Context) error {
+	const op = "database.psql.CloseGraceful"
+	log := s.log.With("op", op)
+
+	ticker := time.NewTicker(closePollInterval)
+	defer ticker.Stop()
+	for inUse := s.db.Stats().InUse; inUse > 0; inUse = s.db.Stats().InUse {
+		select {
+		case <-ctx.Done():
+			log.Warn("Closing with queries in flight", slog.Int("in_use", inUse), sl.Err(ctx.Err()))
+			return s.Close()
+		case <-ticker.C:
+		}
+	}
+	return s.Close()
+}
+
+func (s *Storage) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+func (s *Storage) CreateCart(ctx context.Context, userId string) (models.Cart, error) {
 	const op = "database.psql.CreateCart"
 	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
@@ -74,23 +318,139 @@ func (s *Storage) CreateCart(ctx context.Context) (models.Cart, error) {
 	default:
 	}
 
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
 	var cartId int
-	err := s.db.QueryRowxContext(ctx, `
-        INSERT INTO cart
-        DEFAULT VALUES
+	err := s.logged(log, s.db).QueryRowxContext(ctx, `
+        INSERT INTO cart (user_id)
+        VALUES (NULLIF($1, ''))
         RETURNING id;
-    `).Scan(&cartId)
+    `, userId).Scan(&cartId)
 	if err != nil {
 		log.Error("Error creating cart", sl.Err(err))
 		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return models.Cart{Id: cartId}, nil
+	return models.Cart{Id: cartId, UserId: userId}, nil
+}
+
+// GetOrCreateCart returns the cart created for the external key, creating
+// it for userId if there is none yet; created tells which happened.
+// Concurrent calls with the same key end up with the same cart, the unique
+// index making all but one insert do nothing.
+func (s *Storage) GetOrCreateCart(ctx context.Context, key string, userId string) (models.Cart, bool, error) {
+	const op = "database.psql.GetOrCreateCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, false, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var cartId int
+	err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		INSERT INTO cart (user_id, external_key)
+		VALUES (NULLIF($1, ''), $2)
+		ON CONFLICT (external_key) DO NOTHING
+		RETURNING id;
+	`, userId, key).Scan(&cartId)
+	if err == nil {
+		return models.Cart{Id: cartId, UserId: userId}, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Error("Error creating cart", sl.Err(err))
+		return models.Cart{}, false, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	var owner string
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		SELECT id, COALESCE(user_id, '') FROM cart WHERE external_key=$1;
+	`, key).Scan(&cartId, &owner); err != nil {
+		log.Error("Failed to get cart by key", sl.Err(err))
+		return models.Cart{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{Id: cartId, UserId: owner}, false, nil
+}
+
+func (s *Storage) CartOwner(ctx context.Context, cartId int) (string, error) {
+	const op = "database.psql.CartOwner"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return "", fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var userId sql.NullString
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `SELECT user_id FROM cart WHERE id=$1;`, cartId).Scan(&userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return "", fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to get cart owner", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return userId.String, nil
+}
+
+// CreateCarts creates n empty carts with a single statement, so either all
+// of them are created or none, and returns their ids in ascending order.
+func (s *Storage) CreateCarts(ctx context.Context, n int) ([]int, error) {
+	const op = "database.psql.CreateCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var ids []int
+	if err := sqlx.SelectContext(ctx, s.logged(log, s.db), &ids, `
+		INSERT INTO cart (created_at)
+		SELECT now() FROM generate_series(1, $1)
+		RETURNING id;
+	`, n); err != nil {
+		log.Error("Error creating carts", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	slices.Sort(ids)
+
+	return ids, nil
 }
 
 func (s *Storage) AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error) {
 	const op = "database.psql.AddToCart"
 	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
@@ -99,37 +459,87 @@ func (s *Storage) AddToCart(ctx context.Context, cartId int, item models.CartIte
 	default:
 	}
 
-	tx, err := s.db.Beginx()
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		log.Error("Failed to begin transaction", sl.Err(err))
 		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
-	var existsChecker int
-	if err = tx.QueryRowxContext(ctx, `SELECT id FROM cart WHERE id=$1;`, cartId).Scan(&existsChecker); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrNotFound))
-			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrNotFound)
-		}
+	exists, err := cartExists(ctx, s.logged(log, tx), cartId)
+	if err != nil {
 		log.Error("Error checking cart existence", sl.Err(err))
 		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
 	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	if s.maxItems > 0 {
+		full, err := cartFull(ctx, s.logged(log, tx), cartId, s.maxItems, 1)
+		if err != nil {
+			log.Error("Failed to count cart items", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if full && s.uniqueItems {
+			// Merging into the live item of the same product doesn't grow
+			// the cart.
+			var merging bool
+			if err := s.logged(log, tx).QueryRowxContext(ctx, `
+				SELECT EXISTS (SELECT 1 FROM item WHERE cart_id=$1 AND product=$2 AND deleted_at IS NULL);
+			`, cartId, item.Product).Scan(&merging); err != nil {
+				log.Error("Failed to look up item of the same product", sl.Err(err))
+				return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+			}
+			full = !merging
+		}
+		if full {
+			log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+		}
+	}
 
 	var itemId int
-	row := tx.QueryRowxContext(ctx, `
-		INSERT INTO item (cart_id, product, quantity)
-		VALUES ($1, $2, $3)
-		RETURNING id;
-  `, cartId, item.Product, item.Quantity)
-	if err := row.Scan(&itemId); err != nil {
+	if s.uniqueItems {
+		err = s.logged(log, tx).QueryRowxContext(ctx, `
+			INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (cart_id, product) WHERE deleted_at IS NULL
+			DO UPDATE SET quantity = item.quantity + EXCLUDED.quantity, weight = item.weight + EXCLUDED.weight
+			WHERE item.quantity::bigint + EXCLUDED.quantity <= $7
+			RETURNING id, quantity, measure, weight, unit;
+		`, cartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit, models.MaxQuantity).Scan(&itemId, &item.Quantity, &item.Measure, &item.Weight, &item.Unit)
+		// The update is skipped, returning no row, when the merged quantity
+		// wouldn't fit.
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Merged quantity overflows", sl.Err(databaseerrors.ErrQuantityOverflow))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrQuantityOverflow)
+		}
+	} else {
+		err = s.logged(log, tx).QueryRowxContext(ctx, `
+			INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id;
+		`, cartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit).Scan(&itemId)
+	}
+	if err != nil {
+		err = translateError(err)
+		if errors.Is(err, databaseerrors.ErrConflict) || errors.Is(err, databaseerrors.ErrNotFound) {
+			log.Warn("Constraint violation on item insert", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
 		log.Error("Failed to insert item", sl.Err(err))
 		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
 	}
 
 	if err := tx.Commit(); err != nil {
 		log.Error("Failed to commit transaction", sl.Err(err))
-		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
 	}
 
 	return models.CartItem{
@@ -137,12 +547,17 @@ func (s *Storage) AddToCart(ctx context.Context, cartId int, item models.CartIte
 		CartId:   cartId,
 		Product:  item.Product,
 		Quantity: item.Quantity,
+		Measure:  item.Measure,
+		Weight:   item.Weight,
+		Unit:     item.Unit,
 	}, nil
 }
 
 func (s *Storage) RemoveFromCart(ctx context.Context, cartId int, itemId int) error {
 	const op = "database.psql.RemoveFromCart"
 	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
@@ -151,94 +566,1275 @@ func (s *Storage) RemoveFromCart(ctx context.Context, cartId int, itemId int) er
 	default:
 	}
 
-	tx, err := s.db.Beginx()
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		log.Error("Failed to begin transaction", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
-	var existsChecker int
-	if err = tx.QueryRowxContext(ctx, `SELECT id FROM cart WHERE id=$1;`, cartId).Scan(&existsChecker); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrNotFound))
-			return fmt.Errorf("%s: %w", op, databaseerrors.ErrNotFound)
-		}
+	exists, err := cartExists(ctx, s.logged(log, tx), cartId)
+	if err != nil {
 		log.Error("Error checking cart existence", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
 
-	var itemCartId int
-	if err = tx.QueryRowxContext(ctx, `SELECT cart_id FROM item WHERE id=$1;`, itemId).Scan(&itemCartId); err != nil {
+	// An item of another cart is as missing as one that doesn't exist.
+	var id int
+	if err = s.logged(log, tx).QueryRowxContext(ctx, `SELECT id FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`, itemId, cartId).Scan(&id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrNotFound))
-			return fmt.Errorf("%s: %w", op, databaseerrors.ErrNotFound)
+			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
 		}
 		log.Error("Error checking cart item existence", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	if _, err := tx.ExecContext(ctx, `DELETE FROM item WHERE id=$1;`, itemId); err != nil {
+	removeQuery := `DELETE FROM item WHERE id=$1 AND cart_id=$2;`
+	if s.softDelete {
+		removeQuery = `UPDATE item SET deleted_at=now() WHERE id=$1 AND cart_id=$2;`
+	}
+	res, err := s.logged(log, tx).ExecContext(ctx, removeQuery, itemId, cartId)
+	if err != nil {
+		err = translateError(err)
+		if errors.Is(err, databaseerrors.ErrConflict) || errors.Is(err, databaseerrors.ErrNotFound) {
+			log.Warn("Constraint violation on item delete", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
 		log.Error("Failed to delete item", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
 
 	if err := tx.Commit(); err != nil {
 		log.Error("Failed to commit transaction", sl.Err(err))
-		return fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, translateError(err))
 	}
 
 	return nil
 }
 
-func (s *Storage) ViewCart(ctx context.Context, cartId int) (models.Cart, error) {
-	const op = "database.psql.ViewCart"
+// GetItem returns a live item of the cart.
+func (s *Storage) GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "database.psql.GetItem"
 	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
 		log.Error("Context is over", sl.Err(ctx.Err()))
-		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
 	default:
 	}
 
-	var count int
-	row := s.db.QueryRowContext(ctx, `
-		SELECT COUNT(*) FROM cart WHERE id=$1;
-	`, cartId)
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
 
-	if err := row.Scan(&count); err != nil {
-		log.Error("Failed to check cart existence", sl.Err(err))
-		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	exists, err := cartExists(ctx, s.logged(log, s.db), cartId)
+	if err != nil {
+		log.Error("Error checking cart existence", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+	var item models.CartItem
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+		WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;
+	`, itemId, cartId).Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+		log.Error("Failed to get item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if count == 0 {
-		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrNotFound))
-		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrNotFound)
+	return item, nil
+}
+
+// ItemIds returns the ids of the live items of the cart in id order,
+// without reading the rest of their columns.
+func (s *Storage) ItemIds(ctx context.Context, cartId int) ([]int, error) {
+	const op = "database.psql.ItemIds"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
 	}
 
-	rows, err := s.db.QueryxContext(ctx, `
-	SELECT id, cart_id, product, quantity FROM item
-	WHERE cart_id=$1;
-`, cartId)
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	exists, err := cartExists(ctx, s.logged(log, s.db), cartId)
 	if err != nil {
-		log.Error("Failed to query items", sl.Err(err))
-		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		log.Error("Error checking cart existence", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
 	}
-	defer rows.Close()
 
-	var itemsByCartId []models.CartItem
-	for rows.Next() {
-		var tmpItem models.CartItem
-		if err := rows.Scan(&tmpItem.Id, &tmpItem.CartId, &tmpItem.Product, &tmpItem.Quantity); err != nil {
-			log.Error("Failed to scan row", sl.Err(err))
-			continue
+	itemIds := []int{}
+	if err := sqlx.SelectContext(ctx, s.logged(log, s.db), &itemIds, `
+		SELECT id FROM item WHERE cart_id=$1 AND deleted_at IS NULL ORDER BY id;
+	`, cartId); err != nil {
+		log.Error("Failed to get item ids", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return itemIds, nil
+}
+
+// UpdateItem changes only the columns of the fields set in patch.
+func (s *Storage) UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error) {
+	const op = "database.psql.UpdateItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	exists, err := cartExists(ctx, s.logged(log, s.db), cartId)
+	if err != nil {
+		log.Error("Error checking cart existence", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	// Only the column names come from here; every value is a parameter.
+	var sets []string
+	var args []any
+	if patch.Product != nil {
+		args = append(args, *patch.Product)
+		sets = append(sets, fmt.Sprintf("product=$%d", len(args)))
+	}
+	if patch.Quantity != nil {
+		args = append(args, *patch.Quantity)
+		sets = append(sets, fmt.Sprintf("quantity=$%d", len(args)))
+	}
+	if len(sets) == 0 {
+		return s.GetItem(ctx, cartId, itemId)
+	}
+	query := fmt.Sprintf(`
+		UPDATE item SET %s
+		WHERE id=$%d AND cart_id=$%d AND deleted_at IS NULL
+		RETURNING id, cart_id, product, quantity, measure, weight, unit;
+	`, strings.Join(sets, ", "), len(args)+1, len(args)+2)
+	args = append(args, itemId, cartId)
+
+	var item models.CartItem
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, query, args...).Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
 		}
-		itemsByCartId = append(itemsByCartId, tmpItem)
+		log.Error("Failed to update item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
 	}
 
-	return models.Cart{
-		Id:    cartId,
-		Items: itemsByCartId,
-	}, nil
+	return item, nil
 }
+
+// UpdateQuantities sets the quantity of every item in updates in one
+// transaction. Nothing is changed when any of the items is not a live item
+// of the cart.
+func (s *Storage) UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error) {
+	const op = "database.psql.UpdateQuantities"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `SELECT id FROM cart WHERE id=$1 FOR UPDATE;`, cartId).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to lock cart", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	items := make([]models.CartItem, 0, len(updates))
+	for _, update := range updates {
+		var item models.CartItem
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			UPDATE item SET quantity=$1
+			WHERE id=$2 AND cart_id=$3 AND deleted_at IS NULL
+			RETURNING id, cart_id, product, quantity, measure, weight, unit;
+		`, update.Quantity, update.ItemId, cartId).Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Warn("Cart item doesn't exist", slog.Int("item_id", update.ItemId), sl.Err(databaseerrors.ErrItemNotFound))
+				return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+			}
+			log.Error("Failed to update item", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+		items = append(items, item)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return items, nil
+}
+
+func (s *Storage) ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error) {
+	const op = "database.psql.ViewCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var rawMetadata []byte
+	row := s.logged(log, s.db).QueryRowContext(ctx, `
+		SELECT metadata FROM cart WHERE id=$1;
+	`, cartId)
+
+	if err := row.Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to check cart existence", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The product filter is always bound as a parameter; only the fixed
+	// condition text is added to the query.
+	filter := "WHERE cart_id=$1 AND deleted_at IS NULL"
+	args := []any{cartId}
+	if opts.Product != "" {
+		filter += " AND product ILIKE '%' || $2 || '%'"
+		args = append(args, escapeLike(opts.Product))
+	}
+
+	var total int
+	if err := s.logged(log, s.db).QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM item `+filter+`;
+	`, args...).Scan(&total); err != nil {
+		log.Error("Failed to count items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.logged(log, s.db).QueryxContext(ctx, fmt.Sprintf(`
+	SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+	%s
+	ORDER BY id
+	LIMIT $%d OFFSET $%d;
+`, filter, len(args)+1, len(args)+2), append(args, opts.Limit, opts.Offset)...)
+	if err != nil {
+		log.Error("Failed to query items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var itemsByCartId []models.CartItem
+	for rows.Next() {
+		var tmpItem models.CartItem
+		if err := rows.Scan(&tmpItem.Id, &tmpItem.CartId, &tmpItem.Product, &tmpItem.Quantity, &tmpItem.Measure, &tmpItem.Weight, &tmpItem.Unit); err != nil {
+			log.Error("Failed to scan row", sl.Err(err))
+			continue
+		}
+		itemsByCartId = append(itemsByCartId, tmpItem)
+	}
+
+	return models.Cart{
+		Id:       cartId,
+		Items:    itemsByCartId,
+		Total:    total,
+		Metadata: metadata,
+	}, nil
+}
+
+// ViewCarts loads the carts with one query and all of their items with
+// another, instead of one ViewCart per cart.
+func (s *Storage) ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error) {
+	const op = "database.psql.ViewCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	cartRows, err := s.logged(log, s.db).QueryxContext(ctx, `
+		SELECT id, user_id, metadata FROM cart WHERE id = ANY($1);
+	`, pq.Array(cartIds))
+	if err != nil {
+		log.Error("Failed to query carts", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer cartRows.Close()
+
+	found := make(map[int]*models.Cart, len(cartIds))
+	for cartRows.Next() {
+		var (
+			cart        models.Cart
+			userId      sql.NullString
+			rawMetadata []byte
+		)
+		if err := cartRows.Scan(&cart.Id, &userId, &rawMetadata); err != nil {
+			log.Error("Failed to scan cart", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		cart.UserId = userId.String
+		if cart.Metadata, err = decodeMetadata(rawMetadata); err != nil {
+			log.Error("Failed to decode cart metadata", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		found[cart.Id] = &cart
+	}
+	if err := cartRows.Err(); err != nil {
+		log.Error("Failed to read carts", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(found) > 0 {
+		itemRows, err := s.logged(log, s.db).QueryxContext(ctx, `
+			SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+			WHERE cart_id = ANY($1) AND deleted_at IS NULL
+			ORDER BY cart_id, id;
+		`, pq.Array(cartIds))
+		if err != nil {
+			log.Error("Failed to query items", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		defer itemRows.Close()
+
+		for itemRows.Next() {
+			var item models.CartItem
+			if err := itemRows.Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+				log.Error("Failed to scan row", sl.Err(err))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			if cart, ok := found[item.CartId]; ok {
+				cart.Items = append(cart.Items, item)
+				cart.Total++
+			}
+		}
+		if err := itemRows.Err(); err != nil {
+			log.Error("Failed to read items", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	carts := make([]models.Cart, 0, len(found))
+	for _, id := range cartIds {
+		if cart, ok := found[id]; ok {
+			carts = append(carts, *cart)
+			delete(found, id)
+		}
+	}
+	return carts, nil
+}
+
+func (s *Storage) StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error) {
+	const op = "database.psql.StreamCartItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var rawMetadata []byte
+	if err := s.logged(log, s.db).QueryRowContext(ctx, `SELECT metadata FROM cart WHERE id=$1;`, cartId).Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to check cart existence", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	filter := "WHERE cart_id=$1 AND deleted_at IS NULL"
+	args := []any{cartId}
+	if product != "" {
+		filter += " AND product ILIKE '%' || $2 || '%'"
+		args = append(args, escapeLike(product))
+	}
+
+	rows, err := s.logged(log, s.db).QueryxContext(ctx, `
+		SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+		`+filter+`
+		ORDER BY id;
+	`, args...)
+	if err != nil {
+		log.Error("Failed to query items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	total := 0
+	for rows.Next() {
+		var item models.CartItem
+		if err := rows.Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+			log.Error("Failed to scan row", sl.Err(err))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := yield(item); err != nil {
+			return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		}
+		total++
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Failed to read items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{
+		Id:       cartId,
+		Total:    total,
+		Metadata: metadata,
+	}, nil
+}
+
+func (s *Storage) MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error) {
+	const op = "database.psql.MoveItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range []int{cartId, targetCartId} {
+		exists, err := cartExists(ctx, s.logged(log, tx), id)
+		if err != nil {
+			log.Error("Error checking cart existence", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if !exists {
+			log.Warn("Cart doesn't exist", slog.Int("cart_id", id), sl.Err(databaseerrors.ErrCartNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+	}
+
+	var itemCartId int
+	if err = s.logged(log, tx).QueryRowxContext(ctx, `SELECT cart_id FROM item WHERE id=$1 AND deleted_at IS NULL;`, itemId).Scan(&itemCartId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+		log.Error("Error checking cart item existence", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if itemCartId != cartId {
+		log.Warn("Cart item doesn't belong to cart", sl.Err(databaseerrors.ErrItemNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
+
+	if s.maxItems > 0 && targetCartId != cartId {
+		full, err := cartFull(ctx, s.logged(log, tx), targetCartId, s.maxItems, 1)
+		if err != nil {
+			log.Error("Failed to count cart items", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if full {
+			log.Warn("Target cart is full", sl.Err(databaseerrors.ErrCartFull))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+		}
+	}
+
+	var moved models.CartItem
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `
+		UPDATE item SET cart_id=$1
+		WHERE id=$2
+		RETURNING id, cart_id, product, quantity, measure, weight, unit;
+	`, targetCartId, itemId).Scan(&moved.Id, &moved.CartId, &moved.Product, &moved.Quantity, &moved.Measure, &moved.Weight, &moved.Unit); err != nil {
+		log.Error("Failed to move item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return moved, nil
+}
+
+func (s *Storage) CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error) {
+	const op = "database.psql.CopyCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	exists, err := cartExists(ctx, s.logged(log, tx), cartId)
+	if err != nil {
+		log.Error("Error checking cart existence", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	var newCartId int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `
+		INSERT INTO cart (user_id)
+		VALUES (NULLIF($1, ''))
+		RETURNING id;
+	`, userId).Scan(&newCartId); err != nil {
+		log.Error("Error creating cart", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.logged(log, tx).QueryxContext(ctx, `
+		INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+		SELECT $1, product, quantity, measure, weight, unit FROM item
+		WHERE cart_id=$2 AND deleted_at IS NULL
+		ORDER BY id
+		RETURNING id, cart_id, product, quantity, measure, weight, unit;
+	`, newCartId, cartId)
+	if err != nil {
+		log.Error("Failed to copy items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+	defer rows.Close()
+
+	var copiedItems []models.CartItem
+	for rows.Next() {
+		var tmpItem models.CartItem
+		if err := rows.Scan(&tmpItem.Id, &tmpItem.CartId, &tmpItem.Product, &tmpItem.Quantity, &tmpItem.Measure, &tmpItem.Weight, &tmpItem.Unit); err != nil {
+			log.Error("Failed to scan row", sl.Err(err))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		}
+		copiedItems = append(copiedItems, tmpItem)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Failed to iterate copied items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	rows.Close()
+
+	if s.maxItems > 0 && len(copiedItems) > s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return models.Cart{
+		Id:     newCartId,
+		UserId: userId,
+		Items:  copiedItems,
+	}, nil
+}
+
+// RemoveItems deletes the given items from the cart in one transaction and
+// returns the ids that were actually deleted. Ids that don't belong to the
+// cart are ignored.
+func (s *Storage) RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error) {
+	const op = "database.psql.RemoveItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	exists, err := cartExists(ctx, s.logged(log, tx), cartId)
+	if err != nil {
+		log.Error("Error checking cart existence", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	removeQuery := `
+		DELETE FROM item
+		WHERE cart_id=$1 AND id = ANY($2) AND deleted_at IS NULL
+		RETURNING id;
+	`
+	if s.softDelete {
+		removeQuery = `
+		UPDATE item SET deleted_at=now()
+		WHERE cart_id=$1 AND id = ANY($2) AND deleted_at IS NULL
+		RETURNING id;
+	`
+	}
+	rows, err := s.logged(log, tx).QueryxContext(ctx, removeQuery, cartId, pq.Array(itemIds))
+	if err != nil {
+		log.Error("Failed to delete items", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+	defer rows.Close()
+
+	deletedIds := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Error("Failed to scan row", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		deletedIds = append(deletedIds, id)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Failed to iterate deleted items", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return deletedIds, nil
+}
+
+// RestoreItem clears deleted_at on a soft-deleted item of the cart. It
+// returns databaseerrors.ErrItemNotFound unless such a deleted item exists.
+func (s *Storage) RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "database.psql.RestoreItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	exists, err := cartExists(ctx, s.logged(log, tx), cartId)
+	if err != nil {
+		log.Error("Error checking cart existence", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	var restored models.CartItem
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `
+		UPDATE item SET deleted_at=NULL
+		WHERE id=$1 AND cart_id=$2 AND deleted_at IS NOT NULL
+		RETURNING id, cart_id, product, quantity, measure, weight, unit;
+	`, itemId, cartId).Scan(&restored.Id, &restored.CartId, &restored.Product, &restored.Quantity, &restored.Measure, &restored.Weight, &restored.Unit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Deleted cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+		log.Error("Failed to restore item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if s.maxItems > 0 {
+		// The restored item is already counted.
+		full, err := cartFull(ctx, s.logged(log, tx), cartId, s.maxItems, 0)
+		if err != nil {
+			log.Error("Failed to count cart items", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if full {
+			log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return restored, nil
+}
+
+// cartExists reports whether a cart with the given id exists. Every method
+// that needs the cart to exist goes through it so they agree on the check.
+func cartExists(ctx context.Context, q sqlx.QueryerContext, cartId int) (bool, error) {
+	var exists bool
+	if err := q.QueryRowxContext(ctx, `SELECT EXISTS (SELECT 1 FROM cart WHERE id=$1);`, cartId).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// cartFull reports whether adding n live items would take the cart past max.
+// The cart row stays locked until the transaction ends, so concurrent
+// additions to the same cart are counted one after the other.
+func cartFull(ctx context.Context, q sqllog.Queryer, cartId int, max int, n int) (bool, error) {
+	if _, err := q.ExecContext(ctx, `SELECT id FROM cart WHERE id=$1 FOR UPDATE;`, cartId); err != nil {
+		return false, err
+	}
+	var count int
+	if err := q.QueryRowxContext(ctx, `SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`, cartId).Scan(&count); err != nil {
+		return false, err
+	}
+	return count+n > max, nil
+}
+
+// escapeLike escapes the LIKE wildcards so the value is matched literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// AddItems inserts all given items into the cart in one transaction.
+func (s *Storage) AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error) {
+	const op = "database.psql.AddItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	exists, err := cartExists(ctx, s.logged(log, tx), cartId)
+	if err != nil {
+		log.Error("Error checking cart existence", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+		return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+	}
+
+	if s.maxItems > 0 {
+		full, err := cartFull(ctx, s.logged(log, tx), cartId, s.maxItems, len(items))
+		if err != nil {
+			log.Error("Failed to count cart items", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if full {
+			log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+			return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+		}
+	}
+
+	addedItems := make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		var itemId int
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id;
+		`, cartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit).Scan(&itemId); err != nil {
+			log.Error("Failed to insert item", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+		addedItems = append(addedItems, models.CartItem{
+			Id:       itemId,
+			CartId:   cartId,
+			Product:  item.Product,
+			Quantity: item.Quantity,
+			Measure:  item.Measure,
+			Weight:   item.Weight,
+			Unit:     item.Unit,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return addedItems, nil
+}
+
+// ReplaceItems deletes every item of the cart and inserts the given ones in
+// one transaction, returning the resulting cart.
+func (s *Storage) ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error) {
+	const op = "database.psql.ReplaceItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// Locking the cart row keeps concurrent replacements from interleaving.
+	var rawMetadata []byte
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `
+		SELECT metadata FROM cart WHERE id=$1 FOR UPDATE;
+	`, cartId).Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to lock cart", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if s.maxItems > 0 && len(items) > s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+
+	clearQuery := `DELETE FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`
+	if s.softDelete {
+		clearQuery = `UPDATE item SET deleted_at=now() WHERE cart_id=$1 AND deleted_at IS NULL;`
+	}
+	if _, err := s.logged(log, tx).ExecContext(ctx, clearQuery, cartId); err != nil {
+		log.Error("Failed to delete items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	addedItems := make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		var itemId int
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id;
+		`, cartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit).Scan(&itemId); err != nil {
+			log.Error("Failed to insert item", sl.Err(err))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+		addedItems = append(addedItems, models.CartItem{
+			Id:       itemId,
+			CartId:   cartId,
+			Product:  item.Product,
+			Quantity: item.Quantity,
+			Measure:  item.Measure,
+			Weight:   item.Weight,
+			Unit:     item.Unit,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return models.Cart{Id: cartId, Items: addedItems, Total: len(addedItems), Metadata: metadata}, nil
+}
+
+// DeleteExpiredCarts deletes the carts created before cutoff together with
+// their items and returns how many carts were removed.
+func (s *Storage) DeleteExpiredCarts(ctx context.Context, cutoff time.Time) (int64, error) {
+	const op = "database.psql.DeleteExpiredCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return 0, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := s.logged(log, tx).ExecContext(ctx, `
+		DELETE FROM item
+		WHERE cart_id IN (SELECT id FROM cart WHERE created_at < $1);
+	`, cutoff); err != nil {
+		log.Error("Failed to delete items of expired carts", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	res, err := s.logged(log, tx).ExecContext(ctx, `DELETE FROM cart WHERE created_at < $1;`, cutoff)
+	if err != nil {
+		log.Error("Failed to delete expired carts", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		log.Error("Failed to get affected rows", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return deleted, nil
+}
+
+// PurgeCart deletes the cart together with its soft-deleted items, but only
+// while it holds no live item. The cart row is locked first so that an item
+// added concurrently either lands before the count or waits for the delete.
+func (s *Storage) PurgeCart(ctx context.Context, cartId int) error {
+	const op = "database.psql.PurgeCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `SELECT id FROM cart WHERE id=$1 FOR UPDATE;`, cartId).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to lock cart", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	var count int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`, cartId).Scan(&count); err != nil {
+		log.Error("Failed to count cart items", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+	if count > 0 {
+		log.Warn("Cart is not empty", slog.Int("items", count), sl.Err(databaseerrors.ErrCartNotEmpty))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotEmpty)
+	}
+
+	if _, err := s.logged(log, tx).ExecContext(ctx, `DELETE FROM item WHERE cart_id=$1;`, cartId); err != nil {
+		log.Error("Failed to delete items of cart", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if _, err := s.logged(log, tx).ExecContext(ctx, `DELETE FROM cart WHERE id=$1;`, cartId); err != nil {
+		log.Error("Failed to delete cart", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return nil
+}
+
+// CartStats counts the carts and live items, and the top products with the
+// most items, ties broken by product name.
+func (s *Storage) CartStats(ctx context.Context, top int) (models.CartStats, error) {
+	const op = "database.psql.CartStats"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var stats models.CartStats
+	if err := s.logged(log, s.db).QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM cart),
+			(SELECT COUNT(*) FROM item WHERE deleted_at IS NULL);
+	`).Scan(&stats.Carts, &stats.Items); err != nil {
+		log.Error("Failed to count carts and items", sl.Err(err))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.logged(log, s.db).QueryContext(ctx, `
+		SELECT product, COUNT(*) AS items, COALESCE(SUM(quantity), 0) FROM item
+		WHERE deleted_at IS NULL
+		GROUP BY product
+		ORDER BY items DESC, product
+		LIMIT $1;
+	`, top)
+	if err != nil {
+		log.Error("Failed to query product stats", sl.Err(err))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	stats.TopProducts = make([]models.ProductStats, 0, top)
+	for rows.Next() {
+		var product models.ProductStats
+		if err := rows.Scan(&product.Product, &product.Items, &product.Quantity); err != nil {
+			log.Error("Failed to scan row", sl.Err(err))
+			return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+		}
+		stats.TopProducts = append(stats.TopProducts, product)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Failed to read product stats", sl.Err(err))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// PatchCartMetadata applies patch to the cart metadata as a JSON merge
+// patch: keys mapped to nil are removed, the others are set.
+func (s *Storage) PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error) {
+	const op = "database.psql.PatchCartMetadata"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	rawPatch, err := json.Marshal(patch)
+	if err != nil {
+		log.Error("Failed to encode metadata patch", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Metadata values are never null, so stripping nulls after the merge
+	// removes exactly the keys the patch deletes.
+	var rawMetadata []byte
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		UPDATE cart SET metadata = jsonb_strip_nulls(metadata || $2::jsonb)
+		WHERE id=$1
+		RETURNING metadata;
+	`, cartId, rawPatch).Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to update cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{Id: cartId, Metadata: metadata}, nil
+}
+
+// decodeMetadata decodes the metadata column, leaving an empty object nil.
+func decodeMetadata(raw []byte) (map[string]string, error) {
+	var metadata map[string]string
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(raw, &metadata); err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return metadata, nil
+}
+
+var _ databaseerrors.Storage = (*Storage)(nil)
@@ -1,23 +1,34 @@
 package psql_test
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"net"
 	"regexp"
+	"syscall"
 	"testing"
 	"time"
 
 	databaseerrors "cartapi/internal/database"
 	"cartapi/internal/database/psql"
+	"cartapi/internal/database/sqllog"
 	"cartapi/internal/models"
 	"cartapi/pkg/lib/logger/slogdiscard"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+var existsQuery = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM cart WHERE id=$1);`)
+
 func newTestStorage(t *testing.T) (*psql.Storage, sqlmock.Sqlmock, func()) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -34,6 +45,7 @@ func TestCreateCart(t *testing.T) {
 
 	tests := []struct {
 		name       string
+		userId     string
 		setupMock  func(sqlmock.Sqlmock)
 		ctx        context.Context
 		expectCart models.Cart
@@ -43,12 +55,23 @@ func TestCreateCart(t *testing.T) {
 			name: "Success",
 			setupMock: func(mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id"}).AddRow(123)
-				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO cart DEFAULT VALUES RETURNING id")).WillReturnRows(rows)
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO cart (user_id) VALUES (NULLIF($1, '')) RETURNING id")).WithArgs("").WillReturnRows(rows)
 			},
 			ctx:        context.Background(),
 			expectCart: models.Cart{Id: 123},
 			expectErr:  nil,
 		},
+		{
+			name:   "Success with owner",
+			userId: "user-1",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow(124)
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO cart (user_id) VALUES (NULLIF($1, '')) RETURNING id")).WithArgs("user-1").WillReturnRows(rows)
+			},
+			ctx:        context.Background(),
+			expectCart: models.Cart{Id: 124, UserId: "user-1"},
+			expectErr:  nil,
+		},
 		{
 			name:      "Context canceled",
 			setupMock: func(sqlmock.Sqlmock) {},
@@ -73,7 +96,7 @@ func TestCreateCart(t *testing.T) {
 		{
 			name: "Query error",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO cart DEFAULT VALUES RETURNING id")).WillReturnError(errors.New("db error"))
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO cart (user_id) VALUES (NULLIF($1, '')) RETURNING id")).WillReturnError(errors.New("db error"))
 			},
 			ctx:        context.Background(),
 			expectCart: models.Cart{},
@@ -84,7 +107,7 @@ func TestCreateCart(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.setupMock(mock)
-			cart, err := storage.CreateCart(tt.ctx)
+			cart, err := storage.CreateCart(tt.ctx, tt.userId)
 			if tt.expectErr != nil {
 				assert.Error(t, err)
 			} else {
@@ -100,6 +123,8 @@ func TestAddToCart(t *testing.T) {
 	storage, mock, cleanup := newTestStorage(t)
 	defer cleanup()
 
+	insertErr := errors.New("insert item error")
+
 	tests := []struct {
 		name      string
 		cartId    int
@@ -115,10 +140,10 @@ func TestAddToCart(t *testing.T) {
 			item:   models.CartItem{Product: "product", Quantity: 2},
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1`)).
-					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-				mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity) VALUES ($1, $2, $3) RETURNING id;`)).
-					WithArgs(1, "product", 2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+				mock.ExpectQuery(existsQuery).
+					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)).
+					WithArgs(1, "product", 2, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
 				mock.ExpectCommit()
 			},
 			ctx:      context.Background(),
@@ -156,12 +181,12 @@ func TestAddToCart(t *testing.T) {
 			item:   models.CartItem{Product: "product", Quantity: 2},
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1`)).
-					WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(existsQuery).
+					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 				mock.ExpectRollback()
 			},
 			ctx:     context.Background(),
-			wantErr: databaseerrors.ErrNotFound,
+			wantErr: databaseerrors.ErrCartNotFound,
 		},
 		{
 			name:   "Insert item error",
@@ -169,14 +194,14 @@ func TestAddToCart(t *testing.T) {
 			item:   models.CartItem{Product: "product", Quantity: 2},
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1`)).
-					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-				mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity) VALUES ($1, $2, $3) RETURNING id;`)).
-					WithArgs(1, "product", 2).WillReturnError(errors.New("insert item error"))
+				mock.ExpectQuery(existsQuery).
+					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)).
+					WithArgs(1, "product", 2, "", 0.0, "").WillReturnError(insertErr)
 				mock.ExpectRollback()
 			},
 			ctx:     context.Background(),
-			wantErr: errors.New("insert item error"),
+			wantErr: insertErr,
 		},
 	}
 
@@ -186,7 +211,7 @@ func TestAddToCart(t *testing.T) {
 			gotItem, err := storage.AddToCart(tt.ctx, tt.cartId, tt.item)
 
 			if tt.wantErr != nil {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, tt.wantErr)
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.wantItem, gotItem)
@@ -214,11 +239,11 @@ func TestRemoveFromCart(t *testing.T) {
 			itemId: 20,
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1;`)).WithArgs(10).
-					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT cart_id FROM item WHERE id=$1;`)).WithArgs(20).
-					WillReturnRows(sqlmock.NewRows([]string{"cart_id"}).AddRow(10))
-				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM item WHERE id=$1;`)).WithArgs(20).
+				mock.ExpectQuery(existsQuery).WithArgs(10).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`)).WithArgs(20, 10).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM item WHERE id=$1 AND cart_id=$2;`)).WithArgs(20, 10).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			},
@@ -256,14 +281,31 @@ func TestRemoveFromCart(t *testing.T) {
 			itemId: 20,
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1;`)).WithArgs(10).
-					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT cart_id FROM item WHERE id=$1;`)).
-					WithArgs(20).WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(existsQuery).WithArgs(10).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`)).
+					WithArgs(20, 10).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			ctx:     context.Background(),
+			wantErr: databaseerrors.ErrItemNotFound,
+		},
+		{
+			name:   "Item of another cart",
+			cartId: 10,
+			itemId: 30,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(10).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				// Item 30 lives in another cart, so the lookup scoped to cart 10
+				// finds nothing and no delete is run.
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`)).
+					WithArgs(30, 10).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 				mock.ExpectRollback()
 			},
 			ctx:     context.Background(),
-			wantErr: databaseerrors.ErrNotFound,
+			wantErr: databaseerrors.ErrItemNotFound,
 		},
 	}
 
@@ -273,7 +315,7 @@ func TestRemoveFromCart(t *testing.T) {
 			err := storage.RemoveFromCart(tt.ctx, tt.cartId, tt.itemId)
 
 			if tt.wantErr != nil {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, tt.wantErr)
 			} else {
 				assert.NoError(t, err)
 			}
@@ -286,10 +328,13 @@ func TestViewCart(t *testing.T) {
 	storage, mock, cleanup := newTestStorage(t)
 	defer cleanup()
 
+	queryErr := errors.New("query error")
+
 	tests := []struct {
 		name      string
 		cartId    int
 		setupMock func(sqlmock.Sqlmock)
+		opts      models.ViewCartOptions
 		ctx       context.Context
 		wantCart  models.Cart
 		wantErr   error
@@ -298,24 +343,49 @@ func TestViewCart(t *testing.T) {
 			name:   "Success",
 			cartId: 1,
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM cart WHERE id=$1;`)).WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
-				rows := sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity"}).
-					AddRow(11, 1, "apple", 3).
-					AddRow(12, 1, "banana", 5)
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, cart_id, product, quantity FROM item WHERE cart_id=$1;`)).
-					WithArgs(1).WillReturnRows(rows)
-			},
-			ctx: context.Background(),
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte("{}")))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				rows := sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).
+					AddRow(11, 1, "apple", 3, "", 0.0, "").
+					AddRow(12, 1, "banana", 5, "", 0.0, "")
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE cart_id=$1 AND deleted_at IS NULL ORDER BY id LIMIT $2 OFFSET $3;`)).
+					WithArgs(1, 50, 0).WillReturnRows(rows)
+			},
+			opts: models.ViewCartOptions{Limit: 50},
+			ctx:  context.Background(),
 			wantCart: models.Cart{
 				Id: 1,
 				Items: []models.CartItem{
 					{Id: 11, CartId: 1, Product: "apple", Quantity: 3},
 					{Id: 12, CartId: 1, Product: "banana", Quantity: 5},
 				},
+				Total: 2,
 			},
 			wantErr: nil,
 		},
+		{
+			name:   "Limit and offset applied",
+			cartId: 1,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte("{}")))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+				rows := sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).
+					AddRow(15, 1, "cherry", 1, "", 0.0, "")
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE cart_id=$1 AND deleted_at IS NULL ORDER BY id LIMIT $2 OFFSET $3;`)).
+					WithArgs(1, 1, 4).WillReturnRows(rows)
+			},
+			opts: models.ViewCartOptions{Limit: 1, Offset: 4},
+			ctx:  context.Background(),
+			wantCart: models.Cart{
+				Id:    1,
+				Items: []models.CartItem{{Id: 15, CartId: 1, Product: "cherry", Quantity: 1}},
+				Total: 10,
+			},
+		},
 		{
 			name:      "Context canceled",
 			cartId:    1,
@@ -343,31 +413,304 @@ func TestViewCart(t *testing.T) {
 			name:   "Cart not found",
 			cartId: 1,
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM cart WHERE id=$1;`)).
-					WithArgs(1).WillReturnError(databaseerrors.ErrNotFound)
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).
+					WithArgs(1).WillReturnError(sql.ErrNoRows)
 			},
 			ctx:     context.Background(),
-			wantErr: databaseerrors.ErrNotFound,
+			wantErr: databaseerrors.ErrCartNotFound,
 		},
 		{
 			name:   "Query error",
 			cartId: 1,
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM cart WHERE id=$1;`)).
-					WithArgs(1).WillReturnError(errors.New("query error"))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).
+					WithArgs(1).WillReturnError(queryErr)
+			},
+			ctx:     context.Background(),
+			wantErr: queryErr,
+		},
+		{
+			name:   "Count query error",
+			cartId: 1,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte("{}")))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)).WithArgs(1).
+					WillReturnError(queryErr)
 			},
+			opts:    models.ViewCartOptions{Limit: 50},
 			ctx:     context.Background(),
-			wantErr: errors.New("query error"),
+			wantErr: queryErr,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.setupMock(mock)
-			cart, err := storage.ViewCart(tt.ctx, tt.cartId)
+			cart, err := storage.ViewCart(tt.ctx, tt.cartId, tt.opts)
 
 			if tt.wantErr != nil {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, tt.wantErr)
+				if !errors.Is(tt.wantErr, databaseerrors.ErrNotFound) {
+					// A failing query must not read as a missing cart.
+					assert.NotErrorIs(t, err, databaseerrors.ErrNotFound)
+					assert.ErrorContains(t, err, "database.psql.ViewCart: ")
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantCart, cart)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestConstraintErrors(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		setupMock func(sqlmock.Sqlmock)
+		call      func() error
+		wantErr   error
+	}{
+		{
+			name: "AddToCart unique violation",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).
+					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)).
+					WithArgs(1, "product", 2, "", 0.0, "").WillReturnError(&pq.Error{Code: "23505"})
+				mock.ExpectRollback()
+			},
+			call: func() error {
+				_, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "product", Quantity: 2})
+				return err
+			},
+			wantErr: databaseerrors.ErrConflict,
+		},
+		{
+			name: "AddToCart foreign key violation",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).
+					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)).
+					WithArgs(1, "product", 2, "", 0.0, "").WillReturnError(&pq.Error{Code: "23503"})
+				mock.ExpectRollback()
+			},
+			call: func() error {
+				_, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "product", Quantity: 2})
+				return err
+			},
+			wantErr: databaseerrors.ErrCartNotFound,
+		},
+		{
+			name: "RemoveFromCart foreign key violation",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(10).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`)).WithArgs(20, 10).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM item WHERE id=$1 AND cart_id=$2;`)).WithArgs(20, 10).
+					WillReturnError(&pq.Error{Code: "23503"})
+				mock.ExpectRollback()
+			},
+			call: func() error {
+				return storage.RemoveFromCart(context.Background(), 10, 20)
+			},
+			wantErr: databaseerrors.ErrCartNotFound,
+		},
+		{
+			name: "RemoveFromCart unique violation",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(10).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`)).WithArgs(20, 10).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM item WHERE id=$1 AND cart_id=$2;`)).WithArgs(20, 10).
+					WillReturnError(&pq.Error{Code: "23505"})
+				mock.ExpectRollback()
+			},
+			call: func() error {
+				return storage.RemoveFromCart(context.Background(), 10, 20)
+			},
+			wantErr: databaseerrors.ErrConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock(mock)
+			err := tt.call()
+
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMoveItem(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	tests := []struct {
+		name         string
+		cartId       int
+		itemId       int
+		targetCartId int
+		setupMock    func(sqlmock.Sqlmock)
+		wantItem     models.CartItem
+		wantErr      error
+	}{
+		{
+			name:         "Success",
+			cartId:       1,
+			itemId:       5,
+			targetCartId: 2,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(existsQuery).WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT cart_id FROM item WHERE id=$1 AND deleted_at IS NULL;`)).WithArgs(5).
+					WillReturnRows(sqlmock.NewRows([]string{"cart_id"}).AddRow(1))
+				mock.ExpectQuery(regexp.QuoteMeta(`UPDATE item SET cart_id=$1 WHERE id=$2 RETURNING id, cart_id, product, quantity, measure, weight, unit;`)).
+					WithArgs(2, 5).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).AddRow(5, 2, "apple", 3, "", 0.0, ""))
+				mock.ExpectCommit()
+			},
+			wantItem: models.CartItem{Id: 5, CartId: 2, Product: "apple", Quantity: 3},
+		},
+		{
+			name:         "Target cart not found",
+			cartId:       1,
+			itemId:       5,
+			targetCartId: 2,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(existsQuery).WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+			wantErr: databaseerrors.ErrCartNotFound,
+		},
+		{
+			name:         "Item belongs to another cart",
+			cartId:       1,
+			itemId:       5,
+			targetCartId: 2,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(existsQuery).WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT cart_id FROM item WHERE id=$1 AND deleted_at IS NULL;`)).WithArgs(5).
+					WillReturnRows(sqlmock.NewRows([]string{"cart_id"}).AddRow(3))
+				mock.ExpectRollback()
+			},
+			wantErr: databaseerrors.ErrItemNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock(mock)
+			gotItem, err := storage.MoveItem(context.Background(), tt.cartId, tt.itemId, tt.targetCartId)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantItem, gotItem)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCopyCart(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	copyQuery := regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) SELECT $1, product, quantity, measure, weight, unit FROM item WHERE cart_id=$2 AND deleted_at IS NULL ORDER BY id RETURNING id, cart_id, product, quantity, measure, weight, unit;`)
+
+	tests := []struct {
+		name      string
+		cartId    int
+		setupMock func(sqlmock.Sqlmock)
+		wantCart  models.Cart
+		wantErr   error
+	}{
+		{
+			name:   "Empty cart",
+			cartId: 1,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO cart (user_id) VALUES (NULLIF($1, '')) RETURNING id")).WithArgs("user-1").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectQuery(copyQuery).WithArgs(2, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}))
+				mock.ExpectCommit()
+			},
+			wantCart: models.Cart{Id: 2, UserId: "user-1"},
+		},
+		{
+			name:   "Cart with several items",
+			cartId: 1,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO cart (user_id) VALUES (NULLIF($1, '')) RETURNING id")).WithArgs("user-1").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectQuery(copyQuery).WithArgs(2, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).
+						AddRow(21, 2, "apple", 3, "", 0.0, "").
+						AddRow(22, 2, "banana", 5, "", 0.0, "").
+						AddRow(23, 2, "cherry", 1, "", 0.0, ""))
+				mock.ExpectCommit()
+			},
+			wantCart: models.Cart{
+				Id:     2,
+				UserId: "user-1",
+				Items: []models.CartItem{
+					{Id: 21, CartId: 2, Product: "apple", Quantity: 3},
+					{Id: 22, CartId: 2, Product: "banana", Quantity: 5},
+					{Id: 23, CartId: 2, Product: "cherry", Quantity: 1},
+				},
+			},
+		},
+		{
+			name:   "Source cart not found",
+			cartId: 1,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+			wantErr: databaseerrors.ErrCartNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock(mock)
+			cart, err := storage.CopyCart(context.Background(), tt.cartId, "user-1")
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.wantCart, cart)
@@ -376,3 +719,1566 @@ func TestViewCart(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoveItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	deleteQuery := regexp.QuoteMeta(`DELETE FROM item WHERE cart_id=$1 AND id = ANY($2) AND deleted_at IS NULL RETURNING id;`)
+
+	tests := []struct {
+		name      string
+		cartId    int
+		itemIds   []int
+		setupMock func(sqlmock.Sqlmock)
+		wantIds   []int
+		wantErr   error
+	}{
+		{
+			name:    "Mixed valid and invalid ids",
+			cartId:  1,
+			itemIds: []int{11, 12, 99},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(deleteQuery).WithArgs(1, pq.Array([]int{11, 12, 99})).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11).AddRow(12))
+				mock.ExpectCommit()
+			},
+			wantIds: []int{11, 12},
+		},
+		{
+			name:    "No ids belong to cart",
+			cartId:  1,
+			itemIds: []int{98, 99},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(deleteQuery).WithArgs(1, pq.Array([]int{98, 99})).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectCommit()
+			},
+			wantIds: []int{},
+		},
+		{
+			name:    "Cart not found",
+			cartId:  1,
+			itemIds: []int{11},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(existsQuery).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+			wantErr: databaseerrors.ErrCartNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setupMock(mock)
+			ids, err := storage.RemoveItems(context.Background(), tt.cartId, tt.itemIds)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantIds, ids)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestStreamCartItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	metadataQuery := regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)
+	itemsQuery := regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE cart_id=$1 AND deleted_at IS NULL AND product ILIKE '%' || $2 || '%' ORDER BY id;`)
+	itemColumns := []string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(metadataQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{"channel":"web"}`)))
+		mock.ExpectQuery(itemsQuery).WithArgs(1, "app").
+			WillReturnRows(sqlmock.NewRows(itemColumns).
+				AddRow(11, 1, "Apple", 3, "count", 0.0, "").
+				AddRow(14, 1, "pineapple", 1, "count", 0.0, ""))
+
+		var got []models.CartItem
+		cart, err := storage.StreamCartItems(context.Background(), 1, "app", func(item models.CartItem) error {
+			got = append(got, item)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, models.Cart{Id: 1, Total: 2, Metadata: map[string]string{"channel": "web"}}, cart)
+		assert.Equal(t, []models.CartItem{
+			{Id: 11, CartId: 1, Product: "Apple", Quantity: 3, Measure: "count"},
+			{Id: 14, CartId: 1, Product: "pineapple", Quantity: 1, Measure: "count"},
+		}, got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Yield error stops the stream", func(t *testing.T) {
+		yieldErr := errors.New("client gone")
+		mock.ExpectQuery(metadataQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte("{}")))
+		mock.ExpectQuery(itemsQuery).WithArgs(1, "app").
+			WillReturnRows(sqlmock.NewRows(itemColumns).
+				AddRow(11, 1, "Apple", 3, "count", 0.0, "").
+				AddRow(14, 1, "pineapple", 1, "count", 0.0, ""))
+
+		calls := 0
+		_, err := storage.StreamCartItems(context.Background(), 1, "app", func(models.CartItem) error {
+			calls++
+			return yieldErr
+		})
+		assert.ErrorIs(t, err, yieldErr)
+		assert.Equal(t, 1, calls)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart not found", func(t *testing.T) {
+		mock.ExpectQuery(metadataQuery).WithArgs(2).WillReturnError(sql.ErrNoRows)
+
+		_, err := storage.StreamCartItems(context.Background(), 2, "", func(models.CartItem) error { return nil })
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestViewCarts(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	cartsQuery := regexp.QuoteMeta(`SELECT id, user_id, metadata FROM cart WHERE id = ANY($1);`)
+	itemsQuery := regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE cart_id = ANY($1) AND deleted_at IS NULL ORDER BY cart_id, id;`)
+	itemColumns := []string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}
+
+	t.Run("Carts in the requested order", func(t *testing.T) {
+		mock.ExpectQuery(cartsQuery).WithArgs(pq.Array([]int{3, 1, 2})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "metadata"}).
+				AddRow(1, nil, []byte(`{"channel":"web"}`)).
+				AddRow(3, "user-1", []byte("{}")))
+		mock.ExpectQuery(itemsQuery).WithArgs(pq.Array([]int{3, 1, 2})).
+			WillReturnRows(sqlmock.NewRows(itemColumns).
+				AddRow(11, 1, "apple", 3, "", 0.0, "").
+				AddRow(12, 1, "banana", 5, "", 0.0, "").
+				AddRow(31, 3, "cherry", 1, "", 0.0, ""))
+
+		carts, err := storage.ViewCarts(context.Background(), []int{3, 1, 2})
+		require.NoError(t, err)
+		assert.Equal(t, []models.Cart{
+			{Id: 3, UserId: "user-1", Items: []models.CartItem{{Id: 31, CartId: 3, Product: "cherry", Quantity: 1}}, Total: 1},
+			{
+				Id: 1,
+				Items: []models.CartItem{
+					{Id: 11, CartId: 1, Product: "apple", Quantity: 3},
+					{Id: 12, CartId: 1, Product: "banana", Quantity: 5},
+				},
+				Total:    2,
+				Metadata: map[string]string{"channel": "web"},
+			},
+		}, carts)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No cart found skips the items query", func(t *testing.T) {
+		mock.ExpectQuery(cartsQuery).WithArgs(pq.Array([]int{7})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "metadata"}))
+
+		carts, err := storage.ViewCarts(context.Background(), []int{7})
+		require.NoError(t, err)
+		assert.Empty(t, carts)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Query error", func(t *testing.T) {
+		queryErr := errors.New("query error")
+		mock.ExpectQuery(cartsQuery).WithArgs(pq.Array([]int{1})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "metadata"}).AddRow(1, nil, []byte("{}")))
+		mock.ExpectQuery(itemsQuery).WithArgs(pq.Array([]int{1})).WillReturnError(queryErr)
+
+		_, err := storage.ViewCarts(context.Background(), []int{1})
+		assert.ErrorIs(t, err, queryErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestViewCart_ProductFilter(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		product   string
+		wantArg   string
+		setupRows func() *sqlmock.Rows
+		wantCart  models.Cart
+	}{
+		{
+			name:    "Substring filter",
+			product: "app",
+			wantArg: "app",
+			setupRows: func() *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).
+					AddRow(11, 1, "Apple", 3, "", 0.0, "").
+					AddRow(14, 1, "pineapple", 1, "", 0.0, "")
+			},
+			wantCart: models.Cart{
+				Id: 1,
+				Items: []models.CartItem{
+					{Id: 11, CartId: 1, Product: "Apple", Quantity: 3},
+					{Id: 14, CartId: 1, Product: "pineapple", Quantity: 1},
+				},
+				Total: 2,
+			},
+		},
+		{
+			name:    "Wildcards and injection are bound literally",
+			product: "50%'; DROP TABLE item; --",
+			wantArg: `50\%'; DROP TABLE item; --`,
+			setupRows: func() *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"})
+			},
+			wantCart: models.Cart{Id: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte("{}")))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL AND product ILIKE '%' || $2 || '%';`)).
+				WithArgs(1, tt.wantArg).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(len(tt.wantCart.Items)))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE cart_id=$1 AND deleted_at IS NULL AND product ILIKE '%' || $2 || '%' ORDER BY id LIMIT $3 OFFSET $4;`)).
+				WithArgs(1, tt.wantArg, 50, 0).
+				WillReturnRows(tt.setupRows())
+
+			cart, err := storage.ViewCart(context.Background(), 1, models.ViewCartOptions{Limit: 50, Product: tt.product})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCart, cart)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAddItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	insertQuery := regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "a", 1, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "b", 2, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+		mock.ExpectCommit()
+
+		items, err := storage.AddItems(context.Background(), 1, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 2}})
+		assert.NoError(t, err)
+		assert.Equal(t, []models.CartItem{
+			{Id: 10, CartId: 1, Product: "a", Quantity: 1},
+			{Id: 11, CartId: 1, Product: "b", Quantity: 2},
+		}, items)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Insert error rolls back", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "a", 1, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "b", 2, "", 0.0, "").WillReturnError(errors.New("insert error"))
+		mock.ExpectRollback()
+
+		_, err := storage.AddItems(context.Background(), 1, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 2}})
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReplaceItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	lockQuery := regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1 FOR UPDATE;`)
+	clearQuery := regexp.QuoteMeta(`DELETE FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)
+	items := []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 2}}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{"channel":"web"}`)))
+		mock.ExpectExec(clearQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "a", 1, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "b", 2, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+		mock.ExpectCommit()
+
+		cart, err := storage.ReplaceItems(context.Background(), 1, items)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Cart{
+			Id: 1,
+			Items: []models.CartItem{
+				{Id: 10, CartId: 1, Product: "a", Quantity: 1},
+				{Id: 11, CartId: 1, Product: "b", Quantity: 2},
+			},
+			Total:    2,
+			Metadata: map[string]string{"channel": "web"},
+		}, cart)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart not found", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(2).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := storage.ReplaceItems(context.Background(), 2, items)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Insert error rolls back the delete", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{}`)))
+		mock.ExpectExec(clearQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "a", 1, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "b", 2, "", 0.0, "").WillReturnError(errors.New("insert error"))
+		mock.ExpectRollback()
+
+		_, err := storage.ReplaceItems(context.Background(), 1, items)
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteExpiredCarts(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	cutoff := time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM item WHERE cart_id IN (SELECT id FROM cart WHERE created_at < $1);`)).
+			WithArgs(cutoff).WillReturnResult(sqlmock.NewResult(0, 5))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM cart WHERE created_at < $1;`)).
+			WithArgs(cutoff).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		deleted, err := storage.DeleteExpiredCarts(context.Background(), cutoff)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Delete error rolls back", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM item WHERE cart_id IN (SELECT id FROM cart WHERE created_at < $1);`)).
+			WithArgs(cutoff).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM cart WHERE created_at < $1;`)).
+			WithArgs(cutoff).WillReturnError(errors.New("delete error"))
+		mock.ExpectRollback()
+
+		_, err := storage.DeleteExpiredCarts(context.Background(), cutoff)
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPurgeCart(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1 FOR UPDATE;`)
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)
+
+	t.Run("Empty cart is deleted", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(countQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM item WHERE cart_id=$1;`)).
+			WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM cart WHERE id=$1;`)).
+			WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := storage.PurgeCart(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart with items is kept", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(countQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectRollback()
+
+		err := storage.PurgeCart(context.Background(), 1)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotEmpty)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing cart", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		err := storage.PurgeCart(context.Background(), 1)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCartStats(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	countQuery := regexp.QuoteMeta(`SELECT (SELECT COUNT(*) FROM cart), (SELECT COUNT(*) FROM item WHERE deleted_at IS NULL);`)
+	productsQuery := regexp.QuoteMeta(`SELECT product, COUNT(*) AS items, COALESCE(SUM(quantity), 0) FROM item WHERE deleted_at IS NULL GROUP BY product ORDER BY items DESC, product LIMIT $1;`)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(countQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "count"}).AddRow(3, 7))
+		mock.ExpectQuery(productsQuery).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"product", "items", "sum"}).
+				AddRow("apples", 4, 9).
+				AddRow("pears", 2, 2))
+
+		stats, err := storage.CartStats(context.Background(), 2)
+
+		assert.NoError(t, err)
+		assert.Equal(t, models.CartStats{
+			Carts: 3,
+			Items: 7,
+			TopProducts: []models.ProductStats{
+				{Product: "apples", Items: 4, Quantity: 9},
+				{Product: "pears", Items: 2, Quantity: 2},
+			},
+		}, stats)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No items", func(t *testing.T) {
+		mock.ExpectQuery(countQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "count"}).AddRow(1, 0))
+		mock.ExpectQuery(productsQuery).WithArgs(10).
+			WillReturnRows(sqlmock.NewRows([]string{"product", "items", "sum"}))
+
+		stats, err := storage.CartStats(context.Background(), 10)
+
+		assert.NoError(t, err)
+		assert.Equal(t, models.CartStats{Carts: 1, TopProducts: []models.ProductStats{}}, stats)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Query error", func(t *testing.T) {
+		mock.ExpectQuery(countQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "count"}).AddRow(1, 1))
+		mock.ExpectQuery(productsQuery).WithArgs(10).WillReturnError(errors.New("query error"))
+
+		_, err := storage.CartStats(context.Background(), 10)
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestViewCart_WeightedItem(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte("{}")))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	// lib/pq returns NUMERIC columns as text.
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE cart_id=$1 AND deleted_at IS NULL ORDER BY id LIMIT $2 OFFSET $3;`)).
+		WithArgs(1, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).
+			AddRow(1, 1, "apples", 1, "weight", []byte("0.750"), "kg"))
+
+	cart, err := storage.ViewCart(context.Background(), 1, models.ViewCartOptions{Limit: 50})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.CartItem{{Id: 1, CartId: 1, Product: "apples", Quantity: 1, Measure: "weight", Weight: 0.75, Unit: "kg"}}, cart.Items)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPatchCartMetadata(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	updateQuery := regexp.QuoteMeta(`UPDATE cart SET metadata = jsonb_strip_nulls(metadata || $2::jsonb) WHERE id=$1 RETURNING metadata;`)
+	channel := "web"
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(updateQuery).WithArgs(1, []byte(`{"channel":"web","coupon":null}`)).
+			WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{"channel": "web"}`)))
+
+		cart, err := storage.PatchCartMetadata(context.Background(), 1, map[string]*string{"channel": &channel, "coupon": nil})
+		assert.NoError(t, err)
+		assert.Equal(t, models.Cart{Id: 1, Metadata: map[string]string{"channel": "web"}}, cart)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart not found", func(t *testing.T) {
+		mock.ExpectQuery(updateQuery).WithArgs(2, []byte(`{"channel":"web"}`)).WillReturnError(sql.ErrNoRows)
+
+		_, err := storage.PatchCartMetadata(context.Background(), 2, map[string]*string{"channel": &channel})
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestViewCart_Metadata(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte(`{"channel": "web"}`)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE cart_id=$1 AND deleted_at IS NULL ORDER BY id LIMIT $2 OFFSET $3;`)).
+		WithArgs(1, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}))
+
+	cart, err := storage.ViewCart(context.Background(), 1, models.ViewCartOptions{Limit: 50})
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cart{Id: 1, Metadata: map[string]string{"channel": "web"}}, cart)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCartExistenceCheck(t *testing.T) {
+	methods := map[string]func(s *psql.Storage) error{
+		"AddToCart": func(s *psql.Storage) error {
+			_, err := s.AddToCart(context.Background(), 1, models.CartItem{Product: "apple", Quantity: 1})
+			return err
+		},
+		"RemoveFromCart": func(s *psql.Storage) error {
+			return s.RemoveFromCart(context.Background(), 1, 2)
+		},
+		"CopyCart": func(s *psql.Storage) error {
+			_, err := s.CopyCart(context.Background(), 1, "")
+			return err
+		},
+		"RemoveItems": func(s *psql.Storage) error {
+			_, err := s.RemoveItems(context.Background(), 1, []int{2})
+			return err
+		},
+		"AddItems": func(s *psql.Storage) error {
+			_, err := s.AddItems(context.Background(), 1, []models.CartItem{{Product: "apple", Quantity: 1}})
+			return err
+		},
+	}
+
+	for name, call := range methods {
+		t.Run(name+"/Cart not found", func(t *testing.T) {
+			storage, mock, cleanup := newTestStorage(t)
+			defer cleanup()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(existsQuery).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			mock.ExpectRollback()
+
+			assert.ErrorIs(t, call(storage), databaseerrors.ErrCartNotFound)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+
+		t.Run(name+"/Query error", func(t *testing.T) {
+			storage, mock, cleanup := newTestStorage(t)
+			defer cleanup()
+
+			queryErr := errors.New("connection reset")
+			mock.ExpectBegin()
+			mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnError(queryErr)
+			mock.ExpectRollback()
+
+			err := call(storage)
+			assert.ErrorIs(t, err, queryErr)
+			assert.NotErrorIs(t, err, databaseerrors.ErrNotFound)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+	storage.SetSoftDelete(true)
+
+	t.Run("RemoveFromCart", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(10).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`)).WithArgs(20, 10).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE item SET deleted_at=now() WHERE id=$1 AND cart_id=$2;`)).WithArgs(20, 10).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, storage.RemoveFromCart(context.Background(), 10, 20))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("RemoveItems", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE item SET deleted_at=now() WHERE cart_id=$1 AND id = ANY($2) AND deleted_at IS NULL RETURNING id;`)).
+			WithArgs(1, pq.Array([]int{11, 12})).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11).AddRow(12))
+		mock.ExpectCommit()
+
+		ids, err := storage.RemoveItems(context.Background(), 1, []int{11, 12})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{11, 12}, ids)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRestoreItem(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	restoreQuery := regexp.QuoteMeta(`UPDATE item SET deleted_at=NULL WHERE id=$1 AND cart_id=$2 AND deleted_at IS NOT NULL RETURNING id, cart_id, product, quantity, measure, weight, unit;`)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(restoreQuery).WithArgs(5, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).
+				AddRow(5, 1, "apple", 2, "count", 0.0, ""))
+		mock.ExpectCommit()
+
+		item, err := storage.RestoreItem(context.Background(), 1, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, models.CartItem{Id: 5, CartId: 1, Product: "apple", Quantity: 2, Measure: "count"}, item)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No deleted item", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(restoreQuery).WithArgs(5, 1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := storage.RestoreItem(context.Background(), 1, 5)
+		assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateCarts(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	insertQuery := regexp.QuoteMeta(`INSERT INTO cart (created_at) SELECT now() FROM generate_series(1, $1) RETURNING id;`)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(insertQuery).WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7).AddRow(5).AddRow(6))
+
+		ids, err := storage.CreateCarts(context.Background(), 3)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{5, 6, 7}, ids)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Query error", func(t *testing.T) {
+		mock.ExpectQuery(insertQuery).WithArgs(3).WillReturnError(errors.New("insert error"))
+
+		_, err := storage.CreateCarts(context.Background(), 3)
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCartOwner(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ownerQuery := regexp.QuoteMeta(`SELECT user_id FROM cart WHERE id=$1;`)
+
+	t.Run("Owned cart", func(t *testing.T) {
+		mock.ExpectQuery(ownerQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user-1"))
+
+		owner, err := storage.CartOwner(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "user-1", owner)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart without owner", func(t *testing.T) {
+		mock.ExpectQuery(ownerQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(nil))
+
+		owner, err := storage.CartOwner(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Empty(t, owner)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart not found", func(t *testing.T) {
+		mock.ExpectQuery(ownerQuery).WithArgs(3).WillReturnError(sql.ErrNoRows)
+
+		_, err := storage.CartOwner(context.Background(), 3)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ownerQuery := regexp.QuoteMeta(`SELECT user_id FROM cart WHERE id=$1;`)
+
+	t.Run("Query is capped without a request deadline", func(t *testing.T) {
+		storage.SetQueryTimeout(50 * time.Millisecond)
+		mock.ExpectQuery(ownerQuery).WithArgs(1).WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user-1"))
+
+		start := time.Now()
+		_, err := storage.CartOwner(context.Background(), 1)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Parent cancellation still propagates", func(t *testing.T) {
+		storage.SetQueryTimeout(time.Minute)
+		mock.ExpectQuery(ownerQuery).WithArgs(1).WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user-1"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := storage.CartOwner(ctx, 1)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Fast query is unaffected", func(t *testing.T) {
+		storage.SetQueryTimeout(time.Second)
+		mock.ExpectQuery(ownerQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user-1"))
+
+		owner, err := storage.CartOwner(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "user-1", owner)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUnavailable(t *testing.T) {
+	tests := map[string]error{
+		"Closed connection":  sql.ErrConnDone,
+		"Refused connection": &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+	}
+
+	for name, connErr := range tests {
+		t.Run(name, func(t *testing.T) {
+			storage, mock, cleanup := newTestStorage(t)
+			defer cleanup()
+
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1;`)).WithArgs(1).
+				WillReturnError(connErr)
+
+			_, err := storage.ViewCart(context.Background(), 1, models.ViewCartOptions{})
+			assert.True(t, databaseerrors.IsUnavailable(err), err)
+			assert.NotErrorIs(t, err, databaseerrors.ErrNotFound)
+		})
+	}
+}
+
+func TestAddToCart_MaxItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+	storage.SetMaxItems(2)
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1 FOR UPDATE;`)
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)
+
+	t.Run("Below the limit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(lockQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO item`)).
+			WithArgs(1, "product", 2, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectCommit()
+
+		_, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "product", Quantity: 2})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("At the limit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(lockQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectRollback()
+
+		_, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "product", Quantity: 2})
+		assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddItems_MaxItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+	storage.SetMaxItems(3)
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1 FOR UPDATE;`)
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)
+	items := []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 1}}
+
+	t.Run("Up to the limit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(lockQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "a", 1, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "b", 1, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+		mock.ExpectCommit()
+
+		added, err := storage.AddItems(context.Background(), 1, items)
+		assert.NoError(t, err)
+		assert.Len(t, added, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Over the limit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(lockQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectRollback()
+
+		_, err := storage.AddItems(context.Background(), 1, items)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Replace over the limit", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT metadata FROM cart WHERE id=$1 FOR UPDATE;`)).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow([]byte("{}")))
+		mock.ExpectRollback()
+
+		_, err := storage.ReplaceItems(context.Background(), 1, append(items, models.CartItem{Product: "c", Quantity: 1}, models.CartItem{Product: "d", Quantity: 1}))
+		assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMoveItem_MaxItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+	storage.SetMaxItems(2)
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1 FOR UPDATE;`)
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)
+	expectLookup := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(existsQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT cart_id FROM item WHERE id=$1 AND deleted_at IS NULL;`)).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"cart_id"}).AddRow(1))
+		mock.ExpectExec(lockQuery).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("Target below the limit", func(t *testing.T) {
+		expectLookup()
+		mock.ExpectQuery(countQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE item SET cart_id=$1 WHERE id=$2 RETURNING id, cart_id, product, quantity, measure, weight, unit;`)).
+			WithArgs(2, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}).AddRow(5, 2, "apple", 3, "", 0.0, ""))
+		mock.ExpectCommit()
+
+		_, err := storage.MoveItem(context.Background(), 1, 5, 2)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Target at the limit", func(t *testing.T) {
+		expectLookup()
+		mock.ExpectQuery(countQuery).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectRollback()
+
+		_, err := storage.MoveItem(context.Background(), 1, 5, 2)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddToCart_UniqueItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+	storage.SetUniqueItems(true)
+
+	upsertQuery := regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (cart_id, product) WHERE deleted_at IS NULL DO UPDATE SET quantity = item.quantity + EXCLUDED.quantity, weight = item.weight + EXCLUDED.weight WHERE item.quantity::bigint + EXCLUDED.quantity <= $7 RETURNING id, quantity, measure, weight, unit;`)
+	columns := []string{"id", "quantity", "measure", "weight", "unit"}
+
+	t.Run("New product is inserted", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(upsertQuery).WithArgs(1, "apples", 2, "count", 0.0, "", models.MaxQuantity).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(10, 2, "count", 0.0, ""))
+		mock.ExpectCommit()
+
+		item, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "apples", Quantity: 2, Measure: models.MeasureCount})
+		assert.NoError(t, err)
+		assert.Equal(t, models.CartItem{Id: 10, CartId: 1, Product: "apples", Quantity: 2, Measure: models.MeasureCount}, item)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Existing product is merged", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(upsertQuery).WithArgs(1, "apples", 3, "count", 0.0, "", models.MaxQuantity).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(10, 5, "count", 0.0, ""))
+		mock.ExpectCommit()
+
+		item, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "apples", Quantity: 3, Measure: models.MeasureCount})
+		assert.NoError(t, err)
+		assert.Equal(t, 10, item.Id)
+		assert.Equal(t, 5, item.Quantity)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Merged quantity past the maximum", func(t *testing.T) {
+		nearMax := models.MaxQuantity - 1
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(upsertQuery).WithArgs(1, "pears", nearMax, "count", 0.0, "", models.MaxQuantity).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(11, nearMax, "count", 0.0, ""))
+		mock.ExpectCommit()
+		// The second addition conflicts, and the update is skipped.
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(upsertQuery).WithArgs(1, "pears", nearMax, "count", 0.0, "", models.MaxQuantity).
+			WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectRollback()
+
+		item := models.CartItem{Product: "pears", Quantity: nearMax, Measure: models.MeasureCount}
+		_, err := storage.AddToCart(context.Background(), 1, item)
+		require.NoError(t, err)
+		_, err = storage.AddToCart(context.Background(), 1, item)
+		assert.ErrorIs(t, err, databaseerrors.ErrQuantityOverflow)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddToCart_UniqueItemsFullCart(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+	storage.SetUniqueItems(true)
+	storage.SetMaxItems(2)
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1 FOR UPDATE;`)
+	countQuery := regexp.QuoteMeta(`SELECT COUNT(*) FROM item WHERE cart_id=$1 AND deleted_at IS NULL;`)
+	productQuery := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM item WHERE cart_id=$1 AND product=$2 AND deleted_at IS NULL);`)
+	upsertQuery := regexp.QuoteMeta(`ON CONFLICT (cart_id, product) WHERE deleted_at IS NULL`)
+	expectFull := func(product string, exists bool) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(lockQuery).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(countQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(productQuery).WithArgs(1, product).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+	}
+
+	t.Run("Existing product is merged", func(t *testing.T) {
+		expectFull("apples", true)
+		mock.ExpectQuery(upsertQuery).WithArgs(1, "apples", 3, "", 0.0, "", models.MaxQuantity).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "quantity", "measure", "weight", "unit"}).AddRow(10, 5, "", 0.0, ""))
+		mock.ExpectCommit()
+
+		item, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "apples", Quantity: 3})
+		assert.NoError(t, err)
+		assert.Equal(t, 5, item.Quantity)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("New product is refused", func(t *testing.T) {
+		expectFull("pears", false)
+		mock.ExpectRollback()
+
+		_, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "pears", Quantity: 1})
+		assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUniqueItems_BulkWritesConflict(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+	storage.SetUniqueItems(true)
+
+	insertQuery := regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)
+
+	t.Run("Add items", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "apples", 1, "", 0.0, "").WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		_, err := storage.AddItems(context.Background(), 1, []models.CartItem{{Product: "apples", Quantity: 1}})
+		assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Restore item", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE item SET deleted_at=NULL`)).WithArgs(5, 1).WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		_, err := storage.RestoreItem(context.Background(), 1, 5)
+		assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAddToCart_DuplicateProductWithoutUniqueItems(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	insertQuery := regexp.QuoteMeta(`INSERT INTO item (cart_id, product, quantity, measure, weight, unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)
+	for _, id := range []int{10, 11} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(insertQuery).WithArgs(1, "apples", 1, "", 0.0, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(id))
+		mock.ExpectCommit()
+	}
+
+	first, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+	second, err := storage.AddToCart(context.Background(), 1, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Id, second.Id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSyncUniqueItemsIndex(t *testing.T) {
+	createQuery := regexp.QuoteMeta(`CREATE UNIQUE INDEX IF NOT EXISTS item_cart_product_key ON item (cart_id, product) WHERE deleted_at IS NULL;`)
+	dropQuery := regexp.QuoteMeta(`DROP INDEX IF EXISTS item_cart_product_key;`)
+
+	t.Run("Unique items off drops the index", func(t *testing.T) {
+		storage, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		mock.ExpectExec(dropQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.NoError(t, storage.SyncUniqueItemsIndex(context.Background()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unique items on creates the index", func(t *testing.T) {
+		storage, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		storage.SetUniqueItems(true)
+		mock.ExpectExec(createQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.NoError(t, storage.SyncUniqueItemsIndex(context.Background()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Duplicate products", func(t *testing.T) {
+		storage, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+		storage.SetUniqueItems(true)
+		mock.ExpectExec(createQuery).WillReturnError(&pq.Error{Code: "23505"})
+
+		err := storage.SyncUniqueItemsIndex(context.Background())
+		assert.ErrorContains(t, err, "carts hold duplicate products")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestHasUniqueItemsIndex(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	indexQuery := regexp.QuoteMeta(`SELECT to_regclass('item_cart_product_key') IS NOT NULL;`)
+
+	for _, indexed := range []bool{true, false} {
+		mock.ExpectQuery(indexQuery).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(indexed))
+
+		got, err := storage.HasUniqueItemsIndex(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, indexed, got)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestItemIds(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	idsQuery := regexp.QuoteMeta(`SELECT id FROM item WHERE cart_id=$1 AND deleted_at IS NULL ORDER BY id;`)
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(idsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(5))
+
+		itemIds, err := storage.ItemIds(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2, 5}, itemIds)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Empty cart", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(idsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		itemIds, err := storage.ItemIds(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{}, itemIds)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing cart", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		_, err := storage.ItemIds(context.Background(), 1)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetItem(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	itemQuery := regexp.QuoteMeta(`SELECT id, cart_id, product, quantity, measure, weight, unit FROM item WHERE id=$1 AND cart_id=$2 AND deleted_at IS NULL;`)
+	columns := []string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(itemQuery).WithArgs(2, 1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(2, 1, "product", 3, "count", 0.0, ""))
+
+		item, err := storage.GetItem(context.Background(), 1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, models.CartItem{Id: 2, CartId: 1, Product: "product", Quantity: 3, Measure: "count"}, item)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart not found", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		_, err := storage.GetItem(context.Background(), 1, 2)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Item not found", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(itemQuery).WithArgs(2, 1).WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := storage.GetItem(context.Background(), 1, 2)
+		assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateItem(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	columns := []string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}
+	product, quantity := "pears", 5
+
+	tests := []struct {
+		name  string
+		patch models.ItemPatch
+		query string
+		args  []driver.Value
+		want  models.CartItem
+	}{
+		{
+			name:  "Product",
+			patch: models.ItemPatch{Product: &product},
+			query: `UPDATE item SET product=$1 WHERE id=$2 AND cart_id=$3 AND deleted_at IS NULL RETURNING id, cart_id, product, quantity, measure, weight, unit;`,
+			args:  []driver.Value{"pears", 2, 1},
+			want:  models.CartItem{Id: 2, CartId: 1, Product: "pears", Quantity: 3, Measure: "count"},
+		},
+		{
+			name:  "Quantity",
+			patch: models.ItemPatch{Quantity: &quantity},
+			query: `UPDATE item SET quantity=$1 WHERE id=$2 AND cart_id=$3 AND deleted_at IS NULL RETURNING id, cart_id, product, quantity, measure, weight, unit;`,
+			args:  []driver.Value{5, 2, 1},
+			want:  models.CartItem{Id: 2, CartId: 1, Product: "apples", Quantity: 5, Measure: "count"},
+		},
+		{
+			name:  "Product and quantity",
+			patch: models.ItemPatch{Product: &product, Quantity: &quantity},
+			query: `UPDATE item SET product=$1, quantity=$2 WHERE id=$3 AND cart_id=$4 AND deleted_at IS NULL RETURNING id, cart_id, product, quantity, measure, weight, unit;`,
+			args:  []driver.Value{"pears", 5, 2, 1},
+			want:  models.CartItem{Id: 2, CartId: 1, Product: "pears", Quantity: 5, Measure: "count"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectQuery(regexp.QuoteMeta(tt.query)).WithArgs(tt.args...).
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(tt.want.Id, tt.want.CartId, tt.want.Product, tt.want.Quantity, tt.want.Measure, 0.0, ""))
+
+			item, err := storage.UpdateItem(context.Background(), 1, 2, tt.patch)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, item)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Item not found", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE item SET quantity=$1`)).WithArgs(5, 2, 1).WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := storage.UpdateItem(context.Background(), 1, 2, models.ItemPatch{Quantity: &quantity})
+		assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cart not found", func(t *testing.T) {
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		_, err := storage.UpdateItem(context.Background(), 1, 2, models.ItemPatch{Quantity: &quantity})
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCanceledTransaction(t *testing.T) {
+	t.Run("AddToCart rolls back", func(t *testing.T) {
+		// The canceled connection is discarded, so each case gets its own.
+		storage, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := storage.AddToCart(ctx, 1, models.CartItem{Product: "product", Quantity: 2})
+		assert.Error(t, err)
+		assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("RemoveFromCart rolls back", func(t *testing.T) {
+		storage, mock, cleanup := newTestStorage(t)
+		defer cleanup()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(existsQuery).WithArgs(1).WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectRollback()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		err := storage.RemoveFromCart(ctx, 1, 2)
+		assert.Error(t, err)
+		assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	var logs bytes.Buffer
+	storage := psql.NewWithParams(slog.New(slog.NewJSONHandler(&logs, nil)), &sqlx.DB{DB: db})
+	storage.SetSlowQueryThreshold(50 * time.Millisecond)
+
+	ownerQuery := regexp.QuoteMeta(`SELECT user_id FROM cart WHERE id=$1;`)
+
+	t.Run("Fast query is not logged", func(t *testing.T) {
+		logs.Reset()
+		mock.ExpectQuery(ownerQuery).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user-1"))
+
+		_, err := storage.CartOwner(context.Background(), 1)
+		assert.NoError(t, err)
+		assert.NotContains(t, logs.String(), "Slow query")
+	})
+
+	t.Run("Slow query is logged", func(t *testing.T) {
+		logs.Reset()
+		mock.ExpectQuery(ownerQuery).WithArgs(1).WillDelayFor(100 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user-1"))
+
+		_, err := storage.CartOwner(context.Background(), 1)
+		assert.NoError(t, err)
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+		assert.Equal(t, "WARN", entry["level"])
+		assert.Equal(t, "Slow query", entry["msg"])
+		assert.Equal(t, "database.psql.CartOwner", entry["op"])
+		assert.Contains(t, entry, "elapsed")
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLLog(t *testing.T) {
+	createQuery := regexp.QuoteMeta(`INSERT INTO cart (user_id)`)
+
+	newStorage := func(t *testing.T, level slog.Level, redact bool) (*psql.Storage, sqlmock.Sqlmock, *bytes.Buffer) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		var logs bytes.Buffer
+		log := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: level}))
+		storage := psql.NewWithParams(log, &sqlx.DB{DB: db})
+		storage.SetSQLLog(true, redact)
+		return storage, mock, &logs
+	}
+
+	tests := []struct {
+		name   string
+		redact bool
+		args   []any
+	}{
+		{name: "Arguments are logged", redact: false, args: []any{"user-1"}},
+		{name: "Products and other text are redacted", redact: true, args: []any{sqllog.Redacted}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, mock, logs := newStorage(t, slog.LevelDebug, tt.redact)
+			mock.ExpectQuery(createQuery).WithArgs("user-1").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+			_, err := storage.CreateCart(context.Background(), "user-1")
+			require.NoError(t, err)
+
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			assert.Equal(t, "DEBUG", entry["level"])
+			assert.Equal(t, "SQL", entry["msg"])
+			assert.Equal(t, "database.psql.CreateCart", entry["op"])
+			assert.Equal(t, "INSERT INTO cart (user_id) VALUES (NULLIF($1, '')) RETURNING id;", entry["query"])
+			assert.Equal(t, tt.args, entry["args"])
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Nothing is logged above debug level", func(t *testing.T) {
+		storage, mock, logs := newStorage(t, slog.LevelInfo, false)
+		mock.ExpectQuery(createQuery).WithArgs("user-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+		_, err := storage.CreateCart(context.Background(), "user-1")
+		require.NoError(t, err)
+		assert.Empty(t, logs.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestConnect_Retry(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	t.Run("Succeeds on the second attempt", func(t *testing.T) {
+		var logs bytes.Buffer
+		calls := 0
+		open := func(connStr string) (*sqlx.DB, error) {
+			calls++
+			assert.Equal(t, "postgres://cartapi", connStr)
+			if calls == 1 {
+				return nil, refused
+			}
+			return sqlx.NewDb(db, "postgres"), nil
+		}
+
+		got, err := psql.Connect(slog.New(slog.NewJSONHandler(&logs, nil)), open, "postgres://cartapi",
+			psql.Retry{Attempts: 3, Backoff: time.Millisecond})
+
+		require.NoError(t, err)
+		assert.Same(t, db, got.DB)
+		assert.Equal(t, 2, calls)
+
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(logs.Bytes(), &record))
+		assert.Equal(t, "Database is not reachable, retrying", record["msg"])
+		assert.Equal(t, float64(1), record["attempt"])
+	})
+
+	t.Run("Gives up after the last attempt", func(t *testing.T) {
+		calls := 0
+		open := func(string) (*sqlx.DB, error) {
+			calls++
+			return nil, refused
+		}
+
+		_, err := psql.Connect(slogdiscard.NewDiscardLogger(), open, "", psql.Retry{Attempts: 3, Backoff: time.Millisecond})
+
+		assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+		assert.Equal(t, 3, calls)
+	})
+}
+
+func TestCloseGraceful(t *testing.T) {
+	t.Run("Waits for connections in use", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		storage := psql.NewWithParams(slogdiscard.NewDiscardLogger(), &sqlx.DB{DB: db})
+
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		mock.ExpectClose()
+
+		closed := make(chan error, 1)
+		go func() { closed <- storage.CloseGraceful(context.Background()) }()
+
+		select {
+		case <-closed:
+			t.Fatal("closed while a connection was in use")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		require.NoError(t, conn.Close())
+		select {
+		case err := <-closed:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("not closed after the connection was released")
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Closes at the deadline", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		storage := psql.NewWithParams(slogdiscard.NewDiscardLogger(), &sqlx.DB{DB: db})
+
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		mock.ExpectClose()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		assert.NoError(t, storage.CloseGraceful(ctx))
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+		// The pool closes the connection still in use once it is released.
+		_ = conn.Close()
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetOrCreateCart(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	insertQuery := regexp.QuoteMeta(`INSERT INTO cart (user_id, external_key) VALUES (NULLIF($1, ''), $2) ON CONFLICT (external_key) DO NOTHING RETURNING id;`)
+	selectQuery := regexp.QuoteMeta(`SELECT id, COALESCE(user_id, '') FROM cart WHERE external_key=$1;`)
+
+	t.Run("First call creates", func(t *testing.T) {
+		mock.ExpectQuery(insertQuery).WithArgs("user-1", "session-abc").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+		cart, created, err := storage.GetOrCreateCart(context.Background(), "session-abc", "user-1")
+		assert.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, models.Cart{Id: 7, UserId: "user-1"}, cart)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Second call returns existing", func(t *testing.T) {
+		mock.ExpectQuery(insertQuery).WithArgs("user-1", "session-abc").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(selectQuery).WithArgs("session-abc").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(7, "user-1"))
+
+		cart, created, err := storage.GetOrCreateCart(context.Background(), "session-abc", "user-1")
+		assert.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, models.Cart{Id: 7, UserId: "user-1"}, cart)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUpdateQuantities(t *testing.T) {
+	storage, mock, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	lockQuery := regexp.QuoteMeta(`SELECT id FROM cart WHERE id=$1 FOR UPDATE;`)
+	updateQuery := regexp.QuoteMeta(`UPDATE item SET quantity=$1 WHERE id=$2 AND cart_id=$3 AND deleted_at IS NULL RETURNING id, cart_id, product, quantity, measure, weight, unit;`)
+	columns := []string{"id", "cart_id", "product", "quantity", "measure", "weight", "unit"}
+	updates := []models.QuantityUpdate{{ItemId: 2, Quantity: 3}, {ItemId: 5, Quantity: 1}}
+
+	t.Run("Success", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(updateQuery).WithArgs(3, 2, 1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(2, 1, "apples", 3, "count", 0, ""))
+		mock.ExpectQuery(updateQuery).WithArgs(1, 5, 1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(5, 1, "pears", 1, "count", 0, ""))
+		mock.ExpectCommit()
+
+		items, err := storage.UpdateQuantities(context.Background(), 1, updates)
+		assert.NoError(t, err)
+		assert.Equal(t, []models.CartItem{
+			{Id: 2, CartId: 1, Product: "apples", Quantity: 3, Measure: "count"},
+			{Id: 5, CartId: 1, Product: "pears", Quantity: 1, Measure: "count"},
+		}, items)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing item rolls back", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(updateQuery).WithArgs(3, 2, 1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(2, 1, "apples", 3, "count", 0, ""))
+		mock.ExpectQuery(updateQuery).WithArgs(1, 5, 1).WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectRollback()
+
+		_, err := storage.UpdateQuantities(context.Background(), 1, updates)
+		assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing cart", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).WithArgs(1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := storage.UpdateQuantities(context.Background(), 1, updates)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
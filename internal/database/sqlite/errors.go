@@ -0,0 +1,25 @@
+package sqlite
+
+import (
+	databaseerrors "cartapi/internal/database"
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// translateError maps constraint violations reported by SQLite to the
+// storage-level sentinel errors. Any other error is returned unchanged.
+func translateError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return databaseerrors.ErrConflict
+	default:
+		return err
+	}
+}
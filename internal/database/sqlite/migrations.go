@@ -0,0 +1,93 @@
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"sync"
+
+	"github.com/pressly/goose/v3"
+)
+
+// Migrations holds the SQLite flavour of the schema. It mirrors the psql
+// migrations with the types adapted to SQLite.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+const migrationsDir = "migrations"
+
+// setupMigrations points goose at the embedded migrations. goose keeps them
+// in package state, so this only happens once.
+var setupMigrations = sync.OnceValue(func() error {
+	goose.SetBaseFS(Migrations)
+	return goose.SetDialect("sqlite3")
+})
+
+// MigrateUp applies all pending migrations.
+func MigrateUp(db *sql.DB) error {
+	const op = "database.sqlite.MigrateUp"
+
+	if err := setupMigrations(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := goose.Up(db, migrationsDir); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// MigrateDown rolls back up to steps migrations, stopping early once the
+// schema is back at version 0.
+func MigrateDown(db *sql.DB, steps int) error {
+	const op = "database.sqlite.MigrateDown"
+
+	if err := setupMigrations(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for range steps {
+		version, err := goose.GetDBVersion(db)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if version == 0 {
+			return nil
+		}
+		if err := goose.Down(db, migrationsDir); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// MigrateStatus prints the applied state of every embedded migration.
+func MigrateStatus(db *sql.DB) error {
+	const op = "database.sqlite.MigrateStatus"
+
+	if err := setupMigrations(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := goose.Status(db, migrationsDir); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// LatestMigrationVersion is the version of the newest embedded migration,
+// which an up-to-date schema is at.
+func LatestMigrationVersion() (int64, error) {
+	const op = "database.sqlite.LatestMigrationVersion"
+
+	if err := setupMigrations(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	migrations, err := goose.CollectMigrations(migrationsDir, 0, goose.MaxVersion)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return last.Version, nil
+}
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE cart (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX cart_created_at_idx ON cart (created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE item (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    cart_id INTEGER NOT NULL,
+    product VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE item;
+DROP INDEX cart_created_at_idx;
+DROP TABLE cart;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE item ADD COLUMN measure VARCHAR(16) NOT NULL DEFAULT 'count';
+ALTER TABLE item ADD COLUMN weight REAL NOT NULL DEFAULT 0;
+ALTER TABLE item ADD COLUMN unit VARCHAR(16) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE item DROP COLUMN unit;
+ALTER TABLE item DROP COLUMN weight;
+ALTER TABLE item DROP COLUMN measure;
+-- +goose StatementEnd
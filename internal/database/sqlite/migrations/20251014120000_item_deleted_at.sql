@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE item ADD COLUMN deleted_at DATETIME NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE item DROP COLUMN deleted_at;
+-- +goose StatementEnd
@@ -0,0 +1,1706 @@
+package sqlite
+
+import (
+	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/database/sqllog"
+	"cartapi/internal/models"
+	"cartapi/internal/tracing"
+	"cartapi/pkg/lib/logger/sl"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pressly/goose/v3"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+)
+
+// timestampLayout matches the format SQLite uses for CURRENT_TIMESTAMP, so
+// created_at can be compared as text.
+const timestampLayout = "2006-01-02 15:04:05"
+
+type Storage struct {
+	log          *slog.Logger
+	db           *sqlx.DB
+	softDelete   bool
+	queryTimeout time.Duration
+	maxItems     int
+	uniqueItems  bool
+	slowQuery    time.Duration
+	sqlLog       bool
+	redactSQL    bool
+	tracer       trace.Tracer
+}
+
+// New opens the database file at path (":memory:" for a throwaway database)
+// and applies the migrations.
+func New(log *slog.Logger, path string) (*Storage, error) {
+	const op = "database.sqlite.New"
+	db, err := sqlx.Connect("sqlite", path)
+	if err != nil {
+		log.With("op", op).Error("Error connect to database", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY and
+	// keeps ":memory:" databases from being split across connections.
+	db.SetMaxOpenConns(1)
+
+	if err := MigrateUp(db.DB); err != nil {
+		log.With("op", op).Error("Error applying migrations", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{
+		log:    log,
+		db:     db,
+		tracer: tracing.Noop(),
+	}, nil
+}
+
+// SetSoftDelete makes removed items get a deleted_at timestamp instead of
+// being deleted. It must be called before the storage is used.
+func (s *Storage) SetSoftDelete(enabled bool) {
+	s.softDelete = enabled
+}
+
+// SetQueryTimeout bounds every storage call by d on top of the caller's
+// context, so queries are capped even for requests without a deadline. Zero
+// leaves them unbounded. It must be called before the storage is used.
+func (s *Storage) SetQueryTimeout(d time.Duration) {
+	s.queryTimeout = d
+}
+
+// SetMaxItems makes every write that adds items to a cart (AddToCart,
+// AddItems, ReplaceItems, CopyCart, MoveItem and RestoreItem) refuse to grow
+// it past n items; zero means no limit. It must be called before the
+// storage is used.
+func (s *Storage) SetMaxItems(n int) {
+	s.maxItems = n
+}
+
+// SetUniqueItems makes AddToCart merge an item into the live item of the
+// same product, adding up their quantities and weights, instead of inserting
+// a duplicate. AddItems, ReplaceItems and RestoreItem don't merge; they fail
+// with ErrConflict when they would leave a cart holding a product twice, as
+// do UpdateItem and MoveItem. Writers are serialized, so no index is needed
+// to keep concurrent additions from both inserting. It must be called before
+// the storage is used.
+func (s *Storage) SetUniqueItems(enabled bool) {
+	s.uniqueItems = enabled
+}
+
+// SetSlowQueryThreshold makes every storage call taking at least d log a
+// warning. Zero disables the warning. It must be called before the storage
+// is used.
+func (s *Storage) SetSlowQueryThreshold(d time.Duration) {
+	s.slowQuery = d
+}
+
+// logSlow warns when the call that started at start took at least the slow
+// query threshold.
+func (s *Storage) logSlow(log *slog.Logger, start time.Time) {
+	if s.slowQuery <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= s.slowQuery {
+		log.Warn("Slow query", slog.Duration("elapsed", elapsed), slog.Duration("threshold", s.slowQuery))
+	}
+}
+
+// withQueryTimeout derives the context the queries of one call run under.
+// Canceling ctx still cancels them.
+func (s *Storage) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// SetSQLLog makes every statement be logged with its arguments at debug
+// level; with redactProducts the text arguments are hidden. It must be
+// called before the storage is used.
+func (s *Storage) SetSQLLog(enabled bool, redactProducts bool) {
+	s.sqlLog = enabled
+	s.redactSQL = redactProducts
+}
+
+// logged is q, logging its statements to log when SetSQLLog enabled it.
+func (s *Storage) logged(log *slog.Logger, q sqllog.Queryer) sqllog.Queryer {
+	if !s.sqlLog {
+		return q
+	}
+	return sqllog.Wrap(log, q, s.redactSQL)
+}
+
+// SetTracer sets the tracer recording a span per storage call. It must be
+// called before the storage is used.
+func (s *Storage) SetTracer(tracer trace.Tracer) {
+	s.tracer = tracer
+}
+
+// Ping checks that the database answers.
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "database.sqlite.Ping"
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		s.log.With("op", op).Error("Database is not reachable", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// CurrentMigrationVersion returns the version of the last migration applied
+// to the database.
+func (s *Storage) CurrentMigrationVersion(ctx context.Context) (int64, error) {
+	const op = "database.sqlite.CurrentMigrationVersion"
+	log := s.log.With("op", op)
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	if err := setupMigrations(); err != nil {
+		log.Error("Failed to set up migrations", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	version, err := goose.GetDBVersionContext(ctx, s.db.DB)
+	if err != nil {
+		log.Error("Failed to get migration version", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	return version, nil
+}
+
+func (s *Storage) Close() error {
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("failed to close database connection: %w", err)
+	}
+	return nil
+}
+
+// closePollInterval is how often CloseGraceful checks for queries still
+// holding a connection.
+const closePollInterval = 10 * time.Millisecond
+
+// CloseGraceful waits for the connections in use to be returned to the pool,
+// so that running queries can finish, then closes it. When ctx is done first
+// the pool is closed anyway.
+func (s *Storage) CloseGraceful(ctx context.Context) error {
+	const op = "database.sqlite.CloseGraceful"
+	log := s.log.With("op", op)
+
+	ticker := time.NewTicker(closePollInterval)
+	defer ticker.Stop()
+	for inUse := s.db.Stats().InUse; inUse > 0; inUse = s.db.Stats().InUse {
+		select {
+		case <-ctx.Done():
+			log.Warn("Closing with queries in flight", slog.Int("in_use", inUse), sl.Err(ctx.Err()))
+			return s.Close()
+		case <-ticker.C:
+		}
+	}
+	return s.Close()
+}
+
+func (s *Storage) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+func (s *Storage) CreateCart(ctx context.Context, userId string) (models.Cart, error) {
+	const op = "database.sqlite.CreateCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var cartId int
+	err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		INSERT INTO cart (user_id)
+		VALUES (NULLIF(?, ''))
+		RETURNING id;
+	`, userId).Scan(&cartId)
+	if err != nil {
+		log.Error("Error creating cart", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{Id: cartId, UserId: userId}, nil
+}
+
+// GetOrCreateCart returns the cart created for the external key, creating
+// it for userId if there is none yet; created tells which happened.
+func (s *Storage) GetOrCreateCart(ctx context.Context, key string, userId string) (models.Cart, bool, error) {
+	const op = "database.sqlite.GetOrCreateCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, false, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var cartId int
+	err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		INSERT INTO cart (user_id, external_key)
+		VALUES (NULLIF(?, ''), ?)
+		ON CONFLICT (external_key) DO NOTHING
+		RETURNING id;
+	`, userId, key).Scan(&cartId)
+	if err == nil {
+		return models.Cart{Id: cartId, UserId: userId}, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Error("Error creating cart", sl.Err(err))
+		return models.Cart{}, false, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	var owner string
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		SELECT id, COALESCE(user_id, '') FROM cart WHERE external_key=?;
+	`, key).Scan(&cartId, &owner); err != nil {
+		log.Error("Failed to get cart by key", sl.Err(err))
+		return models.Cart{}, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{Id: cartId, UserId: owner}, false, nil
+}
+
+func (s *Storage) CartOwner(ctx context.Context, cartId int) (string, error) {
+	const op = "database.sqlite.CartOwner"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return "", fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var userId sql.NullString
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `SELECT user_id FROM cart WHERE id=?;`, cartId).Scan(&userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return "", fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to get cart owner", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return userId.String, nil
+}
+
+// CreateCarts creates n empty carts with a single statement, so either all
+// of them are created or none, and returns their ids in ascending order.
+func (s *Storage) CreateCarts(ctx context.Context, n int) ([]int, error) {
+	const op = "database.sqlite.CreateCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var ids []int
+	if err := sqlx.SelectContext(ctx, s.logged(log, s.db), &ids, `
+		WITH RECURSIVE seq(n) AS (SELECT 1 UNION ALL SELECT n+1 FROM seq WHERE n < ?)
+		INSERT INTO cart (created_at)
+		SELECT CURRENT_TIMESTAMP FROM seq
+		RETURNING id;
+	`, n); err != nil {
+		log.Error("Error creating carts", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+func (s *Storage) AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error) {
+	const op = "database.sqlite.AddToCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := cartExists(ctx, s.logged(log, tx), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var itemId int
+	merged := false
+	if s.uniqueItems {
+		// The oldest item absorbs the addition should duplicates predate
+		// the setting.
+		var existingQuantity int
+		err := s.logged(log, tx).QueryRowxContext(ctx, `
+			SELECT id, quantity FROM item
+			WHERE cart_id = ? AND product = ? AND deleted_at IS NULL
+			ORDER BY id LIMIT 1;
+		`, cartId, item.Product).Scan(&itemId, &existingQuantity)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Error("Failed to look up item of the same product", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		merged = err == nil
+		if merged && existingQuantity+item.Quantity > models.MaxQuantity {
+			log.Warn("Merged quantity overflows", sl.Err(databaseerrors.ErrQuantityOverflow))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrQuantityOverflow)
+		}
+	}
+
+	if merged {
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			UPDATE item SET quantity = quantity + ?, weight = weight + ?
+			WHERE id = ?
+			RETURNING quantity, measure, weight, unit;
+		`, item.Quantity, item.Weight, itemId).Scan(&item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+			log.Error("Failed to merge item", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+	} else {
+		if s.maxItems > 0 {
+			full, err := cartFull(ctx, s.logged(log, tx), cartId, s.maxItems, 1)
+			if err != nil {
+				log.Error("Failed to count cart items", sl.Err(err))
+				return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+			}
+			if full {
+				log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+				return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+			}
+		}
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id;
+		`, cartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit).Scan(&itemId); err != nil {
+			log.Error("Failed to insert item", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return models.CartItem{
+		Id:       itemId,
+		CartId:   cartId,
+		Product:  item.Product,
+		Quantity: item.Quantity,
+		Measure:  item.Measure,
+		Weight:   item.Weight,
+		Unit:     item.Unit,
+	}, nil
+}
+
+func (s *Storage) RemoveFromCart(ctx context.Context, cartId int, itemId int) error {
+	const op = "database.sqlite.RemoveFromCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := cartExists(ctx, s.logged(log, tx), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	removeQuery := `DELETE FROM item WHERE id=? AND cart_id=? AND deleted_at IS NULL;`
+	if s.softDelete {
+		removeQuery = `UPDATE item SET deleted_at=CURRENT_TIMESTAMP WHERE id=? AND cart_id=? AND deleted_at IS NULL;`
+	}
+	res, err := s.logged(log, tx).ExecContext(ctx, removeQuery, itemId, cartId)
+	if err != nil {
+		log.Error("Failed to delete item", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		log.Error("Failed to get affected rows", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	} else if n == 0 {
+		log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return nil
+}
+
+// GetItem returns a live item of the cart.
+func (s *Storage) GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "database.sqlite.GetItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	if err := cartExists(ctx, s.logged(log, s.db), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	var item models.CartItem
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+		WHERE id=? AND cart_id=? AND deleted_at IS NULL;
+	`, itemId, cartId).Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+		log.Error("Failed to get item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return item, nil
+}
+
+// ItemIds returns the ids of the live items of the cart in id order,
+// without reading the rest of their columns.
+func (s *Storage) ItemIds(ctx context.Context, cartId int) ([]int, error) {
+	const op = "database.sqlite.ItemIds"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	if err := cartExists(ctx, s.logged(log, s.db), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	itemIds := []int{}
+	if err := sqlx.SelectContext(ctx, s.logged(log, s.db), &itemIds, `
+		SELECT id FROM item WHERE cart_id=? AND deleted_at IS NULL ORDER BY id;
+	`, cartId); err != nil {
+		log.Error("Failed to get item ids", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return itemIds, nil
+}
+
+// UpdateItem changes only the columns of the fields set in patch.
+func (s *Storage) UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error) {
+	const op = "database.sqlite.UpdateItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	if err := cartExists(ctx, s.logged(log, s.db), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Only the column names come from here; every value is a parameter.
+	var sets []string
+	var args []any
+	if patch.Product != nil {
+		args = append(args, *patch.Product)
+		sets = append(sets, "product=?")
+	}
+	if patch.Quantity != nil {
+		args = append(args, *patch.Quantity)
+		sets = append(sets, "quantity=?")
+	}
+	if len(sets) == 0 {
+		return s.GetItem(ctx, cartId, itemId)
+	}
+	query := fmt.Sprintf(`
+		UPDATE item SET %s
+		WHERE id=? AND cart_id=? AND deleted_at IS NULL
+		RETURNING id, cart_id, product, quantity, measure, weight, unit;
+	`, strings.Join(sets, ", "))
+	args = append(args, itemId, cartId)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var item models.CartItem
+	if err := s.logged(log, tx).QueryRowxContext(ctx, query, args...).Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+		log.Error("Failed to update item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if s.uniqueItems && patch.Product != nil {
+		count, err := productCount(ctx, s.logged(log, tx), cartId, item.Product)
+		if err != nil {
+			log.Error("Failed to look up item of the same product", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if count > 1 {
+			log.Warn("Product already in cart", sl.Err(databaseerrors.ErrConflict))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return item, nil
+}
+
+// UpdateQuantities sets the quantity of every item in updates in one
+// transaction. Nothing is changed when any of the items is not a live item
+// of the cart.
+func (s *Storage) UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error) {
+	const op = "database.sqlite.UpdateQuantities"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `SELECT id FROM cart WHERE id=?;`, cartId).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to lock cart", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	items := make([]models.CartItem, 0, len(updates))
+	for _, update := range updates {
+		var item models.CartItem
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			UPDATE item SET quantity=?
+			WHERE id=? AND cart_id=? AND deleted_at IS NULL
+			RETURNING id, cart_id, product, quantity, measure, weight, unit;
+		`, update.Quantity, update.ItemId, cartId).Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Warn("Cart item doesn't exist", slog.Int("item_id", update.ItemId), sl.Err(databaseerrors.ErrItemNotFound))
+				return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+			}
+			log.Error("Failed to update item", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+		items = append(items, item)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return items, nil
+}
+
+func (s *Storage) ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error) {
+	const op = "database.sqlite.ViewCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var rawMetadata string
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `SELECT metadata FROM cart WHERE id=?;`, cartId).Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to check cart existence", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// LIKE is case-insensitive for ASCII in SQLite, matching ILIKE in psql.
+	filter := "WHERE cart_id=? AND deleted_at IS NULL"
+	args := []any{cartId}
+	if opts.Product != "" {
+		filter += ` AND product LIKE '%' || ? || '%' ESCAPE '\'`
+		args = append(args, escapeLike(opts.Product))
+	}
+
+	var total int
+	if err := s.logged(log, s.db).QueryRowContext(ctx, `SELECT COUNT(*) FROM item `+filter+`;`, args...).Scan(&total); err != nil {
+		log.Error("Failed to count items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var items []models.CartItem
+	if err := sqlx.SelectContext(ctx, s.logged(log, s.db), &items, `
+		SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+		`+filter+`
+		ORDER BY id
+		LIMIT ? OFFSET ?;
+	`, append(args, opts.Limit, opts.Offset)...); err != nil {
+		log.Error("Failed to query items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{
+		Id:       cartId,
+		Items:    items,
+		Total:    total,
+		Metadata: metadata,
+	}, nil
+}
+
+// ViewCarts loads the carts with one query and all of their items with
+// another, instead of one ViewCart per cart. The ids are bound as an IN
+// list since SQLite has no arrays.
+func (s *Storage) ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error) {
+	const op = "database.sqlite.ViewCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	query, args, err := sqlx.In(`SELECT id, user_id, metadata FROM cart WHERE id IN (?);`, cartIds)
+	if err != nil {
+		log.Error("Failed to build carts query", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	cartRows, err := s.logged(log, s.db).QueryxContext(ctx, query, args...)
+	if err != nil {
+		log.Error("Failed to query carts", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer cartRows.Close()
+
+	found := make(map[int]*models.Cart, len(cartIds))
+	for cartRows.Next() {
+		var (
+			cart        models.Cart
+			userId      sql.NullString
+			rawMetadata string
+		)
+		if err := cartRows.Scan(&cart.Id, &userId, &rawMetadata); err != nil {
+			log.Error("Failed to scan cart", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		cart.UserId = userId.String
+		if cart.Metadata, err = decodeMetadata(rawMetadata); err != nil {
+			log.Error("Failed to decode cart metadata", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		found[cart.Id] = &cart
+	}
+	if err := cartRows.Err(); err != nil {
+		log.Error("Failed to read carts", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(found) > 0 {
+		query, args, err := sqlx.In(`
+			SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+			WHERE cart_id IN (?) AND deleted_at IS NULL
+			ORDER BY cart_id, id;
+		`, cartIds)
+		if err != nil {
+			log.Error("Failed to build items query", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		itemRows, err := s.logged(log, s.db).QueryxContext(ctx, query, args...)
+		if err != nil {
+			log.Error("Failed to query items", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		defer itemRows.Close()
+
+		for itemRows.Next() {
+			var item models.CartItem
+			if err := itemRows.Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+				log.Error("Failed to scan row", sl.Err(err))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			if cart, ok := found[item.CartId]; ok {
+				cart.Items = append(cart.Items, item)
+				cart.Total++
+			}
+		}
+		if err := itemRows.Err(); err != nil {
+			log.Error("Failed to read items", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	carts := make([]models.Cart, 0, len(found))
+	for _, id := range cartIds {
+		if cart, ok := found[id]; ok {
+			carts = append(carts, *cart)
+			delete(found, id)
+		}
+	}
+	return carts, nil
+}
+
+func (s *Storage) StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error) {
+	const op = "database.sqlite.StreamCartItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var rawMetadata string
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `SELECT metadata FROM cart WHERE id=?;`, cartId).Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to check cart existence", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	filter := "WHERE cart_id=? AND deleted_at IS NULL"
+	args := []any{cartId}
+	if product != "" {
+		filter += ` AND product LIKE '%' || ? || '%' ESCAPE '\'`
+		args = append(args, escapeLike(product))
+	}
+
+	rows, err := s.logged(log, s.db).QueryxContext(ctx, `
+		SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+		`+filter+`
+		ORDER BY id;
+	`, args...)
+	if err != nil {
+		log.Error("Failed to query items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	total := 0
+	for rows.Next() {
+		var item models.CartItem
+		if err := rows.Scan(&item.Id, &item.CartId, &item.Product, &item.Quantity, &item.Measure, &item.Weight, &item.Unit); err != nil {
+			log.Error("Failed to scan row", sl.Err(err))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := yield(item); err != nil {
+			return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		}
+		total++
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Failed to read items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{
+		Id:       cartId,
+		Total:    total,
+		Metadata: metadata,
+	}, nil
+}
+
+func (s *Storage) MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error) {
+	const op = "database.sqlite.MoveItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range []int{cartId, targetCartId} {
+		if err := cartExists(ctx, s.logged(log, tx), id); err != nil {
+			log.Warn("Cart existence check failed", slog.Int("cart_id", id), sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if s.maxItems > 0 && targetCartId != cartId {
+		full, err := cartFull(ctx, s.logged(log, tx), targetCartId, s.maxItems, 1)
+		if err != nil {
+			log.Error("Failed to count cart items", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if full {
+			log.Warn("Target cart is full", sl.Err(databaseerrors.ErrCartFull))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+		}
+	}
+
+	var moved models.CartItem
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `
+		UPDATE item SET cart_id=?
+		WHERE id=? AND cart_id=? AND deleted_at IS NULL
+		RETURNING id, cart_id, product, quantity, measure, weight, unit;
+	`, targetCartId, itemId, cartId).Scan(&moved.Id, &moved.CartId, &moved.Product, &moved.Quantity, &moved.Measure, &moved.Weight, &moved.Unit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+		log.Error("Failed to move item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if s.uniqueItems && targetCartId != cartId {
+		count, err := productCount(ctx, s.logged(log, tx), targetCartId, moved.Product)
+		if err != nil {
+			log.Error("Failed to look up item of the same product", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if count > 1 {
+			log.Warn("Product already in target cart", sl.Err(databaseerrors.ErrConflict))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return moved, nil
+}
+
+func (s *Storage) CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error) {
+	const op = "database.sqlite.CopyCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := cartExists(ctx, s.logged(log, tx), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var newCartId int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `INSERT INTO cart (user_id) VALUES (NULLIF(?, '')) RETURNING id;`, userId).Scan(&newCartId); err != nil {
+		log.Error("Error creating cart", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := s.logged(log, tx).ExecContext(ctx, `
+		INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+		SELECT ?, product, quantity, measure, weight, unit FROM item
+		WHERE cart_id=? AND deleted_at IS NULL
+		ORDER BY id;
+	`, newCartId, cartId); err != nil {
+		log.Error("Failed to copy items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	var copiedItems []models.CartItem
+	if err := sqlx.SelectContext(ctx, s.logged(log, tx), &copiedItems, `
+		SELECT id, cart_id, product, quantity, measure, weight, unit FROM item
+		WHERE cart_id=?
+		ORDER BY id;
+	`, newCartId); err != nil {
+		log.Error("Failed to query copied items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if s.maxItems > 0 && len(copiedItems) > s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return models.Cart{
+		Id:     newCartId,
+		UserId: userId,
+		Items:  copiedItems,
+	}, nil
+}
+
+// RemoveItems deletes the given items from the cart in one transaction and
+// returns the ids that were actually deleted. Ids that don't belong to the
+// cart are ignored.
+func (s *Storage) RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error) {
+	const op = "database.sqlite.RemoveItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := cartExists(ctx, s.logged(log, tx), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deletedIds := []int{}
+	if len(itemIds) == 0 {
+		return deletedIds, nil
+	}
+
+	removeQuery := `DELETE FROM item WHERE cart_id=? AND id IN (?) AND deleted_at IS NULL RETURNING id;`
+	if s.softDelete {
+		removeQuery = `UPDATE item SET deleted_at=CURRENT_TIMESTAMP WHERE cart_id=? AND id IN (?) AND deleted_at IS NULL RETURNING id;`
+	}
+	query, args, err := sqlx.In(removeQuery, cartId, itemIds)
+	if err != nil {
+		log.Error("Failed to build delete query", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := sqlx.SelectContext(ctx, s.logged(log, tx), &deletedIds, query, args...); err != nil {
+		log.Error("Failed to delete items", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return deletedIds, nil
+}
+
+// AddItems inserts all given items into the cart in one transaction.
+func (s *Storage) AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error) {
+	const op = "database.sqlite.AddItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := cartExists(ctx, s.logged(log, tx), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if s.maxItems > 0 {
+		full, err := cartFull(ctx, s.logged(log, tx), cartId, s.maxItems, len(items))
+		if err != nil {
+			log.Error("Failed to count cart items", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if full {
+			log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+			return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+		}
+	}
+
+	addedItems := make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		if s.uniqueItems {
+			count, err := productCount(ctx, s.logged(log, tx), cartId, item.Product)
+			if err != nil {
+				log.Error("Failed to look up item of the same product", sl.Err(err))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			if count > 0 {
+				log.Warn("Product already in cart", sl.Err(databaseerrors.ErrConflict))
+				return nil, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+			}
+		}
+		var itemId int
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id;
+		`, cartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit).Scan(&itemId); err != nil {
+			log.Error("Failed to insert item", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+		addedItems = append(addedItems, models.CartItem{
+			Id:       itemId,
+			CartId:   cartId,
+			Product:  item.Product,
+			Quantity: item.Quantity,
+			Measure:  item.Measure,
+			Weight:   item.Weight,
+			Unit:     item.Unit,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return addedItems, nil
+}
+
+// ReplaceItems deletes every item of the cart and inserts the given ones in
+// one transaction, returning the resulting cart.
+func (s *Storage) ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error) {
+	const op = "database.sqlite.ReplaceItems"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var rawMetadata string
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `SELECT metadata FROM cart WHERE id=?;`, cartId).Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to check cart existence", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if s.maxItems > 0 && len(items) > s.maxItems {
+		log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+	}
+
+	clearQuery := `DELETE FROM item WHERE cart_id=? AND deleted_at IS NULL;`
+	if s.softDelete {
+		clearQuery = `UPDATE item SET deleted_at=CURRENT_TIMESTAMP WHERE cart_id=? AND deleted_at IS NULL;`
+	}
+	if _, err := s.logged(log, tx).ExecContext(ctx, clearQuery, cartId); err != nil {
+		log.Error("Failed to delete items", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	addedItems := make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		if s.uniqueItems {
+			count, err := productCount(ctx, s.logged(log, tx), cartId, item.Product)
+			if err != nil {
+				log.Error("Failed to look up item of the same product", sl.Err(err))
+				return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+			}
+			if count > 0 {
+				log.Warn("Product already in cart", sl.Err(databaseerrors.ErrConflict))
+				return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+			}
+		}
+		var itemId int
+		if err := s.logged(log, tx).QueryRowxContext(ctx, `
+			INSERT INTO item (cart_id, product, quantity, measure, weight, unit)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id;
+		`, cartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit).Scan(&itemId); err != nil {
+			log.Error("Failed to insert item", sl.Err(err))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+		}
+		addedItems = append(addedItems, models.CartItem{
+			Id:       itemId,
+			CartId:   cartId,
+			Product:  item.Product,
+			Quantity: item.Quantity,
+			Measure:  item.Measure,
+			Weight:   item.Weight,
+			Unit:     item.Unit,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return models.Cart{Id: cartId, Items: addedItems, Total: len(addedItems), Metadata: metadata}, nil
+}
+
+// DeleteExpiredCarts deletes the carts created before cutoff together with
+// their items and returns how many carts were removed.
+func (s *Storage) DeleteExpiredCarts(ctx context.Context, cutoff time.Time) (int64, error) {
+	const op = "database.sqlite.DeleteExpiredCarts"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return 0, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	ts := cutoff.UTC().Format(timestampLayout)
+	if _, err := s.logged(log, tx).ExecContext(ctx, `
+		DELETE FROM item
+		WHERE cart_id IN (SELECT id FROM cart WHERE created_at < ?);
+	`, ts); err != nil {
+		log.Error("Failed to delete items of expired carts", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	res, err := s.logged(log, tx).ExecContext(ctx, `DELETE FROM cart WHERE created_at < ?;`, ts)
+	if err != nil {
+		log.Error("Failed to delete expired carts", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		log.Error("Failed to get affected rows", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return deleted, nil
+}
+
+// PurgeCart deletes the cart together with its soft-deleted items, but only
+// while it holds no live item. SQLite serializes writers, so no item can be
+// added between the count and the delete.
+func (s *Storage) PurgeCart(ctx context.Context, cartId int) error {
+	const op = "database.sqlite.PurgeCart"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `SELECT id FROM cart WHERE id=?;`, cartId).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to check cart existence", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	var count int
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `SELECT COUNT(*) FROM item WHERE cart_id=? AND deleted_at IS NULL;`, cartId).Scan(&count); err != nil {
+		log.Error("Failed to count cart items", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+	if count > 0 {
+		log.Warn("Cart is not empty", slog.Int("items", count), sl.Err(databaseerrors.ErrCartNotEmpty))
+		return fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotEmpty)
+	}
+
+	if _, err := s.logged(log, tx).ExecContext(ctx, `DELETE FROM item WHERE cart_id=?;`, cartId); err != nil {
+		log.Error("Failed to delete items of cart", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if _, err := s.logged(log, tx).ExecContext(ctx, `DELETE FROM cart WHERE id=?;`, cartId); err != nil {
+		log.Error("Failed to delete cart", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return nil
+}
+
+// CartStats counts the carts and live items, and the top products with the
+// most items, ties broken by product name.
+func (s *Storage) CartStats(ctx context.Context, top int) (models.CartStats, error) {
+	const op = "database.sqlite.CartStats"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	var stats models.CartStats
+	if err := s.logged(log, s.db).QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM cart),
+			(SELECT COUNT(*) FROM item WHERE deleted_at IS NULL);
+	`).Scan(&stats.Carts, &stats.Items); err != nil {
+		log.Error("Failed to count carts and items", sl.Err(err))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.logged(log, s.db).QueryContext(ctx, `
+		SELECT product, COUNT(*) AS items, COALESCE(SUM(quantity), 0) FROM item
+		WHERE deleted_at IS NULL
+		GROUP BY product
+		ORDER BY items DESC, product
+		LIMIT ?;
+	`, top)
+	if err != nil {
+		log.Error("Failed to query product stats", sl.Err(err))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	stats.TopProducts = make([]models.ProductStats, 0, top)
+	for rows.Next() {
+		var product models.ProductStats
+		if err := rows.Scan(&product.Product, &product.Items, &product.Quantity); err != nil {
+			log.Error("Failed to scan row", sl.Err(err))
+			return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+		}
+		stats.TopProducts = append(stats.TopProducts, product)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error("Failed to read product stats", sl.Err(err))
+		return models.CartStats{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// PatchCartMetadata applies patch to the cart metadata as a JSON merge
+// patch: keys mapped to nil are removed, the others are set.
+func (s *Storage) PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error) {
+	const op = "database.sqlite.PatchCartMetadata"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	rawPatch, err := json.Marshal(patch)
+	if err != nil {
+		log.Error("Failed to encode metadata patch", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var rawMetadata string
+	if err := s.logged(log, s.db).QueryRowxContext(ctx, `
+		UPDATE cart SET metadata = json_patch(metadata, ?)
+		WHERE id=?
+		RETURNING metadata;
+	`, string(rawPatch), cartId).Scan(&rawMetadata); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Cart doesn't exist", sl.Err(databaseerrors.ErrCartNotFound))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartNotFound)
+		}
+		log.Error("Failed to update cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	metadata, err := decodeMetadata(rawMetadata)
+	if err != nil {
+		log.Error("Failed to decode cart metadata", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.Cart{Id: cartId, Metadata: metadata}, nil
+}
+
+// decodeMetadata decodes the metadata column, leaving an empty object nil.
+func decodeMetadata(raw string) (map[string]string, error) {
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return metadata, nil
+}
+
+// RestoreItem clears deleted_at on a soft-deleted item of the cart. It
+// returns databaseerrors.ErrItemNotFound unless such a deleted item exists.
+func (s *Storage) RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "database.sqlite.RestoreItem"
+	log := s.log.With("op", op)
+	ctx, span := s.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		log.Error("Context is over", sl.Err(ctx.Err()))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, ctx.Err())
+	default:
+	}
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+	defer s.logSlow(log, time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		log.Error("Failed to begin transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := cartExists(ctx, s.logged(log, tx), cartId); err != nil {
+		log.Warn("Cart existence check failed", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var restored models.CartItem
+	if err := s.logged(log, tx).QueryRowxContext(ctx, `
+		UPDATE item SET deleted_at=NULL
+		WHERE id=? AND cart_id=? AND deleted_at IS NOT NULL
+		RETURNING id, cart_id, product, quantity, measure, weight, unit;
+	`, itemId, cartId).Scan(&restored.Id, &restored.CartId, &restored.Product, &restored.Quantity, &restored.Measure, &restored.Weight, &restored.Unit); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn("Deleted cart item doesn't exist", sl.Err(databaseerrors.ErrItemNotFound))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrItemNotFound)
+		}
+		log.Error("Failed to restore item", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	if s.maxItems > 0 {
+		// The restored item is already counted.
+		full, err := cartFull(ctx, s.logged(log, tx), cartId, s.maxItems, 0)
+		if err != nil {
+			log.Error("Failed to count cart items", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if full {
+			log.Warn("Cart is full", sl.Err(databaseerrors.ErrCartFull))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrCartFull)
+		}
+	}
+	if s.uniqueItems {
+		count, err := productCount(ctx, s.logged(log, tx), cartId, restored.Product)
+		if err != nil {
+			log.Error("Failed to look up item of the same product", sl.Err(err))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if count > 1 {
+			log.Warn("Product already in cart", sl.Err(databaseerrors.ErrConflict))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, databaseerrors.ErrConflict)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Error("Failed to commit transaction", sl.Err(err))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, translateError(err))
+	}
+
+	return restored, nil
+}
+
+// cartExists returns databaseerrors.ErrCartNotFound when there is no cart with
+// the given id.
+func cartExists(ctx context.Context, q sqlx.QueryerContext, cartId int) error {
+	var existsChecker int
+	if err := q.QueryRowxContext(ctx, `SELECT id FROM cart WHERE id=?;`, cartId).Scan(&existsChecker); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return databaseerrors.ErrCartNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// cartFull reports whether adding n live items would take the cart past max.
+func cartFull(ctx context.Context, q sqlx.QueryerContext, cartId int, max int, n int) (bool, error) {
+	var count int
+	if err := q.QueryRowxContext(ctx, `SELECT COUNT(*) FROM item WHERE cart_id=? AND deleted_at IS NULL;`, cartId).Scan(&count); err != nil {
+		return false, err
+	}
+	return count+n > max, nil
+}
+
+// productCount returns how many live items of the product the cart holds.
+func productCount(ctx context.Context, q sqlx.QueryerContext, cartId int, product string) (int, error) {
+	var count int
+	if err := q.QueryRowxContext(ctx, `SELECT COUNT(*) FROM item WHERE cart_id=? AND product=? AND deleted_at IS NULL;`, cartId, product).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// escapeLike escapes the LIKE wildcards so the value is matched literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+var _ databaseerrors.Storage = (*Storage)(nil)
@@ -0,0 +1,782 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/database/sqlite"
+	"cartapi/internal/models"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T) *sqlite.Storage {
+	storage, err := sqlite.New(slogdiscard.NewDiscardLogger(), ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestCreateCart(t *testing.T) {
+	storage := newTestStorage(t)
+
+	first, err := storage.CreateCart(context.Background(), "")
+	assert.NoError(t, err)
+	second, err := storage.CreateCart(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Id+1, second.Id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = storage.CreateCart(ctx, "")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCartOwner(t *testing.T) {
+	storage := newTestStorage(t)
+
+	owned, err := storage.CreateCart(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", owned.UserId)
+	unowned, err := storage.CreateCart(context.Background(), "")
+	require.NoError(t, err)
+
+	owner, err := storage.CartOwner(context.Background(), owned.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", owner)
+
+	owner, err = storage.CartOwner(context.Background(), unowned.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, owner)
+
+	_, err = storage.CartOwner(context.Background(), 999)
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+}
+
+func TestAddToCart(t *testing.T) {
+	storage := newTestStorage(t)
+	cart, err := storage.CreateCart(context.Background(), "")
+	require.NoError(t, err)
+
+	item, err := storage.AddToCart(context.Background(), cart.Id, models.CartItem{Product: "product", Quantity: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, models.CartItem{Id: item.Id, CartId: cart.Id, Product: "product", Quantity: 2}, item)
+
+	_, err = storage.AddToCart(context.Background(), cart.Id+1, models.CartItem{Product: "product", Quantity: 2})
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+}
+
+func TestRemoveFromCart(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	other, _ := storage.CreateCart(ctx, "")
+	item, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 1})
+	otherItem, _ := storage.AddToCart(ctx, other.Id, models.CartItem{Product: "product", Quantity: 1})
+
+	assert.ErrorIs(t, storage.RemoveFromCart(ctx, 42, item.Id), databaseerrors.ErrCartNotFound)
+	assert.ErrorIs(t, storage.RemoveFromCart(ctx, cart.Id, otherItem.Id), databaseerrors.ErrItemNotFound)
+	assert.NoError(t, storage.RemoveFromCart(ctx, cart.Id, item.Id))
+	assert.ErrorIs(t, storage.RemoveFromCart(ctx, cart.Id, item.Id), databaseerrors.ErrItemNotFound)
+}
+
+func TestViewCart(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	apple, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "Apple", Quantity: 1})
+	pear, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 2})
+	percent, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "50% apple", Quantity: 3})
+
+	tests := []struct {
+		name     string
+		cartId   int
+		opts     models.ViewCartOptions
+		wantCart models.Cart
+		wantErr  error
+	}{
+		{
+			name:     "All items",
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 50},
+			wantCart: models.Cart{Id: cart.Id, Items: []models.CartItem{apple, pear, percent}, Total: 3},
+		},
+		{
+			name:     "Paginated",
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 1, Offset: 1},
+			wantCart: models.Cart{Id: cart.Id, Items: []models.CartItem{pear}, Total: 3},
+		},
+		{
+			name:     "Product filter is case-insensitive",
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 50, Product: "APPLE"},
+			wantCart: models.Cart{Id: cart.Id, Items: []models.CartItem{apple, percent}, Total: 2},
+		},
+		{
+			name:     "Wildcards are matched literally",
+			cartId:   cart.Id,
+			opts:     models.ViewCartOptions{Limit: 50, Product: "0%"},
+			wantCart: models.Cart{Id: cart.Id, Items: []models.CartItem{percent}, Total: 1},
+		},
+		{
+			name:    "Cart not found",
+			cartId:  42,
+			opts:    models.ViewCartOptions{Limit: 50},
+			wantErr: databaseerrors.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storage.ViewCart(ctx, tt.cartId, tt.opts)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCart, got)
+		})
+	}
+}
+
+func TestStreamCartItems(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	apple, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "Apple", Quantity: 1})
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 2})
+	percent, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "50% apple", Quantity: 3})
+
+	var got []models.CartItem
+	streamed, err := storage.StreamCartItems(ctx, cart.Id, "apple", func(item models.CartItem) error {
+		got = append(got, item)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.Cart{Id: cart.Id, Total: 2}, streamed)
+	assert.Equal(t, []models.CartItem{apple, percent}, got)
+
+	_, err = storage.StreamCartItems(ctx, cart.Id+1, "", func(models.CartItem) error { return nil })
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+}
+
+func TestViewCarts(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+	first, _ := storage.CreateCart(ctx, "")
+	second, _ := storage.CreateCart(ctx, "user-1")
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "apples", Quantity: 1})
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "pears", Quantity: 2})
+	removed, _ := storage.AddToCart(ctx, first.Id, models.CartItem{Product: "plums", Quantity: 3})
+	require.NoError(t, storage.RemoveFromCart(ctx, first.Id, removed.Id))
+	channel := "web"
+	_, err := storage.PatchCartMetadata(ctx, second.Id, map[string]*string{"channel": &channel})
+	require.NoError(t, err)
+
+	carts, err := storage.ViewCarts(ctx, []int{second.Id, 999, first.Id})
+	require.NoError(t, err)
+	require.Len(t, carts, 2)
+
+	assert.Equal(t, second.Id, carts[0].Id)
+	assert.Equal(t, "user-1", carts[0].UserId)
+	assert.Empty(t, carts[0].Items)
+	assert.Equal(t, map[string]string{"channel": "web"}, carts[0].Metadata)
+
+	assert.Equal(t, first.Id, carts[1].Id)
+	assert.Equal(t, 2, carts[1].Total)
+	require.Len(t, carts[1].Items, 2)
+	assert.Equal(t, "apples", carts[1].Items[0].Product)
+	assert.Equal(t, "pears", carts[1].Items[1].Product)
+}
+
+func TestMoveCopyAndRemoveItems(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	source, _ := storage.CreateCart(ctx, "")
+	target, _ := storage.CreateCart(ctx, "")
+	added, err := storage.AddItems(ctx, source.Id, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 2}})
+	require.NoError(t, err)
+
+	_, err = storage.MoveItem(ctx, target.Id, added[0].Id, source.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+
+	moved, err := storage.MoveItem(ctx, source.Id, added[0].Id, target.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, target.Id, moved.CartId)
+
+	copied, err := storage.CopyCart(ctx, target.Id, "user-1")
+	assert.NoError(t, err)
+	assert.Len(t, copied.Items, 1)
+	assert.Equal(t, "a", copied.Items[0].Product)
+	owner, err := storage.CartOwner(ctx, copied.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", owner)
+
+	deleted, err := storage.RemoveItems(ctx, source.Id, []int{added[1].Id, moved.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{added[1].Id}, deleted)
+}
+
+func TestAddToCart_UniqueItems(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetSoftDelete(true)
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	first, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 2})
+	require.NoError(t, err)
+	merged, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 3})
+	require.NoError(t, err)
+	assert.Equal(t, first.Id, merged.Id)
+	assert.Equal(t, 5, merged.Quantity)
+
+	other, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Id, other.Id)
+
+	// A removed item no longer takes additions.
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, other.Id))
+	readded, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 4})
+	require.NoError(t, err)
+	assert.NotEqual(t, other.Id, readded.Id)
+	assert.Equal(t, 4, readded.Quantity)
+
+	got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	require.Len(t, got.Items, 2)
+	assert.Equal(t, 5, got.Items[0].Quantity)
+}
+
+func TestUniqueItems_BulkWritesConflict(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetSoftDelete(true)
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	_, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+
+	_, err = storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "pears", Quantity: 1}, {Product: "apples", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	_, err = storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "pears", Quantity: 1}, {Product: "pears", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Len(t, got.Items, 1, "a rejected batch adds nothing")
+
+	_, err = storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "plums", Quantity: 1}, {Product: "plums", Quantity: 2}})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	replaced, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "apples", Quantity: 2}, {Product: "pears", Quantity: 1}})
+	require.NoError(t, err)
+	require.Len(t, replaced.Items, 2)
+
+	// Restoring pears conflicts once another live item holds the product.
+	pears := replaced.Items[1]
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, pears.Id))
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 3})
+	require.NoError(t, err)
+	_, err = storage.RestoreItem(ctx, cart.Id, pears.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+}
+
+func TestUniqueItems_RenameAndMoveConflict(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	other, _ := storage.CreateCart(ctx, "")
+	apples, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+	pears, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+	require.NoError(t, err)
+
+	product := "apples"
+	_, err = storage.UpdateItem(ctx, cart.Id, pears.Id, models.ItemPatch{Product: &product})
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	got, err := storage.GetItem(ctx, cart.Id, pears.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "pears", got.Product)
+
+	// Keeping the product of the item itself is no conflict.
+	quantity := 2
+	_, err = storage.UpdateItem(ctx, cart.Id, apples.Id, models.ItemPatch{Product: &product, Quantity: &quantity})
+	assert.NoError(t, err)
+
+	_, err = storage.AddToCart(ctx, other.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+	_, err = storage.MoveItem(ctx, cart.Id, apples.Id, other.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrConflict)
+	got, err = storage.GetItem(ctx, cart.Id, apples.Id)
+	require.NoError(t, err)
+	assert.Equal(t, cart.Id, got.CartId)
+
+	_, err = storage.MoveItem(ctx, cart.Id, pears.Id, other.Id)
+	assert.NoError(t, err)
+}
+
+func TestAddToCart_UniqueItemsFullCart(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetUniqueItems(true)
+	storage.SetMaxItems(1)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	first, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 2})
+	require.NoError(t, err)
+	merged, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 3})
+	require.NoError(t, err, "merging doesn't grow a full cart")
+	assert.Equal(t, first.Id, merged.Id)
+	assert.Equal(t, 5, merged.Quantity)
+
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+}
+
+func TestAddToCart_UniqueItemsOverflow(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetUniqueItems(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	nearMax := models.CartItem{Product: "apples", Quantity: models.MaxQuantity - 1}
+	first, err := storage.AddToCart(ctx, cart.Id, nearMax)
+	require.NoError(t, err)
+	_, err = storage.AddToCart(ctx, cart.Id, nearMax)
+	assert.ErrorIs(t, err, databaseerrors.ErrQuantityOverflow)
+
+	got, err := storage.GetItem(ctx, cart.Id, first.Id)
+	require.NoError(t, err)
+	assert.Equal(t, models.MaxQuantity-1, got.Quantity)
+}
+
+func TestDeleteExpiredCarts(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 1})
+
+	deleted, err := storage.DeleteExpiredCarts(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	deleted, err = storage.DeleteExpiredCarts(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+}
+
+func TestPurgeCart(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+
+	t.Run("Empty cart is deleted", func(t *testing.T) {
+		cart, _ := storage.CreateCart(ctx, "")
+		removed, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 1})
+		require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, removed.Id))
+
+		require.NoError(t, storage.PurgeCart(ctx, cart.Id))
+
+		_, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+		_, err = storage.RestoreItem(ctx, cart.Id, removed.Id)
+		assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+	})
+
+	t.Run("Cart with items is kept", func(t *testing.T) {
+		cart, _ := storage.CreateCart(ctx, "")
+		_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 1})
+
+		err := storage.PurgeCart(ctx, cart.Id)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotEmpty)
+
+		got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+		require.NoError(t, err)
+		assert.Len(t, got.Items, 1)
+	})
+
+	t.Run("Missing cart", func(t *testing.T) {
+		err := storage.PurgeCart(ctx, 999)
+		assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+	})
+}
+
+func TestCartStats(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+	first, _ := storage.CreateCart(ctx, "")
+	second, _ := storage.CreateCart(ctx, "")
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "pears", Quantity: 1})
+	_, _ = storage.AddToCart(ctx, first.Id, models.CartItem{Product: "apples", Quantity: 2})
+	_, _ = storage.AddToCart(ctx, second.Id, models.CartItem{Product: "apples", Quantity: 3})
+	_, _ = storage.AddToCart(ctx, second.Id, models.CartItem{Product: "plums", Quantity: 1})
+	removed, _ := storage.AddToCart(ctx, second.Id, models.CartItem{Product: "plums", Quantity: 5})
+	require.NoError(t, storage.RemoveFromCart(ctx, second.Id, removed.Id))
+
+	stats, err := storage.CartStats(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, models.CartStats{
+		Carts: 2,
+		Items: 4,
+		TopProducts: []models.ProductStats{
+			{Product: "apples", Items: 2, Quantity: 5},
+			{Product: "pears", Items: 1, Quantity: 1},
+		},
+	}, stats)
+}
+
+func TestUpdateItem(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	added, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+
+	product, quantity := "pears", 4
+	updated, err := storage.UpdateItem(ctx, cart.Id, added.Id, models.ItemPatch{Product: &product})
+	require.NoError(t, err)
+	assert.Equal(t, "pears", updated.Product)
+	assert.Equal(t, 1, updated.Quantity)
+
+	updated, err = storage.UpdateItem(ctx, cart.Id, added.Id, models.ItemPatch{Quantity: &quantity})
+	require.NoError(t, err)
+	assert.Equal(t, "pears", updated.Product)
+	assert.Equal(t, 4, updated.Quantity)
+
+	product, quantity = "plums", 2
+	updated, err = storage.UpdateItem(ctx, cart.Id, added.Id, models.ItemPatch{Product: &product, Quantity: &quantity})
+	require.NoError(t, err)
+	got, err := storage.GetItem(ctx, cart.Id, added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, updated, got)
+	assert.Equal(t, models.CartItem{Id: added.Id, CartId: cart.Id, Product: "plums", Quantity: 2}, got)
+
+	_, err = storage.UpdateItem(ctx, cart.Id, added.Id+1, models.ItemPatch{Quantity: &quantity})
+	assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+	_, err = storage.UpdateItem(ctx, cart.Id+1, added.Id, models.ItemPatch{Quantity: &quantity})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+}
+
+func TestWeightedItem(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+
+	added, err := storage.AddToCart(ctx, cart.Id, models.CartItem{
+		Product:  "apples",
+		Quantity: 1,
+		Measure:  models.MeasureWeight,
+		Weight:   0.75,
+		Unit:     "kg",
+	})
+	require.NoError(t, err)
+
+	viewed, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, []models.CartItem{added}, viewed.Items)
+	assert.InDelta(t, 0.75, viewed.Items[0].Weight, 1e-9)
+	assert.Equal(t, models.MeasureWeight, viewed.Items[0].Measure)
+}
+
+func TestPatchCartMetadata(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	channel, coupon := "web", "SUMMER"
+
+	patched, err := storage.PatchCartMetadata(ctx, cart.Id, map[string]*string{"channel": &channel, "coupon": &coupon})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"channel": "web", "coupon": "SUMMER"}, patched.Metadata)
+
+	patched, err = storage.PatchCartMetadata(ctx, cart.Id, map[string]*string{"coupon": nil})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"channel": "web"}, patched.Metadata)
+
+	viewed, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"channel": "web"}, viewed.Metadata)
+
+	_, err = storage.PatchCartMetadata(ctx, cart.Id+1, map[string]*string{"channel": &channel})
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+}
+
+func TestSoftDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cartapi.db")
+	storage, err := sqlite.New(slogdiscard.NewDiscardLogger(), path)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	storage.SetSoftDelete(true)
+
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	kept, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 1, Measure: models.MeasureCount})
+	removed, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 1, Measure: models.MeasureCount})
+	batchRemoved, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "plum", Quantity: 1, Measure: models.MeasureCount})
+
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, removed.Id))
+	ids, err := storage.RemoveItems(ctx, cart.Id, []int{batchRemoved.Id})
+	require.NoError(t, err)
+	assert.Equal(t, []int{batchRemoved.Id}, ids)
+
+	viewed, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, []models.CartItem{kept}, viewed.Items)
+	assert.Equal(t, 1, viewed.Total)
+
+	assert.ErrorIs(t, storage.RemoveFromCart(ctx, cart.Id, removed.Id), databaseerrors.ErrNotFound)
+	_, err = storage.MoveItem(ctx, cart.Id, removed.Id, cart.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+
+	copied, err := storage.CopyCart(ctx, cart.Id, "")
+	require.NoError(t, err)
+	assert.Len(t, copied.Items, 1)
+
+	raw, err := sqlx.Connect("sqlite", path)
+	require.NoError(t, err)
+	defer raw.Close()
+	var deleted int
+	require.NoError(t, raw.Get(&deleted, `SELECT COUNT(*) FROM item WHERE cart_id=? AND deleted_at IS NOT NULL;`, cart.Id))
+	assert.Equal(t, 2, deleted)
+}
+
+func TestRestoreItem(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetSoftDelete(true)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	item, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 2, Measure: models.MeasureCount})
+
+	_, err := storage.RestoreItem(ctx, cart.Id, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound, "a live item can't be restored")
+
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, item.Id))
+	restored, err := storage.RestoreItem(ctx, cart.Id, item.Id)
+	require.NoError(t, err)
+	assert.Equal(t, item, restored)
+
+	viewed, _ := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.Equal(t, []models.CartItem{item}, viewed.Items)
+
+	_, err = storage.RestoreItem(ctx, cart.Id, item.Id+100)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+	_, err = storage.RestoreItem(ctx, cart.Id+100, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrNotFound)
+}
+
+func TestCreateCarts(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+
+	ids, err := storage.CreateCarts(ctx, 3)
+	require.NoError(t, err)
+	assert.Len(t, ids, 3)
+	assert.IsIncreasing(t, ids)
+
+	for _, id := range ids {
+		_, err := storage.ViewCart(ctx, id, models.ViewCartOptions{Limit: 50})
+		assert.NoError(t, err)
+	}
+}
+
+func TestReplaceItems(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+
+	cart, _ := storage.CreateCart(ctx, "")
+	other, _ := storage.CreateCart(ctx, "")
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "old", Quantity: 1, Measure: models.MeasureCount})
+	kept, _ := storage.AddToCart(ctx, other.Id, models.CartItem{Product: "other", Quantity: 1, Measure: models.MeasureCount})
+
+	replaced, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{
+		{Product: "milk", Quantity: 2, Measure: models.MeasureCount},
+		{Product: "bread", Quantity: 1, Measure: models.MeasureCount},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, replaced.Total)
+	require.Len(t, replaced.Items, 2)
+	assert.Equal(t, "milk", replaced.Items[0].Product)
+	assert.Equal(t, "bread", replaced.Items[1].Product)
+
+	viewed, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, replaced.Items, viewed.Items)
+
+	viewed, err = storage.ViewCart(ctx, other.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, []models.CartItem{kept}, viewed.Items)
+
+	emptied, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{})
+	require.NoError(t, err)
+	assert.Empty(t, emptied.Items)
+
+	_, err = storage.ReplaceItems(ctx, 42, []models.CartItem{})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+}
+
+func TestAddToCart_MaxItems(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetMaxItems(2)
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "a", Quantity: 1})
+	require.NoError(t, err)
+	second, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "b", Quantity: 1})
+	require.NoError(t, err)
+
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "c", Quantity: 1})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+
+	// Removed items no longer count against the limit.
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, second.Id))
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "c", Quantity: 1})
+	assert.NoError(t, err)
+}
+
+func TestAddItems_MaxItems(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetMaxItems(3)
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	_, err = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "a", Quantity: 1})
+	require.NoError(t, err)
+
+	_, err = storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}, {Product: "d", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+	got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	assert.Len(t, got.Items, 1, "a rejected batch adds nothing")
+
+	added, err := storage.AddItems(ctx, cart.Id, []models.CartItem{{Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}})
+	require.NoError(t, err)
+	assert.Len(t, added, 2)
+
+	_, err = storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}, {Product: "d", Quantity: 1}})
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+	replaced, err := storage.ReplaceItems(ctx, cart.Id, []models.CartItem{{Product: "a", Quantity: 1}, {Product: "b", Quantity: 1}, {Product: "c", Quantity: 1}})
+	require.NoError(t, err)
+	assert.Len(t, replaced.Items, 3)
+}
+
+func TestMoveItem_MaxItems(t *testing.T) {
+	storage := newTestStorage(t)
+	storage.SetMaxItems(1)
+	ctx := context.Background()
+	source, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	target, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	item, err := storage.AddToCart(ctx, source.Id, models.CartItem{Product: "a", Quantity: 1})
+	require.NoError(t, err)
+
+	moved, err := storage.MoveItem(ctx, source.Id, item.Id, target.Id)
+	require.NoError(t, err)
+	assert.Equal(t, target.Id, moved.CartId)
+
+	other, err := storage.AddToCart(ctx, source.Id, models.CartItem{Product: "b", Quantity: 1})
+	require.NoError(t, err)
+	_, err = storage.MoveItem(ctx, source.Id, other.Id, target.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrCartFull)
+	got, err := storage.GetItem(ctx, source.Id, other.Id)
+	require.NoError(t, err)
+	assert.Equal(t, source.Id, got.CartId)
+}
+
+func TestGetItem(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	other, _ := storage.CreateCart(ctx, "")
+	item, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "product", Quantity: 3})
+
+	got, err := storage.GetItem(ctx, cart.Id, item.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, item, got)
+
+	_, err = storage.GetItem(ctx, other.Id, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+
+	_, err = storage.GetItem(ctx, 999, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrCartNotFound)
+
+	require.NoError(t, storage.RemoveFromCart(ctx, cart.Id, item.Id))
+	_, err = storage.GetItem(ctx, cart.Id, item.Id)
+	assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+}
+
+func TestCurrentMigrationVersion(t *testing.T) {
+	storage := newTestStorage(t)
+
+	latest, err := sqlite.LatestMigrationVersion()
+	require.NoError(t, err)
+
+	version, err := storage.CurrentMigrationVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, latest, version)
+}
+
+func TestPing(t *testing.T) {
+	storage, err := sqlite.New(slogdiscard.NewDiscardLogger(), ":memory:")
+	require.NoError(t, err)
+
+	assert.NoError(t, storage.Ping(context.Background()))
+
+	require.NoError(t, storage.Close())
+	assert.Error(t, storage.Ping(context.Background()))
+}
+
+func TestGetOrCreateCart(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+
+	created, isNew, err := storage.GetOrCreateCart(ctx, "session-abc", "user-1")
+	require.NoError(t, err)
+	assert.True(t, isNew)
+	assert.Equal(t, "user-1", created.UserId)
+
+	got, isNew, err := storage.GetOrCreateCart(ctx, "session-abc", "user-1")
+	require.NoError(t, err)
+	assert.False(t, isNew)
+	assert.Equal(t, created, got)
+
+	other, isNew, err := storage.GetOrCreateCart(ctx, "session-def", "")
+	require.NoError(t, err)
+	assert.True(t, isNew)
+	assert.NotEqual(t, created.Id, other.Id)
+}
+
+func TestUpdateQuantities(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+
+	cart, _ := storage.CreateCart(ctx, "")
+	apples, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	pears, _ := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+
+	items, err := storage.UpdateQuantities(ctx, cart.Id, []models.QuantityUpdate{{ItemId: apples.Id, Quantity: 3}, {ItemId: pears.Id, Quantity: 5}})
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, 3, items[0].Quantity)
+	assert.Equal(t, 5, items[1].Quantity)
+
+	_, err = storage.UpdateQuantities(ctx, cart.Id, []models.QuantityUpdate{{ItemId: apples.Id, Quantity: 7}, {ItemId: 999, Quantity: 7}})
+	assert.ErrorIs(t, err, databaseerrors.ErrItemNotFound)
+
+	got, err := storage.GetItem(ctx, cart.Id, apples.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 3, got.Quantity)
+}
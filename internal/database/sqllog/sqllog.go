@@ -0,0 +1,92 @@
+// Package sqllog logs the statements the SQL storages run, with their
+// arguments, at debug level.
+package sqllog
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Queryer is what a storage runs statements on, the pool or a transaction.
+// Both *sqlx.DB and *sqlx.Tx implement it.
+type Queryer interface {
+	sqlx.ExtContext
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Redacted replaces the arguments hidden from the log.
+const Redacted = "[redacted]"
+
+// Wrap returns q logging every statement to log before running it. When log
+// doesn't handle debug records, q is returned as is, so logging costs
+// nothing above debug level.
+//
+// With redact set, every argument but numbers, booleans, times and nulls is
+// logged as Redacted. Product names are bound as text like the filters,
+// user ids and metadata, so all of them are hidden together.
+func Wrap(log *slog.Logger, q Queryer, redact bool) Queryer {
+	if !log.Enabled(context.Background(), slog.LevelDebug) {
+		return q
+	}
+	return &queryer{Queryer: q, log: log, redact: redact}
+}
+
+type queryer struct {
+	Queryer
+	log    *slog.Logger
+	redact bool
+}
+
+func (q *queryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	q.logQuery(ctx, query, args)
+	return q.Queryer.QueryContext(ctx, query, args...)
+}
+
+func (q *queryer) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	q.logQuery(ctx, query, args)
+	return q.Queryer.QueryxContext(ctx, query, args...)
+}
+
+func (q *queryer) QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row {
+	q.logQuery(ctx, query, args)
+	return q.Queryer.QueryRowxContext(ctx, query, args...)
+}
+
+func (q *queryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	q.logQuery(ctx, query, args)
+	return q.Queryer.QueryRowContext(ctx, query, args...)
+}
+
+func (q *queryer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	q.logQuery(ctx, query, args)
+	return q.Queryer.ExecContext(ctx, query, args...)
+}
+
+func (q *queryer) logQuery(ctx context.Context, query string, args []any) {
+	logged := args
+	if q.redact {
+		logged = make([]any, len(args))
+		for i, arg := range args {
+			logged[i] = redact(arg)
+		}
+	}
+	// The statement templates span several indented lines.
+	q.log.DebugContext(ctx, "SQL",
+		slog.String("query", strings.Join(strings.Fields(query), " ")),
+		slog.Any("args", logged),
+	)
+}
+
+func redact(arg any) any {
+	switch arg.(type) {
+	case nil, bool, int, int32, int64, float64, time.Time:
+		return arg
+	default:
+		return Redacted
+	}
+}
@@ -0,0 +1,85 @@
+package databaseerrors
+
+import (
+	"cartapi/internal/models"
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Storage is implemented by every cart storage backend.
+type Storage interface {
+	// CreateCart creates an empty cart owned by userId; an empty userId
+	// creates a cart without an owner.
+	CreateCart(ctx context.Context, userId string) (models.Cart, error)
+	// GetOrCreateCart returns the cart created for the external key, or
+	// creates one owned by userId; created reports which happened.
+	GetOrCreateCart(ctx context.Context, key string, userId string) (cart models.Cart, created bool, err error)
+	// CartOwner returns the user id the cart was created for, empty when it
+	// has no owner.
+	CartOwner(ctx context.Context, cartId int) (string, error)
+	CreateCarts(ctx context.Context, n int) ([]int, error)
+	AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error)
+	RemoveFromCart(ctx context.Context, cartId int, itemId int) error
+	// GetItem returns a live item of the cart.
+	GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error)
+	// ItemIds returns the ids of the live items of the cart in id order.
+	ItemIds(ctx context.Context, cartId int) ([]int, error)
+	// UpdateItem sets the fields of patch on a live item of the cart and
+	// returns the updated item.
+	UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error)
+	// UpdateQuantities sets the quantities of several live items of the cart
+	// at once, or of none when any item is missing.
+	UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error)
+	ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error)
+	// ViewCarts returns the carts with the given ids, in that order, with
+	// all of their items and their owner. Missing ids are left out.
+	ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error)
+	// StreamCartItems calls yield for every item of the cart matching
+	// product, in id order, without holding them all in memory. The returned
+	// cart has no Items; Total counts the yielded ones.
+	StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error)
+	MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error)
+	CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error)
+	RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error)
+	AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error)
+	ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error)
+	DeleteExpiredCarts(ctx context.Context, cutoff time.Time) (int64, error)
+	// PurgeCart deletes the cart in one transaction, failing with
+	// ErrCartNotEmpty while it still holds live items.
+	PurgeCart(ctx context.Context, cartId int) error
+	// CartStats counts the carts and live items of the storage, along with
+	// the top products with the most items.
+	CartStats(ctx context.Context, top int) (models.CartStats, error)
+	PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error)
+	RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error)
+	// SetSoftDelete switches removals between deleting items and marking
+	// them deleted.
+	SetSoftDelete(enabled bool)
+	// SetQueryTimeout caps the time a single storage call may spend on the
+	// database, independently of the caller's deadline.
+	SetQueryTimeout(d time.Duration)
+	// SetSlowQueryThreshold makes storage calls lasting at least d log a
+	// warning.
+	SetSlowQueryThreshold(d time.Duration)
+	// SetSQLLog logs every statement with its arguments at debug level,
+	// hiding the text arguments such as product names with redactProducts.
+	SetSQLLog(enabled bool, redactProducts bool)
+	// SetMaxItems limits the number of items a cart may hold; zero means no
+	// limit.
+	SetMaxItems(n int)
+	// SetUniqueItems makes AddToCart merge items of the same product
+	// instead of adding duplicates.
+	SetUniqueItems(enabled bool)
+	// SetTracer sets the tracer recording a span per storage call.
+	SetTracer(tracer trace.Tracer)
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+	// CurrentMigrationVersion returns the version of the last schema
+	// migration applied; backends without a schema report 0.
+	CurrentMigrationVersion(ctx context.Context) (int64, error)
+	Close() error
+	// CloseGraceful is Close after waiting, up to the deadline of ctx, for
+	// the queries in flight to finish.
+	CloseGraceful(ctx context.Context) error
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	CartCreated Type = "CartCreated"
+	ItemAdded   Type = "ItemAdded"
+	ItemRemoved Type = "ItemRemoved"
+	ItemUpdated Type = "ItemUpdated"
+)
+
+// Valid reports whether t is one of the event types above.
+func (t Type) Valid() bool {
+	switch t {
+	case CartCreated, ItemAdded, ItemRemoved, ItemUpdated:
+		return true
+	default:
+		return false
+	}
+}
+
+type Event struct {
+	Type      Type      `json:"type"`
+	CartId    int       `json:"cart_id"`
+	ItemId    int       `json:"item_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NopPublisher drops every event. It is used when no publisher is configured.
+type NopPublisher struct{}
+
+func (NopPublisher) Publish(_ context.Context, _ Event) error {
+	return nil
+}
+
+// MemoryPublisher keeps published events in memory, mostly for tests.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *MemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]Event, len(p.events))
+	copy(out, p.events)
+	return out
+}
@@ -0,0 +1,185 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"cartapi/pkg/lib/logger/sl"
+)
+
+// WebhookSignatureHeader carries "sha256=" followed by the hex HMAC-SHA256
+// of the webhook body under the shared secret, the format the API itself
+// accepts in X-Signature.
+const WebhookSignatureHeader = "X-Signature"
+
+// DefaultWebhookTypes are the events delivered when none are configured.
+var DefaultWebhookTypes = []Type{CartCreated, ItemAdded, ItemRemoved}
+
+// DefaultWebhookMaxPending bounds the deliveries in flight when
+// WebhookConfig.MaxPending is not set.
+const DefaultWebhookMaxPending = 64
+
+// ErrWebhookBacklog is returned by Publish when MaxPending deliveries are
+// already in flight; the event is dropped.
+var ErrWebhookBacklog = errors.New("webhook backlog full")
+
+// WebhookConfig describes where and how events are delivered. Attempts
+// counts the first try; the backoff doubles after every failed one.
+// MaxPending bounds the deliveries in flight at once.
+type WebhookConfig struct {
+	URL        string
+	Secret     string
+	Types      []Type
+	Timeout    time.Duration
+	Attempts   int
+	Backoff    time.Duration
+	MaxPending int
+}
+
+// WebhookPublisher POSTs every event of the configured types to a URL as
+// JSON. Publish doesn't wait for the delivery, so a slow endpoint never
+// holds up a request; Wait lets shutdown finish the pending ones.
+type WebhookPublisher struct {
+	log     *slog.Logger
+	cfg     WebhookConfig
+	client  *http.Client
+	types   map[Type]bool
+	pending chan struct{}
+	wg      sync.WaitGroup
+	// stop is canceled when Wait gives up, abandoning the deliveries that
+	// are still posting or backing off.
+	stop   context.Context
+	cancel context.CancelFunc
+}
+
+func NewWebhookPublisher(log *slog.Logger, cfg WebhookConfig) *WebhookPublisher {
+	if len(cfg.Types) == 0 {
+		cfg.Types = DefaultWebhookTypes
+	}
+	cfg.Attempts = max(cfg.Attempts, 1)
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = DefaultWebhookMaxPending
+	}
+	types := make(map[Type]bool, len(cfg.Types))
+	for _, t := range cfg.Types {
+		types[t] = true
+	}
+	stop, cancel := context.WithCancel(context.Background())
+	return &WebhookPublisher{
+		log:     log.With("op", "events.WebhookPublisher"),
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		types:   types,
+		pending: make(chan struct{}, cfg.MaxPending),
+		stop:    stop,
+		cancel:  cancel,
+	}
+}
+
+func (p *WebhookPublisher) Publish(_ context.Context, event Event) error {
+	if !p.types[event.Type] {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case p.pending <- struct{}{}:
+	default:
+		return ErrWebhookBacklog
+	}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.pending
+			p.wg.Done()
+		}()
+		p.deliver(event, body)
+	}()
+	return nil
+}
+
+// Wait blocks until the pending deliveries are done or ctx is. When ctx
+// ends first, the deliveries still running are abandoned.
+func (p *WebhookPublisher) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return ctx.Err()
+	}
+}
+
+// deliver retries failed attempts until one succeeds, the endpoint rejects
+// the event with a 4xx other than 429, the attempts run out or Wait gives
+// up.
+func (p *WebhookPublisher) deliver(event Event, body []byte) {
+	log := p.log.With(slog.String("type", string(event.Type)), slog.Int("cart_id", event.CartId))
+	backoff := p.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := p.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == p.cfg.Attempts {
+			log.Error("Failed to deliver webhook", slog.Int("attempt", attempt), sl.Err(err))
+			return
+		}
+		log.Warn("Webhook delivery failed, retrying", slog.Int("attempt", attempt), slog.Duration("backoff", backoff), sl.Err(err))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-p.stop.Done():
+			timer.Stop()
+			log.Error("Webhook delivery abandoned on shutdown", slog.Int("attempt", attempt))
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (p *WebhookPublisher) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(p.stop, http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(p.cfg.Secret))
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,161 @@
+package events_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cartapi/internal/events"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type capturedWebhook struct {
+	body      []byte
+	signature string
+}
+
+// webhookServer answers with the given statuses in turn, then 200, and
+// records every request.
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, func() []capturedWebhook) {
+	var mu sync.Mutex
+	var captured []capturedWebhook
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		captured = append(captured, capturedWebhook{body: body, signature: r.Header.Get(events.WebhookSignatureHeader)})
+		status := http.StatusOK
+		if len(captured) <= len(statuses) {
+			status = statuses[len(captured)-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []capturedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]capturedWebhook(nil), captured...)
+	}
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	event := events.Event{Type: events.ItemAdded, CartId: 1, ItemId: 2, Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	t.Run("Signed event is delivered", func(t *testing.T) {
+		server, captured := webhookServer(t)
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Secret: "secret", Timeout: time.Second})
+
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		require.NoError(t, publisher.Wait(context.Background()))
+
+		got := captured()
+		require.Len(t, got, 1)
+		var delivered events.Event
+		require.NoError(t, json.Unmarshal(got[0].body, &delivered))
+		assert.Equal(t, event, delivered)
+
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(got[0].body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), got[0].signature)
+	})
+
+	t.Run("Failed deliveries are retried", func(t *testing.T) {
+		server, captured := webhookServer(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Attempts: 3, Backoff: time.Millisecond})
+
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		require.NoError(t, publisher.Wait(context.Background()))
+		assert.Len(t, captured(), 3)
+	})
+
+	t.Run("Attempts run out", func(t *testing.T) {
+		server, captured := webhookServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Attempts: 2, Backoff: time.Millisecond})
+
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		require.NoError(t, publisher.Wait(context.Background()))
+		assert.Len(t, captured(), 2)
+	})
+
+	t.Run("Rejected event is not retried", func(t *testing.T) {
+		server, captured := webhookServer(t, http.StatusBadRequest)
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Attempts: 3, Backoff: time.Millisecond})
+
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		require.NoError(t, publisher.Wait(context.Background()))
+		assert.Len(t, captured(), 1)
+	})
+
+	t.Run("Other event types are skipped", func(t *testing.T) {
+		server, captured := webhookServer(t)
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Types: []events.Type{events.CartCreated}})
+
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		require.NoError(t, publisher.Publish(context.Background(), events.Event{Type: events.CartCreated, CartId: 1}))
+		require.NoError(t, publisher.Wait(context.Background()))
+		assert.Len(t, captured(), 1)
+	})
+
+	t.Run("Publish doesn't wait for the endpoint", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Timeout: time.Second})
+
+		start := time.Now()
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, publisher.Wait(ctx), context.DeadlineExceeded)
+	})
+
+	t.Run("Events beyond MaxPending are dropped", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Timeout: time.Second, MaxPending: 2})
+
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		assert.ErrorIs(t, publisher.Publish(context.Background(), event), events.ErrWebhookBacklog)
+
+		close(release)
+		require.NoError(t, publisher.Wait(context.Background()))
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		require.NoError(t, publisher.Wait(context.Background()))
+	})
+
+	t.Run("Wait interrupts the backoff", func(t *testing.T) {
+		server, captured := webhookServer(t, http.StatusServiceUnavailable)
+		publisher := events.NewWebhookPublisher(slogdiscard.NewDiscardLogger(), events.WebhookConfig{URL: server.URL, Attempts: 2, Backoff: time.Hour})
+
+		require.NoError(t, publisher.Publish(context.Background(), event))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, publisher.Wait(ctx), context.DeadlineExceeded)
+
+		// The abandoned delivery returns instead of sleeping out the hour.
+		done, doneCancel := context.WithTimeout(context.Background(), time.Second)
+		defer doneCancel()
+		require.NoError(t, publisher.Wait(done))
+		assert.Len(t, captured(), 1)
+	})
+}
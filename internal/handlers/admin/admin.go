@@ -0,0 +1,244 @@
+package adminhandler
+
+import (
+	"cartapi/internal/apierror"
+	"cartapi/internal/models"
+	"cartapi/internal/respond"
+	"cartapi/pkg/lib/logger/sl"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type StatsProvider interface {
+	Stats() sql.DBStats
+}
+
+// CartStatsProvider aggregates the carts of the storage.
+type CartStatsProvider interface {
+	CartStats(ctx context.Context, top int) (models.CartStats, error)
+}
+
+// ReadOnlySwitch reports and changes the read-only mode of the cart API.
+type ReadOnlySwitch interface {
+	ReadOnly() bool
+	SetReadOnly(enabled bool)
+}
+
+// MigrationVersioner reports the schema version the database is at.
+type MigrationVersioner interface {
+	CurrentMigrationVersion(ctx context.Context) (int64, error)
+}
+
+type Handler struct {
+	log       *slog.Logger
+	stats     StatsProvider
+	cartStats CartStatsProvider
+	readOnly  ReadOnlySwitch
+	versions  MigrationVersioner
+	latest    int64
+}
+
+// New creates the admin handler. stats may be nil when the storage backend
+// has no connection pool.
+func New(log *slog.Logger, stats StatsProvider, cartStats CartStatsProvider, readOnly ReadOnlySwitch) *Handler {
+	return &Handler{
+		log:       log,
+		stats:     stats,
+		cartStats: cartStats,
+		readOnly:  readOnly,
+	}
+}
+
+// SetSchema enables GET /admin/schema/check, comparing the version of
+// versions against latest, the highest embedded migration. It must be
+// called before the handler is used.
+func (h *Handler) SetSchema(versions MigrationVersioner, latest int64) {
+	h.versions = versions
+	h.latest = latest
+}
+
+type dbStatsResponse struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// GET /admin/db/stats
+func (h *Handler) DBStats(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.admin.DBStats"
+	log := h.log.With("op", op)
+
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if h.stats == nil {
+		apierror.Write(w, http.StatusNotImplemented, apierror.NotImplemented, "Database stats are not available for this storage")
+		return
+	}
+
+	stats := h.stats.Stats()
+	response := dbStatsResponse{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respond.JSON(w, r, log, http.StatusOK, response)
+}
+
+const (
+	defaultTopProducts = 10
+	maxTopProducts     = 100
+)
+
+type cartStatsResponse struct {
+	Carts       int                    `json:"carts"`
+	Items       int                    `json:"items"`
+	TopProducts []productStatsResponse `json:"top_products"`
+}
+
+type productStatsResponse struct {
+	Product  string `json:"product"`
+	Items    int    `json:"items"`
+	Quantity int    `json:"quantity"`
+}
+
+// GET /admin/stats?top=N
+//
+// Totals across every cart, with the N products found in the most items
+// (10 by default, at most 100).
+func (h *Handler) CartStats(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.admin.CartStats"
+	log := h.log.With("op", op)
+
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	top := defaultTopProducts
+	if topStr := r.URL.Query().Get("top"); topStr != "" {
+		n, err := strconv.Atoi(topStr)
+		if err != nil || n <= 0 {
+			log.Warn("Invalid top parameter", slog.String("top", topStr))
+			apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Invalid top")
+			return
+		}
+		top = min(n, maxTopProducts)
+	}
+
+	stats, err := h.cartStats.CartStats(r.Context(), top)
+	if err != nil {
+		log.Error("Failed to get cart stats", sl.Err(err))
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Failed to get cart stats")
+		return
+	}
+
+	response := cartStatsResponse{
+		Carts:       stats.Carts,
+		Items:       stats.Items,
+		TopProducts: make([]productStatsResponse, 0, len(stats.TopProducts)),
+	}
+	for _, product := range stats.TopProducts {
+		response.TopProducts = append(response.TopProducts, productStatsResponse(product))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respond.JSON(w, r, log, http.StatusOK, response)
+}
+
+type readOnlyRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type readOnlyResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// GET, PUT /admin/read-only
+func (h *Handler) ReadOnly(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.admin.ReadOnly"
+	log := h.log.With("op", op)
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var request readOnlyRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Warn("Failed to decode read-only request", sl.Err(err))
+			apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+			return
+		}
+		if request.Enabled == nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "enabled field is required")
+			return
+		}
+		h.readOnly.SetReadOnly(*request.Enabled)
+		log.Info("Read-only mode changed", slog.Bool("enabled", *request.Enabled))
+	default:
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respond.JSON(w, r, log, http.StatusOK, readOnlyResponse{Enabled: h.readOnly.ReadOnly()})
+}
+
+type schemaCheckResponse struct {
+	Current  int64 `json:"current"`
+	Latest   int64 `json:"latest"`
+	UpToDate bool  `json:"up_to_date"`
+}
+
+// GET /admin/schema/check
+//
+// Reports whether the applied migrations are behind the embedded ones. Unlike
+// /readyz it answers 200 either way.
+func (h *Handler) SchemaCheck(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.admin.SchemaCheck"
+	log := h.log.With("op", op)
+
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if h.versions == nil {
+		apierror.Write(w, http.StatusNotImplemented, apierror.NotImplemented, "Schema check is not available")
+		return
+	}
+
+	current, err := h.versions.CurrentMigrationVersion(r.Context())
+	if err != nil {
+		log.Error("Failed to get migration version", sl.Err(err))
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.Unavailable, "Storage unavailable")
+		return
+	}
+
+	response := schemaCheckResponse{Current: current, Latest: h.latest, UpToDate: current >= h.latest}
+	if !response.UpToDate {
+		log.Warn("Schema is behind", slog.Int64("current", current), slog.Int64("latest", h.latest))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respond.JSON(w, r, log, http.StatusOK, response)
+}
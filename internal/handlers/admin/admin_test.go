@@ -0,0 +1,159 @@
+package adminhandler_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cartapi/internal/database/memory"
+	adminhandler "cartapi/internal/handlers/admin"
+	"cartapi/internal/middleware"
+	"cartapi/internal/models"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubStats struct {
+	stats sql.DBStats
+}
+
+func (s stubStats) Stats() sql.DBStats {
+	return s.stats
+}
+
+func TestHandler_DBStats(t *testing.T) {
+	provider := stubStats{stats: sql.DBStats{
+		MaxOpenConnections: 10,
+		OpenConnections:    4,
+		InUse:              3,
+		Idle:               1,
+		WaitCount:          7,
+		WaitDuration:       1500 * time.Millisecond,
+	}}
+	handler := adminhandler.New(slogdiscard.NewDiscardLogger(), provider, nil, middleware.NewReadOnly(false))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/db/stats", nil)
+	ww := httptest.NewRecorder()
+
+	handler.DBStats(ww, req)
+	resp := ww.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var got map[string]int64
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, int64(10), got["max_open_connections"])
+	assert.Equal(t, int64(4), got["open_connections"])
+	assert.Equal(t, int64(3), got["in_use"])
+	assert.Equal(t, int64(1), got["idle"])
+	assert.Equal(t, int64(7), got["wait_count"])
+	assert.Equal(t, int64(1500), got["wait_duration_ms"])
+}
+
+func TestHandler_CartStats(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	ctx := context.Background()
+	cart, _ := storage.CreateCart(ctx, "")
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 2})
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	_, _ = storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 4})
+	handler := adminhandler.New(log, nil, storage, middleware.NewReadOnly(false))
+
+	stats := func(query string) *httptest.ResponseRecorder {
+		ww := httptest.NewRecorder()
+		handler.CartStats(ww, httptest.NewRequest(http.MethodGet, "/admin/stats"+query, nil))
+		return ww
+	}
+
+	ww := stats("?top=1")
+	assert.Equal(t, http.StatusOK, ww.Code)
+	assert.JSONEq(t, `{"carts":1,"items":3,"top_products":[{"product":"apples","items":2,"quantity":3}]}`, ww.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, stats("?top=0").Code)
+	assert.Equal(t, http.StatusBadRequest, stats("?top=many").Code)
+}
+
+func TestHandler_ReadOnly(t *testing.T) {
+	readOnly := middleware.NewReadOnly(false)
+	handler := adminhandler.New(slogdiscard.NewDiscardLogger(), stubStats{}, nil, readOnly)
+
+	testCases := []struct {
+		name     string
+		method   string
+		body     string
+		expected int
+		enabled  bool
+	}{
+		{name: "get current mode", method: http.MethodGet, expected: http.StatusOK, enabled: false},
+		{name: "enable", method: http.MethodPut, body: `{"enabled": true}`, expected: http.StatusOK, enabled: true},
+		{name: "missing field", method: http.MethodPut, body: `{}`, expected: http.StatusBadRequest, enabled: true},
+		{name: "disable", method: http.MethodPut, body: `{"enabled": false}`, expected: http.StatusOK, enabled: false},
+		{name: "method not allowed", method: http.MethodDelete, expected: http.StatusMethodNotAllowed, enabled: false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/read-only", strings.NewReader(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.ReadOnly(ww, req)
+
+			assert.Equal(t, tt.expected, ww.Code)
+			assert.Equal(t, tt.enabled, readOnly.ReadOnly())
+			if tt.expected == http.StatusOK {
+				var got map[string]bool
+				assert.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+				assert.Equal(t, tt.enabled, got["enabled"])
+			}
+		})
+	}
+}
+
+type fakeVersioner struct {
+	version int64
+}
+
+func (f fakeVersioner) CurrentMigrationVersion(context.Context) (int64, error) {
+	return f.version, nil
+}
+
+func TestHandler_SchemaCheck(t *testing.T) {
+	const latest = 20251014150000
+
+	tests := []struct {
+		name     string
+		current  int64
+		upToDate bool
+	}{
+		{name: "Up to date", current: latest, upToDate: true},
+		{name: "Behind", current: 20251014130000, upToDate: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := adminhandler.New(slogdiscard.NewDiscardLogger(), nil, nil, middleware.NewReadOnly(false))
+			handler.SetSchema(fakeVersioner{version: tt.current}, latest)
+
+			ww := httptest.NewRecorder()
+			handler.SchemaCheck(ww, httptest.NewRequest(http.MethodGet, "/admin/schema/check", nil))
+
+			assert.Equal(t, http.StatusOK, ww.Code)
+			var got map[string]any
+			assert.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+			assert.Equal(t, map[string]any{
+				"current":    float64(tt.current),
+				"latest":     float64(latest),
+				"up_to_date": tt.upToDate,
+			}, got)
+		})
+	}
+}
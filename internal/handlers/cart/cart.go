@@ -1,30 +1,74 @@
 package carthandler
 
 import (
+	"bytes"
+	"cartapi/internal/apierror"
 	"cartapi/internal/models"
+	"cartapi/internal/respond"
 	serviceerrors "cartapi/internal/service"
 	"cartapi/pkg/lib/logger/sl"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 const StatusClientClosedRequest = 499
 
+// UserIdHeader identifies the caller, who owns the carts they create.
+const UserIdHeader = "X-User-Id"
+
+// unavailableRetryAfter is the Retry-After, in seconds, sent with the 503
+// answering requests while the storage is unreachable.
+const unavailableRetryAfter = 5
+
+const (
+	defaultItemsLimit = 50
+	maxItemsLimit     = 200
+	maxBatchCarts     = 1000
+	maxViewCarts      = 100
+	maxExternalKeyLen = 255
+	maxUpdates        = 1000
+)
+
 type CartItemService interface {
-	CreateCart(ctx context.Context) (models.Cart, error)
+	CreateCart(ctx context.Context, userId string) (models.Cart, error)
+	GetOrCreateCart(ctx context.Context, key string, userId string) (models.Cart, bool, error)
+	AuthorizeCart(ctx context.Context, cartId int, userId string) error
+	CreateCarts(ctx context.Context, n int) ([]int, error)
 	AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error)
 	RemoveFromCart(ctx context.Context, cartId int, itemId int) error
-	ViewCart(ctx context.Context, cartId int) (models.Cart, error)
+	GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error)
+	ItemIds(ctx context.Context, cartId int) ([]int, error)
+	UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error)
+	UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error)
+	ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error)
+	ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error)
+	StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error)
+	MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error)
+	CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error)
+	RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error)
+	AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error)
+	ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error)
+	PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error)
+	RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error)
+	PurgeCart(ctx context.Context, cartId int) error
 }
 
 type Handler struct {
-	log     *slog.Logger
-	service CartItemService
+	log              *slog.Logger
+	service          CartItemService
+	enforceOwnership bool
+	defaultQuantity  int
+	idempotentRemove bool
 }
 
 func New(log *slog.Logger, service CartItemService) *Handler {
@@ -34,23 +78,126 @@ func New(log *slog.Logger, service CartItemService) *Handler {
 	}
 }
 
+// SetEnforceOwnership makes viewing a cart and adding or removing its items
+// answer 403 unless the caller's X-User-Id matches the owner of the cart.
+// It must be called before the handler is used.
+func (h *Handler) SetEnforceOwnership(enabled bool) {
+	h.enforceOwnership = enabled
+}
+
+// SetDefaultQuantity makes AddToCart fill in n for a missing or zero
+// quantity instead of rejecting the item. Zero keeps quantity required. It
+// must be called before the handler is used.
+func (h *Handler) SetDefaultQuantity(n int) {
+	h.defaultQuantity = n
+}
+
+// SetIdempotentRemove makes RemoveFromCart answer 204 for an item that is
+// already gone, so that a retried removal succeeds like the first one. It
+// must be called before the handler is used.
+func (h *Handler) SetIdempotentRemove(enabled bool) {
+	h.idempotentRemove = enabled
+}
+
 // POST /carts
 func (h *Handler) CreateCart(w http.ResponseWriter, r *http.Request) {
 	const op = "handlers.cart.CreateCart"
 	log := h.log.With("op", op)
 
-	cart, err := h.service.CreateCart(r.Context())
+	cart, err := h.service.CreateCart(r.Context(), r.Header.Get(UserIdHeader))
 	if err != nil {
 		handleServiceError(w, log, err, "Failed to create cart")
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(cart); err != nil {
-		log.Error("Failed to respond user", sl.Err(err))
-		http.Error(w, "Failed to respond user", http.StatusInternalServerError)
+	respond.JSON(w, r, log, http.StatusCreated, cart)
+}
+
+type getOrCreateCartRequest struct {
+	Key string `json:"key"`
+}
+
+// POST /carts/by-key
+//
+// Returns the cart created for the external key, such as a session id, with
+// 200 and its first page of items, or creates it with 201 on first use.
+func (h *Handler) GetOrCreateCart(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.cart.GetOrCreateCart"
+	log := h.log.With("op", op)
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var req getOrCreateCartRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	if strings.TrimSpace(req.Key) == "" || len(req.Key) > maxExternalKeyLen {
+		log.Error("Invalid key", slog.Int("length", len(req.Key)))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, fmt.Sprintf("key must be set and at most %d bytes long", maxExternalKeyLen))
+		return
+	}
+
+	cart, created, err := h.service.GetOrCreateCart(r.Context(), req.Key, r.Header.Get(UserIdHeader))
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to get or create cart")
+		return
+	}
+	if created {
+		respond.JSON(w, r, log, http.StatusCreated, cart)
+		return
+	}
+
+	if !h.authorize(w, r, log, cart.Id) {
 		return
 	}
+
+	cart, err = h.service.ViewCart(r.Context(), cart.Id, models.ViewCartOptions{Limit: defaultItemsLimit})
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to view the cart")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, cart)
+}
+
+type createCartsRequest struct {
+	Count int `json:"count"`
+}
+
+type createCartsResponse struct {
+	Ids []int `json:"ids"`
+}
+
+// POST /carts/batch
+func (h *Handler) CreateCarts(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.cart.CreateCarts"
+	log := h.log.With("op", op)
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var createReq createCartsRequest
+	if err := json.NewDecoder(body).Decode(&createReq); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	if createReq.Count <= 0 || createReq.Count > maxBatchCarts {
+		log.Error("Invalid count", slog.Int("count", createReq.Count))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, fmt.Sprintf("count must be between 1 and %d", maxBatchCarts))
+		return
+	}
+
+	ids, err := h.service.CreateCarts(r.Context(), createReq.Count)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to create carts")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusCreated, createCartsResponse{Ids: ids})
 }
 
 // POST /carts/{cartId}/items
@@ -61,34 +208,59 @@ func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request, cartIdStr st
 	cartId, err := parseCartID(cartIdStr)
 	if err != nil {
 		log.Error("Invalid cartId parameter", sl.Err(err))
-		http.Error(w, "Invalid cart ID", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
 		return
 	}
 
-	requestBody, err := io.ReadAll(r.Body)
-	defer r.Body.Close()
+	body := nonNilBody(r)
+	defer body.Close()
+	requestBody, err := io.ReadAll(body)
 	if err != nil {
 		log.Error("Cannot read request body", sl.Err(err))
-		http.Error(w, "Cannot read request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot read request body")
 		return
 	}
-
-	var item models.CartItem
-	if err := json.Unmarshal(requestBody, &item); err != nil {
-		log.Error("Cannot unmarshal request body", sl.Err(err))
-		http.Error(w, "Cannot unmarshal request body", http.StatusBadRequest)
+	// An empty object still gets the field errors below; only a missing
+	// body is reported as such.
+	if len(bytes.TrimSpace(requestBody)) == 0 {
+		log.Error("Empty request body")
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Request body required")
 		return
 	}
 
-	if item.Product == "" {
-		log.Error("Product field is required", sl.Err(errors.New("product field is required")))
-		http.Error(w, "Product field is required", http.StatusBadRequest)
+	item, err := decodeCartItem(r.Header.Get("Content-Type"), requestBody)
+	if err != nil {
+		if errors.Is(err, errUnsupportedMediaType) {
+			log.Error("Unsupported content type", sl.Err(err))
+			apierror.Write(w, http.StatusUnsupportedMediaType, apierror.ValidationFailed, "Unsupported content type")
+			return
+		}
+		if errors.Is(err, errInvalidQuantity) {
+			log.Error("Invalid quantity", sl.Err(err))
+			apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Quantity must be an integer")
+			return
+		}
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
 		return
 	}
 
-	if item.Quantity <= 0 {
-		log.Error("Quantity must be greater than zero", sl.Err(errors.New("quantity must be greater than zero")))
-		http.Error(w, "Quantity must be greater than zero", http.StatusBadRequest)
+	item = normalizeCartItem(item)
+	if item.Quantity == 0 && h.defaultQuantity > 0 {
+		item.Quantity = h.defaultQuantity
+	}
+	if err := validateCartItem(item); err != nil {
+		log.Error("Validation failed", sl.Err(err))
+		var fields fieldErrors
+		if errors.As(err, &fields) {
+			apierror.WriteFields(w, http.StatusBadRequest, apierror.ValidationFailed, capitalize(err.Error()), fields.byField())
+			return
+		}
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, capitalize(err.Error()))
 		return
 	}
 
@@ -98,12 +270,8 @@ func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request, cartIdStr st
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(insertedItem); err != nil {
-		log.Error("Failed to respond user", sl.Err(err))
-		http.Error(w, "Failed to respond user", http.StatusInternalServerError)
-		return
-	}
+	w.Header().Set("ETag", itemETag(insertedItem))
+	respond.JSON(w, r, log, http.StatusCreated, insertedItem)
 }
 
 // DELETE /carts/{cartId}/items/{itemId}
@@ -114,27 +282,96 @@ func (h *Handler) RemoveFromCart(w http.ResponseWriter, r *http.Request, cartIdS
 	cartId, err := parseCartID(cartIdStr)
 	if err != nil {
 		log.Error("Invalid cartId parameter", sl.Err(err))
-		http.Error(w, "Invalid cart ID", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
 		return
 	}
 
 	itemId, err := parseItemID(itemIdStr)
 	if err != nil {
 		log.Error("Invalid itemId parameter", sl.Err(err))
-		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidItemID, "Invalid item ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
 		return
 	}
 
+	// If-Match makes the removal conditional on the item being unchanged
+	// since the client got its ETag.
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		item, err := h.service.GetItem(r.Context(), cartId, itemId)
+		if err != nil {
+			h.handleRemoveError(w, log, err)
+			return
+		}
+		if etag := itemETag(item); !etagMatches(ifMatch, etag) {
+			log.Warn("Item changed since it was read", slog.String("if_match", ifMatch), slog.String("etag", etag))
+			w.Header().Set("ETag", etag)
+			apierror.Write(w, http.StatusPreconditionFailed, apierror.PreconditionFailed, "Item has changed")
+			return
+		}
+	}
+
 	err = h.service.RemoveFromCart(r.Context(), cartId, itemId)
 	if err != nil {
-		handleServiceError(w, log, err, "Failed to remove from cart")
+		h.handleRemoveError(w, log, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRemoveError answers a failed removal, treating an item that is
+// already gone as removed in idempotent mode.
+func (h *Handler) handleRemoveError(w http.ResponseWriter, log *slog.Logger, err error) {
+	if h.idempotentRemove && errors.Is(err, serviceerrors.ErrItemNotFound) {
+		log.Info("Item already removed", sl.Err(err))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	handleServiceError(w, log, err, "Failed to remove from cart")
+}
+
+// DELETE /carts/{cartId}?if_empty=true
+//
+// Deletes the cart only while it has no items, answering 409 otherwise.
+// if_empty=true is required so that the condition is always spelled out.
+func (h *Handler) PurgeCart(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.PurgeCart"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	ifEmptyStr := r.URL.Query().Get("if_empty")
+	if ifEmpty, err := strconv.ParseBool(ifEmptyStr); err != nil || !ifEmpty {
+		log.Error("Invalid if_empty parameter", slog.String("if_empty", ifEmptyStr))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "if_empty=true is required")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	if err := h.service.PurgeCart(r.Context(), cartId); err != nil {
+		handleServiceError(w, log, err, "Failed to purge cart")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// GET /carts/{cartId}
+// GET /carts/{cartId}?limit=&offset=&product=&stream=
+//
+// stream=true writes every matching item as it is read from the storage
+// instead of buffering a page of them; limit, offset and ETags don't apply
+// and the v1 representation is always used.
 func (h *Handler) ViewCart(w http.ResponseWriter, r *http.Request, cartIdStr string) {
 	const op = "handlers.cart.ViewCart"
 	log := h.log.With("op", op)
@@ -142,37 +379,905 @@ func (h *Handler) ViewCart(w http.ResponseWriter, r *http.Request, cartIdStr str
 	cartId, err := parseCartID(cartIdStr)
 	if err != nil {
 		log.Error("Invalid cartId parameter", sl.Err(err))
-		http.Error(w, "Invalid cart ID", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	query := r.URL.Query()
+	limit, offset, err := parsePaginationParams(query)
+	if err != nil {
+		log.Error("Invalid pagination parameters", sl.Err(err),
+			slog.String("limit", query.Get("limit")), slog.String("offset", query.Get("offset")))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, capitalize(err.Error()))
 		return
 	}
+	opts := models.ViewCartOptions{Limit: limit, Offset: offset, Product: query.Get("product")}
+
+	if streamStr := query.Get("stream"); streamStr != "" {
+		stream, err := strconv.ParseBool(streamStr)
+		if err != nil {
+			log.Error("Invalid stream parameter", slog.String("stream", streamStr))
+			apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Invalid stream")
+			return
+		}
+		if stream {
+			h.streamCart(w, r, log, &cartStream{w: w, cartId: cartId}, opts.Product)
+			return
+		}
+	}
 
-	cart, err := h.service.ViewCart(r.Context(), cartId)
+	cart, err := h.service.ViewCart(r.Context(), cartId, opts)
 	if err != nil {
 		handleServiceError(w, log, err, "Failed to view the cart")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(cart); err != nil {
-		log.Error("Failed to respond user", sl.Err(err))
-		http.Error(w, "Failed to respond user", http.StatusInternalServerError)
+	version := negotiateVersion(r.Header.Get("Accept"))
+	etag := version.tagETag(cartETag(cart))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+
+	w.Header().Set("Content-Type", version.contentType())
+	respond.JSON(w, r, log, http.StatusOK, cartResponse(cart, version))
+}
+
+type itemIdsResponse struct {
+	ItemIds []int `json:"item_ids"`
+}
+
+// GET /carts/{cartId}/items?fields=id
+//
+// Lists only the ids of the items, in id order, for clients syncing a cart
+// without needing the items themselves. id is the only supported field.
+func (h *Handler) ListItemIds(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.ListItemIds"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if fields := r.URL.Query().Get("fields"); fields != "id" {
+		log.Error("Invalid fields parameter", slog.String("fields", fields))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "fields=id is required")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	itemIds, err := h.service.ItemIds(r.Context(), cartId)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to list item ids")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, itemIdsResponse{ItemIds: itemIds})
+}
+
+type viewCartsResponse struct {
+	Carts []any `json:"carts"`
+	// Missing lists the requested ids with no cart, or none the caller may
+	// see.
+	Missing []int `json:"missing"`
+}
+
+// GET /carts?ids=1,2,3
+//
+// Every requested cart is returned with all of its items, in the order of
+// ids; duplicates are returned once.
+func (h *Handler) ViewCarts(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.cart.ViewCarts"
+	log := h.log.With("op", op)
+
+	idsStr := r.URL.Query().Get("ids")
+	if idsStr == "" {
+		log.Error("Missing ids parameter")
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "ids is required")
+		return
+	}
+	var cartIds []int
+	seen := make(map[int]bool)
+	for _, idStr := range strings.Split(idsStr, ",") {
+		cartId, err := parseCartID(strings.TrimSpace(idStr))
+		if err != nil {
+			log.Error("Invalid ids parameter", sl.Err(err))
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+			return
+		}
+		if !seen[cartId] {
+			seen[cartId] = true
+			cartIds = append(cartIds, cartId)
+		}
+	}
+	if len(cartIds) > maxViewCarts {
+		log.Error("Too many ids", slog.Int("ids", len(cartIds)))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, fmt.Sprintf("at most %d carts can be viewed at once", maxViewCarts))
+		return
+	}
+
+	carts, err := h.service.ViewCarts(r.Context(), cartIds)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to view the carts")
+		return
+	}
+
+	version := negotiateVersion(r.Header.Get("Accept"))
+	userId := r.Header.Get(UserIdHeader)
+	resp := viewCartsResponse{Carts: make([]any, 0, len(carts)), Missing: []int{}}
+	returned := make(map[int]bool, len(carts))
+	for _, cart := range carts {
+		// Other users' carts are reported missing rather than forbidden, so
+		// that the response doesn't tell which ids exist.
+		if h.enforceOwnership && cart.UserId != "" && cart.UserId != userId {
+			continue
+		}
+		returned[cart.Id] = true
+		cart.UserId = ""
+		resp.Carts = append(resp.Carts, cartResponse(cart, version))
+	}
+	for _, cartId := range cartIds {
+		if !returned[cartId] {
+			resp.Missing = append(resp.Missing, cartId)
+		}
+	}
+
+	w.Header().Set("Content-Type", version.contentType())
+	w.Header().Set("Vary", "Accept")
+	respond.JSON(w, r, log, http.StatusOK, resp)
+}
+
+func (h *Handler) streamCart(w http.ResponseWriter, r *http.Request, log *slog.Logger, stream *cartStream, product string) {
+	cart, err := h.service.StreamCartItems(r.Context(), stream.cartId, product, stream.item)
+	if err == nil {
+		err = stream.finish(cart)
+	}
+	if err == nil {
+		return
+	}
+
+	if !stream.started {
+		handleServiceError(w, log, err, "Failed to view the cart")
+		return
+	}
+	// The status line is gone; cutting the connection is the only way left
+	// to tell the client the body is incomplete.
+	log.Error("Failed to stream the cart", sl.Err(err))
+	panic(http.ErrAbortHandler)
+}
+
+type moveItemRequest struct {
+	TargetCartId int `json:"target_cart_id"`
+}
+
+// POST /carts/{cartId}/items/{itemId}/move
+func (h *Handler) MoveItem(w http.ResponseWriter, r *http.Request, cartIdStr string, itemIdStr string) {
+	const op = "handlers.cart.MoveItem"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	itemId, err := parseItemID(itemIdStr)
+	if err != nil {
+		log.Error("Invalid itemId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidItemID, "Invalid item ID")
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var moveReq moveItemRequest
+	if err := json.NewDecoder(body).Decode(&moveReq); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	if moveReq.TargetCartId <= 0 {
+		log.Error("Invalid target_cart_id", sl.Err(errors.New("target_cart_id must be a positive integer")))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid target cart ID")
+		return
+	}
+
+	// Moving takes the item out of one cart and into another, so the caller
+	// must own both.
+	for _, id := range []int{cartId, moveReq.TargetCartId} {
+		if !h.authorize(w, r, log, id) {
+			return
+		}
+	}
+
+	movedItem, err := h.service.MoveItem(r.Context(), cartId, itemId, moveReq.TargetCartId)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to move item")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, movedItem)
+}
+
+// POST /carts/{cartId}/items/{itemId}/restore
+func (h *Handler) RestoreItem(w http.ResponseWriter, r *http.Request, cartIdStr string, itemIdStr string) {
+	const op = "handlers.cart.RestoreItem"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	itemId, err := parseItemID(itemIdStr)
+	if err != nil {
+		log.Error("Invalid itemId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidItemID, "Invalid item ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	restoredItem, err := h.service.RestoreItem(r.Context(), cartId, itemId)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to restore item")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, restoredItem)
+}
+
+// patchItemRequest tells omitted fields, left nil, from fields sent with a
+// zero value. Quantity stays raw so that it is parsed like on AddToCart.
+type patchItemRequest struct {
+	Product  *string         `json:"product"`
+	Quantity json.RawMessage `json:"quantity"`
+}
+
+// PATCH /carts/{cartId}/items/{itemId}
+//
+// Updates any subset of the product and quantity of an item.
+func (h *Handler) PatchItem(w http.ResponseWriter, r *http.Request, cartIdStr string, itemIdStr string) {
+	const op = "handlers.cart.PatchItem"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	itemId, err := parseItemID(itemIdStr)
+	if err != nil {
+		log.Error("Invalid itemId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidItemID, "Invalid item ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var patchReq patchItemRequest
+	if err := json.NewDecoder(body).Decode(&patchReq); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	patch, err := patchReq.itemPatch()
+	if err != nil {
+		log.Error("Validation failed", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, capitalize(err.Error()))
+		return
+	}
+
+	updatedItem, err := h.service.UpdateItem(r.Context(), cartId, itemId, patch)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to update item")
+		return
+	}
+
+	w.Header().Set("ETag", itemETag(updatedItem))
+	respond.JSON(w, r, log, http.StatusOK, updatedItem)
+}
+
+// itemPatch validates the fields that were sent.
+func (req patchItemRequest) itemPatch() (models.ItemPatch, error) {
+	if req.Product == nil && len(req.Quantity) == 0 {
+		return models.ItemPatch{}, errors.New("product or quantity field is required")
+	}
+
+	patch := models.ItemPatch{Product: req.Product}
+	if patch.Product != nil && *patch.Product == "" {
+		return models.ItemPatch{}, errors.New("product must not be empty")
+	}
+	if patch.Product != nil && hasControlChars(*patch.Product) {
+		return models.ItemPatch{}, errors.New("product must not contain control characters")
+	}
+	if len(req.Quantity) > 0 {
+		quantity, err := parseQuantity(req.Quantity)
+		if err != nil {
+			return models.ItemPatch{}, errInvalidQuantity
+		}
+		if quantity < 1 {
+			return models.ItemPatch{}, errors.New("quantity must be greater than zero")
+		}
+		patch.Quantity = &quantity
+	}
+	return patch, nil
+}
+
+// POST /carts/{cartId}/copy
+func (h *Handler) CopyCart(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.CopyCart"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	cart, err := h.service.CopyCart(r.Context(), cartId, r.Header.Get(UserIdHeader))
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to copy cart")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusCreated, cart)
+}
+
+type removeItemsRequest struct {
+	ItemIds []int `json:"item_ids"`
+}
+
+type removeItemsResponse struct {
+	Deleted int   `json:"deleted"`
+	ItemIds []int `json:"item_ids"`
+}
+
+// POST /carts/{cartId}/items/delete
+func (h *Handler) RemoveItems(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.RemoveItems"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var removeReq removeItemsRequest
+	if err := json.NewDecoder(body).Decode(&removeReq); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	if len(removeReq.ItemIds) == 0 {
+		log.Error("item_ids field is required", sl.Err(errors.New("item_ids field is required")))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "item_ids field is required")
+		return
+	}
+	for _, itemId := range removeReq.ItemIds {
+		if itemId <= 0 {
+			log.Error("Invalid itemId in item_ids", sl.Err(errors.New("invalid itemId, must be a positive integer")))
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidItemID, "Invalid item ID")
+			return
+		}
+	}
+
+	deletedIds, err := h.service.RemoveItems(r.Context(), cartId, removeReq.ItemIds)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to remove items from cart")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, removeItemsResponse{Deleted: len(deletedIds), ItemIds: deletedIds})
+}
+
+type addItemsRequest struct {
+	Items []models.CartItem `json:"items"`
+}
+
+type itemError struct {
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+type addItemsResponse struct {
+	Added  []models.CartItem `json:"added"`
+	Errors []itemError       `json:"errors"`
+}
+
+// POST /carts/{cartId}/items/batch
+//
+// Valid items are inserted in one transaction, invalid ones are reported by
+// their index in the request. A partially applied batch answers with 207.
+func (h *Handler) AddItems(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.AddItems"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var addReq addItemsRequest
+	if err := json.NewDecoder(body).Decode(&addReq); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	if len(addReq.Items) == 0 {
+		log.Error("items field is required", sl.Err(errors.New("items field is required")))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "items field is required")
+		return
+	}
+
+	response := addItemsResponse{Added: []models.CartItem{}, Errors: []itemError{}}
+	validItems := make([]models.CartItem, 0, len(addReq.Items))
+	for i, item := range addReq.Items {
+		item = normalizeCartItem(item)
+		if err := validateCartItem(item); err != nil {
+			response.Errors = append(response.Errors, itemError{Index: i, Message: err.Error()})
+			continue
+		}
+		validItems = append(validItems, models.CartItem{
+			Product:  item.Product,
+			Quantity: item.Quantity,
+			Measure:  item.Measure,
+			Weight:   item.Weight,
+			Unit:     item.Unit,
+		})
+	}
+
+	status := http.StatusBadRequest
+	if len(validItems) > 0 {
+		addedItems, err := h.service.AddItems(r.Context(), cartId, validItems)
+		if err != nil {
+			handleServiceError(w, log, err, "Failed to add items to cart")
+			return
+		}
+		response.Added = addedItems
+
+		status = http.StatusCreated
+		if len(response.Errors) > 0 {
+			status = http.StatusMultiStatus
+		}
+	}
+
+	respond.JSON(w, r, log, status, response)
+}
+
+// PUT /carts/{cartId}/items
+func (h *Handler) ReplaceItems(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.ReplaceItems"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var replaceReq addItemsRequest
+	if err := json.NewDecoder(body).Decode(&replaceReq); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	// An empty list is allowed and empties the cart; a missing one is not.
+	if replaceReq.Items == nil {
+		log.Error("items field is required", sl.Err(errors.New("items field is required")))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "items field is required")
+		return
+	}
+
+	items := make([]models.CartItem, 0, len(replaceReq.Items))
+	invalid := map[string]string{}
+	for i, item := range replaceReq.Items {
+		item = normalizeCartItem(item)
+		if err := validateCartItem(item); err != nil {
+			var fields fieldErrors
+			if !errors.As(err, &fields) {
+				invalid[fmt.Sprintf("items[%d]", i)] = capitalize(err.Error())
+				continue
+			}
+			for field, msg := range fields.byField() {
+				invalid[fmt.Sprintf("items[%d].%s", i, field)] = msg
+			}
+			continue
+		}
+		items = append(items, models.CartItem{
+			Product:  item.Product,
+			Quantity: item.Quantity,
+			Measure:  item.Measure,
+			Weight:   item.Weight,
+			Unit:     item.Unit,
+		})
+	}
+	if len(invalid) > 0 {
+		log.Error("Validation failed", slog.Int("invalid_fields", len(invalid)))
+		apierror.WriteFields(w, http.StatusBadRequest, apierror.ValidationFailed, "Some items are invalid", invalid)
+		return
+	}
+
+	cart, err := h.service.ReplaceItems(r.Context(), cartId, items)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to replace cart items")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, cart)
+}
+
+type quantityUpdateRequest struct {
+	Updates []quantityUpdate `json:"updates"`
+}
+
+type quantityUpdate struct {
+	Id       int `json:"id"`
+	Quantity int `json:"quantity"`
+}
+
+type quantityUpdateResponse struct {
+	Items []models.CartItem `json:"items"`
+}
+
+// PATCH /carts/{cartId}/items
+//
+// Sets the quantities of several items in one transaction. When any id is not
+// an item of the cart nothing is changed and 404 is returned.
+func (h *Handler) UpdateQuantities(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.UpdateQuantities"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var req quantityUpdateRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	if len(req.Updates) == 0 || len(req.Updates) > maxUpdates {
+		log.Error("Invalid number of updates", slog.Int("updates", len(req.Updates)))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, fmt.Sprintf("updates must hold between 1 and %d items", maxUpdates))
+		return
+	}
+
+	updates := make([]models.QuantityUpdate, 0, len(req.Updates))
+	seen := make(map[int]bool, len(req.Updates))
+	invalid := map[string]string{}
+	for i, update := range req.Updates {
+		switch {
+		case update.Id <= 0:
+			invalid[fmt.Sprintf("updates[%d].id", i)] = "Id must be greater than zero"
+		case seen[update.Id]:
+			invalid[fmt.Sprintf("updates[%d].id", i)] = "Id is listed more than once"
+		}
+		if update.Quantity < 1 {
+			invalid[fmt.Sprintf("updates[%d].quantity", i)] = "Quantity must be greater than zero"
+		}
+		seen[update.Id] = true
+		updates = append(updates, models.QuantityUpdate{ItemId: update.Id, Quantity: update.Quantity})
+	}
+	if len(invalid) > 0 {
+		log.Error("Validation failed", slog.Int("invalid_fields", len(invalid)))
+		apierror.WriteFields(w, http.StatusBadRequest, apierror.ValidationFailed, "Some updates are invalid", invalid)
+		return
+	}
+
+	items, err := h.service.UpdateQuantities(r.Context(), cartId, updates)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to update quantities")
+		return
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, quantityUpdateResponse{Items: items})
+}
+
+const (
+	maxMetadataKeys        = 32
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 512
+)
+
+type patchCartRequest struct {
+	Metadata map[string]*string `json:"metadata"`
+}
+
+type patchCartResponse struct {
+	Id       int               `json:"id"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// PATCH /carts/{cartId}
+func (h *Handler) PatchCart(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.PatchCart"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	var patchReq patchCartRequest
+	if err := json.NewDecoder(body).Decode(&patchReq); err != nil {
+		log.Error("Cannot unmarshal request body", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot unmarshal request body")
+		return
+	}
+
+	if len(patchReq.Metadata) == 0 {
+		log.Error("metadata field is required", sl.Err(errors.New("metadata field is required")))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "metadata field is required")
+		return
+	}
+
+	if err := validateMetadataPatch(patchReq.Metadata); err != nil {
+		log.Error("Validation failed", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, capitalize(err.Error()))
+		return
+	}
+
+	cart, err := h.service.PatchCartMetadata(r.Context(), cartId, patchReq.Metadata)
+	if err != nil {
+		handleServiceError(w, log, err, "Failed to update cart")
+		return
+	}
+
+	metadata := cart.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	respond.JSON(w, r, log, http.StatusOK, patchCartResponse{Id: cart.Id, Metadata: metadata})
+}
+
+func validateMetadataPatch(patch map[string]*string) error {
+	if len(patch) > maxMetadataKeys {
+		return fmt.Errorf("metadata must have at most %d keys", maxMetadataKeys)
+	}
+	for key, value := range patch {
+		if key == "" || utf8.RuneCountInString(key) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata keys must be 1 to %d characters", maxMetadataKeyLength)
+		}
+		if value != nil && utf8.RuneCountInString(*value) > maxMetadataValueLength {
+			return fmt.Errorf("metadata value for %q must be at most %d characters", key, maxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// authorize answers 403 and returns false when ownership is enforced and the
+// cart belongs to someone other than the caller.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, log *slog.Logger, cartId int) bool {
+	if !h.enforceOwnership {
+		return true
+	}
+	if err := h.service.AuthorizeCart(r.Context(), cartId, r.Header.Get(UserIdHeader)); err != nil {
+		handleServiceError(w, log, err, "Failed to check cart owner")
+		return false
+	}
+	return true
 }
 
 func handleServiceError(w http.ResponseWriter, log *slog.Logger, err error, msg string) {
 	if errors.Is(err, serviceerrors.ErrContextCanceled) {
 		log.Warn("Context canceled", sl.Err(serviceerrors.ErrContextCanceled))
-		http.Error(w, "Context canceled", StatusClientClosedRequest)
+		apierror.Write(w, StatusClientClosedRequest, apierror.Canceled, "Context canceled")
 	} else if errors.Is(err, serviceerrors.ErrDeadlineExceeded) {
 		log.Warn("Deadline exceeded", sl.Err(serviceerrors.ErrDeadlineExceeded))
-		http.Error(w, "Deadline exceeded", http.StatusGatewayTimeout)
+		apierror.Write(w, http.StatusGatewayTimeout, apierror.Timeout, "Deadline exceeded")
+	} else if errors.Is(err, serviceerrors.ErrItemNotFound) {
+		log.Warn("Item not found", sl.Err(serviceerrors.ErrItemNotFound))
+		apierror.Write(w, http.StatusNotFound, apierror.ItemNotFound, "Item not found")
 	} else if errors.Is(err, serviceerrors.ErrNotFound) {
 		log.Warn("Cart not found", sl.Err(serviceerrors.ErrNotFound))
-		http.Error(w, "Cart not found", http.StatusNotFound)
+		apierror.Write(w, http.StatusNotFound, apierror.CartNotFound, "Cart not found")
+	} else if errors.Is(err, serviceerrors.ErrForbidden) {
+		log.Warn("Forbidden", sl.Err(serviceerrors.ErrForbidden))
+		apierror.Write(w, http.StatusForbidden, apierror.Forbidden, "Cart belongs to another user")
+	} else if errors.Is(err, serviceerrors.ErrUnknownProduct) {
+		log.Warn("Unknown product", sl.Err(err))
+		apierror.Write(w, http.StatusUnprocessableEntity, apierror.UnknownProduct, "Unknown product")
+	} else if errors.Is(err, serviceerrors.ErrCartFull) {
+		log.Warn("Cart full", sl.Err(serviceerrors.ErrCartFull))
+		apierror.Write(w, http.StatusConflict, apierror.CartFull, "Cart is full")
+	} else if errors.Is(err, serviceerrors.ErrCartNotEmpty) {
+		log.Warn("Cart not empty", sl.Err(serviceerrors.ErrCartNotEmpty))
+		apierror.Write(w, http.StatusConflict, apierror.CartNotEmpty, "Cart is not empty")
+	} else if errors.Is(err, serviceerrors.ErrConflict) {
+		log.Warn("Conflict", sl.Err(serviceerrors.ErrConflict))
+		apierror.Write(w, http.StatusConflict, apierror.Conflict, "Conflict")
+	} else if errors.Is(err, serviceerrors.ErrUnavailable) {
+		log.Error("Storage unavailable", sl.Err(err))
+		w.Header().Set("Retry-After", strconv.Itoa(unavailableRetryAfter))
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.Unavailable, "Service temporarily unavailable")
+	} else if errors.Is(err, serviceerrors.ErrEmptyProduct) {
+		log.Warn("Empty product", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Product must not be empty")
+	} else if errors.Is(err, serviceerrors.ErrQuantityOverflow) {
+		log.Warn("Quantity overflow", sl.Err(serviceerrors.ErrQuantityOverflow))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, fmt.Sprintf("Quantity must not exceed %d", models.MaxQuantity))
 	} else {
 		log.Error(msg, sl.Err(err))
-		http.Error(w, msg, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, msg)
+	}
+}
+
+// nonNilBody returns the request body, or an empty one for requests built
+// without a body, which leave r.Body nil.
+func nonNilBody(r *http.Request) io.ReadCloser {
+	if r.Body == nil {
+		return http.NoBody
+	}
+	return r.Body
+}
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errInvalidQuantity      = errors.New("quantity must be an integer")
+)
+
+// cartItemRequest keeps the raw quantity so that floats and strings are
+// rejected instead of being truncated or reported as a type mismatch.
+type cartItemRequest struct {
+	models.CartItem
+	Quantity json.RawMessage `json:"quantity"`
+}
+
+// decodeCartItem parses the request body according to its content type.
+// JSON is assumed when the client doesn't send a content type.
+func decodeCartItem(contentType string, body []byte) (models.CartItem, error) {
+	mediaType := "application/json"
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return models.CartItem{}, fmt.Errorf("%w: %s", errUnsupportedMediaType, contentType)
+		}
+		mediaType = parsed
+	}
+
+	var item models.CartItem
+	switch mediaType {
+	case "application/json":
+		var req cartItemRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return models.CartItem{}, err
+		}
+		item = req.CartItem
+		if len(req.Quantity) > 0 {
+			quantity, err := parseQuantity(req.Quantity)
+			if err != nil {
+				return models.CartItem{}, err
+			}
+			item.Quantity = quantity
+		}
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return models.CartItem{}, err
+		}
+		item.Product = values.Get("product")
+		if quantityStr := values.Get("quantity"); quantityStr != "" {
+			quantity, err := strconv.Atoi(quantityStr)
+			if err != nil {
+				return models.CartItem{}, errInvalidQuantity
+			}
+			item.Quantity = quantity
+		}
+		item.Measure = values.Get("measure")
+		if weightStr := values.Get("weight"); weightStr != "" {
+			weight, err := strconv.ParseFloat(weightStr, 64)
+			if err != nil {
+				return models.CartItem{}, errors.New("weight must be a number")
+			}
+			item.Weight = weight
+		}
+		item.Unit = values.Get("unit")
+	default:
+		return models.CartItem{}, fmt.Errorf("%w: %s", errUnsupportedMediaType, mediaType)
+	}
+
+	return item, nil
+}
+
+// parseQuantity accepts only a JSON integer literal, or null for no
+// quantity. Integers out of the range of an int are clamped to it, so they
+// still fail the quantity checks like any other out-of-bounds value.
+func parseQuantity(raw json.RawMessage) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return 0, err
+	}
+
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case json.Number:
+		quantity, err := strconv.ParseInt(n.String(), 10, 0)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("%w: %s", errInvalidQuantity, n)
+		}
+		return int(quantity), nil
+	default:
+		return 0, fmt.Errorf("%w: %s", errInvalidQuantity, raw)
 	}
 }
 
@@ -3,20 +3,28 @@ package carthandler_test
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"cartapi/internal/apierror"
+	"cartapi/internal/database/memory"
 	carthandler "cartapi/internal/handlers/cart"
 	"cartapi/internal/handlers/cart/mocks"
 	"cartapi/internal/models"
 	serviceerrors "cartapi/internal/service"
+	cartservice "cartapi/internal/service/cart"
 	"cartapi/pkg/lib/logger/slogdiscard"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func newTestHandler(service *mocks.Service) *carthandler.Handler {
@@ -35,7 +43,7 @@ func TestHandler_CreateCart(t *testing.T) {
 		{
 			name: "Success",
 			setupMock: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
 			},
 			reqContext:   context.Background(),
 			expectedCode: http.StatusCreated,
@@ -44,7 +52,7 @@ func TestHandler_CreateCart(t *testing.T) {
 		{
 			name: "Context canceled",
 			setupMock: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{}, serviceerrors.ErrContextCanceled)
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, serviceerrors.ErrContextCanceled)
 			},
 			reqContext:   func() context.Context { ctx, cancel := context.WithCancel(context.Background()); cancel(); return ctx }(),
 			expectedCode: carthandler.StatusClientClosedRequest,
@@ -52,7 +60,7 @@ func TestHandler_CreateCart(t *testing.T) {
 		{
 			name: "Deadline exceeded",
 			setupMock: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{}, serviceerrors.ErrDeadlineExceeded)
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, serviceerrors.ErrDeadlineExceeded)
 			},
 			reqContext:   func() context.Context { ctx, cancel := context.WithCancel(context.Background()); cancel(); return ctx }(),
 			expectedCode: http.StatusGatewayTimeout,
@@ -60,7 +68,7 @@ func TestHandler_CreateCart(t *testing.T) {
 		{
 			name: "Failed to create cart",
 			setupMock: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{}, errors.New("error"))
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, errors.New("error"))
 			},
 			reqContext:   context.Background(),
 			expectedCode: http.StatusInternalServerError,
@@ -135,7 +143,7 @@ func TestHandler_AddToCart(t *testing.T) {
 			name:   "Success",
 			cartId: "1",
 			setupMock: func(s *mocks.Service) {
-				item := models.CartItem{Product: "item", Quantity: 5}
+				item := models.CartItem{Product: "item", Quantity: 5, Measure: models.MeasureCount}
 				returnItem := models.CartItem{Id: 1, CartId: 1, Product: item.Product, Quantity: item.Quantity}
 				s.On("AddToCart", mock.Anything, 1, item).Return(returnItem, nil)
 			},
@@ -147,12 +155,32 @@ func TestHandler_AddToCart(t *testing.T) {
 			name:   "Service error",
 			cartId: "1",
 			setupMock: func(s *mocks.Service) {
-				item := models.CartItem{Product: "item", Quantity: 5}
+				item := models.CartItem{Product: "item", Quantity: 5, Measure: models.MeasureCount}
 				s.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{}, errors.New("service failure"))
 			},
 			body:         []byte(`{"product":"item","quantity":5}`),
 			expectedCode: http.StatusInternalServerError,
 		},
+		{
+			name:   "Conflict",
+			cartId: "1",
+			setupMock: func(s *mocks.Service) {
+				item := models.CartItem{Product: "item", Quantity: 5, Measure: models.MeasureCount}
+				s.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{}, serviceerrors.ErrConflict)
+			},
+			body:         []byte(`{"product":"item","quantity":5}`),
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:   "Quantity overflow",
+			cartId: "1",
+			setupMock: func(s *mocks.Service) {
+				item := models.CartItem{Product: "item", Quantity: models.MaxQuantity + 1, Measure: models.MeasureCount}
+				s.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{}, serviceerrors.ErrQuantityOverflow)
+			},
+			body:         []byte(`{"product":"item","quantity":2147483648}`),
+			expectedCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -214,6 +242,15 @@ func TestHandler_RemoveFromCart(t *testing.T) {
 			},
 			expectedCode: http.StatusInternalServerError,
 		},
+		{
+			name:   "Not found",
+			cartId: "1",
+			itemId: "2",
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 2).Return(serviceerrors.ErrNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
 	}
 
 	for _, tt := range tests {
@@ -235,6 +272,328 @@ func TestHandler_RemoveFromCart(t *testing.T) {
 	}
 }
 
+func TestHandler_RemoveFromCart_NotFound(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	cart, err := storage.CreateCart(context.Background(), "")
+	require.NoError(t, err)
+	cartIdStr := fmt.Sprint(cart.Id)
+
+	tests := []struct {
+		name         string
+		cartId       string
+		expectedCode apierror.Code
+		expectedMsg  string
+	}{
+		{name: "Missing cart", cartId: "999", expectedCode: apierror.CartNotFound, expectedMsg: "Cart not found"},
+		{name: "Missing item", cartId: cartIdStr, expectedCode: apierror.ItemNotFound, expectedMsg: "Item not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ww := httptest.NewRecorder()
+			handler.RemoveFromCart(ww, httptest.NewRequest(http.MethodDelete, "/carts/"+tt.cartId+"/items/7", nil), tt.cartId, "7")
+
+			assert.Equal(t, http.StatusNotFound, ww.Code)
+			var resp apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+			assert.Equal(t, tt.expectedCode, resp.Error.Code)
+			assert.Equal(t, tt.expectedMsg, resp.Error.Message)
+		})
+	}
+}
+
+func TestHandler_RemoveFromCart_Idempotent(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	ctx := context.Background()
+
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	item, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 1})
+	require.NoError(t, err)
+	cartIdStr, itemIdStr := fmt.Sprint(cart.Id), fmt.Sprint(item.Id)
+
+	remove := func(handler *carthandler.Handler, cartId string) *httptest.ResponseRecorder {
+		ww := httptest.NewRecorder()
+		handler.RemoveFromCart(ww, httptest.NewRequest(http.MethodDelete, "/carts/"+cartId+"/items/"+itemIdStr, nil), cartId, itemIdStr)
+		return ww
+	}
+
+	idempotent := carthandler.New(log, cartservice.New(log, storage))
+	idempotent.SetIdempotentRemove(true)
+	strict := carthandler.New(log, cartservice.New(log, storage))
+
+	assert.Equal(t, http.StatusNoContent, remove(idempotent, cartIdStr).Code)
+
+	t.Run("Retry succeeds in idempotent mode", func(t *testing.T) {
+		ww := remove(idempotent, cartIdStr)
+		assert.Equal(t, http.StatusNoContent, ww.Code)
+		assert.Zero(t, ww.Body.Len())
+	})
+
+	t.Run("Retry fails in strict mode", func(t *testing.T) {
+		ww := remove(strict, cartIdStr)
+		assert.Equal(t, http.StatusNotFound, ww.Code)
+		var resp apierror.Response
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+		assert.Equal(t, apierror.ItemNotFound, resp.Error.Code)
+	})
+
+	t.Run("Missing cart is still not found", func(t *testing.T) {
+		ww := remove(idempotent, "999")
+		assert.Equal(t, http.StatusNotFound, ww.Code)
+		var resp apierror.Response
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+		assert.Equal(t, apierror.CartNotFound, resp.Error.Code)
+	})
+}
+
+func TestHandler_PurgeCart(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+	ctx := context.Background()
+
+	empty, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	full, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	_, err = storage.AddToCart(ctx, full.Id, models.CartItem{Product: "apple", Quantity: 1})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		cartId         int
+		query          string
+		expectedStatus int
+		expectedCode   apierror.Code
+	}{
+		{name: "Without if_empty", cartId: empty.Id, expectedStatus: http.StatusBadRequest, expectedCode: apierror.ValidationFailed},
+		{name: "if_empty=false", cartId: empty.Id, query: "?if_empty=false", expectedStatus: http.StatusBadRequest, expectedCode: apierror.ValidationFailed},
+		{name: "Cart with items", cartId: full.Id, query: "?if_empty=true", expectedStatus: http.StatusConflict, expectedCode: apierror.CartNotEmpty},
+		{name: "Empty cart", cartId: empty.Id, query: "?if_empty=true", expectedStatus: http.StatusNoContent},
+		{name: "Already purged", cartId: empty.Id, query: "?if_empty=true", expectedStatus: http.StatusNotFound, expectedCode: apierror.CartNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cartIdStr := fmt.Sprint(tt.cartId)
+			ww := httptest.NewRecorder()
+			handler.PurgeCart(ww, httptest.NewRequest(http.MethodDelete, "/carts/"+cartIdStr+tt.query, nil), cartIdStr)
+
+			assert.Equal(t, tt.expectedStatus, ww.Code)
+			if tt.expectedCode == "" {
+				assert.Zero(t, ww.Body.Len())
+				return
+			}
+			var resp apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+			assert.Equal(t, tt.expectedCode, resp.Error.Code)
+		})
+	}
+
+	_, err = storage.ViewCart(ctx, full.Id, models.ViewCartOptions{Limit: 50})
+	assert.NoError(t, err)
+}
+
+func TestHandler_ListItemIds(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+	ctx := context.Background()
+
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	first, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 1})
+	require.NoError(t, err)
+	second, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 2})
+	require.NoError(t, err)
+	empty, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		cartId         string
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Only ids", cartId: fmt.Sprint(cart.Id), query: "?fields=id", expectedStatus: http.StatusOK, expectedBody: fmt.Sprintf(`{"item_ids":[%d,%d]}`, first.Id, second.Id)},
+		{name: "Empty cart", cartId: fmt.Sprint(empty.Id), query: "?fields=id", expectedStatus: http.StatusOK, expectedBody: `{"item_ids":[]}`},
+		{name: "Missing cart", cartId: "999", query: "?fields=id", expectedStatus: http.StatusNotFound},
+		{name: "Without fields", cartId: fmt.Sprint(cart.Id), expectedStatus: http.StatusBadRequest},
+		{name: "Unsupported fields", cartId: fmt.Sprint(cart.Id), query: "?fields=id,product", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ww := httptest.NewRecorder()
+			handler.ListItemIds(ww, httptest.NewRequest(http.MethodGet, "/carts/"+tt.cartId+"/items"+tt.query, nil), tt.cartId)
+
+			assert.Equal(t, tt.expectedStatus, ww.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, ww.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_ViewCarts(t *testing.T) {
+	tooMany := make([]string, 101)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint(i + 1)
+	}
+
+	tests := []struct {
+		name             string
+		query            string
+		enforceOwnership bool
+		setupMock        func(s *mocks.Service)
+		expectedCode     int
+		expectedBody     string
+	}{
+		{name: "Missing ids", query: "", setupMock: func(s *mocks.Service) {}, expectedCode: http.StatusBadRequest},
+		{name: "Invalid id", query: "?ids=1,abc", setupMock: func(s *mocks.Service) {}, expectedCode: http.StatusBadRequest},
+		{name: "Too many ids", query: "?ids=" + strings.Join(tooMany, ","), setupMock: func(s *mocks.Service) {}, expectedCode: http.StatusBadRequest},
+		{
+			name:  "Missing carts are flagged",
+			query: "?ids=2,1,3,2",
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCarts", mock.Anything, []int{2, 1, 3}).Return([]models.Cart{
+					{Id: 2, Items: []models.CartItem{{Id: 5, CartId: 2, Product: "apple", Quantity: 1}}, Total: 1},
+					{Id: 1, Total: 0, Empty: true},
+				}, nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"carts":[{"id":2,"items":[{"id":5,"cart_id":2,"product":"apple","quantity":1}],"total":1},` +
+				`{"id":1,"items":null,"total":0,"empty":true}],"missing":[3]}`,
+		},
+		{
+			name:             "Other users' carts are missing",
+			query:            "?ids=1,2",
+			enforceOwnership: true,
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCarts", mock.Anything, []int{1, 2}).Return([]models.Cart{
+					{Id: 1, UserId: "user-1", Empty: true},
+					{Id: 2, UserId: "user-2", Empty: true},
+				}, nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"carts":[{"id":1,"items":null,"total":0,"empty":true}],"missing":[2]}`,
+		},
+		{
+			name:  "Service error",
+			query: "?ids=1",
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCarts", mock.Anything, []int{1}).Return([]models.Cart(nil), serviceerrors.ErrUnavailable)
+			},
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+			handler.SetEnforceOwnership(tt.enforceOwnership)
+
+			req := httptest.NewRequest(http.MethodGet, "/carts"+tt.query, nil)
+			req.Header.Set(carthandler.UserIdHeader, "user-1")
+			ww := httptest.NewRecorder()
+
+			handler.ViewCarts(ww, req)
+
+			assert.Equal(t, tt.expectedCode, ww.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, ww.Body.String())
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+// catalog is a ProductValidator knowing the products mapped to true.
+type catalog map[string]bool
+
+func (c catalog) Exists(_ context.Context, product string) (bool, error) {
+	return c[product], nil
+}
+
+func TestHandler_AddToCart_ProductValidator(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	service := cartservice.New(log, storage, cartservice.WithProductValidator(catalog{"apple": true}))
+	handler := carthandler.New(log, service)
+	cart, err := storage.CreateCart(context.Background(), "")
+	require.NoError(t, err)
+	cartIdStr := fmt.Sprint(cart.Id)
+
+	t.Run("Known product", func(t *testing.T) {
+		ww := httptest.NewRecorder()
+		handler.AddToCart(ww, httptest.NewRequest(http.MethodPost, "/carts/"+cartIdStr+"/items", strings.NewReader(`{"product":"apple","quantity":1}`)), cartIdStr)
+		assert.Equal(t, http.StatusCreated, ww.Code)
+	})
+
+	t.Run("Unknown product", func(t *testing.T) {
+		ww := httptest.NewRecorder()
+		handler.AddToCart(ww, httptest.NewRequest(http.MethodPost, "/carts/"+cartIdStr+"/items", strings.NewReader(`{"product":"plum","quantity":1}`)), cartIdStr)
+		assert.Equal(t, http.StatusUnprocessableEntity, ww.Code)
+		var resp apierror.Response
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+		assert.Equal(t, apierror.UnknownProduct, resp.Error.Code)
+	})
+}
+
+func TestHandler_ErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   apierror.Code
+	}{
+		{name: "Cart not found", err: serviceerrors.ErrCartNotFound, expectedStatus: http.StatusNotFound, expectedCode: apierror.CartNotFound},
+		{name: "Generic not found", err: serviceerrors.ErrNotFound, expectedStatus: http.StatusNotFound, expectedCode: apierror.CartNotFound},
+		{name: "Item not found", err: serviceerrors.ErrItemNotFound, expectedStatus: http.StatusNotFound, expectedCode: apierror.ItemNotFound},
+		{name: "Conflict", err: serviceerrors.ErrConflict, expectedStatus: http.StatusConflict, expectedCode: apierror.Conflict},
+		{name: "Cart full", err: serviceerrors.ErrCartFull, expectedStatus: http.StatusConflict, expectedCode: apierror.CartFull},
+		{name: "Unknown product", err: serviceerrors.ErrUnknownProduct, expectedStatus: http.StatusUnprocessableEntity, expectedCode: apierror.UnknownProduct},
+		{name: "Deadline exceeded", err: serviceerrors.ErrDeadlineExceeded, expectedStatus: http.StatusGatewayTimeout, expectedCode: apierror.Timeout},
+		{name: "Context canceled", err: serviceerrors.ErrContextCanceled, expectedStatus: carthandler.StatusClientClosedRequest, expectedCode: apierror.Canceled},
+		{name: "Storage unavailable", err: serviceerrors.ErrUnavailable, expectedStatus: http.StatusServiceUnavailable, expectedCode: apierror.Unavailable},
+		{name: "Quantity overflow", err: serviceerrors.ErrQuantityOverflow, expectedStatus: http.StatusBadRequest, expectedCode: apierror.ValidationFailed},
+		{name: "Empty product", err: serviceerrors.ErrEmptyProduct, expectedStatus: http.StatusBadRequest, expectedCode: apierror.ValidationFailed},
+		{name: "Unknown error", err: errors.New("service error"), expectedStatus: http.StatusInternalServerError, expectedCode: apierror.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			mockService.On("RemoveFromCart", mock.Anything, 1, 2).Return(fmt.Errorf("wrapped: %w", tt.err))
+			handler := newTestHandler(mockService)
+
+			ww := httptest.NewRecorder()
+			handler.RemoveFromCart(ww, httptest.NewRequest(http.MethodDelete, "/carts/1/items/2", nil), "1", "2")
+
+			assert.Equal(t, tt.expectedStatus, ww.Code)
+			assert.Equal(t, "application/json", ww.Header().Get("Content-Type"))
+
+			var resp apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+			assert.Equal(t, tt.expectedCode, resp.Error.Code)
+			assert.NotEmpty(t, resp.Error.Message)
+			if tt.expectedStatus == http.StatusServiceUnavailable {
+				assert.Equal(t, "5", ww.Header().Get("Retry-After"))
+			} else {
+				assert.Empty(t, ww.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
 func TestHandler_ViewCart(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -247,7 +606,7 @@ func TestHandler_ViewCart(t *testing.T) {
 			name:   "Success",
 			cartId: "1",
 			setupMock: func(s *mocks.Service) {
-				s.On("ViewCart", mock.Anything, 1).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
 			},
 			expectedCode: http.StatusOK,
 			checkBody:    true,
@@ -262,7 +621,7 @@ func TestHandler_ViewCart(t *testing.T) {
 			name:   "Not found error",
 			cartId: "1",
 			setupMock: func(s *mocks.Service) {
-				s.On("ViewCart", mock.Anything, 1).Return(models.Cart{}, serviceerrors.ErrNotFound)
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{}, serviceerrors.ErrNotFound)
 			},
 			expectedCode: http.StatusNotFound,
 		},
@@ -270,7 +629,7 @@ func TestHandler_ViewCart(t *testing.T) {
 			name:   "Service error",
 			cartId: "1",
 			setupMock: func(s *mocks.Service) {
-				s.On("ViewCart", mock.Anything, 1).Return(models.Cart{}, errors.New("service error"))
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{}, errors.New("service error"))
 			},
 			expectedCode: http.StatusInternalServerError,
 		},
@@ -301,3 +660,1739 @@ func TestHandler_ViewCart(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_MoveItem(t *testing.T) {
+	tests := []struct {
+		name         string
+		cartId       string
+		itemId       string
+		body         []byte
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		checkBody    bool
+	}{
+		{
+			name:   "Success",
+			cartId: "1",
+			itemId: "5",
+			body:   []byte(`{"target_cart_id":2}`),
+			setupMock: func(s *mocks.Service) {
+				s.On("MoveItem", mock.Anything, 1, 5, 2).Return(models.CartItem{Id: 5, CartId: 2, Product: "item", Quantity: 1}, nil)
+			},
+			expectedCode: http.StatusOK,
+			checkBody:    true,
+		},
+		{
+			name:         "Missing target cart id",
+			cartId:       "1",
+			itemId:       "5",
+			body:         []byte(`{}`),
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:   "Target cart not found",
+			cartId: "1",
+			itemId: "5",
+			body:   []byte(`{"target_cart_id":2}`),
+			setupMock: func(s *mocks.Service) {
+				s.On("MoveItem", mock.Anything, 1, 5, 2).Return(models.CartItem{}, serviceerrors.ErrNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/"+tt.cartId+"/items/"+tt.itemId+"/move", bytes.NewBuffer(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.MoveItem(ww, req, tt.cartId, tt.itemId)
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if tt.checkBody {
+				var got models.CartItem
+				err := json.NewDecoder(resp.Body).Decode(&got)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, got.CartId)
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_CopyCart(t *testing.T) {
+	tests := []struct {
+		name         string
+		cartId       string
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		checkBody    bool
+	}{
+		{
+			name:   "Success",
+			cartId: "1",
+			setupMock: func(s *mocks.Service) {
+				s.On("CopyCart", mock.Anything, 1, "").Return(models.Cart{
+					Id:    2,
+					Items: []models.CartItem{{Id: 21, CartId: 2, Product: "item", Quantity: 1}},
+				}, nil)
+			},
+			expectedCode: http.StatusCreated,
+			checkBody:    true,
+		},
+		{
+			name:         "Invalid cartId",
+			cartId:       "abc",
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:   "Source cart not found",
+			cartId: "1",
+			setupMock: func(s *mocks.Service) {
+				s.On("CopyCart", mock.Anything, 1, "").Return(models.Cart{}, serviceerrors.ErrNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/"+tt.cartId+"/copy", nil)
+			ww := httptest.NewRecorder()
+
+			handler.CopyCart(ww, req, tt.cartId)
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if tt.checkBody {
+				var got models.Cart
+				err := json.NewDecoder(resp.Body).Decode(&got)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, got.Id)
+				assert.Len(t, got.Items, 1)
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_AddToCart_ContentTypes(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+	}{
+		{
+			name:        "JSON",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"product":"item","quantity":5}`,
+			setupMock: func(s *mocks.Service) {
+				item := models.CartItem{Product: "item", Quantity: 5, Measure: models.MeasureCount}
+				s.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{Id: 1, CartId: 1, Product: "item", Quantity: 5}, nil)
+			},
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:        "No content type defaults to JSON",
+			contentType: "",
+			body:        `{"product":"item","quantity":5}`,
+			setupMock: func(s *mocks.Service) {
+				item := models.CartItem{Product: "item", Quantity: 5, Measure: models.MeasureCount}
+				s.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{Id: 1, CartId: 1, Product: "item", Quantity: 5}, nil)
+			},
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:        "Form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "product=item&quantity=5",
+			setupMock: func(s *mocks.Service) {
+				item := models.CartItem{Product: "item", Quantity: 5, Measure: models.MeasureCount}
+				s.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{Id: 1, CartId: 1, Product: "item", Quantity: 5}, nil)
+			},
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "Form with invalid quantity",
+			contentType:  "application/x-www-form-urlencoded",
+			body:         "product=item&quantity=five",
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Unsupported content type",
+			contentType:  "text/plain",
+			body:         "product=item",
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/1/items", bytes.NewBufferString(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			ww := httptest.NewRecorder()
+
+			handler.AddToCart(ww, req, "1")
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_ViewCart_ETag(t *testing.T) {
+	cart := models.Cart{Id: 1, Items: []models.CartItem{{Id: 2, CartId: 1, Product: "item", Quantity: 3}}}
+
+	fetchETag := func(t *testing.T, cart models.Cart) string {
+		mockService := new(mocks.Service)
+		mockService.On("ViewCart", mock.Anything, 1, mock.Anything).Return(cart, nil)
+		handler := newTestHandler(mockService)
+
+		ww := httptest.NewRecorder()
+		handler.ViewCart(ww, httptest.NewRequest(http.MethodGet, "/carts/1", nil), "1")
+		etag := ww.Result().Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+		return etag
+	}
+
+	etag := fetchETag(t, cart)
+	assert.Equal(t, etag, fetchETag(t, cart), "etag must be stable for identical carts")
+
+	changed := models.Cart{Id: 1, Items: []models.CartItem{{Id: 2, CartId: 1, Product: "item", Quantity: 4}}}
+	assert.NotEqual(t, etag, fetchETag(t, changed), "etag must change when quantity changes")
+
+	tests := []struct {
+		name         string
+		ifNoneMatch  string
+		expectedCode int
+	}{
+		{
+			name:         "Matching If-None-Match",
+			ifNoneMatch:  etag,
+			expectedCode: http.StatusNotModified,
+		},
+		{
+			name:         "Non-matching If-None-Match",
+			ifNoneMatch:  `"stale"`,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			mockService.On("ViewCart", mock.Anything, 1, mock.Anything).Return(cart, nil)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			ww := httptest.NewRecorder()
+
+			handler.ViewCart(ww, req, "1")
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			assert.Equal(t, etag, resp.Header.Get("ETag"))
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_ViewCart_Empty(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1, Items: []models.CartItem{}, Empty: true}, nil)
+	handler := newTestHandler(mockService)
+
+	ww := httptest.NewRecorder()
+	handler.ViewCart(ww, httptest.NewRequest(http.MethodGet, "/carts/1", nil), "1")
+
+	assert.Equal(t, http.StatusOK, ww.Code)
+	assert.JSONEq(t, `{"id":1,"items":[],"total":0,"empty":true}`, ww.Body.String())
+}
+
+func TestHandler_ViewCart_Stream(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	for i := range 250 {
+		_, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: fmt.Sprintf("item-%d", i), Quantity: i + 1})
+		require.NoError(t, err)
+	}
+	channel := "web"
+	_, err = storage.PatchCartMetadata(ctx, cart.Id, map[string]*string{"channel": &channel})
+	require.NoError(t, err)
+
+	view := func(query string) (int, models.Cart) {
+		ww := httptest.NewRecorder()
+		handler.ViewCart(ww, httptest.NewRequest(http.MethodGet, "/carts/1?"+query, nil), "1")
+		var got models.Cart
+		if ww.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(ww.Body.Bytes(), &got))
+		}
+		return ww.Code, got
+	}
+
+	// One buffered page can't hold the 250 items; two of them can.
+	_, first := view("limit=200")
+	_, second := view("limit=200&offset=200")
+	buffered := first
+	buffered.Items = append(buffered.Items, second.Items...)
+
+	status, streamed := view("stream=true")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, buffered, streamed)
+	assert.Len(t, streamed.Items, 250)
+
+	status, filtered := view("stream=true&product=item-24")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 11, filtered.Total)
+
+	status, empty := view("stream=true&product=missing")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.Cart{Id: cart.Id, Items: []models.CartItem{}, Empty: true, Metadata: map[string]string{"channel": "web"}}, empty)
+
+	ww := httptest.NewRecorder()
+	handler.ViewCart(ww, httptest.NewRequest(http.MethodGet, "/carts/2?stream=true", nil), "2")
+	assert.Equal(t, http.StatusNotFound, ww.Code)
+	assert.Contains(t, ww.Body.String(), `"code":"CART_NOT_FOUND"`)
+
+	status, _ = view("stream=maybe")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestHandler_ViewCart_Versions(t *testing.T) {
+	cart := models.Cart{
+		Id: 1,
+		Items: []models.CartItem{
+			{Id: 2, CartId: 1, Product: "milk", Quantity: 3, Measure: models.MeasureCount},
+			{Id: 3, CartId: 1, Product: "apples", Quantity: 1, Measure: models.MeasureWeight, Weight: 0.5, Unit: "kg"},
+		},
+		Total: 2,
+	}
+	items := `[{"id":2,"cart_id":1,"product":"milk","quantity":3,"measure":"count"},` +
+		`{"id":3,"cart_id":1,"product":"apples","quantity":1,"measure":"weight","weight":0.5,"unit":"kg"}]`
+
+	tests := []struct {
+		name                string
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "Default is v1",
+			expectedContentType: "application/json",
+			expectedBody:        `{"id":1,"items":` + items + `,"total":2}`,
+		},
+		{
+			name:                "Plain JSON is v1",
+			accept:              "application/json",
+			expectedContentType: "application/json",
+			expectedBody:        `{"id":1,"items":` + items + `,"total":2}`,
+		},
+		{
+			name:                "v2",
+			accept:              "application/vnd.cartapi.v2+json",
+			expectedContentType: "application/vnd.cartapi.v2+json",
+			expectedBody:        `{"id":1,"items":` + items + `,"totals":{"items":2,"quantity":3,"weight":0.5},"empty":false,"metadata":{}}`,
+		},
+		{
+			name:                "v2 among other media types",
+			accept:              "text/html, application/vnd.cartapi.v2+json;q=0.9",
+			expectedContentType: "application/vnd.cartapi.v2+json",
+			expectedBody:        `{"id":1,"items":` + items + `,"totals":{"items":2,"quantity":3,"weight":0.5},"empty":false,"metadata":{}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			mockService.On("ViewCart", mock.Anything, 1, mock.Anything).Return(cart, nil)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			ww := httptest.NewRecorder()
+
+			handler.ViewCart(ww, req, "1")
+
+			assert.Equal(t, http.StatusOK, ww.Code)
+			assert.Equal(t, tt.expectedContentType, ww.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", ww.Header().Get("Vary"))
+			assert.JSONEq(t, tt.expectedBody, ww.Body.String())
+		})
+	}
+}
+
+func TestHandler_ViewCart_VersionedETag(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
+	handler := newTestHandler(mockService)
+
+	v1 := httptest.NewRecorder()
+	handler.ViewCart(v1, httptest.NewRequest(http.MethodGet, "/carts/1", nil), "1")
+
+	req := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+	req.Header.Set("Accept", "application/vnd.cartapi.v2+json")
+	req.Header.Set("If-None-Match", v1.Header().Get("ETag"))
+	v2 := httptest.NewRecorder()
+	handler.ViewCart(v2, req, "1")
+
+	assert.Equal(t, http.StatusOK, v2.Code)
+	assert.NotEqual(t, v1.Header().Get("ETag"), v2.Header().Get("ETag"))
+}
+
+func TestHandler_RemoveItems(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         []byte
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		wantDeleted  int
+	}{
+		{
+			name: "Mixed valid and invalid ids",
+			body: []byte(`{"item_ids":[11,12,99]}`),
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveItems", mock.Anything, 1, []int{11, 12, 99}).Return([]int{11, 12}, nil)
+			},
+			expectedCode: http.StatusOK,
+			wantDeleted:  2,
+		},
+		{
+			name:         "Empty item_ids",
+			body:         []byte(`{"item_ids":[]}`),
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Non-positive id",
+			body:         []byte(`{"item_ids":[1,0]}`),
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "Cart not found",
+			body: []byte(`{"item_ids":[1]}`),
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveItems", mock.Anything, 1, []int{1}).Return([]int(nil), serviceerrors.ErrNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/1/items/delete", bytes.NewBuffer(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.RemoveItems(ww, req, "1")
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if resp.StatusCode == http.StatusOK {
+				var got struct {
+					Deleted int   `json:"deleted"`
+					ItemIds []int `json:"item_ids"`
+				}
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+				assert.Equal(t, tt.wantDeleted, got.Deleted)
+				assert.Len(t, got.ItemIds, tt.wantDeleted)
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_ViewCart_Pagination(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		wantOpts     models.ViewCartOptions
+		expectedCode int
+	}{
+		{
+			name:         "Defaults",
+			query:        "",
+			wantOpts:     models.ViewCartOptions{Limit: 50},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Limit and offset",
+			query:        "?limit=10&offset=20",
+			wantOpts:     models.ViewCartOptions{Limit: 10, Offset: 20},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Limit capped at max",
+			query:        "?limit=1000",
+			wantOpts:     models.ViewCartOptions{Limit: 200},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Invalid limit",
+			query:        "?limit=abc",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			if tt.expectedCode == http.StatusOK {
+				mockService.On("ViewCart", mock.Anything, 1, tt.wantOpts).Return(models.Cart{Id: 1, Total: 3}, nil)
+			}
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodGet, "/carts/1"+tt.query, nil)
+			ww := httptest.NewRecorder()
+
+			handler.ViewCart(ww, req, "1")
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if resp.StatusCode == http.StatusOK {
+				var got models.Cart
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+				assert.Equal(t, 3, got.Total)
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_AddItems(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         []byte
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		wantAdded    int
+		wantErrors   []int
+	}{
+		{
+			name: "All valid",
+			body: []byte(`{"items":[{"product":"a","quantity":1},{"product":"b","quantity":2}]}`),
+			setupMock: func(s *mocks.Service) {
+				s.On("AddItems", mock.Anything, 1, []models.CartItem{{Product: "a", Quantity: 1, Measure: models.MeasureCount}, {Product: "b", Quantity: 2, Measure: models.MeasureCount}}).
+					Return([]models.CartItem{{Id: 1, CartId: 1, Product: "a", Quantity: 1}, {Id: 2, CartId: 1, Product: "b", Quantity: 2}}, nil)
+			},
+			expectedCode: http.StatusCreated,
+			wantAdded:    2,
+			wantErrors:   []int{},
+		},
+		{
+			name: "Mixed valid and invalid",
+			body: []byte(`{"items":[{"product":"a","quantity":1},{"product":"","quantity":1},{"product":"c","quantity":0}]}`),
+			setupMock: func(s *mocks.Service) {
+				s.On("AddItems", mock.Anything, 1, []models.CartItem{{Product: "a", Quantity: 1, Measure: models.MeasureCount}}).
+					Return([]models.CartItem{{Id: 1, CartId: 1, Product: "a", Quantity: 1}}, nil)
+			},
+			expectedCode: http.StatusMultiStatus,
+			wantAdded:    1,
+			wantErrors:   []int{1, 2},
+		},
+		{
+			name:         "All invalid",
+			body:         []byte(`{"items":[{"product":"","quantity":1}]}`),
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+			wantAdded:    0,
+			wantErrors:   []int{0},
+		},
+		{
+			name: "Cart not found",
+			body: []byte(`{"items":[{"product":"a","quantity":1}]}`),
+			setupMock: func(s *mocks.Service) {
+				s.On("AddItems", mock.Anything, 1, mock.Anything).Return([]models.CartItem(nil), serviceerrors.ErrNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/1/items/batch", bytes.NewBuffer(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.AddItems(ww, req, "1")
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if tt.wantErrors != nil {
+				var got struct {
+					Added  []models.CartItem `json:"added"`
+					Errors []struct {
+						Index   int    `json:"index"`
+						Message string `json:"message"`
+					} `json:"errors"`
+				}
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+				assert.Len(t, got.Added, tt.wantAdded)
+				gotIndexes := []int{}
+				for _, e := range got.Errors {
+					gotIndexes = append(gotIndexes, e.Index)
+					assert.NotEmpty(t, e.Message)
+				}
+				assert.Equal(t, tt.wantErrors, gotIndexes)
+			}
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_AddToCart_Validation(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		expectedMsg string
+	}{
+		{name: "required product", body: `{"quantity":1}`, expectedMsg: "Product field is required"},
+		{name: "empty product", body: `{"product":"","quantity":1}`, expectedMsg: "Product field is required"},
+		{name: "gte quantity zero", body: `{"product":"item","quantity":0}`, expectedMsg: "Quantity must be greater than zero"},
+		{name: "gte quantity negative", body: `{"product":"item","quantity":-3}`, expectedMsg: "Quantity must be greater than zero"},
+		{name: "oneof measure", body: `{"product":"item","quantity":1,"measure":"volume"}`, expectedMsg: "Measure must be one of: count weight"},
+		{name: "weight required for weighted items", body: `{"product":"apples","measure":"weight"}`, expectedMsg: "Weight must be greater than zero for weighted items"},
+		{name: "gte weight negative", body: `{"product":"apples","measure":"weight","weight":-0.5}`, expectedMsg: "Weight must not be negative"},
+		{name: "max unit", body: `{"product":"apples","measure":"weight","weight":1,"unit":"a-very-long-unit-name"}`, expectedMsg: "Unit must be at most 16 characters"},
+		{name: "NUL byte in product", body: `{"product":"app\u0000les","quantity":1}`, expectedMsg: "Product must not contain control characters"},
+		{name: "control character in product", body: `{"product":"apples\n","quantity":1}`, expectedMsg: "Product must not contain control characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/1/items", strings.NewReader(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.AddToCart(ww, req, "1")
+
+			assert.Equal(t, http.StatusBadRequest, ww.Code)
+			var resp apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+			assert.Equal(t, apierror.ValidationFailed, resp.Error.Code)
+			assert.Equal(t, tt.expectedMsg, resp.Error.Message)
+			mockService.AssertNotCalled(t, "AddToCart", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestHandler_AddToCart_Quantity(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedMsg  string
+	}{
+		{name: "integer", body: `{"product":"item","quantity":2}`, expectedCode: http.StatusCreated},
+		{name: "float", body: `{"product":"item","quantity":2.5}`, expectedCode: http.StatusBadRequest, expectedMsg: "Quantity must be an integer"},
+		{name: "integral float", body: `{"product":"item","quantity":2.0}`, expectedCode: http.StatusBadRequest, expectedMsg: "Quantity must be an integer"},
+		{name: "string", body: `{"product":"item","quantity":"2"}`, expectedCode: http.StatusBadRequest, expectedMsg: "Quantity must be an integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			item := models.CartItem{Product: "item", Quantity: 2, Measure: models.MeasureCount}
+			mockService.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{Id: 1, CartId: 1, Product: "item", Quantity: 2}, nil)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/1/items", strings.NewReader(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.AddToCart(ww, req, "1")
+
+			require.Equal(t, tt.expectedCode, ww.Code)
+			if tt.expectedCode != http.StatusBadRequest {
+				mockService.AssertExpectations(t)
+				return
+			}
+			var resp apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+			assert.Equal(t, apierror.ValidationFailed, resp.Error.Code)
+			assert.Equal(t, tt.expectedMsg, resp.Error.Message)
+			mockService.AssertNotCalled(t, "AddToCart", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestHandler_AddToCart_AllValidationErrors(t *testing.T) {
+	mockService := new(mocks.Service)
+	handler := newTestHandler(mockService)
+
+	req := httptest.NewRequest(http.MethodPost, "/carts/1/items", strings.NewReader(`{"product":"","quantity":0,"measure":"volume"}`))
+	ww := httptest.NewRecorder()
+
+	handler.AddToCart(ww, req, "1")
+
+	assert.Equal(t, http.StatusBadRequest, ww.Code)
+	var resp apierror.Response
+	require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+	assert.Equal(t, apierror.ValidationFailed, resp.Error.Code)
+	assert.Equal(t, map[string]string{
+		"product":  "Product field is required",
+		"quantity": "Quantity must be greater than zero",
+		"measure":  "Measure must be one of: count weight",
+	}, resp.Error.Fields)
+	mockService.AssertNotCalled(t, "AddToCart", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandler_AddToCart_NilBody(t *testing.T) {
+	mockService := new(mocks.Service)
+	handler := newTestHandler(mockService)
+
+	req := httptest.NewRequest(http.MethodPost, "/carts/1/items", nil)
+	req.Body = nil
+	ww := httptest.NewRecorder()
+
+	assert.NotPanics(t, func() { handler.AddToCart(ww, req, "1") })
+	assert.Equal(t, http.StatusBadRequest, ww.Code)
+	mockService.AssertNotCalled(t, "AddToCart", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandler_AddToCart_EmptyBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        io.Reader
+		wantMessage string
+		wantFields  map[string]string
+	}{
+		{name: "Nil body", body: nil, wantMessage: "Request body required"},
+		{name: "Empty string", body: strings.NewReader(""), wantMessage: "Request body required"},
+		{
+			name:        "Empty object",
+			body:        strings.NewReader("{}"),
+			wantMessage: "Product field is required",
+			wantFields: map[string]string{
+				"product":  "Product field is required",
+				"quantity": "Quantity must be greater than zero",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/1/items", tt.body)
+			if tt.body == nil {
+				req.Body = nil
+			}
+			ww := httptest.NewRecorder()
+
+			handler.AddToCart(ww, req, "1")
+
+			assert.Equal(t, http.StatusBadRequest, ww.Code)
+			var resp apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+			assert.Equal(t, apierror.ValidationFailed, resp.Error.Code)
+			assert.Equal(t, tt.wantMessage, resp.Error.Message)
+			assert.Equal(t, tt.wantFields, resp.Error.Fields)
+			mockService.AssertNotCalled(t, "AddToCart", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestHandler_AddToCart_DefaultQuantity(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultQuantity int
+		body            string
+		expectedCode    int
+		wantQuantity    int
+	}{
+		{name: "Omitted quantity is required", body: `{"product":"apple"}`, expectedCode: http.StatusBadRequest},
+		{name: "Omitted quantity gets the default", defaultQuantity: 1, body: `{"product":"apple"}`, expectedCode: http.StatusCreated, wantQuantity: 1},
+		{name: "Zero quantity gets the default", defaultQuantity: 2, body: `{"product":"apple","quantity":0}`, expectedCode: http.StatusCreated, wantQuantity: 2},
+		{name: "Given quantity is kept", defaultQuantity: 1, body: `{"product":"apple","quantity":3}`, expectedCode: http.StatusCreated, wantQuantity: 3},
+		{name: "Negative quantity is still rejected", defaultQuantity: 1, body: `{"product":"apple","quantity":-1}`, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			if tt.expectedCode == http.StatusCreated {
+				item := models.CartItem{Product: "apple", Quantity: tt.wantQuantity, Measure: models.MeasureCount}
+				mockService.On("AddToCart", mock.Anything, 1, item).Return(models.CartItem{Id: 1, CartId: 1, Product: "apple", Quantity: tt.wantQuantity}, nil)
+			}
+			handler := newTestHandler(mockService)
+			handler.SetDefaultQuantity(tt.defaultQuantity)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/1/items", strings.NewReader(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.AddToCart(ww, req, "1")
+
+			assert.Equal(t, tt.expectedCode, ww.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_CreateCart_Owner(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("CreateCart", mock.Anything, "user-1").Return(models.Cart{Id: 1, UserId: "user-1"}, nil)
+	handler := newTestHandler(mockService)
+
+	req := httptest.NewRequest(http.MethodPost, "/carts", nil)
+	req.Header.Set(carthandler.UserIdHeader, "user-1")
+	ww := httptest.NewRecorder()
+
+	handler.CreateCart(ww, req)
+
+	assert.Equal(t, http.StatusCreated, ww.Code)
+	assert.JSONEq(t, `{"id":1,"items":null,"total":0,"user_id":"user-1"}`, ww.Body.String())
+	mockService.AssertExpectations(t)
+}
+
+func TestHandler_Ownership(t *testing.T) {
+	requests := []struct {
+		name      string
+		setupMock func(s *mocks.Service)
+		serve     func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request)
+		method    string
+		body      string
+		okStatus  int
+	}{
+		{
+			name: "View",
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.ViewCart(w, r, "1") },
+			method:   http.MethodGet,
+			okStatus: http.StatusOK,
+		},
+		{
+			name: "Add",
+			setupMock: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 1, mock.Anything).Return(models.CartItem{Id: 1, CartId: 1, Product: "apple", Quantity: 1}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.AddToCart(w, r, "1") },
+			method:   http.MethodPost,
+			body:     `{"product":"apple","quantity":1}`,
+			okStatus: http.StatusCreated,
+		},
+		{
+			name: "Remove",
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 2).Return(nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.RemoveFromCart(w, r, "1", "2") },
+			method:   http.MethodDelete,
+			okStatus: http.StatusNoContent,
+		},
+		{
+			name: "Move",
+			setupMock: func(s *mocks.Service) {
+				s.On("MoveItem", mock.Anything, 1, 2, 3).Return(models.CartItem{Id: 2, CartId: 3}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.MoveItem(w, r, "1", "2") },
+			method:   http.MethodPost,
+			body:     `{"target_cart_id":3}`,
+			okStatus: http.StatusOK,
+		},
+		{
+			name: "Restore",
+			setupMock: func(s *mocks.Service) {
+				s.On("RestoreItem", mock.Anything, 1, 2).Return(models.CartItem{Id: 2, CartId: 1}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.RestoreItem(w, r, "1", "2") },
+			method:   http.MethodPost,
+			okStatus: http.StatusOK,
+		},
+		{
+			name: "Copy",
+			setupMock: func(s *mocks.Service) {
+				s.On("CopyCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 2}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.CopyCart(w, r, "1") },
+			method:   http.MethodPost,
+			okStatus: http.StatusCreated,
+		},
+		{
+			name: "Remove items",
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveItems", mock.Anything, 1, []int{2}).Return([]int{2}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.RemoveItems(w, r, "1") },
+			method:   http.MethodPost,
+			body:     `{"item_ids":[2]}`,
+			okStatus: http.StatusOK,
+		},
+		{
+			name: "Add items",
+			setupMock: func(s *mocks.Service) {
+				s.On("AddItems", mock.Anything, 1, mock.Anything).Return([]models.CartItem{{Id: 1, CartId: 1, Product: "apple", Quantity: 1}}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.AddItems(w, r, "1") },
+			method:   http.MethodPost,
+			body:     `{"items":[{"product":"apple","quantity":1}]}`,
+			okStatus: http.StatusCreated,
+		},
+		{
+			name: "Replace items",
+			setupMock: func(s *mocks.Service) {
+				s.On("ReplaceItems", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.ReplaceItems(w, r, "1") },
+			method:   http.MethodPut,
+			body:     `{"items":[]}`,
+			okStatus: http.StatusOK,
+		},
+		{
+			name: "Update quantities",
+			setupMock: func(s *mocks.Service) {
+				s.On("UpdateQuantities", mock.Anything, 1, mock.Anything).Return([]models.CartItem{{Id: 2, CartId: 1, Quantity: 3}}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.UpdateQuantities(w, r, "1") },
+			method:   http.MethodPatch,
+			body:     `{"updates":[{"id":2,"quantity":3}]}`,
+			okStatus: http.StatusOK,
+		},
+		{
+			name: "Patch cart",
+			setupMock: func(s *mocks.Service) {
+				s.On("PatchCartMetadata", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1}, nil)
+			},
+			serve:    func(h *carthandler.Handler, w http.ResponseWriter, r *http.Request) { h.PatchCart(w, r, "1") },
+			method:   http.MethodPatch,
+			body:     `{"metadata":{"channel":"web"}}`,
+			okStatus: http.StatusOK,
+		},
+	}
+
+	for _, rt := range requests {
+		t.Run(rt.name+"/Matching owner", func(t *testing.T) {
+			mockService := new(mocks.Service)
+			mockService.On("AuthorizeCart", mock.Anything, mock.Anything, "user-1").Return(nil)
+			rt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+			handler.SetEnforceOwnership(true)
+
+			req := httptest.NewRequest(rt.method, "/carts/1", strings.NewReader(rt.body))
+			req.Header.Set(carthandler.UserIdHeader, "user-1")
+			ww := httptest.NewRecorder()
+
+			rt.serve(handler, ww, req)
+
+			assert.Equal(t, rt.okStatus, ww.Code)
+			mockService.AssertExpectations(t)
+		})
+
+		t.Run(rt.name+"/Mismatched owner", func(t *testing.T) {
+			mockService := new(mocks.Service)
+			mockService.On("AuthorizeCart", mock.Anything, 1, "user-2").Return(serviceerrors.ErrForbidden)
+			handler := newTestHandler(mockService)
+			handler.SetEnforceOwnership(true)
+
+			req := httptest.NewRequest(rt.method, "/carts/1", strings.NewReader(rt.body))
+			req.Header.Set(carthandler.UserIdHeader, "user-2")
+			ww := httptest.NewRecorder()
+
+			rt.serve(handler, ww, req)
+
+			assert.Equal(t, http.StatusForbidden, ww.Code)
+			assert.JSONEq(t, `{"error":{"code":"FORBIDDEN","message":"Cart belongs to another user"}}`, ww.Body.String())
+			mockService.AssertExpectations(t)
+		})
+
+		t.Run(rt.name+"/Not enforced", func(t *testing.T) {
+			mockService := new(mocks.Service)
+			rt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(rt.method, "/carts/1", strings.NewReader(rt.body))
+			req.Header.Set(carthandler.UserIdHeader, "user-2")
+			ww := httptest.NewRecorder()
+
+			rt.serve(handler, ww, req)
+
+			assert.Equal(t, rt.okStatus, ww.Code)
+			mockService.AssertNotCalled(t, "AuthorizeCart", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestHandler_MoveItem_TargetOwnership(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("AuthorizeCart", mock.Anything, 1, "user-2").Return(nil)
+	mockService.On("AuthorizeCart", mock.Anything, 3, "user-2").Return(serviceerrors.ErrForbidden)
+	handler := newTestHandler(mockService)
+	handler.SetEnforceOwnership(true)
+
+	req := httptest.NewRequest(http.MethodPost, "/carts/1/items/2/move", strings.NewReader(`{"target_cart_id":3}`))
+	req.Header.Set(carthandler.UserIdHeader, "user-2")
+	ww := httptest.NewRecorder()
+
+	handler.MoveItem(ww, req, "1", "2")
+
+	assert.Equal(t, http.StatusForbidden, ww.Code)
+	mockService.AssertExpectations(t)
+	mockService.AssertNotCalled(t, "MoveItem", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandler_UpdateQuantities_ForbiddenBeforeValidation(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("AuthorizeCart", mock.Anything, 1, "user-2").Return(serviceerrors.ErrForbidden)
+	handler := newTestHandler(mockService)
+	handler.SetEnforceOwnership(true)
+
+	req := httptest.NewRequest(http.MethodPatch, "/carts/1/items", strings.NewReader(`{"updates":[]}`))
+	req.Header.Set(carthandler.UserIdHeader, "user-2")
+	ww := httptest.NewRecorder()
+
+	handler.UpdateQuantities(ww, req, "1")
+
+	assert.Equal(t, http.StatusForbidden, ww.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestHandler_AddToCart_WeightedItem(t *testing.T) {
+	mockService := new(mocks.Service)
+	item := models.CartItem{Product: "apples", Quantity: 1, Measure: models.MeasureWeight, Weight: 0.75, Unit: "kg"}
+	stored := item
+	stored.Id, stored.CartId = 1, 1
+	mockService.On("AddToCart", mock.Anything, 1, item).Return(stored, nil)
+	handler := newTestHandler(mockService)
+
+	req := httptest.NewRequest(http.MethodPost, "/carts/1/items", strings.NewReader(`{"product":"apples","measure":"weight","weight":0.75}`))
+	ww := httptest.NewRecorder()
+
+	handler.AddToCart(ww, req, "1")
+
+	assert.Equal(t, http.StatusCreated, ww.Code)
+	var got models.CartItem
+	assert.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+	assert.Equal(t, stored, got)
+	mockService.AssertExpectations(t)
+}
+
+func TestHandler_PatchCart(t *testing.T) {
+	channel := "web"
+
+	tests := []struct {
+		name         string
+		cartId       string
+		body         string
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:   "Success",
+			cartId: "1",
+			body:   `{"metadata":{"channel":"web","coupon":null}}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("PatchCartMetadata", mock.Anything, 1, map[string]*string{"channel": &channel, "coupon": nil}).
+					Return(models.Cart{Id: 1, Metadata: map[string]string{"channel": "web"}}, nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":1,"metadata":{"channel":"web"}}`,
+		},
+		{
+			name:         "Invalid cartId",
+			cartId:       "abc",
+			body:         `{"metadata":{"channel":"web"}}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Missing metadata",
+			cartId:       "1",
+			body:         `{}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Key too long",
+			cartId:       "1",
+			body:         `{"metadata":{"` + strings.Repeat("k", 65) + `":"v"}}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Value too long",
+			cartId:       "1",
+			body:         `{"metadata":{"k":"` + strings.Repeat("v", 513) + `"}}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:   "Cart not found",
+			cartId: "1",
+			body:   `{"metadata":{"channel":"web"}}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("PatchCartMetadata", mock.Anything, 1, map[string]*string{"channel": &channel}).
+					Return(models.Cart{}, serviceerrors.ErrNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPatch, "/carts/"+tt.cartId, strings.NewReader(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.PatchCart(ww, req, tt.cartId)
+
+			assert.Equal(t, tt.expectedCode, ww.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, ww.Body.String())
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_RestoreItem(t *testing.T) {
+	tests := []struct {
+		name         string
+		cartId       string
+		itemId       string
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:   "Success",
+			cartId: "1",
+			itemId: "5",
+			setupMock: func(s *mocks.Service) {
+				s.On("RestoreItem", mock.Anything, 1, 5).
+					Return(models.CartItem{Id: 5, CartId: 1, Product: "apple", Quantity: 2, Measure: models.MeasureCount}, nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":5,"cart_id":1,"product":"apple","quantity":2,"measure":"count"}`,
+		},
+		{
+			name:         "Invalid itemId",
+			cartId:       "1",
+			itemId:       "abc",
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:   "No deleted item",
+			cartId: "1",
+			itemId: "5",
+			setupMock: func(s *mocks.Service) {
+				s.On("RestoreItem", mock.Anything, 1, 5).Return(models.CartItem{}, serviceerrors.ErrNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/"+tt.cartId+"/items/"+tt.itemId+"/restore", nil)
+			ww := httptest.NewRecorder()
+
+			handler.RestoreItem(ww, req, tt.cartId, tt.itemId)
+
+			assert.Equal(t, tt.expectedCode, ww.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, ww.Body.String())
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_CreateCarts(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name: "Success",
+			body: `{"count":3}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("CreateCarts", mock.Anything, 3).Return([]int{1, 2, 3}, nil)
+			},
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"ids":[1,2,3]}`,
+		},
+		{
+			name:         "Zero count",
+			body:         `{"count":0}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Count above cap",
+			body:         `{"count":1001}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid body",
+			body:         `{"count":"many"}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "Service error",
+			body: `{"count":2}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("CreateCarts", mock.Anything, 2).Return([]int(nil), errors.New("db down"))
+			},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/carts/batch", strings.NewReader(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.CreateCarts(ww, req)
+
+			assert.Equal(t, tt.expectedCode, ww.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, ww.Body.String())
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandler_ReplaceItems(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name: "Success",
+			body: `{"items":[{"product":"milk","quantity":2}]}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("ReplaceItems", mock.Anything, 1, []models.CartItem{{Product: "milk", Quantity: 2, Measure: models.MeasureCount}}).
+					Return(models.Cart{Id: 1, Items: []models.CartItem{{Id: 7, CartId: 1, Product: "milk", Quantity: 2, Measure: models.MeasureCount}}, Total: 1}, nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":1,"items":[{"id":7,"cart_id":1,"product":"milk","quantity":2,"measure":"count"}],"total":1}`,
+		},
+		{
+			name: "Empty list clears the cart",
+			body: `{"items":[]}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("ReplaceItems", mock.Anything, 1, []models.CartItem{}).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":1,"items":[],"total":0}`,
+		},
+		{
+			name:         "Missing items",
+			body:         `{}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "One invalid item rejects the whole set",
+			body:         `{"items":[{"product":"milk","quantity":2},{"product":"","quantity":0}]}`,
+			setupMock:    func(s *mocks.Service) {},
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"error":{"code":"VALIDATION_FAILED","message":"Some items are invalid","fields":{` +
+				`"items[1].product":"Product field is required","items[1].quantity":"Quantity must be greater than zero"}}}`,
+		},
+		{
+			name: "Cart not found",
+			body: `{"items":[{"product":"milk","quantity":2}]}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("ReplaceItems", mock.Anything, 1, mock.Anything).Return(models.Cart{}, serviceerrors.ErrCartNotFound)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+			handler := newTestHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPut, "/carts/1/items", strings.NewReader(tt.body))
+			ww := httptest.NewRecorder()
+
+			handler.ReplaceItems(ww, req, "1")
+
+			assert.Equal(t, tt.expectedCode, ww.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, ww.Body.String())
+			}
+			mockService.AssertExpectations(t)
+			if tt.expectedCode == http.StatusBadRequest {
+				mockService.AssertNotCalled(t, "ReplaceItems", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestHandler_RemoveFromCart_IfMatch(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	cart, err := storage.CreateCart(context.Background(), "")
+	require.NoError(t, err)
+	add := func() (string, string) {
+		ww := httptest.NewRecorder()
+		handler.AddToCart(ww, httptest.NewRequest(http.MethodPost, "/carts/1/items", strings.NewReader(`{"product":"item","quantity":2}`)), "1")
+		require.Equal(t, http.StatusCreated, ww.Code)
+		var item models.CartItem
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&item))
+		return fmt.Sprint(item.Id), ww.Header().Get("ETag")
+	}
+	remove := func(itemId string, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/carts/1/items/"+itemId, nil)
+		req.Header.Set("If-Match", ifMatch)
+		ww := httptest.NewRecorder()
+		handler.RemoveFromCart(ww, req, "1", itemId)
+		return ww
+	}
+
+	t.Run("Mismatching If-Match", func(t *testing.T) {
+		itemId, etag := add()
+		require.NotEmpty(t, etag)
+
+		ww := remove(itemId, `"stale"`)
+		assert.Equal(t, http.StatusPreconditionFailed, ww.Code)
+		assert.Equal(t, etag, ww.Header().Get("ETag"))
+		var resp apierror.Response
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&resp))
+		assert.Equal(t, apierror.PreconditionFailed, resp.Error.Code)
+
+		view, err := storage.ViewCart(context.Background(), cart.Id, models.ViewCartOptions{})
+		require.NoError(t, err)
+		assert.Len(t, view.Items, 1)
+	})
+
+	t.Run("Matching If-Match", func(t *testing.T) {
+		itemId, etag := add()
+
+		assert.Equal(t, http.StatusNoContent, remove(itemId, etag).Code)
+		assert.Equal(t, http.StatusNotFound, remove(itemId, etag).Code)
+	})
+
+	t.Run("Wildcard If-Match", func(t *testing.T) {
+		itemId, _ := add()
+
+		assert.Equal(t, http.StatusNoContent, remove(itemId, "*").Code)
+	})
+}
+
+func TestHandler_ExportCart(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	apples, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 3})
+	require.NoError(t, err)
+	pears, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: `pears, "green"`, Quantity: 1})
+	require.NoError(t, err)
+
+	export := func(cartId string, query string) *httptest.ResponseRecorder {
+		ww := httptest.NewRecorder()
+		handler.ExportCart(ww, httptest.NewRequest(http.MethodGet, "/carts/"+cartId+"/export?"+query, nil), cartId)
+		return ww
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		ww := export("1", "format=csv")
+		require.Equal(t, http.StatusOK, ww.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", ww.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="cart-1.csv"`, ww.Header().Get("Content-Disposition"))
+
+		rows, err := csv.NewReader(ww.Body).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"id", "product", "quantity"},
+			{fmt.Sprint(apples.Id), "apples", "3"},
+			{fmt.Sprint(pears.Id), `pears, "green"`, "1"},
+		}, rows)
+	})
+
+	t.Run("JSON by default", func(t *testing.T) {
+		ww := export("1", "")
+		require.Equal(t, http.StatusOK, ww.Code)
+		assert.Equal(t, "application/json", ww.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="cart-1.json"`, ww.Header().Get("Content-Disposition"))
+
+		var got models.Cart
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+		assert.Equal(t, []models.CartItem{apples, pears}, got.Items)
+	})
+
+	t.Run("Missing cart", func(t *testing.T) {
+		ww := export("999", "format=csv")
+		assert.Equal(t, http.StatusNotFound, ww.Code)
+		assert.Empty(t, ww.Header().Get("Content-Disposition"))
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, export("1", "format=xml").Code)
+	})
+}
+
+func TestHandler_ImportCart(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+
+	importCSV := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/carts/1/import", strings.NewReader(body))
+		r.Header.Set("Content-Type", "text/csv")
+		ww := httptest.NewRecorder()
+		handler.ImportCart(ww, r, fmt.Sprint(cart.Id))
+		return ww
+	}
+	type response struct {
+		Imported int `json:"imported"`
+		Errors   []struct {
+			Line    int    `json:"line"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	t.Run("Clean import", func(t *testing.T) {
+		ww := importCSV("id,product,quantity\n7,apples,3\n8,\"pears, green\",1\n")
+		require.Equal(t, http.StatusCreated, ww.Code)
+
+		var got response
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+		assert.Equal(t, 2, got.Imported)
+		assert.Empty(t, got.Errors)
+
+		viewed, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{})
+		require.NoError(t, err)
+		require.Len(t, viewed.Items, 2)
+		assert.Equal(t, "pears, green", viewed.Items[1].Product)
+	})
+
+	t.Run("Invalid row", func(t *testing.T) {
+		ww := importCSV("plums,2\nfigs,1.5\n,1\n")
+		require.Equal(t, http.StatusMultiStatus, ww.Code)
+
+		var got response
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+		assert.Equal(t, 1, got.Imported)
+		require.Len(t, got.Errors, 2)
+		assert.Equal(t, 2, got.Errors[0].Line)
+		assert.Equal(t, "Quantity must be an integer", got.Errors[0].Message)
+		assert.Equal(t, 3, got.Errors[1].Line)
+	})
+
+	t.Run("Malformed CSV", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, importCSV("apples,1\npears\n").Code)
+		assert.Equal(t, http.StatusBadRequest, importCSV("apples,\"1\n").Code)
+		assert.Equal(t, http.StatusBadRequest, importCSV("").Code)
+	})
+
+	t.Run("Wrong content type", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/carts/1/import", strings.NewReader("apples,1\n"))
+		r.Header.Set("Content-Type", "application/json")
+		ww := httptest.NewRecorder()
+		handler.ImportCart(ww, r, fmt.Sprint(cart.Id))
+		assert.Equal(t, http.StatusUnsupportedMediaType, ww.Code)
+	})
+}
+
+func TestHandler_PatchItem(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	item, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+
+	patch := func(itemId string, body string) *httptest.ResponseRecorder {
+		ww := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPatch, "/carts/1/items/"+itemId, strings.NewReader(body))
+		handler.PatchItem(ww, r, fmt.Sprint(cart.Id), itemId)
+		return ww
+	}
+	itemId := fmt.Sprint(item.Id)
+
+	tests := []struct {
+		name     string
+		body     string
+		product  string
+		quantity int
+	}{
+		{name: "Product only", body: `{"product":"pears"}`, product: "pears", quantity: 1},
+		{name: "Quantity only", body: `{"quantity":3}`, product: "pears", quantity: 3},
+		{name: "Product and quantity", body: `{"product":"plums","quantity":5}`, product: "plums", quantity: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ww := patch(itemId, tt.body)
+			require.Equal(t, http.StatusOK, ww.Code)
+
+			var got models.CartItem
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+			assert.Equal(t, item.Id, got.Id)
+			assert.Equal(t, tt.product, got.Product)
+			assert.Equal(t, tt.quantity, got.Quantity)
+			assert.NotEmpty(t, ww.Header().Get("ETag"))
+
+			stored, err := storage.GetItem(ctx, cart.Id, item.Id)
+			require.NoError(t, err)
+			assert.Equal(t, got, stored)
+		})
+	}
+
+	t.Run("Invalid fields", func(t *testing.T) {
+		for _, body := range []string{`{}`, `{"product":""}`, `{"quantity":0}`, `{"quantity":1.5}`, `not json`} {
+			assert.Equal(t, http.StatusBadRequest, patch(itemId, body).Code, body)
+		}
+	})
+
+	t.Run("Item not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, patch("999", `{"quantity":2}`).Code)
+	})
+}
+
+func TestHandler_GetOrCreateCart(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	getOrCreate := func(body string) *httptest.ResponseRecorder {
+		ww := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/carts/by-key", strings.NewReader(body))
+		handler.GetOrCreateCart(ww, r)
+		return ww
+	}
+
+	ww := getOrCreate(`{"key":"session-abc"}`)
+	require.Equal(t, http.StatusCreated, ww.Code)
+	var created models.Cart
+	require.NoError(t, json.NewDecoder(ww.Body).Decode(&created))
+	assert.NotZero(t, created.Id)
+
+	_, err := storage.AddToCart(context.Background(), created.Id, models.CartItem{Product: "apples", Quantity: 2})
+	require.NoError(t, err)
+
+	ww = getOrCreate(`{"key":"session-abc"}`)
+	require.Equal(t, http.StatusOK, ww.Code)
+	var existing models.Cart
+	require.NoError(t, json.NewDecoder(ww.Body).Decode(&existing))
+	assert.Equal(t, created.Id, existing.Id)
+	require.Len(t, existing.Items, 1)
+	assert.Equal(t, "apples", existing.Items[0].Product)
+
+	for _, body := range []string{`{}`, `{"key":"  "}`, `{"key":"` + strings.Repeat("k", 256) + `"}`, `not json`} {
+		assert.Equal(t, http.StatusBadRequest, getOrCreate(body).Code, body)
+	}
+}
+
+func TestHandler_ExportCart_Large(t *testing.T) {
+	const itemCount = 5000
+
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	items := make([]models.CartItem, itemCount)
+	for i := range items {
+		items[i] = models.CartItem{Product: fmt.Sprintf("product-%d", i), Quantity: i%9 + 1}
+	}
+	_, err = storage.AddItems(ctx, cart.Id, items)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ExportCart(w, r, fmt.Sprint(cart.Id))
+	}))
+	defer server.Close()
+
+	download := func(t *testing.T, format string) *http.Response {
+		resp, err := http.Get(server.URL + "/?format=" + format)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		// The export is flushed while it is written, never sized up front.
+		assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		return resp
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		rows, err := csv.NewReader(download(t, "csv").Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, itemCount+1)
+		for i, row := range rows[1:] {
+			assert.Equal(t, items[i].Product, row[1])
+			assert.Equal(t, fmt.Sprint(items[i].Quantity), row[2])
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var got models.Cart
+		require.NoError(t, json.NewDecoder(download(t, "json").Body).Decode(&got))
+		require.Len(t, got.Items, itemCount)
+		for i, item := range got.Items {
+			assert.Equal(t, items[i].Product, item.Product)
+			assert.Equal(t, items[i].Quantity, item.Quantity)
+		}
+	})
+}
+
+func TestHandler_UpdateQuantities(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	handler := carthandler.New(log, cartservice.New(log, storage))
+
+	ctx := context.Background()
+	cart, err := storage.CreateCart(ctx, "")
+	require.NoError(t, err)
+	apples, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "apples", Quantity: 1})
+	require.NoError(t, err)
+	pears, err := storage.AddToCart(ctx, cart.Id, models.CartItem{Product: "pears", Quantity: 1})
+	require.NoError(t, err)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		ww := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPatch, "/carts/1/items", strings.NewReader(body))
+		handler.UpdateQuantities(ww, r, fmt.Sprint(cart.Id))
+		return ww
+	}
+	quantities := func() []int {
+		got, err := storage.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+		require.NoError(t, err)
+		var q []int
+		for _, item := range got.Items {
+			q = append(q, item.Quantity)
+		}
+		return q
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		ww := update(fmt.Sprintf(`{"updates":[{"id":%d,"quantity":3},{"id":%d,"quantity":5}]}`, apples.Id, pears.Id))
+		require.Equal(t, http.StatusOK, ww.Code)
+
+		var got struct {
+			Items []models.CartItem `json:"items"`
+		}
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+		require.Len(t, got.Items, 2)
+		assert.Equal(t, apples.Id, got.Items[0].Id)
+		assert.Equal(t, 3, got.Items[0].Quantity)
+		assert.Equal(t, "pears", got.Items[1].Product)
+		assert.Equal(t, 5, got.Items[1].Quantity)
+		assert.Equal(t, []int{3, 5}, quantities())
+	})
+
+	t.Run("Unknown item rolls back", func(t *testing.T) {
+		ww := update(fmt.Sprintf(`{"updates":[{"id":%d,"quantity":7},{"id":999,"quantity":7}]}`, apples.Id))
+		assert.Equal(t, http.StatusNotFound, ww.Code)
+		assert.Equal(t, []int{3, 5}, quantities())
+	})
+
+	t.Run("Item of another cart rolls back", func(t *testing.T) {
+		other, err := storage.CreateCart(ctx, "")
+		require.NoError(t, err)
+		foreign, err := storage.AddToCart(ctx, other.Id, models.CartItem{Product: "plums", Quantity: 1})
+		require.NoError(t, err)
+
+		ww := update(fmt.Sprintf(`{"updates":[{"id":%d,"quantity":7},{"id":%d,"quantity":7}]}`, apples.Id, foreign.Id))
+		assert.Equal(t, http.StatusNotFound, ww.Code)
+		assert.Equal(t, []int{3, 5}, quantities())
+	})
+
+	t.Run("Invalid updates", func(t *testing.T) {
+		for _, body := range []string{
+			`{}`,
+			`{"updates":[]}`,
+			`{"updates":[{"id":0,"quantity":1}]}`,
+			`{"updates":[{"id":1,"quantity":0}]}`,
+			fmt.Sprintf(`{"updates":[{"id":%d,"quantity":1},{"id":%d,"quantity":2}]}`, apples.Id, apples.Id),
+		} {
+			assert.Equal(t, http.StatusBadRequest, update(body).Code, body)
+		}
+		assert.Equal(t, []int{3, 5}, quantities())
+	})
+}
@@ -0,0 +1,49 @@
+package carthandler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"cartapi/internal/models"
+)
+
+// cartETag builds a strong ETag from the cart contents, so identical carts
+// always produce the same value and any item change produces a new one.
+func cartETag(cart models.Cart) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "cart:%d:%d;", cart.Id, cart.Total)
+	for _, item := range cart.Items {
+		fmt.Fprintf(hash, "item:%d:%q:%d:%q:%g:%q;", item.Id, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit)
+	}
+	for _, key := range slices.Sorted(maps.Keys(cart.Metadata)) {
+		fmt.Fprintf(hash, "meta:%q:%q;", key, cart.Metadata[key])
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// itemETag builds a strong ETag from the item fields, so it changes as soon
+// as the item does.
+func itemETag(item models.CartItem) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "item:%d:%d:%q:%d:%q:%g:%q;", item.Id, item.CartId, item.Product, item.Quantity, item.Measure, item.Weight, item.Unit)
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// etagMatches reports whether an If-None-Match or If-Match header value
+// matches etag.
+func etagMatches(header string, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
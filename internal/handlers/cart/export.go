@@ -0,0 +1,126 @@
+package carthandler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"cartapi/internal/apierror"
+	"cartapi/internal/models"
+	"cartapi/pkg/lib/logger/sl"
+)
+
+const (
+	exportFormatJSON = "json"
+	exportFormatCSV  = "csv"
+)
+
+// csvHeader is the first row of a CSV export.
+var csvHeader = []string{"id", "product", "quantity"}
+
+// GET /carts/{cartId}/export?format=json|csv
+//
+// Every item of the cart is streamed as a download; json, the default, is
+// the same body as a streamed view of the cart.
+func (h *Handler) ExportCart(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.ExportCart"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatJSON
+	}
+	if format != exportFormatJSON && format != exportFormatCSV {
+		log.Error("Invalid format parameter", slog.String("format", format))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Invalid format")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	disposition := fmt.Sprintf(`attachment; filename="cart-%d.%s"`, cartId, format)
+	if format == exportFormatJSON {
+		h.streamCart(w, r, log, &cartStream{w: w, cartId: cartId, disposition: disposition}, "")
+		return
+	}
+
+	stream := &csvStream{w: w, disposition: disposition}
+	_, err = h.service.StreamCartItems(r.Context(), cartId, "", stream.item)
+	if err == nil {
+		err = stream.finish()
+	}
+	if err == nil {
+		return
+	}
+
+	if !stream.started {
+		handleServiceError(w, log, err, "Failed to export the cart")
+		return
+	}
+	log.Error("Failed to stream the export", sl.Err(err))
+	panic(http.ErrAbortHandler)
+}
+
+// csvStream writes the items of a cart as CSV rows. Like cartStream it
+// sends nothing before the first row, so a missing cart still gets a
+// regular error response.
+type csvStream struct {
+	w           http.ResponseWriter
+	csv         *csv.Writer
+	disposition string
+	started     bool
+	written     int
+}
+
+func (s *csvStream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	s.w.Header().Set("Content-Disposition", s.disposition)
+	s.w.WriteHeader(http.StatusOK)
+	s.csv = csv.NewWriter(s.w)
+	return s.csv.Write(csvHeader)
+}
+
+func (s *csvStream) item(item models.CartItem) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.csv.Write([]string{strconv.Itoa(item.Id), item.Product, strconv.Itoa(item.Quantity)}); err != nil {
+		return err
+	}
+	s.written++
+	if s.written%streamFlushEvery == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+// finish writes the header of an empty cart and flushes what is left.
+func (s *csvStream) finish() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *csvStream) flush() error {
+	s.csv.Flush()
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return s.csv.Error()
+}
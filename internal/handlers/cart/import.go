@@ -0,0 +1,181 @@
+package carthandler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cartapi/internal/apierror"
+	"cartapi/internal/models"
+	"cartapi/internal/respond"
+	"cartapi/pkg/lib/logger/sl"
+)
+
+// maxImportRows caps the number of items a single import may add.
+const maxImportRows = 1000
+
+type importResponse struct {
+	Imported int              `json:"imported"`
+	Errors   []importRowError `json:"errors"`
+}
+
+// importRowError reports an invalid row by its line in the CSV body.
+type importRowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// POST /carts/{cartId}/import
+//
+// The text/csv body holds product,quantity rows, optionally under a header
+// row naming the columns, such as the one of an export. Valid rows are
+// inserted in one transaction and invalid ones are reported by line; the
+// status follows the same rules as POST /carts/{cartId}/items/batch.
+func (h *Handler) ImportCart(w http.ResponseWriter, r *http.Request, cartIdStr string) {
+	const op = "handlers.cart.ImportCart"
+	log := h.log.With("op", op)
+
+	cartId, err := parseCartID(cartIdStr)
+	if err != nil {
+		log.Error("Invalid cartId parameter", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "text/csv" {
+		log.Error("Unsupported content type", slog.String("content_type", r.Header.Get("Content-Type")))
+		apierror.Write(w, http.StatusUnsupportedMediaType, apierror.ValidationFailed, "Unsupported content type")
+		return
+	}
+
+	if !h.authorize(w, r, log, cartId) {
+		return
+	}
+
+	body := nonNilBody(r)
+	defer body.Close()
+	rows, err := readImportRows(body)
+	if err != nil {
+		log.Error("Malformed CSV", sl.Err(err))
+		apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, capitalize(err.Error()))
+		return
+	}
+
+	response := importResponse{Errors: []importRowError{}}
+	validItems := make([]models.CartItem, 0, len(rows))
+	for _, row := range rows {
+		item, err := row.item()
+		if err == nil {
+			item = normalizeCartItem(item)
+			err = validateCartItem(item)
+		}
+		if err != nil {
+			response.Errors = append(response.Errors, importRowError{Line: row.line, Message: capitalize(err.Error())})
+			continue
+		}
+		validItems = append(validItems, item)
+	}
+
+	status := http.StatusBadRequest
+	if len(validItems) > 0 {
+		addedItems, err := h.service.AddItems(r.Context(), cartId, validItems)
+		if err != nil {
+			handleServiceError(w, log, err, "Failed to import items")
+			return
+		}
+		response.Imported = len(addedItems)
+
+		status = http.StatusCreated
+		if len(response.Errors) > 0 {
+			status = http.StatusMultiStatus
+		}
+	}
+
+	respond.JSON(w, r, log, status, response)
+}
+
+// importRow is one data row of an import with the line it was read from.
+type importRow struct {
+	line     int
+	product  string
+	quantity string
+}
+
+func (row importRow) item() (models.CartItem, error) {
+	quantity, err := strconv.Atoi(strings.TrimSpace(row.quantity))
+	if err != nil {
+		return models.CartItem{}, errInvalidQuantity
+	}
+	return models.CartItem{Product: row.product, Quantity: quantity}, nil
+}
+
+// readImportRows parses the CSV body. Without a header the columns are
+// product and quantity; a header containing a product column picks the
+// columns by name and may carry others, which are ignored. Every record must
+// have as many fields as the first one.
+func readImportRows(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	productCol, quantityCol := 0, 1
+	var rows []importRow
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed CSV: %w", err)
+		}
+
+		if first && isImportHeader(record) {
+			productCol, quantityCol = importColumns(record)
+			if quantityCol < 0 {
+				return nil, errors.New("header has no quantity column")
+			}
+			continue
+		}
+		if first && len(record) != 2 {
+			return nil, errors.New("rows must have product and quantity columns")
+		}
+
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("at most %d rows can be imported at once", maxImportRows)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, importRow{line: line, product: record[productCol], quantity: record[quantityCol]})
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("no rows to import")
+	}
+	return rows, nil
+}
+
+func importColumns(header []string) (productCol, quantityCol int) {
+	productCol, quantityCol = -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "product":
+			productCol = i
+		case "quantity":
+			quantityCol = i
+		}
+	}
+	return productCol, quantityCol
+}
+
+func isImportHeader(record []string) bool {
+	for _, name := range record {
+		if strings.EqualFold(strings.TrimSpace(name), "product") {
+			return true
+		}
+	}
+	return false
+}
@@ -11,8 +11,8 @@ type Service struct {
 	mock.Mock
 }
 
-func (m *Service) CreateCart(ctx context.Context) (models.Cart, error) {
-	args := m.Called(ctx)
+func (m *Service) CreateCart(ctx context.Context, userId string) (models.Cart, error) {
+	args := m.Called(ctx, userId)
 	return args.Get(0).(models.Cart), args.Error(1)
 }
 func (m *Service) AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error) {
@@ -23,7 +23,79 @@ func (m *Service) RemoveFromCart(ctx context.Context, cartId int, itemId int) er
 	args := m.Called(ctx, cartId, itemId)
 	return args.Error(0)
 }
-func (m *Service) ViewCart(ctx context.Context, cartId int) (models.Cart, error) {
+func (m *Service) ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error) {
+	args := m.Called(ctx, cartId, opts)
+	return args.Get(0).(models.Cart), args.Error(1)
+}
+func (m *Service) MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error) {
+	args := m.Called(ctx, cartId, itemId, targetCartId)
+	return args.Get(0).(models.CartItem), args.Error(1)
+}
+func (m *Service) CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error) {
+	args := m.Called(ctx, cartId, userId)
+	return args.Get(0).(models.Cart), args.Error(1)
+}
+func (m *Service) RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error) {
+	args := m.Called(ctx, cartId, itemIds)
+	return args.Get(0).([]int), args.Error(1)
+}
+func (m *Service) AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error) {
+	args := m.Called(ctx, cartId, items)
+	return args.Get(0).([]models.CartItem), args.Error(1)
+}
+func (m *Service) PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error) {
+	args := m.Called(ctx, cartId, patch)
+	return args.Get(0).(models.Cart), args.Error(1)
+}
+func (m *Service) RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	args := m.Called(ctx, cartId, itemId)
+	return args.Get(0).(models.CartItem), args.Error(1)
+}
+func (m *Service) CreateCarts(ctx context.Context, n int) ([]int, error) {
+	args := m.Called(ctx, n)
+	return args.Get(0).([]int), args.Error(1)
+}
+func (m *Service) ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error) {
+	args := m.Called(ctx, cartId, items)
+	return args.Get(0).(models.Cart), args.Error(1)
+}
+func (m *Service) AuthorizeCart(ctx context.Context, cartId int, userId string) error {
+	args := m.Called(ctx, cartId, userId)
+	return args.Error(0)
+}
+func (m *Service) CartOwner(ctx context.Context, cartId int) (string, error) {
 	args := m.Called(ctx, cartId)
+	return args.String(0), args.Error(1)
+}
+func (m *Service) StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error) {
+	args := m.Called(ctx, cartId, product, yield)
 	return args.Get(0).(models.Cart), args.Error(1)
 }
+func (m *Service) GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	args := m.Called(ctx, cartId, itemId)
+	return args.Get(0).(models.CartItem), args.Error(1)
+}
+func (m *Service) UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error) {
+	args := m.Called(ctx, cartId, itemId, patch)
+	return args.Get(0).(models.CartItem), args.Error(1)
+}
+func (m *Service) ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error) {
+	args := m.Called(ctx, cartIds)
+	return args.Get(0).([]models.Cart), args.Error(1)
+}
+func (m *Service) PurgeCart(ctx context.Context, cartId int) error {
+	args := m.Called(ctx, cartId)
+	return args.Error(0)
+}
+func (m *Service) ItemIds(ctx context.Context, cartId int) ([]int, error) {
+	args := m.Called(ctx, cartId)
+	return args.Get(0).([]int), args.Error(1)
+}
+func (m *Service) GetOrCreateCart(ctx context.Context, key string, userId string) (models.Cart, bool, error) {
+	args := m.Called(ctx, key, userId)
+	return args.Get(0).(models.Cart), args.Bool(1), args.Error(2)
+}
+func (m *Service) UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error) {
+	args := m.Called(ctx, cartId, updates)
+	return args.Get(0).([]models.CartItem), args.Error(1)
+}
@@ -0,0 +1,35 @@
+package carthandler
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+var (
+	errInvalidLimit  = errors.New("invalid limit")
+	errInvalidOffset = errors.New("invalid offset")
+)
+
+// parsePaginationParams reads ?limit= and ?offset=. A missing limit is
+// defaultItemsLimit and one above maxItemsLimit is capped to it; a missing
+// offset is zero. A limit that isn't a positive integer, or an offset that
+// isn't a non-negative one, is an error instead of reaching the query.
+func parsePaginationParams(query url.Values) (limit int, offset int, err error) {
+	limit = defaultItemsLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			return 0, 0, errInvalidLimit
+		}
+		limit = min(n, maxItemsLimit)
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			return 0, 0, errInvalidOffset
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
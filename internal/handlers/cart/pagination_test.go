@@ -0,0 +1,42 @@
+package carthandler
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePaginationParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantLimit  int
+		wantOffset int
+		wantErr    error
+	}{
+		{name: "Defaults", query: "", wantLimit: defaultItemsLimit},
+		{name: "Limit and offset", query: "limit=10&offset=20", wantLimit: 10, wantOffset: 20},
+		{name: "Zero offset", query: "offset=0", wantLimit: defaultItemsLimit},
+		{name: "Huge limit is capped", query: "limit=1000000", wantLimit: maxItemsLimit},
+		{name: "Huge offset", query: "offset=1000000", wantLimit: defaultItemsLimit, wantOffset: 1000000},
+		{name: "Zero limit", query: "limit=0", wantErr: errInvalidLimit},
+		{name: "Negative limit", query: "limit=-1", wantErr: errInvalidLimit},
+		{name: "Non-numeric limit", query: "limit=ten", wantErr: errInvalidLimit},
+		{name: "Limit overflowing an int", query: "limit=99999999999999999999", wantErr: errInvalidLimit},
+		{name: "Negative offset", query: "offset=-5", wantErr: errInvalidOffset},
+		{name: "Non-numeric offset", query: "offset=1.5", wantErr: errInvalidOffset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			assert.NoError(t, err)
+
+			limit, offset, err := parsePaginationParams(query)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantLimit, limit)
+			assert.Equal(t, tt.wantOffset, offset)
+		})
+	}
+}
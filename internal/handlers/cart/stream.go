@@ -0,0 +1,92 @@
+package carthandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cartapi/internal/models"
+)
+
+// streamFlushEvery is the number of items written between flushes of a
+// streamed cart.
+const streamFlushEvery = 100
+
+// cartStream writes the v1 representation of a cart one item at a time.
+// Nothing is sent before the first item or the end of the cart, so a
+// missing cart still gets a regular error response.
+type cartStream struct {
+	w      http.ResponseWriter
+	cartId int
+	// disposition, when set, is sent as the Content-Disposition header.
+	disposition string
+	started     bool
+	written     int
+}
+
+// streamTrailer holds the cart fields written after the items.
+type streamTrailer struct {
+	Total    int               `json:"total"`
+	Empty    bool              `json:"empty,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (s *cartStream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/json")
+	if s.disposition != "" {
+		s.w.Header().Set("Content-Disposition", s.disposition)
+	}
+	s.w.WriteHeader(http.StatusOK)
+	_, err := fmt.Fprintf(s.w, `{"id":%d,"items":[`, s.cartId)
+	return err
+}
+
+func (s *cartStream) item(item models.CartItem) error {
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := s.start(); err != nil {
+		return err
+	}
+	if s.written > 0 {
+		raw = append([]byte{','}, raw...)
+	}
+	if _, err := s.w.Write(raw); err != nil {
+		return err
+	}
+	s.written++
+	if s.written%streamFlushEvery == 0 {
+		s.flush()
+	}
+	return nil
+}
+
+// finish closes the items array and writes the rest of the cart.
+func (s *cartStream) finish(cart models.Cart) error {
+	raw, err := json.Marshal(streamTrailer{Total: cart.Total, Empty: cart.Empty, Metadata: cart.Metadata})
+	if err != nil {
+		return err
+	}
+	if err := s.start(); err != nil {
+		return err
+	}
+	// raw is an object; its opening brace is replaced by the comma after
+	// the items.
+	if _, err := io.WriteString(s.w, `],`+string(raw[1:])+"\n"); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+func (s *cartStream) flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,147 @@
+package carthandler
+
+import (
+	"cartapi/internal/models"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// Report fields by their JSON name, which is what clients send.
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterStructValidation(validateMeasure, models.CartItem{})
+	_ = v.RegisterValidation("no_control", func(fl validator.FieldLevel) bool {
+		return !hasControlChars(fl.Field().String())
+	})
+	return v
+}
+
+// hasControlChars reports whether s holds a NUL byte or another control
+// character, which Postgres text columns cannot store or clients cannot
+// display.
+func hasControlChars(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+const defaultWeightUnit = "kg"
+
+// normalizeCartItem fills in the defaults for fields a client may omit: items
+// are counted unless they say otherwise, and a weighted item is one piece in
+// kilograms.
+func normalizeCartItem(item models.CartItem) models.CartItem {
+	if item.Measure == "" {
+		item.Measure = models.MeasureCount
+	}
+	if item.Measure == models.MeasureWeight {
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+		if item.Unit == "" {
+			item.Unit = defaultWeightUnit
+		}
+	}
+	return item
+}
+
+// validateMeasure requires a positive weight on weighted items.
+func validateMeasure(level validator.StructLevel) {
+	item := level.Current().Interface().(models.CartItem)
+	if item.Measure == models.MeasureWeight && item.Weight <= 0 {
+		level.ReportError(item.Weight, "weight", "Weight", "weight_required", "")
+	}
+}
+
+// fieldError describes the first validate tag a request field failed.
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+func (e fieldError) Error() string {
+	return e.Message
+}
+
+// fieldErrors lists every field of a request that failed validation, in
+// declaration order. Its message is the one of the first field.
+type fieldErrors []fieldError
+
+func (e fieldErrors) Error() string {
+	return e[0].Message
+}
+
+// byField maps each failed field to its capitalized message.
+func (e fieldErrors) byField() map[string]string {
+	fields := make(map[string]string, len(e))
+	for _, fe := range e {
+		fields[fe.Field] = capitalize(fe.Message)
+	}
+	return fields
+}
+
+// validateCartItem checks the validate tags on models.CartItem and reports
+// all failed fields as fieldErrors.
+func validateCartItem(item models.CartItem) error {
+	err := validate.Struct(item)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	errs := make(fieldErrors, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		errs = append(errs, fieldError{Field: fe.Field(), Message: fieldErrorMessage(fe)})
+	}
+	return errs
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s field is required", fe.Field())
+	case "gte":
+		if fe.Param() == "0" {
+			return fmt.Sprintf("%s must not be negative", fe.Field())
+		}
+		if fe.Param() == "1" {
+			return fmt.Sprintf("%s must be greater than zero", fe.Field())
+		}
+		return fmt.Sprintf("%s must be at least %s", fe.Field(), fe.Param())
+	case "weight_required":
+		return fmt.Sprintf("%s must be greater than zero for weighted items", fe.Field())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", fe.Field(), fe.Param())
+	case "no_control":
+		return fmt.Sprintf("%s must not contain control characters", fe.Field())
+	case "max":
+		return fmt.Sprintf("%s must be at most %s characters", fe.Field(), fe.Param())
+	default:
+		return fmt.Sprintf("%s is invalid", fe.Field())
+	}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
@@ -0,0 +1,98 @@
+package carthandler
+
+import (
+	"mime"
+	"strings"
+
+	"cartapi/internal/models"
+)
+
+// mediaTypeV2 selects the v2 representation of a cart. Any other Accept
+// value, including none, gets v1.
+const mediaTypeV2 = "application/vnd.cartapi.v2+json"
+
+type apiVersion int
+
+const (
+	apiV1 apiVersion = iota + 1
+	apiV2
+)
+
+// negotiateVersion picks the response version from the Accept header.
+func negotiateVersion(accept string) apiVersion {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil || mediaType != mediaTypeV2 {
+			continue
+		}
+		if params["q"] == "0" {
+			continue
+		}
+		return apiV2
+	}
+	return apiV1
+}
+
+// contentType is the Content-Type a response of version v is sent with.
+func (v apiVersion) contentType() string {
+	if v == apiV2 {
+		return mediaTypeV2
+	}
+	return "application/json"
+}
+
+// tagETag makes the ETag of a v2 response differ from the v1 one of the
+// same cart, since the bodies differ.
+func (v apiVersion) tagETag(etag string) string {
+	if v == apiV2 {
+		return `"v2-` + strings.Trim(etag, `"`) + `"`
+	}
+	return etag
+}
+
+// cartV2Response always carries metadata, even when empty, and groups the
+// totals of the cart.
+type cartV2Response struct {
+	Id       int               `json:"id"`
+	Items    []models.CartItem `json:"items"`
+	Totals   cartTotals        `json:"totals"`
+	Empty    bool              `json:"empty"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// cartTotals counts every matching item in Items, while Quantity and Weight
+// sum the counted and weighted items of the returned page.
+type cartTotals struct {
+	Items    int     `json:"items"`
+	Quantity int     `json:"quantity"`
+	Weight   float64 `json:"weight"`
+}
+
+// cartResponse serializes the cart in the representation of version v.
+func cartResponse(cart models.Cart, v apiVersion) any {
+	if v != apiV2 {
+		return cart
+	}
+
+	resp := cartV2Response{
+		Id:       cart.Id,
+		Items:    cart.Items,
+		Totals:   cartTotals{Items: cart.Total},
+		Empty:    cart.Empty,
+		Metadata: cart.Metadata,
+	}
+	if resp.Items == nil {
+		resp.Items = []models.CartItem{}
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+	for _, item := range cart.Items {
+		if item.Measure == models.MeasureWeight {
+			resp.Totals.Weight += item.Weight
+		} else {
+			resp.Totals.Quantity += item.Quantity
+		}
+	}
+	return resp
+}
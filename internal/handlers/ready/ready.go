@@ -0,0 +1,66 @@
+package readyhandler
+
+import (
+	"cartapi/internal/apierror"
+	"cartapi/internal/respond"
+	"cartapi/pkg/lib/logger/sl"
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// MigrationVersioner reports the schema version the database is at.
+type MigrationVersioner interface {
+	CurrentMigrationVersion(ctx context.Context) (int64, error)
+}
+
+type Handler struct {
+	log      *slog.Logger
+	storage  MigrationVersioner
+	expected int64
+}
+
+// New returns a readiness handler that reports ready once the schema is at
+// least at the expected migration version.
+func New(log *slog.Logger, storage MigrationVersioner, expected int64) *Handler {
+	return &Handler{
+		log:      log,
+		storage:  storage,
+		expected: expected,
+	}
+}
+
+type readyResponse struct {
+	Status                   string `json:"status"`
+	MigrationVersion         int64  `json:"migration_version"`
+	ExpectedMigrationVersion int64  `json:"expected_migration_version"`
+}
+
+// GET /readyz
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.ready.Ready"
+	log := h.log.With("op", op)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	version, err := h.storage.CurrentMigrationVersion(r.Context())
+	if err != nil {
+		log.Error("Failed to get migration version", sl.Err(err))
+		apierror.Write(w, http.StatusServiceUnavailable, apierror.Unavailable, "Storage unavailable")
+		return
+	}
+
+	status := http.StatusOK
+	response := readyResponse{Status: "ok", MigrationVersion: version, ExpectedMigrationVersion: h.expected}
+	if version < h.expected {
+		log.Warn("Schema is behind", slog.Int64("version", version), slog.Int64("expected", h.expected))
+		status = http.StatusServiceUnavailable
+		response.Status = "schema_behind"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respond.JSON(w, r, log, status, response)
+}
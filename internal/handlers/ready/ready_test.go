@@ -0,0 +1,69 @@
+package readyhandler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	readyhandler "cartapi/internal/handlers/ready"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeVersioner struct {
+	version int64
+	err     error
+}
+
+func (f fakeVersioner) CurrentMigrationVersion(context.Context) (int64, error) {
+	return f.version, f.err
+}
+
+func TestHandler_Ready(t *testing.T) {
+	tests := []struct {
+		name       string
+		storage    fakeVersioner
+		wantStatus int
+		wantBody   map[string]any
+	}{
+		{
+			name:       "Schema up to date",
+			storage:    fakeVersioner{version: 20251014130000},
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]any{"status": "ok", "migration_version": float64(20251014130000), "expected_migration_version": float64(20251014130000)},
+		},
+		{
+			name:       "Schema behind",
+			storage:    fakeVersioner{version: 20250806081559},
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   map[string]any{"status": "schema_behind", "migration_version": float64(20250806081559), "expected_migration_version": float64(20251014130000)},
+		},
+		{
+			name:       "Storage unavailable",
+			storage:    fakeVersioner{err: errors.New("connection refused")},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := readyhandler.New(slogdiscard.NewDiscardLogger(), tt.storage, 20251014130000)
+
+			ww := httptest.NewRecorder()
+			handler.Ready(ww, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tt.wantStatus, ww.Code)
+			if tt.wantBody == nil {
+				return
+			}
+			var got map[string]any
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+			assert.Equal(t, tt.wantBody, got)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package versionhandler
+
+import (
+	"cartapi/internal/apierror"
+	"cartapi/internal/buildinfo"
+	"cartapi/internal/respond"
+	"log/slog"
+	"net/http"
+)
+
+type Handler struct {
+	log  *slog.Logger
+	info buildinfo.Info
+}
+
+func New(log *slog.Logger, info buildinfo.Info) *Handler {
+	return &Handler{
+		log:  log,
+		info: info,
+	}
+}
+
+// GET /version
+func (h *Handler) Version(w http.ResponseWriter, r *http.Request) {
+	const op = "handlers.version.Version"
+	log := h.log.With("op", op)
+
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respond.JSON(w, r, log, http.StatusOK, h.info)
+}
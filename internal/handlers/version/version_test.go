@@ -0,0 +1,49 @@
+package versionhandler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/buildinfo"
+	versionhandler "cartapi/internal/handlers/version"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Version(t *testing.T) {
+	info := buildinfo.Info{Version: "v1.2.0", Commit: "abc1234", Date: "2026-01-02T03:04:05Z"}
+	handler := versionhandler.New(slogdiscard.NewDiscardLogger(), info)
+
+	tests := []struct {
+		name       string
+		method     string
+		wantStatus int
+	}{
+		{name: "Success", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "Wrong method", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/version", nil)
+			ww := httptest.NewRecorder()
+
+			handler.Version(ww, req)
+			resp := ww.Result()
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got buildinfo.Info
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			assert.Equal(t, info, got)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"cartapi/internal/apierror"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// HealthPath and ReadyPath are served without authentication so probes
+// don't need a token.
+const (
+	HealthPath = "/healthz"
+	ReadyPath  = "/readyz"
+)
+
+// isProbe reports whether path is one of the probe endpoints.
+func isProbe(path string) bool {
+	return path == HealthPath || path == ReadyPath
+}
+
+// Auth rejects requests without an "Authorization: Bearer <token>" header
+// matching one of tokens with 401.
+func Auth(tokens []string) func(http.Handler) http.Handler {
+	// Comparing fixed-size hashes keeps the check constant-time regardless
+	// of the token lengths.
+	hashes := make([][sha256.Size]byte, len(tokens))
+	for i, token := range tokens {
+		hashes[i] = sha256.Sum256([]byte(token))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isProbe(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := bearerToken(r.Header.Get("Authorization"))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cartapi"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "missing bearer token")
+				return
+			}
+			if !validToken(hashes, token) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cartapi", error="invalid_token"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "invalid bearer token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func validToken(hashes [][sha256.Size]byte, token string) bool {
+	sum := sha256.Sum256([]byte(token))
+	valid := 0
+	for _, h := range hashes {
+		valid |= subtle.ConstantTimeCompare(h[:], sum[:])
+	}
+	return valid == 1
+}
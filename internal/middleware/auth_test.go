@@ -0,0 +1,51 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuth(t *testing.T) {
+	testCases := []struct {
+		name          string
+		path          string
+		authorization string
+		expected      int
+		challenge     string
+	}{
+		{name: "valid token", path: "/carts/1", authorization: "Bearer secret-1", expected: http.StatusOK},
+		{name: "second valid token", path: "/carts/1", authorization: "Bearer secret-2", expected: http.StatusOK},
+		{name: "scheme is case-insensitive", path: "/carts/1", authorization: "bearer secret-1", expected: http.StatusOK},
+		{name: "invalid token", path: "/carts/1", authorization: "Bearer wrong", expected: http.StatusUnauthorized, challenge: `Bearer realm="cartapi", error="invalid_token"`},
+		{name: "missing token", path: "/carts/1", expected: http.StatusUnauthorized, challenge: `Bearer realm="cartapi"`},
+		{name: "empty token", path: "/carts/1", authorization: "Bearer ", expected: http.StatusUnauthorized, challenge: `Bearer realm="cartapi"`},
+		{name: "other scheme", path: "/carts/1", authorization: "Basic c2VjcmV0LTE=", expected: http.StatusUnauthorized, challenge: `Bearer realm="cartapi"`},
+		{name: "health check skipped", path: "/healthz", expected: http.StatusOK},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware.Auth([]string{"secret-1", "secret-2"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			ww := httptest.NewRecorder()
+			handler.ServeHTTP(ww, req)
+
+			assert.Equal(t, tt.expected, ww.Code)
+			assert.Equal(t, tt.challenge, ww.Header().Get("WWW-Authenticate"))
+			if tt.expected == http.StatusUnauthorized {
+				assert.Contains(t, ww.Body.String(), `"code":"UNAUTHORIZED"`)
+			}
+		})
+	}
+}
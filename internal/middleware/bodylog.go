@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"cartapi/internal/clientip"
+)
+
+const redacted = "[REDACTED]"
+
+// BodyLog logs request and response bodies at debug level, keeping at most
+// maxBytes of each and masking the values of JSON fields named in redact.
+// It does nothing unless the logger has debug enabled.
+func BodyLog(log *slog.Logger, maxBytes int, redact []string) func(http.Handler) http.Handler {
+	fields := make(map[string]struct{}, len(redact))
+	for _, f := range redact {
+		fields[strings.ToLower(f)] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !log.Enabled(r.Context(), slog.LevelDebug) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var reqBody []byte
+			reqTruncated := false
+			if r.Body != nil && r.Body != http.NoBody {
+				// Only the logged prefix is buffered; the handler reads it back
+				// followed by whatever is left of the original body.
+				prefix, err := io.ReadAll(io.LimitReader(r.Body, int64(maxBytes)+1))
+				r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(prefix), r.Body))
+				if err == nil {
+					reqBody, reqTruncated = truncate(prefix, maxBytes)
+				}
+			}
+
+			rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK, max: maxBytes}
+			next.ServeHTTP(rec, r)
+
+			log.Debug("HTTP bodies",
+				slog.String("op", "middleware.BodyLog"),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("client_ip", clientip.FromContext(r.Context())),
+				slog.String("request_body", redactBody(reqBody, reqTruncated, fields)),
+				slog.Bool("request_truncated", reqTruncated),
+				slog.Int("status", rec.status),
+				slog.String("response_body", redactBody(rec.body.Bytes(), rec.truncated, fields)),
+				slog.Bool("response_truncated", rec.truncated),
+			)
+		})
+	}
+}
+
+type bodyRecorder struct {
+	http.ResponseWriter
+	status    int
+	max       int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (r *bodyRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *bodyRecorder) Write(p []byte) (int, error) {
+	if room := r.max - r.body.Len(); room > 0 {
+		if len(p) > room {
+			r.body.Write(p[:room])
+			r.truncated = true
+		} else {
+			r.body.Write(p)
+		}
+	} else if len(p) > 0 {
+		r.truncated = true
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *bodyRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func truncate(b []byte, max int) ([]byte, bool) {
+	if len(b) > max {
+		return b[:max], true
+	}
+	return b, false
+}
+
+// redactBody masks the configured fields of a JSON body. A body that cannot
+// be parsed, e.g. because it was truncated, is dropped when it might contain
+// one of the fields rather than risk logging it.
+func redactBody(body []byte, truncated bool, fields map[string]struct{}) string {
+	if len(fields) == 0 || len(body) == 0 {
+		return string(body)
+	}
+
+	var v any
+	if !truncated && json.Unmarshal(body, &v) == nil {
+		masked, err := json.Marshal(redactValue(v, fields))
+		if err == nil {
+			return string(masked)
+		}
+	}
+
+	lower := strings.ToLower(string(body))
+	for f := range fields {
+		if strings.Contains(lower, f) {
+			return redacted
+		}
+	}
+	return string(body)
+}
+
+func redactValue(v any, fields map[string]struct{}) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, inner := range val {
+			if _, ok := fields[strings.ToLower(k)]; ok {
+				val[k] = redacted
+				continue
+			}
+			val[k] = redactValue(inner, fields)
+		}
+	case []any:
+		for i, inner := range val {
+			val[i] = redactValue(inner, fields)
+		}
+	}
+	return v
+}
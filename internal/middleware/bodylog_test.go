@@ -0,0 +1,87 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cartapi/internal/middleware"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyLog(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	var handlerSaw string
+	handler := middleware.BodyLog(log, 1024, []string{"password"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		handlerSaw = string(body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+
+	reqBody := `{"product":"apple","password":"secret"}`
+	ww := httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodPost, "/carts", strings.NewReader(reqBody)))
+
+	assert.Equal(t, reqBody, handlerSaw)
+	assert.Equal(t, http.StatusCreated, ww.Code)
+	assert.Equal(t, `{"id":1}`, ww.Body.String())
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.JSONEq(t, `{"product":"apple","password":"[REDACTED]"}`, entry["request_body"].(string))
+	assert.Equal(t, `{"id":1}`, entry["response_body"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.NotContains(t, logs.String(), "secret")
+}
+
+func TestBodyLog_Truncated(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	var handlerSaw string
+	handler := middleware.BodyLog(log, 4, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		handlerSaw = string(body)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+
+	ww := httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodPost, "/carts", strings.NewReader("abcdefghij")))
+
+	assert.Equal(t, "abcdefghij", handlerSaw)
+	assert.Equal(t, "0123456789", ww.Body.String())
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "abcd", entry["request_body"])
+	assert.Equal(t, true, entry["request_truncated"])
+	assert.Equal(t, "0123", entry["response_body"])
+	assert.Equal(t, true, entry["response_truncated"])
+}
+
+func TestBodyLog_DisabledBelowDebug(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	var handlerSaw string
+	handler := middleware.BodyLog(log, 1024, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		handlerSaw = string(body)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/carts", strings.NewReader("hello")))
+
+	assert.Equal(t, "hello", handlerSaw)
+	assert.Empty(t, logs.String())
+}
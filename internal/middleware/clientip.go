@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"net/http"
+
+	"cartapi/internal/clientip"
+)
+
+// ClientIP puts the address of the client, as resolved past the trusted
+// proxies, in the request context for the logs and limits that need it.
+func ClientIP(resolver *clientip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := clientip.NewContext(r.Context(), resolver.ClientIP(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
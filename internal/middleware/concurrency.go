@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"cartapi/internal/apierror"
+	"net/http"
+	"strconv"
+)
+
+// concurrencyRetryAfter is the Retry-After, in seconds, sent with the 503
+// answering requests over the limit.
+const concurrencyRetryAfter = 1
+
+// ConcurrencyLimit serves at most max requests at a time, so that bursts
+// queue at the clients instead of on the database pool. Requests over the
+// limit are answered 503 right away. A max of zero or less disables it.
+func ConcurrencyLimit(max int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if max <= 0 {
+			return next
+		}
+		slots := make(chan struct{}, max)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				w.Header().Set("Retry-After", strconv.Itoa(concurrencyRetryAfter))
+				apierror.Write(w, http.StatusServiceUnavailable, apierror.Unavailable, "too many concurrent requests")
+				return
+			}
+			defer func() { <-slots }()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
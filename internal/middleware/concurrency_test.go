@@ -0,0 +1,87 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"cartapi/internal/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	const limit = 3
+	var running, peak atomic.Int64
+	started := make(chan struct{}, limit)
+	release := make(chan struct{})
+	handler := middleware.ConcurrencyLimit(limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/carts/1", nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	codes := make(chan int, limit)
+	for range limit {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve().Code
+		}()
+	}
+	for range limit {
+		<-started
+	}
+
+	// Every slot is taken, so more requests are turned away concurrently.
+	var rejected sync.WaitGroup
+	for range 5 {
+		rejected.Add(1)
+		go func() {
+			defer rejected.Done()
+			w := serve()
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, "1", w.Header().Get("Retry-After"))
+		}()
+	}
+	rejected.Wait()
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.Equal(t, int64(limit), peak.Load())
+
+	// The slots are free again once the requests are done.
+	started = make(chan struct{}, 1)
+	assert.Equal(t, http.StatusOK, serve().Code)
+}
+
+func TestConcurrencyLimit_Disabled(t *testing.T) {
+	handler := middleware.ConcurrencyLimit(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/carts/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
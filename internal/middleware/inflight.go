@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const inFlightPollInterval = 10 * time.Millisecond
+
+// InFlight counts the requests that are currently being served so shutdown
+// can wait for them to drain.
+type InFlight struct {
+	count atomic.Int64
+}
+
+func NewInFlight() *InFlight {
+	return &InFlight{}
+}
+
+func (f *InFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.count.Add(1)
+		defer f.count.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *InFlight) Count() int64 {
+	return f.count.Load()
+}
+
+// Wait blocks until no requests are in flight or ctx is done, and returns
+// the number of requests still running.
+func (f *InFlight) Wait(ctx context.Context) int64 {
+	ticker := time.NewTicker(inFlightPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if n := f.Count(); n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return f.Count()
+		case <-ticker.C:
+		}
+	}
+}
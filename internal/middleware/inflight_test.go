@@ -0,0 +1,66 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cartapi/internal/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInFlight_WaitForDrain(t *testing.T) {
+	inFlight := middleware.NewInFlight()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := inFlight.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/carts/1", nil))
+	<-started
+	assert.Equal(t, int64(1), inFlight.Count())
+
+	waited := make(chan int64)
+	go func() {
+		waited <- inFlight.Wait(context.Background())
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while a request was still in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case remaining := <-waited:
+		assert.Equal(t, int64(0), remaining)
+	case <-time.After(time.Second):
+		t.Fatal("Wait didn't return after the request finished")
+	}
+}
+
+func TestInFlight_WaitTimeout(t *testing.T) {
+	inFlight := middleware.NewInFlight()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := inFlight.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/carts/1", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, int64(1), inFlight.Wait(ctx))
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"cartapi/internal/apierror"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ReadOnly rejects writes to the cart routes with 503 while enabled, so the
+// database can be maintained without taking reads down. It can be switched at
+// runtime.
+type ReadOnly struct {
+	enabled atomic.Bool
+}
+
+func NewReadOnly(enabled bool) *ReadOnly {
+	m := &ReadOnly{}
+	m.enabled.Store(enabled)
+	return m
+}
+
+func (m *ReadOnly) ReadOnly() bool {
+	return m.enabled.Load()
+}
+
+func (m *ReadOnly) SetReadOnly(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+// Toggle flips the mode and returns the new value.
+func (m *ReadOnly) Toggle() bool {
+	for {
+		old := m.enabled.Load()
+		if m.enabled.CompareAndSwap(old, !old) {
+			return !old
+		}
+	}
+}
+
+func (m *ReadOnly) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.ReadOnly() && isWrite(r.Method) && isCartPath(r.URL.Path) {
+			apierror.Write(w, http.StatusServiceUnavailable, apierror.ReadOnly, "service is in read-only mode")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isCartPath(path string) bool {
+	return path == "/carts" || strings.HasPrefix(path, "/carts/")
+}
@@ -0,0 +1,60 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnly(t *testing.T) {
+	testCases := []struct {
+		name     string
+		enabled  bool
+		method   string
+		path     string
+		expected int
+	}{
+		{name: "read allowed in read-only mode", enabled: true, method: http.MethodGet, path: "/carts/1", expected: http.StatusOK},
+		{name: "create blocked", enabled: true, method: http.MethodPost, path: "/carts", expected: http.StatusServiceUnavailable},
+		{name: "add blocked", enabled: true, method: http.MethodPost, path: "/carts/1/items", expected: http.StatusServiceUnavailable},
+		{name: "delete blocked", enabled: true, method: http.MethodDelete, path: "/carts/1/items/2", expected: http.StatusServiceUnavailable},
+		{name: "patch blocked", enabled: true, method: http.MethodPatch, path: "/carts/1", expected: http.StatusServiceUnavailable},
+		{name: "admin writes allowed", enabled: true, method: http.MethodPost, path: "/admin/read-only", expected: http.StatusOK},
+		{name: "writes allowed when disabled", enabled: false, method: http.MethodPost, path: "/carts/1/items", expected: http.StatusOK},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			readOnly := middleware.NewReadOnly(tt.enabled)
+			handler := readOnly.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			ww := httptest.NewRecorder()
+			handler.ServeHTTP(ww, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, tt.expected, ww.Code)
+		})
+	}
+}
+
+func TestReadOnly_Toggle(t *testing.T) {
+	readOnly := middleware.NewReadOnly(false)
+	handler := readOnly.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	assert.True(t, readOnly.Toggle())
+	ww := httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodPost, "/carts", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, ww.Code)
+
+	assert.False(t, readOnly.Toggle())
+	ww = httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodPost, "/carts", nil))
+	assert.Equal(t, http.StatusCreated, ww.Code)
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"cartapi/internal/apierror"
+	"cartapi/internal/clientip"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panic in a handler into a 500 response so that a single
+// bad request doesn't take the process down.
+func Recover(log *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				log.Error("Recovered from panic",
+					slog.String("op", "middleware.Recover"),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("client_ip", clientip.FromContext(r.Context())),
+					slog.Any("panic", rec),
+					slog.String("stack", string(debug.Stack())),
+				)
+
+				apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "internal server error")
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+
+	"cartapi/internal/requestid"
+)
+
+// RequestID gives every request an id, reusing a valid X-Request-Id sent by
+// the client. The id is put in the request context and in the X-Request-Id
+// response header, where error responses pick it up.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
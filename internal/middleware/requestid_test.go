@@ -0,0 +1,57 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/apierror"
+	"cartapi/internal/middleware"
+	"cartapi/internal/requestid"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "id is generated", incoming: ""},
+		{name: "client id is reused", incoming: "abc-123", reused: true},
+		{name: "invalid client id is replaced", incoming: "bad id\n"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromContext string
+			h := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = requestid.FromContext(r.Context())
+				apierror.Write(w, http.StatusNotFound, apierror.CartNotFound, "Cart not found")
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+			if tt.incoming != "" {
+				r.Header.Set(requestid.Header, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			id := w.Header().Get(requestid.Header)
+			require.NotEmpty(t, id)
+			assert.Equal(t, id, fromContext)
+			if tt.reused {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.NotEqual(t, tt.incoming, id)
+			}
+
+			var resp apierror.Response
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, id, resp.Error.RequestID)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"bytes"
+	"cartapi/internal/apierror"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the raw request body,
+// optionally prefixed with "sha256=".
+const SignatureHeader = "X-Signature"
+
+// MaxSignedBodyBytes caps the body Signature buffers to check. Larger
+// bodies are rejected with 413 before the HMAC is computed.
+const MaxSignedBodyBytes = 1 << 20
+
+// Signature rejects requests whose X-Signature doesn't match the HMAC of
+// their body under secret with 401. The body, up to MaxSignedBodyBytes, is
+// buffered and handed on unchanged, so handlers still read it.
+func Signature(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isProbe(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(SignatureHeader), "sha256="))
+			if err != nil || len(got) == 0 {
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "missing or malformed signature")
+				return
+			}
+
+			var body []byte
+			if r.Body != nil {
+				body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, MaxSignedBodyBytes))
+				r.Body.Close()
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.BodyTooLarge, "Request body too large")
+					return
+				}
+				if err != nil {
+					apierror.Write(w, http.StatusBadRequest, apierror.ValidationFailed, "Cannot read request body")
+					return
+				}
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(body)
+			if !hmac.Equal(got, mac.Sum(nil)) {
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "invalid signature")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
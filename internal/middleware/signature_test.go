@@ -0,0 +1,83 @@
+package middleware_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cartapi/internal/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignature(t *testing.T) {
+	const body = `{"product":"apple","quantity":1}`
+
+	testCases := []struct {
+		name      string
+		path      string
+		body      string
+		signature string
+		expected  int
+	}{
+		{name: "valid signature", path: "/carts/1/items", body: body, signature: sign("secret", body), expected: http.StatusCreated},
+		{name: "valid prefixed signature", path: "/carts/1/items", body: body, signature: "sha256=" + sign("secret", body), expected: http.StatusCreated},
+		{name: "valid signature of empty body", path: "/carts/1", signature: sign("secret", ""), expected: http.StatusCreated},
+		{name: "tampered body", path: "/carts/1/items", body: `{"product":"apple","quantity":9}`, signature: sign("secret", body), expected: http.StatusUnauthorized},
+		{name: "wrong secret", path: "/carts/1/items", body: body, signature: sign("other", body), expected: http.StatusUnauthorized},
+		{name: "malformed signature", path: "/carts/1/items", body: body, signature: "not-hex", expected: http.StatusUnauthorized},
+		{name: "missing signature", path: "/carts/1/items", body: body, expected: http.StatusUnauthorized},
+		{name: "health check skipped", path: "/healthz", expected: http.StatusCreated},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := middleware.Signature([]byte("secret"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				seen = string(b)
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.signature != "" {
+				req.Header.Set(middleware.SignatureHeader, tt.signature)
+			}
+			ww := httptest.NewRecorder()
+			handler.ServeHTTP(ww, req)
+
+			assert.Equal(t, tt.expected, ww.Code)
+			if tt.expected == http.StatusCreated {
+				assert.Equal(t, tt.body, seen)
+			} else {
+				assert.Contains(t, ww.Body.String(), `"code":"UNAUTHORIZED"`)
+			}
+		})
+	}
+}
+
+func TestSignature_BodyTooLarge(t *testing.T) {
+	body := strings.Repeat("a", middleware.MaxSignedBodyBytes+1)
+	handler := middleware.Signature([]byte("secret"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler must not run for an oversized body")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/carts/1/items", strings.NewReader(body))
+	req.Header.Set(middleware.SignatureHeader, sign("secret", body))
+	ww := httptest.NewRecorder()
+	handler.ServeHTTP(ww, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, ww.Code)
+	assert.Contains(t, ww.Body.String(), `"code":"BODY_TOO_LARGE"`)
+}
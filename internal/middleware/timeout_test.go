@@ -0,0 +1,50 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cartapi/internal/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	timeouts := map[string]time.Duration{
+		"/view": 2 * time.Second,
+		"/add":  5 * time.Second,
+	}
+	timeout := middleware.Timeout(func(r *http.Request) time.Duration {
+		return timeouts[r.URL.Path]
+	})
+
+	tests := []struct {
+		path        string
+		hasDeadline bool
+		want        time.Duration
+	}{
+		{path: "/view", hasDeadline: true, want: 2 * time.Second},
+		{path: "/add", hasDeadline: true, want: 5 * time.Second},
+		{path: "/other", hasDeadline: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var deadline time.Time
+			var ok bool
+			handler := timeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				deadline, ok = r.Context().Deadline()
+			}))
+
+			start := time.Now()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.hasDeadline, ok)
+			if tt.hasDeadline {
+				assert.WithinDuration(t, start.Add(tt.want), deadline, time.Second)
+			}
+		})
+	}
+}
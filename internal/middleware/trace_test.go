@@ -0,0 +1,61 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/database/memory"
+	carthandler "cartapi/internal/handlers/cart"
+	"cartapi/internal/middleware"
+	"cartapi/internal/models"
+	cartservice "cartapi/internal/service/cart"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTrace_ViewCart(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	cart, err := storage.CreateCart(context.Background(), "")
+	require.NoError(t, err)
+	_, err = storage.AddToCart(context.Background(), cart.Id, models.CartItem{Product: "apple", Quantity: 1})
+	require.NoError(t, err)
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	storage.SetTracer(tracer)
+	handler := carthandler.New(log, cartservice.New(log, storage, cartservice.WithTracer(tracer)))
+
+	h := middleware.Trace(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ViewCart(w, r, "1")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	storageSpan, serviceSpan, httpSpan := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "HTTP GET", httpSpan.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", httpSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", httpSpan.Parent().SpanID().String())
+	assert.True(t, httpSpan.Parent().IsRemote())
+
+	assert.Equal(t, "service.cartapi.ViewCart", serviceSpan.Name())
+	assert.Equal(t, httpSpan.SpanContext().SpanID(), serviceSpan.Parent().SpanID())
+	assert.Contains(t, serviceSpan.Attributes(), attribute.Int("cart.id", cart.Id))
+
+	assert.Equal(t, "database.memory.ViewCart", storageSpan.Name())
+	assert.Equal(t, serviceSpan.SpanContext().SpanID(), storageSpan.Parent().SpanID())
+	assert.Contains(t, storageSpan.Attributes(), attribute.Int("cart.id", cart.Id))
+}
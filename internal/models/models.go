@@ -1,13 +1,73 @@
 package models
 
+import "math"
+
+// MaxQuantity is the largest quantity an item can hold; item.quantity is a
+// 32-bit INT column.
+const MaxQuantity = math.MaxInt32
+
 type Cart struct {
 	Id    int        `json:"id"`
 	Items []CartItem `json:"items"`
+	Total int        `json:"total"`
+	// UserId is the owner the cart was created for, if any.
+	UserId string `json:"user_id,omitempty"`
+	// Empty is set when no item matches the view at all, which tells an
+	// empty cart apart from a page past the last item.
+	Empty    bool              `json:"empty,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ViewCartOptions selects which page of the cart items is returned and
+// optionally filters them by a product substring.
+type ViewCartOptions struct {
+	Limit   int
+	Offset  int
+	Product string
 }
 
+const (
+	// MeasureCount items are sold by the piece; Quantity is what counts.
+	MeasureCount = "count"
+	// MeasureWeight items are sold by weight; Weight (in Unit) is what
+	// counts and Quantity is the number of weighed pieces.
+	MeasureWeight = "weight"
+)
+
 type CartItem struct {
-	Id       int    `json:"id" db:"id"`
-	CartId   int    `json:"cart_id" db:"cart_id"`
-	Product  string `json:"product" db:"product"`
-	Quantity int    `json:"quantity" db:"quantity"`
+	Id       int     `json:"id" db:"id"`
+	CartId   int     `json:"cart_id" db:"cart_id"`
+	Product  string  `json:"product" db:"product" validate:"required,no_control"`
+	Quantity int     `json:"quantity" db:"quantity" validate:"gte=1"`
+	Measure  string  `json:"measure,omitempty" db:"measure" validate:"omitempty,oneof=count weight"`
+	Weight   float64 `json:"weight,omitempty" db:"weight" validate:"gte=0"`
+	Unit     string  `json:"unit,omitempty" db:"unit" validate:"max=16"`
+}
+
+// ItemPatch lists the item fields a partial update changes; nil fields are
+// left as they are.
+type ItemPatch struct {
+	Product  *string
+	Quantity *int
+}
+
+// QuantityUpdate sets the quantity of one item of a cart.
+type QuantityUpdate struct {
+	ItemId   int
+	Quantity int
+}
+
+// CartStats aggregates every cart of a storage. Items counts live items
+// only, and TopProducts lists the products with the most items first.
+type CartStats struct {
+	Carts       int
+	Items       int
+	TopProducts []ProductStats
+}
+
+// ProductStats counts the live items of one product across all carts.
+type ProductStats struct {
+	Product  string
+	Items    int
+	Quantity int
 }
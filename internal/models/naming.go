@@ -0,0 +1,58 @@
+package models
+
+import "encoding/json"
+
+// Naming selects the case of the JSON field names carts and items are
+// encoded with. Decoding is unaffected.
+type Naming int
+
+const (
+	SnakeCase Naming = iota
+	CamelCase
+)
+
+var jsonNaming = SnakeCase
+
+// SetJSONNaming switches the field names of every Cart and CartItem encoded
+// afterwards. It must be called before any of them is encoded.
+func SetJSONNaming(n Naming) {
+	jsonNaming = n
+}
+
+// cartJSON and cartItemJSON have the fields of Cart and CartItem without
+// their MarshalJSON methods; the camel variants only differ in their tags.
+type (
+	cartJSON      Cart
+	cartCamelJSON struct {
+		Id       int               `json:"id"`
+		Items    []CartItem        `json:"items"`
+		Total    int               `json:"total"`
+		UserId   string            `json:"userId,omitempty"`
+		Empty    bool              `json:"empty,omitempty"`
+		Metadata map[string]string `json:"metadata,omitempty"`
+	}
+	cartItemJSON      CartItem
+	cartItemCamelJSON struct {
+		Id       int     `json:"id"`
+		CartId   int     `json:"cartId"`
+		Product  string  `json:"product"`
+		Quantity int     `json:"quantity"`
+		Measure  string  `json:"measure,omitempty"`
+		Weight   float64 `json:"weight,omitempty"`
+		Unit     string  `json:"unit,omitempty"`
+	}
+)
+
+func (c Cart) MarshalJSON() ([]byte, error) {
+	if jsonNaming == CamelCase {
+		return json.Marshal(cartCamelJSON(c))
+	}
+	return json.Marshal(cartJSON(c))
+}
+
+func (i CartItem) MarshalJSON() ([]byte, error) {
+	if jsonNaming == CamelCase {
+		return json.Marshal(cartItemCamelJSON(i))
+	}
+	return json.Marshal(cartItemJSON(i))
+}
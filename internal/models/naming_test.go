@@ -0,0 +1,52 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cartapi/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCartItem_JSONNaming(t *testing.T) {
+	item := models.CartItem{Id: 1, CartId: 2, Product: "apples", Quantity: 3, Measure: models.MeasureWeight, Weight: 1.5, Unit: "kg"}
+
+	tests := []struct {
+		name     string
+		naming   models.Naming
+		expected string
+	}{
+		{name: "snake_case", naming: models.SnakeCase, expected: `{"id":1,"cart_id":2,"product":"apples","quantity":3,"measure":"weight","weight":1.5,"unit":"kg"}`},
+		{name: "camelCase", naming: models.CamelCase, expected: `{"id":1,"cartId":2,"product":"apples","quantity":3,"measure":"weight","weight":1.5,"unit":"kg"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models.SetJSONNaming(tt.naming)
+			t.Cleanup(func() { models.SetJSONNaming(models.SnakeCase) })
+
+			raw, err := json.Marshal(item)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(raw))
+		})
+	}
+}
+
+func TestCart_JSONNaming(t *testing.T) {
+	models.SetJSONNaming(models.CamelCase)
+	t.Cleanup(func() { models.SetJSONNaming(models.SnakeCase) })
+
+	cart := models.Cart{
+		Id:       2,
+		Items:    []models.CartItem{{Id: 1, CartId: 2, Product: "apples", Quantity: 3}},
+		Total:    1,
+		UserId:   "user-1",
+		Metadata: map[string]string{"gift_note": "hi"},
+	}
+	raw, err := json.Marshal(cart)
+	require.NoError(t, err)
+	// Metadata keys are client data and keep their case.
+	assert.JSONEq(t, `{"id":2,"items":[{"id":1,"cartId":2,"product":"apples","quantity":3}],"total":1,"userId":"user-1","metadata":{"gift_note":"hi"}}`, string(raw))
+}
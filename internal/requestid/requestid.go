@@ -0,0 +1,53 @@
+// Package requestid carries the id identifying a request from the
+// middleware assigning it to the responses and logs quoting it.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header is sent back with every response and taken from the request when
+// the client or a proxy in front already assigned an id.
+const Header = "X-Request-Id"
+
+// maxLen bounds the ids accepted from clients.
+const maxLen = 128
+
+type ctxKey struct{}
+
+// New returns a random id.
+func New() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether an id sent by a client can be reused: not empty,
+// at most 128 characters and only letters, digits, '-', '_' and '.'.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the id of the request ctx belongs to, or "" outside
+// of a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
@@ -0,0 +1,46 @@
+package respond
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"cartapi/internal/apierror"
+	"cartapi/pkg/lib/logger/sl"
+)
+
+// JSON sends v with status. v is encoded before anything is written, so a
+// value that fails to encode gets a proper 500 instead of the status of a
+// success followed by a truncated body. Headers meant for the success
+// response, such as an ETag, are dropped from the 500.
+//
+// A request with ?pretty=true gets v indented for reading; anything else,
+// including an invalid value, gets it compact.
+func JSON(w http.ResponseWriter, r *http.Request, log *slog.Logger, status int, v any) {
+	var body []byte
+	var err error
+	if pretty(r) {
+		body, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		body, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.Error("Failed to respond user", sl.Err(err))
+		w.Header().Del("ETag")
+		w.Header().Del("Content-Disposition")
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Failed to respond user")
+		return
+	}
+
+	w.WriteHeader(status)
+	// Keep the trailing newline json.Encoder used to write.
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		log.Error("Failed to respond user", sl.Err(err))
+	}
+}
+
+func pretty(r *http.Request) bool {
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	return err == nil && enabled
+}
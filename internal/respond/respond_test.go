@@ -0,0 +1,98 @@
+package respond_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cartapi/internal/apierror"
+	"cartapi/internal/models"
+	"cartapi/internal/respond"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSON(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+
+	t.Run("Success", func(t *testing.T) {
+		ww := httptest.NewRecorder()
+		respond.JSON(ww, httptest.NewRequest(http.MethodPost, "/carts", nil), log, http.StatusCreated, map[string]int{"id": 1})
+
+		assert.Equal(t, http.StatusCreated, ww.Code)
+		assert.Equal(t, "{\"id\":1}\n", ww.Body.String())
+	})
+
+	t.Run("Value fails to marshal", func(t *testing.T) {
+		ww := httptest.NewRecorder()
+		ww.Header().Set("ETag", `"1"`)
+		respond.JSON(ww, httptest.NewRequest(http.MethodPost, "/carts", nil), log, http.StatusCreated, map[string]any{"unsupported": make(chan int)})
+
+		assert.Equal(t, http.StatusInternalServerError, ww.Code)
+		assert.Empty(t, ww.Header().Get("ETag"))
+
+		var got apierror.Response
+		require.NoError(t, json.NewDecoder(ww.Body).Decode(&got))
+		assert.Equal(t, apierror.Internal, got.Error.Code)
+		assert.Equal(t, "Failed to respond user", got.Error.Message)
+	})
+}
+
+func TestJSON_Pretty(t *testing.T) {
+	log := slogdiscard.NewDiscardLogger()
+	cart := models.Cart{Id: 1, Items: []models.CartItem{{Id: 2, CartId: 1, Product: "apple", Quantity: 3}}, Total: 1}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name: "Compact by default",
+			want: `{"id":1,"items":[{"id":2,"cart_id":1,"product":"apple","quantity":3}],"total":1}` + "\n",
+		},
+		{
+			name:  "Compact when disabled",
+			query: "?pretty=false",
+			want:  `{"id":1,"items":[{"id":2,"cart_id":1,"product":"apple","quantity":3}],"total":1}` + "\n",
+		},
+		{
+			name:  "Compact for an invalid value",
+			query: "?pretty=yes please",
+			want:  `{"id":1,"items":[{"id":2,"cart_id":1,"product":"apple","quantity":3}],"total":1}` + "\n",
+		},
+		{
+			name:  "Indented",
+			query: "?pretty=true",
+			want: `{
+  "id": 1,
+  "items": [
+    {
+      "id": 2,
+      "cart_id": 1,
+      "product": "apple",
+      "quantity": 3
+    }
+  ],
+  "total": 1
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ww := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/carts/1", nil)
+			r.URL.RawQuery = strings.TrimPrefix(tt.query, "?")
+			respond.JSON(ww, r, log, http.StatusOK, cart)
+
+			assert.Equal(t, http.StatusOK, ww.Code)
+			assert.Equal(t, tt.want, ww.Body.String())
+		})
+	}
+}
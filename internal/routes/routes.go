@@ -1,43 +1,282 @@
 package routes
 
 import (
+	"cartapi/internal/apierror"
+	adminhandler "cartapi/internal/handlers/admin"
 	carthandler "cartapi/internal/handlers/cart"
+	readyhandler "cartapi/internal/handlers/ready"
+	versionhandler "cartapi/internal/handlers/version"
+	"cartapi/internal/middleware"
+	"cartapi/pkg/lib/urlparser"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 type Routes struct {
+	mux             *http.ServeMux
 	cartItemHandler *carthandler.Handler
+	adminHandler    *adminhandler.Handler
+	versionHandler  *versionhandler.Handler
+	readyHandler    *readyhandler.Handler
+	basePath        string
+	maxPathLength   int
 }
 
-func New(cartItemHandler *carthandler.Handler) *Routes {
+// New creates the router. adminHandler, versionHandler and readyHandler may
+// be nil, in which case their routes are not registered. A non-empty basePath such as /api/v1 mounts
+// every route under that prefix.
+func New(cartItemHandler *carthandler.Handler, adminHandler *adminhandler.Handler, versionHandler *versionhandler.Handler, readyHandler *readyhandler.Handler, basePath string) *Routes {
 	return &Routes{
+		mux:             http.NewServeMux(),
 		cartItemHandler: cartItemHandler,
+		adminHandler:    adminHandler,
+		versionHandler:  versionHandler,
+		readyHandler:    readyHandler,
+		basePath:        strings.TrimSuffix(basePath, "/"),
 	}
 }
 
+// SetMaxPathLength answers 414 to cart requests whose path is longer than n
+// bytes, before the path is parsed. Zero, the default, means no limit. It
+// must be called before the router is used.
+func (r *Routes) SetMaxPathLength(n int) {
+	r.maxPathLength = n
+}
+
 func (r *Routes) Register() {
-	// POST /carts
-	http.HandleFunc("/carts", r.cartItemHandler.CreateCart)
-	http.HandleFunc("/carts/", r.pathParser)
+	// POST /carts, GET /carts?ids=
+	r.mux.HandleFunc("/carts", r.carts)
+	r.mux.HandleFunc("/carts/", r.pathParser)
+	r.mux.HandleFunc("/", func(ww http.ResponseWriter, req *http.Request) { notFound(ww) })
+	// GET /healthz
+	r.mux.HandleFunc(middleware.HealthPath, healthz)
+
+	if r.adminHandler != nil {
+		// GET /admin/db/stats
+		r.mux.HandleFunc("/admin/db/stats", r.adminHandler.DBStats)
+		// GET /admin/stats
+		r.mux.HandleFunc("/admin/stats", r.adminHandler.CartStats)
+		// GET, PUT /admin/read-only
+		r.mux.HandleFunc("/admin/read-only", r.adminHandler.ReadOnly)
+		// GET /admin/schema/check
+		r.mux.HandleFunc("/admin/schema/check", r.adminHandler.SchemaCheck)
+	}
+
+	if r.versionHandler != nil {
+		// GET /version
+		r.mux.HandleFunc("/version", r.versionHandler.Version)
+	}
+
+	if r.readyHandler != nil {
+		// GET /readyz
+		r.mux.HandleFunc(middleware.ReadyPath, r.readyHandler.Ready)
+	}
+}
+
+// Handler returns the handler serving the registered routes, wrapped in
+// the given middlewares with the first one outermost. The base path is
+// stripped before any of them run, so middlewares matching on the path see
+// the same paths with or without it. Requests outside the base path get the
+// JSON 404.
+func (r *Routes) Handler(middlewares ...func(http.Handler) http.Handler) http.Handler {
+	var h http.Handler = r.mux
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	if r.basePath == "" {
+		return h
+	}
+
+	strip := http.StripPrefix(r.basePath, h)
+	return http.HandlerFunc(func(ww http.ResponseWriter, req *http.Request) {
+		rest := strings.TrimPrefix(req.URL.Path, r.basePath)
+		if len(rest) == len(req.URL.Path) || !strings.HasPrefix(rest, "/") {
+			notFound(ww)
+			return
+		}
+		strip.ServeHTTP(ww, req)
+	})
+}
+
+// healthz reports that the process is up and serving requests.
+func healthz(ww http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		notFound(ww)
+		return
+	}
+	ww.Header().Set("Content-Type", "application/json")
+	ww.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(ww, `{"status":"ok"}`+"\n")
+}
+
+// carts serves /carts: GET views several carts, any other method creates
+// one.
+func (r *Routes) carts(ww http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		r.cartItemHandler.ViewCarts(ww, req)
+		return
+	}
+	r.cartItemHandler.CreateCart(ww, req)
 }
 
 func (r *Routes) pathParser(ww http.ResponseWriter, req *http.Request) {
-	path := strings.Trim(req.URL.Path, "/")
-	parts := strings.Split(path, "/")
+	if r.maxPathLength > 0 && len(req.URL.Path) > r.maxPathLength {
+		apierror.Write(ww, http.StatusRequestURITooLong, apierror.URITooLong, "URI too long")
+		return
+	}
+	path := normalizePath(req)
+	switch {
+	case path == "/carts":
+		r.carts(ww, req)
+		return
+	case path == "/carts/batch" && req.Method == http.MethodPost:
+		// POST /carts/batch
+		r.cartItemHandler.CreateCarts(ww, req)
+		return
+	case path == "/carts/by-key" && req.Method == http.MethodPost:
+		// POST /carts/by-key
+		r.cartItemHandler.GetOrCreateCart(ww, req)
+		return
+	}
+	parsed, err := urlparser.ParseCartPath(path)
+
+	route, ok := cartRoutes[cartRouteKey{parsed.Kind, req.Method}]
+	if !ok {
+		notFound(ww)
+		return
+	}
 
 	switch {
-	case len(parts) == 2 && req.Method == http.MethodGet:
-		// GET /carts/{cartId}
-		r.cartItemHandler.ViewCart(ww, req, parts[1])
-	case len(parts) == 3 && parts[2] == "items" && req.Method == http.MethodPost:
-		// POST /carts/{cartId}/items
-		r.cartItemHandler.AddToCart(ww, req, parts[1])
-	case len(parts) == 4 && parts[2] == "items" && req.Method == http.MethodDelete:
-		// DELETE /carts/{cartId}/items/{itemId}
-		r.cartItemHandler.RemoveFromCart(ww, req, parts[1], parts[3])
+	case errors.Is(err, urlparser.ErrInvalidCartID):
+		apierror.Write(ww, http.StatusBadRequest, apierror.InvalidCartID, "Invalid cart ID")
+	case errors.Is(err, urlparser.ErrInvalidItemID):
+		apierror.Write(ww, http.StatusBadRequest, apierror.InvalidItemID, "Invalid item ID")
+	case err != nil:
+		notFound(ww)
 	default:
-		http.NotFound(ww, req)
+		route.handle(r.cartItemHandler, ww, req, parsed)
+	}
+}
+
+// Operations name the cart routes, e.g. to configure per-route timeouts.
+const (
+	OpCreate  = "create"
+	OpView    = "view"
+	OpPatch   = "patch"
+	OpCopy    = "copy"
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpUpdate  = "update"
+	OpMove    = "move"
+	OpRestore = "restore"
+	OpReplace = "replace"
+	OpExport  = "export"
+	OpPurge   = "purge"
+)
+
+// Operation returns the operation served for req, or "" when req doesn't
+// match a cart route.
+func Operation(req *http.Request) string {
+	path := normalizePath(req)
+	if path == "/carts" && req.Method == http.MethodGet {
+		return OpView
+	}
+	if path == "/carts" || ((path == "/carts/batch" || path == "/carts/by-key") && req.Method == http.MethodPost) {
+		return OpCreate
 	}
+	parsed, _ := urlparser.ParseCartPath(path)
+	return cartRoutes[cartRouteKey{parsed.Kind, req.Method}].op
+}
+
+// normalizePath drops a single trailing slash so /carts/1/ is /carts/1.
+// Repeated slashes never get here, the mux redirects them to the cleaned
+// path.
+func normalizePath(req *http.Request) string {
+	return strings.TrimSuffix(req.URL.EscapedPath(), "/")
+}
+
+type cartRouteKey struct {
+	kind   urlparser.Kind
+	method string
+}
+
+type cartRoute struct {
+	op     string
+	handle func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath)
+}
+
+var cartRoutes = map[cartRouteKey]cartRoute{
+	// GET /carts/{cartId}
+	{urlparser.KindCart, http.MethodGet}: {OpView, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.ViewCart(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// DELETE /carts/{cartId}?if_empty=true
+	{urlparser.KindCart, http.MethodDelete}: {OpPurge, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.PurgeCart(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// PATCH /carts/{cartId}
+	{urlparser.KindCart, http.MethodPatch}: {OpPatch, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.PatchCart(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// POST /carts/{cartId}/copy
+	{urlparser.KindCartCopy, http.MethodPost}: {OpCopy, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.CopyCart(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// GET /carts/{cartId}/export
+	{urlparser.KindCartExport, http.MethodGet}: {OpExport, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.ExportCart(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// POST /carts/{cartId}/import
+	{urlparser.KindCartImport, http.MethodPost}: {OpAdd, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.ImportCart(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// GET /carts/{cartId}/items?fields=id
+	{urlparser.KindItems, http.MethodGet}: {OpView, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.ListItemIds(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// POST /carts/{cartId}/items
+	{urlparser.KindItems, http.MethodPost}: {OpAdd, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.AddToCart(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// PATCH /carts/{cartId}/items
+	{urlparser.KindItems, http.MethodPatch}: {OpUpdate, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.UpdateQuantities(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// PUT /carts/{cartId}/items
+	{urlparser.KindItems, http.MethodPut}: {OpReplace, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.ReplaceItems(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// POST /carts/{cartId}/items/batch
+	{urlparser.KindItemsBatch, http.MethodPost}: {OpAdd, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.AddItems(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// POST /carts/{cartId}/items/delete
+	{urlparser.KindItemsDelete, http.MethodPost}: {OpRemove, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.RemoveItems(ww, req, strconv.Itoa(p.CartID))
+	}},
+	// DELETE /carts/{cartId}/items/{itemId}
+	{urlparser.KindItem, http.MethodDelete}: {OpRemove, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.RemoveFromCart(ww, req, strconv.Itoa(p.CartID), strconv.Itoa(p.ItemID))
+	}},
+	// PATCH /carts/{cartId}/items/{itemId}
+	{urlparser.KindItem, http.MethodPatch}: {OpUpdate, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.PatchItem(ww, req, strconv.Itoa(p.CartID), strconv.Itoa(p.ItemID))
+	}},
+	// POST /carts/{cartId}/items/{itemId}/move
+	{urlparser.KindItemMove, http.MethodPost}: {OpMove, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.MoveItem(ww, req, strconv.Itoa(p.CartID), strconv.Itoa(p.ItemID))
+	}},
+	// POST /carts/{cartId}/items/{itemId}/restore
+	{urlparser.KindItemRestore, http.MethodPost}: {OpRestore, func(h *carthandler.Handler, ww http.ResponseWriter, req *http.Request, p urlparser.CartPath) {
+		h.RestoreItem(ww, req, strconv.Itoa(p.CartID), strconv.Itoa(p.ItemID))
+	}},
+}
 
+// notFound answers unknown routes with the JSON error envelope instead of
+// the plain-text http.NotFound page.
+func notFound(ww http.ResponseWriter) {
+	apierror.Write(ww, http.StatusNotFound, apierror.RouteNotFound, "route not found")
 }
@@ -0,0 +1,479 @@
+package routes_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cartapi/internal/apierror"
+	carthandler "cartapi/internal/handlers/cart"
+	"cartapi/internal/handlers/cart/mocks"
+	"cartapi/internal/middleware"
+	"cartapi/internal/models"
+	"cartapi/internal/routes"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRouter(service *mocks.Service) http.Handler {
+	return newTestRouterAt(service, "")
+}
+
+func newTestRouterAt(service *mocks.Service, basePath string) http.Handler {
+	router := routes.New(carthandler.New(slogdiscard.NewDiscardLogger(), service), nil, nil, nil, basePath)
+	router.Register()
+	return router.Handler()
+}
+
+func TestRoutes_NotFound(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{name: "Unknown top-level path", method: http.MethodGet, path: "/unknown"},
+		{name: "Unknown cart subresource", method: http.MethodGet, path: "/carts/1/unknown"},
+		{name: "Wrong method", method: http.MethodDelete, path: "/carts/1/items"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ww := httptest.NewRecorder()
+			newTestRouter(new(mocks.Service)).ServeHTTP(ww, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, http.StatusNotFound, ww.Code)
+			assert.Equal(t, "application/json", ww.Header().Get("Content-Type"))
+
+			var body apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&body))
+			assert.Equal(t, apierror.RouteNotFound, body.Error.Code)
+			assert.Equal(t, "route not found", body.Error.Message)
+		})
+	}
+}
+
+func TestRoutes_Dispatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		setupMock func(s *mocks.Service)
+	}{
+		{
+			name:   "View cart",
+			method: http.MethodGet,
+			path:   "/carts/1",
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1}, nil)
+			},
+		},
+		{
+			name:   "View carts",
+			method: http.MethodGet,
+			path:   "/carts?ids=1,2",
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCarts", mock.Anything, []int{1, 2}).Return([]models.Cart{{Id: 1}}, nil)
+			},
+		},
+		{
+			name:   "Patch cart",
+			method: http.MethodPatch,
+			path:   "/carts/1",
+			body:   `{"metadata":{"channel":"web"}}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("PatchCartMetadata", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1}, nil)
+			},
+		},
+		{
+			name:   "Copy cart",
+			method: http.MethodPost,
+			path:   "/carts/1/copy",
+			setupMock: func(s *mocks.Service) {
+				s.On("CopyCart", mock.Anything, 1, "").Return(models.Cart{Id: 2}, nil)
+			},
+		},
+		{
+			name:   "Add to cart",
+			method: http.MethodPost,
+			path:   "/carts/1/items",
+			body:   `{"product":"apple","quantity":1}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 1, mock.Anything).Return(models.CartItem{Id: 1}, nil)
+			},
+		},
+		{
+			name:   "Add items",
+			method: http.MethodPost,
+			path:   "/carts/1/items/batch",
+			body:   `{"items":[{"product":"apple","quantity":1}]}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("AddItems", mock.Anything, 1, mock.Anything).Return([]models.CartItem{{Id: 1}}, nil)
+			},
+		},
+		{
+			name:   "Remove items",
+			method: http.MethodPost,
+			path:   "/carts/1/items/delete",
+			body:   `{"item_ids":[2]}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveItems", mock.Anything, 1, []int{2}).Return([]int{2}, nil)
+			},
+		},
+		{
+			name:   "Encoded ids",
+			method: http.MethodDelete,
+			path:   "/carts/%31/items/%32",
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 2).Return(nil)
+			},
+		},
+		{
+			name:   "Remove from cart",
+			method: http.MethodDelete,
+			path:   "/carts/1/items/2",
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 2).Return(nil)
+			},
+		},
+		{
+			name:   "List item ids",
+			method: http.MethodGet,
+			path:   "/carts/1/items?fields=id",
+			setupMock: func(s *mocks.Service) {
+				s.On("ItemIds", mock.Anything, 1).Return([]int{2, 3}, nil)
+			},
+		},
+		{
+			name:   "Purge cart",
+			method: http.MethodDelete,
+			path:   "/carts/1?if_empty=true",
+			setupMock: func(s *mocks.Service) {
+				s.On("PurgeCart", mock.Anything, 1).Return(nil)
+			},
+		},
+		{
+			name:   "Move item",
+			method: http.MethodPost,
+			path:   "/carts/1/items/2/move",
+			body:   `{"target_cart_id":3}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("MoveItem", mock.Anything, 1, 2, 3).Return(models.CartItem{Id: 2, CartId: 3}, nil)
+			},
+		},
+		{
+			name:   "Create carts",
+			method: http.MethodPost,
+			path:   "/carts/batch",
+			body:   `{"count":2}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("CreateCarts", mock.Anything, 2).Return([]int{1, 2}, nil)
+			},
+		},
+		{
+			name:   "Get or create cart by key",
+			method: http.MethodPost,
+			path:   "/carts/by-key",
+			body:   `{"key":"session-abc"}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("GetOrCreateCart", mock.Anything, "session-abc", "").Return(models.Cart{Id: 1}, true, nil)
+			},
+		},
+		{
+			name:   "Restore item",
+			method: http.MethodPost,
+			path:   "/carts/1/items/2/restore",
+			setupMock: func(s *mocks.Service) {
+				s.On("RestoreItem", mock.Anything, 1, 2).Return(models.CartItem{Id: 2, CartId: 1}, nil)
+			},
+		},
+		{
+			name:   "Replace items",
+			method: http.MethodPut,
+			path:   "/carts/1/items",
+			body:   `{"items":[]}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("ReplaceItems", mock.Anything, 1, []models.CartItem{}).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
+			},
+		},
+		{
+			name:   "Update quantities",
+			method: http.MethodPatch,
+			path:   "/carts/1/items",
+			body:   `{"updates":[{"id":2,"quantity":3}]}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("UpdateQuantities", mock.Anything, 1, []models.QuantityUpdate{{ItemId: 2, Quantity: 3}}).
+					Return([]models.CartItem{{Id: 2, CartId: 1, Quantity: 3}}, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			ww := httptest.NewRecorder()
+			newTestRouter(mockService).ServeHTTP(ww, req)
+
+			assert.Less(t, ww.Code, http.StatusBadRequest, ww.Body.String())
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRoutes_InvalidIDs(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		expectedCode apierror.Code
+	}{
+		{name: "Invalid cart id", method: http.MethodGet, path: "/carts/abc", expectedCode: apierror.InvalidCartID},
+		{name: "Non-positive cart id", method: http.MethodPost, path: "/carts/0/items", expectedCode: apierror.InvalidCartID},
+		{name: "Invalid item id", method: http.MethodDelete, path: "/carts/1/items/abc", expectedCode: apierror.InvalidItemID},
+		{name: "Encoded slash in cart id", method: http.MethodGet, path: "/carts/1%2F2", expectedCode: apierror.InvalidCartID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ww := httptest.NewRecorder()
+			newTestRouter(new(mocks.Service)).ServeHTTP(ww, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, http.StatusBadRequest, ww.Code)
+			var body apierror.Response
+			require.NoError(t, json.NewDecoder(ww.Body).Decode(&body))
+			assert.Equal(t, tt.expectedCode, body.Error.Code)
+		})
+	}
+}
+
+func TestRoutes_MaxPathLength(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1}, nil)
+
+	router := routes.New(carthandler.New(slogdiscard.NewDiscardLogger(), mockService), nil, nil, nil, "")
+	router.SetMaxPathLength(64)
+	router.Register()
+	h := router.Handler()
+
+	ww := httptest.NewRecorder()
+	h.ServeHTTP(ww, httptest.NewRequest(http.MethodGet, "/carts/1/items/"+strings.Repeat("1/", 100), nil))
+	assert.Equal(t, http.StatusRequestURITooLong, ww.Code)
+	var body apierror.Response
+	require.NoError(t, json.NewDecoder(ww.Body).Decode(&body))
+	assert.Equal(t, apierror.URITooLong, body.Error.Code)
+	mockService.AssertNotCalled(t, "ViewCart", mock.Anything, mock.Anything, mock.Anything)
+
+	ww = httptest.NewRecorder()
+	h.ServeHTTP(ww, httptest.NewRequest(http.MethodGet, "/carts/1", nil))
+	assert.Equal(t, http.StatusOK, ww.Code)
+}
+
+func TestRoutes_TrailingSlash(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		setupMock func(s *mocks.Service)
+	}{
+		{
+			name:   "Carts collection",
+			method: http.MethodPost,
+			path:   "/carts/",
+			setupMock: func(s *mocks.Service) {
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{Id: 1}, nil)
+			},
+		},
+		{
+			name:   "Cart",
+			method: http.MethodGet,
+			path:   "/carts/1/",
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1}, nil)
+			},
+		},
+		{
+			name:   "Items",
+			method: http.MethodPost,
+			path:   "/carts/1/items/",
+			body:   `{"product":"apple","quantity":1}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 1, mock.Anything).Return(models.CartItem{Id: 1}, nil)
+			},
+		},
+		{
+			name:   "Item",
+			method: http.MethodDelete,
+			path:   "/carts/1/items/2/",
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 2).Return(nil)
+			},
+		},
+		{
+			name:   "Item move",
+			method: http.MethodPost,
+			path:   "/carts/1/items/2/move/",
+			body:   `{"target_cart_id":3}`,
+			setupMock: func(s *mocks.Service) {
+				s.On("MoveItem", mock.Anything, 1, 2, 3).Return(models.CartItem{Id: 2, CartId: 3}, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			ww := httptest.NewRecorder()
+			newTestRouter(mockService).ServeHTTP(ww, req)
+
+			assert.Less(t, ww.Code, http.StatusBadRequest, ww.Body.String())
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOperation(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodPost, path: "/carts", want: routes.OpCreate},
+		{method: http.MethodPost, path: "/carts/by-key", want: routes.OpCreate},
+		{method: http.MethodGet, path: "/carts", want: routes.OpView},
+		{method: http.MethodGet, path: "/carts/1", want: routes.OpView},
+		{method: http.MethodGet, path: "/carts/1/", want: routes.OpView},
+		{method: http.MethodPatch, path: "/carts/1", want: routes.OpPatch},
+		{method: http.MethodDelete, path: "/carts/1", want: routes.OpPurge},
+		{method: http.MethodPost, path: "/carts/1/copy", want: routes.OpCopy},
+		{method: http.MethodGet, path: "/carts/1/items", want: routes.OpView},
+		{method: http.MethodPost, path: "/carts/1/items", want: routes.OpAdd},
+		{method: http.MethodPost, path: "/carts/1/items/batch", want: routes.OpAdd},
+		{method: http.MethodPost, path: "/carts/1/items/delete", want: routes.OpRemove},
+		{method: http.MethodDelete, path: "/carts/1/items/2", want: routes.OpRemove},
+		{method: http.MethodPatch, path: "/carts/1/items/2", want: routes.OpUpdate},
+		{method: http.MethodPost, path: "/carts/1/items/2/move", want: routes.OpMove},
+		{method: http.MethodPost, path: "/carts/1/items/2/restore", want: routes.OpRestore},
+		{method: http.MethodPut, path: "/carts/1/items", want: routes.OpReplace},
+		{method: http.MethodPatch, path: "/carts/1/items", want: routes.OpUpdate},
+		{method: http.MethodPut, path: "/carts/1", want: ""},
+		{method: http.MethodGet, path: "/admin/db/stats", want: ""},
+		{method: http.MethodGet, path: "/admin/stats", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, routes.Operation(httptest.NewRequest(tt.method, tt.path, nil)))
+		})
+	}
+}
+
+func TestRoutes_BasePath(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		setupMock    func(s *mocks.Service)
+		expectedCode int
+	}{
+		{
+			name:   "Create cart under prefix",
+			method: http.MethodPost,
+			path:   "/api/v1/carts",
+			setupMock: func(s *mocks.Service) {
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{Id: 1}, nil)
+			},
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:   "View cart under prefix",
+			method: http.MethodGet,
+			path:   "/api/v1/carts/1",
+			setupMock: func(s *mocks.Service) {
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:   "Remove item under prefix",
+			method: http.MethodDelete,
+			path:   "/api/v1/carts/1/items/2",
+			setupMock: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 2).Return(nil)
+			},
+			expectedCode: http.StatusNoContent,
+		},
+		{name: "Without prefix", method: http.MethodGet, path: "/carts/1", setupMock: func(s *mocks.Service) {}, expectedCode: http.StatusNotFound},
+		{name: "Prefix as path segment prefix", method: http.MethodGet, path: "/api/v1carts/1", setupMock: func(s *mocks.Service) {}, expectedCode: http.StatusNotFound},
+		{name: "Prefix only", method: http.MethodGet, path: "/api/v1", setupMock: func(s *mocks.Service) {}, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(mocks.Service)
+			tt.setupMock(mockService)
+
+			ww := httptest.NewRecorder()
+			newTestRouterAt(mockService, "/api/v1/").ServeHTTP(ww, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, tt.expectedCode, ww.Code, ww.Body.String())
+			if tt.expectedCode == http.StatusNotFound {
+				var body apierror.Response
+				require.NoError(t, json.NewDecoder(ww.Body).Decode(&body))
+				assert.Equal(t, apierror.RouteNotFound, body.Error.Code)
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRoutes_BasePathMiddlewares(t *testing.T) {
+	mockService := new(mocks.Service)
+	mockService.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{Id: 1, Items: []models.CartItem{}}, nil)
+	router := routes.New(carthandler.New(slogdiscard.NewDiscardLogger(), mockService), nil, nil, nil, "/api/v1")
+	router.Register()
+
+	var order []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(ww http.ResponseWriter, req *http.Request) {
+				order = append(order, name+" "+req.URL.Path)
+				next.ServeHTTP(ww, req)
+			})
+		}
+	}
+
+	ww := httptest.NewRecorder()
+	router.Handler(record("outer"), record("inner")).ServeHTTP(ww, httptest.NewRequest(http.MethodGet, "/api/v1/carts/1", nil))
+
+	assert.Equal(t, http.StatusOK, ww.Code)
+	assert.Equal(t, []string{"outer /carts/1", "inner /carts/1"}, order)
+}
+
+func TestRoutes_HealthzWithAuth(t *testing.T) {
+	router := routes.New(carthandler.New(slogdiscard.NewDiscardLogger(), new(mocks.Service)), nil, nil, nil, "/api/v1")
+	router.Register()
+	handler := router.Handler(middleware.Auth([]string{"secret"}))
+
+	ww := httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodGet, "/api/v1/healthz", nil))
+	assert.Equal(t, http.StatusOK, ww.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, ww.Body.String())
+
+	ww = httptest.NewRecorder()
+	handler.ServeHTTP(ww, httptest.NewRequest(http.MethodGet, "/api/v1/carts/1", nil))
+	assert.Equal(t, http.StatusUnauthorized, ww.Code)
+}
@@ -5,35 +5,98 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
 	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/events"
 	"cartapi/internal/models"
 	serviceerrors "cartapi/internal/service"
+	"cartapi/internal/tracing"
 	"cartapi/pkg/lib/logger/sl"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type CartItemStorage interface {
-	CreateCart(ctx context.Context) (models.Cart, error)
+	CreateCart(ctx context.Context, userId string) (models.Cart, error)
+	GetOrCreateCart(ctx context.Context, key string, userId string) (models.Cart, bool, error)
+	CartOwner(ctx context.Context, cartId int) (string, error)
+	CreateCarts(ctx context.Context, n int) ([]int, error)
 	AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error)
 	RemoveFromCart(ctx context.Context, cartId int, itemId int) error
-	ViewCart(ctx context.Context, cartId int) (models.Cart, error)
+	GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error)
+	ItemIds(ctx context.Context, cartId int) ([]int, error)
+	UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error)
+	UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error)
+	ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error)
+	ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error)
+	StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error)
+	MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error)
+	CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error)
+	RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error)
+	AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error)
+	ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error)
+	PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error)
+	RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error)
+	PurgeCart(ctx context.Context, cartId int) error
+}
+
+type EventPublisher interface {
+	Publish(ctx context.Context, event events.Event) error
 }
 
 type CartApiService struct {
-	log     *slog.Logger
-	storage CartItemStorage
+	log       *slog.Logger
+	storage   CartItemStorage
+	publisher EventPublisher
+	tracer    trace.Tracer
+	normalize ProductNormalization
+	catalog   ProductValidator
+}
+
+type Option func(*CartApiService)
+
+// WithPublisher sets the publisher notified after successful cart mutations.
+func WithPublisher(publisher EventPublisher) Option {
+	return func(c *CartApiService) {
+		c.publisher = publisher
+	}
+}
+
+// WithTracer sets the tracer recording a span per service call.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(c *CartApiService) {
+		c.tracer = tracer
+	}
 }
 
-func New(log *slog.Logger, storage CartItemStorage) *CartApiService {
-	return &CartApiService{
-		log:     log,
-		storage: storage,
+// WithProductNormalization sets how product names are normalized before
+// they are stored and when filtering by product.
+func WithProductNormalization(normalize ProductNormalization) Option {
+	return func(c *CartApiService) {
+		c.normalize = normalize
 	}
 }
 
-func (c *CartApiService) CreateCart(ctx context.Context) (models.Cart, error) {
+func New(log *slog.Logger, storage CartItemStorage, opts ...Option) *CartApiService {
+	c := &CartApiService{
+		log:       log,
+		storage:   storage,
+		publisher: events.NopPublisher{},
+		tracer:    tracing.Noop(),
+		normalize: NormalizeNone,
+		catalog:   AcceptAllProducts{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *CartApiService) CreateCart(ctx context.Context, userId string) (models.Cart, error) {
 	const op = "service.cartapi.CreateCart"
-	log := c.log.With("op", op)
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op)
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
@@ -41,17 +104,97 @@ func (c *CartApiService) CreateCart(ctx context.Context) (models.Cart, error) {
 	default:
 	}
 
-	cart, err := c.storage.CreateCart(ctx)
+	cart, err := c.storage.CreateCart(ctx, userId)
 	if err != nil {
 		return models.Cart{}, handleDatabaseError(log, err, op, "Failed to create a cart")
 	}
 
+	c.publish(ctx, log, events.CartCreated, cart.Id, 0)
+
 	return cart, nil
 }
 
+// GetOrCreateCart returns the cart of the external key, creating it for
+// userId on first use; created reports whether it was.
+func (c *CartApiService) GetOrCreateCart(ctx context.Context, key string, userId string) (models.Cart, bool, error) {
+	const op = "service.cartapi.GetOrCreateCart"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.Cart{}, false, handleContextError(log, ctx, op)
+	default:
+	}
+
+	cart, created, err := c.storage.GetOrCreateCart(ctx, key, userId)
+	if err != nil {
+		return models.Cart{}, false, handleDatabaseError(log, err, op, "Failed to get or create a cart")
+	}
+
+	if created {
+		c.publish(ctx, log, events.CartCreated, cart.Id, 0)
+	}
+
+	return cart, created, nil
+}
+
+// AuthorizeCart checks that userId may access the cart. Carts created
+// without an owner are open to everyone.
+func (c *CartApiService) AuthorizeCart(ctx context.Context, cartId int, userId string) error {
+	const op = "service.cartapi.AuthorizeCart"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return handleContextError(log, ctx, op)
+	default:
+	}
+
+	owner, err := c.storage.CartOwner(ctx, cartId)
+	if err != nil {
+		return handleDatabaseError(log, err, op, "Failed to get cart owner")
+	}
+	if owner != "" && owner != userId {
+		log.Warn("Cart belongs to another user", slog.Int("cart_id", cartId))
+		return fmt.Errorf("%s: %w", op, serviceerrors.ErrForbidden)
+	}
+
+	return nil
+}
+
+func (c *CartApiService) CreateCarts(ctx context.Context, n int) ([]int, error) {
+	const op = "service.cartapi.CreateCarts"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return nil, handleContextError(log, ctx, op)
+	default:
+	}
+
+	ids, err := c.storage.CreateCarts(ctx, n)
+	if err != nil {
+		return nil, handleDatabaseError(log, err, op, "Failed to create carts")
+	}
+
+	for _, id := range ids {
+		c.publish(ctx, log, events.CartCreated, id, 0)
+	}
+
+	return ids, nil
+}
+
 func (c *CartApiService) AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error) {
 	const op = "service.cartapi.AddToCart"
-	log := c.log.With("op", op)
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
@@ -59,17 +202,39 @@ func (c *CartApiService) AddToCart(ctx context.Context, cartId int, item models.
 	default:
 	}
 
+	if err := checkQuantity(item.Quantity); err != nil {
+		log.Warn("Quantity is too large", slog.Int("quantity", item.Quantity))
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	item.Product = c.normalize.product(item.Product)
+	if err := c.normalize.checkNormalized(item.Product); err != nil {
+		log.Warn("Product is empty once normalized")
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := c.checkProducts(ctx, log, item.Product); err != nil {
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
 	cartItem, err := c.storage.AddToCart(ctx, cartId, item)
 	if err != nil {
+		if ctx.Err() != nil {
+			// The canceled transaction may have failed with a driver
+			// error rather than the context one.
+			return models.CartItem{}, handleContextError(log, ctx, op)
+		}
 		return models.CartItem{}, handleDatabaseError(log, err, op, "Failed to add item to cart")
 	}
 
+	c.publish(ctx, log, events.ItemAdded, cartId, cartItem.Id)
+
 	return cartItem, nil
 }
 
 func (c *CartApiService) RemoveFromCart(ctx context.Context, cartId int, itemId int) error {
 	const op = "service.cartapi.RemoveFromCart"
-	log := c.log.With("op", op)
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
@@ -79,15 +244,140 @@ func (c *CartApiService) RemoveFromCart(ctx context.Context, cartId int, itemId
 
 	err := c.storage.RemoveFromCart(ctx, cartId, itemId)
 	if err != nil {
+		if ctx.Err() != nil {
+			// The canceled transaction may have failed with a driver
+			// error rather than the context one.
+			return handleContextError(log, ctx, op)
+		}
 		return handleDatabaseError(log, err, op, "Failed to remove item from cart")
 	}
 
+	c.publish(ctx, log, events.ItemRemoved, cartId, itemId)
+
 	return nil
 }
 
-func (c *CartApiService) ViewCart(ctx context.Context, cartId int) (models.Cart, error) {
+func (c *CartApiService) GetItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "service.cartapi.GetItem"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.CartItem{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	item, err := c.storage.GetItem(ctx, cartId, itemId)
+	if err != nil {
+		return models.CartItem{}, handleDatabaseError(log, err, op, "Failed to get item")
+	}
+
+	return item, nil
+}
+
+// ItemIds returns the ids of the items of the cart.
+func (c *CartApiService) ItemIds(ctx context.Context, cartId int) ([]int, error) {
+	const op = "service.cartapi.ItemIds"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return nil, handleContextError(log, ctx, op)
+	default:
+	}
+
+	itemIds, err := c.storage.ItemIds(ctx, cartId)
+	if err != nil {
+		return nil, handleDatabaseError(log, err, op, "Failed to get item ids")
+	}
+
+	return itemIds, nil
+}
+
+// UpdateItem applies patch to the item, normalizing a new product name like
+// the one of an added item.
+func (c *CartApiService) UpdateItem(ctx context.Context, cartId int, itemId int, patch models.ItemPatch) (models.CartItem, error) {
+	const op = "service.cartapi.UpdateItem"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.CartItem{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	if patch.Quantity != nil {
+		if err := checkQuantity(*patch.Quantity); err != nil {
+			log.Warn("Quantity is too large", slog.Int("quantity", *patch.Quantity))
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if patch.Product != nil {
+		product := c.normalize.product(*patch.Product)
+		if err := c.normalize.checkNormalized(product); err != nil {
+			log.Warn("Product is empty once normalized")
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := c.checkProducts(ctx, log, product); err != nil {
+			return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+		}
+		patch.Product = &product
+	}
+
+	item, err := c.storage.UpdateItem(ctx, cartId, itemId, patch)
+	if err != nil {
+		return models.CartItem{}, handleDatabaseError(log, err, op, "Failed to update item")
+	}
+
+	c.publish(ctx, log, events.ItemUpdated, cartId, itemId)
+
+	return item, nil
+}
+
+// UpdateQuantities sets the quantities of several items of the cart in
+// one go; either every item is updated or none is.
+func (c *CartApiService) UpdateQuantities(ctx context.Context, cartId int, updates []models.QuantityUpdate) ([]models.CartItem, error) {
+	const op = "service.cartapi.UpdateQuantities"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return nil, handleContextError(log, ctx, op)
+	default:
+	}
+
+	for _, update := range updates {
+		if err := checkQuantity(update.Quantity); err != nil {
+			log.Warn("Quantity is too large", slog.Int("quantity", update.Quantity))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	items, err := c.storage.UpdateQuantities(ctx, cartId, updates)
+	if err != nil {
+		return nil, handleDatabaseError(log, err, op, "Failed to update quantities")
+	}
+
+	for _, item := range items {
+		c.publish(ctx, log, events.ItemUpdated, cartId, item.Id)
+	}
+
+	return items, nil
+}
+
+func (c *CartApiService) ViewCart(ctx context.Context, cartId int, opts models.ViewCartOptions) (models.Cart, error) {
 	const op = "service.cartapi.ViewCart"
-	log := c.log.With("op", op)
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
 
 	select {
 	case <-ctx.Done():
@@ -95,14 +385,228 @@ func (c *CartApiService) ViewCart(ctx context.Context, cartId int) (models.Cart,
 	default:
 	}
 
-	cart, err := c.storage.ViewCart(ctx, cartId)
+	opts.Product = c.normalize.product(opts.Product)
+	cart, err := c.storage.ViewCart(ctx, cartId, opts)
 	if err != nil {
 		return models.Cart{}, handleDatabaseError(log, err, op, "Failed to get items from cart")
 	}
+	// Total already counts every matching item, so this needs no query.
+	cart.Empty = cart.Total == 0 && len(cart.Items) == 0
 
 	return cart, nil
 }
 
+// ViewCarts returns the existing carts among cartIds with all of their
+// items, leaving the missing ones out.
+func (c *CartApiService) ViewCarts(ctx context.Context, cartIds []int) ([]models.Cart, error) {
+	const op = "service.cartapi.ViewCarts"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op)
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return nil, handleContextError(log, ctx, op)
+	default:
+	}
+
+	carts, err := c.storage.ViewCarts(ctx, cartIds)
+	if err != nil {
+		return nil, handleDatabaseError(log, err, op, "Failed to get carts")
+	}
+	for i := range carts {
+		carts[i].Empty = len(carts[i].Items) == 0
+	}
+
+	return carts, nil
+}
+
+// StreamCartItems is ViewCart over every matching item, handing them to
+// yield one at a time instead of returning them.
+func (c *CartApiService) StreamCartItems(ctx context.Context, cartId int, product string, yield func(models.CartItem) error) (models.Cart, error) {
+	const op = "service.cartapi.StreamCartItems"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.Cart{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	cart, err := c.storage.StreamCartItems(ctx, cartId, c.normalize.product(product), yield)
+	if err != nil {
+		return models.Cart{}, handleDatabaseError(log, err, op, "Failed to stream items from cart")
+	}
+	cart.Empty = cart.Total == 0
+
+	return cart, nil
+}
+
+func (c *CartApiService) MoveItem(ctx context.Context, cartId int, itemId int, targetCartId int) (models.CartItem, error) {
+	const op = "service.cartapi.MoveItem"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.CartItem{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	movedItem, err := c.storage.MoveItem(ctx, cartId, itemId, targetCartId)
+	if err != nil {
+		return models.CartItem{}, handleDatabaseError(log, err, op, "Failed to move item between carts")
+	}
+
+	c.publish(ctx, log, events.ItemRemoved, cartId, itemId)
+	c.publish(ctx, log, events.ItemAdded, targetCartId, itemId)
+
+	return movedItem, nil
+}
+
+func (c *CartApiService) CopyCart(ctx context.Context, cartId int, userId string) (models.Cart, error) {
+	const op = "service.cartapi.CopyCart"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.Cart{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	cart, err := c.storage.CopyCart(ctx, cartId, userId)
+	if err != nil {
+		return models.Cart{}, handleDatabaseError(log, err, op, "Failed to copy cart")
+	}
+
+	c.publish(ctx, log, events.CartCreated, cart.Id, 0)
+
+	return cart, nil
+}
+
+func (c *CartApiService) RemoveItems(ctx context.Context, cartId int, itemIds []int) ([]int, error) {
+	const op = "service.cartapi.RemoveItems"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return nil, handleContextError(log, ctx, op)
+	default:
+	}
+
+	deletedIds, err := c.storage.RemoveItems(ctx, cartId, itemIds)
+	if err != nil {
+		return nil, handleDatabaseError(log, err, op, "Failed to remove items from cart")
+	}
+
+	for _, itemId := range deletedIds {
+		c.publish(ctx, log, events.ItemRemoved, cartId, itemId)
+	}
+
+	return deletedIds, nil
+}
+
+func (c *CartApiService) AddItems(ctx context.Context, cartId int, items []models.CartItem) ([]models.CartItem, error) {
+	const op = "service.cartapi.AddItems"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return nil, handleContextError(log, ctx, op)
+	default:
+	}
+
+	for _, item := range items {
+		if err := checkQuantity(item.Quantity); err != nil {
+			log.Warn("Quantity is too large", slog.Int("quantity", item.Quantity))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	items = c.normalize.items(items)
+	if err := c.normalize.checkNormalized(products(items)...); err != nil {
+		log.Warn("Product is empty once normalized", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := c.checkProducts(ctx, log, products(items)...); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	addedItems, err := c.storage.AddItems(ctx, cartId, items)
+	if err != nil {
+		return nil, handleDatabaseError(log, err, op, "Failed to add items to cart")
+	}
+
+	for _, item := range addedItems {
+		c.publish(ctx, log, events.ItemAdded, cartId, item.Id)
+	}
+
+	return addedItems, nil
+}
+
+// ReplaceItems swaps the cart contents for items. Only the new items are
+// announced, as ItemAdded events.
+func (c *CartApiService) ReplaceItems(ctx context.Context, cartId int, items []models.CartItem) (models.Cart, error) {
+	const op = "service.cartapi.ReplaceItems"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.Cart{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	for _, item := range items {
+		if err := checkQuantity(item.Quantity); err != nil {
+			log.Warn("Quantity is too large", slog.Int("quantity", item.Quantity))
+			return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	items = c.normalize.items(items)
+	if err := c.normalize.checkNormalized(products(items)...); err != nil {
+		log.Warn("Product is empty once normalized", sl.Err(err))
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := c.checkProducts(ctx, log, products(items)...); err != nil {
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	cart, err := c.storage.ReplaceItems(ctx, cartId, items)
+	if err != nil {
+		return models.Cart{}, handleDatabaseError(log, err, op, "Failed to replace cart items")
+	}
+
+	for _, item := range cart.Items {
+		c.publish(ctx, log, events.ItemAdded, cartId, item.Id)
+	}
+
+	return cart, nil
+}
+
+// publish notifies the publisher about a mutation. A failed publish is only
+// logged: the storage change has already been made at this point.
+func (c *CartApiService) publish(ctx context.Context, log *slog.Logger, eventType events.Type, cartId int, itemId int) {
+	event := events.Event{
+		Type:      eventType,
+		CartId:    cartId,
+		ItemId:    itemId,
+		Timestamp: time.Now().UTC(),
+	}
+	if err := c.publisher.Publish(ctx, event); err != nil {
+		log.Error("Failed to publish event", slog.String("event", string(eventType)), sl.Err(err))
+	}
+}
+
 func handleContextError(log *slog.Logger, ctx context.Context, op string) error {
 	if err := ctx.Err(); err != nil {
 		if errors.Is(err, context.Canceled) {
@@ -117,6 +621,90 @@ func handleContextError(log *slog.Logger, ctx context.Context, op string) error
 	return nil
 }
 
+// PatchCartMetadata merges patch into the cart metadata; keys mapped to nil
+// are removed.
+func (c *CartApiService) PatchCartMetadata(ctx context.Context, cartId int, patch map[string]*string) (models.Cart, error) {
+	const op = "service.cartapi.PatchCartMetadata"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.Cart{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	cart, err := c.storage.PatchCartMetadata(ctx, cartId, patch)
+	if err != nil {
+		return models.Cart{}, handleDatabaseError(log, err, op, "Failed to update cart metadata")
+	}
+
+	return cart, nil
+}
+
+// RestoreItem brings back an item that was soft-deleted from the cart.
+func (c *CartApiService) RestoreItem(ctx context.Context, cartId int, itemId int) (models.CartItem, error) {
+	const op = "service.cartapi.RestoreItem"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, itemId))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return models.CartItem{}, handleContextError(log, ctx, op)
+	default:
+	}
+
+	item, err := c.storage.RestoreItem(ctx, cartId, itemId)
+	if err != nil {
+		return models.CartItem{}, handleDatabaseError(log, err, op, "Failed to restore item")
+	}
+
+	c.publish(ctx, log, events.ItemAdded, cartId, itemId)
+
+	return item, nil
+}
+
+// PurgeCart deletes the cart if it holds no items.
+func (c *CartApiService) PurgeCart(ctx context.Context, cartId int) error {
+	const op = "service.cartapi.PurgeCart"
+	log := c.logger(ctx, op)
+	ctx, span := c.tracer.Start(ctx, op, tracing.CartAttrs(cartId, 0))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		return handleContextError(log, ctx, op)
+	default:
+	}
+
+	if err := c.storage.PurgeCart(ctx, cartId); err != nil {
+		return handleDatabaseError(log, err, op, "Failed to purge cart")
+	}
+
+	return nil
+}
+
+// checkQuantity rejects quantities the storage can't hold instead of letting
+// them wrap or fail as an opaque database error.
+func checkQuantity(quantity int) error {
+	if quantity > models.MaxQuantity {
+		return serviceerrors.ErrQuantityOverflow
+	}
+	return nil
+}
+
+// logger returns the logger of a service call. When ctx has a deadline, its
+// records carry the time that was left of it when the call started.
+func (c *CartApiService) logger(ctx context.Context, op string) *slog.Logger {
+	log := c.log.With("op", op)
+	if deadline, ok := ctx.Deadline(); ok {
+		log = log.With(slog.Duration("deadline_remaining", time.Until(deadline)))
+	}
+	return log
+}
+
 func handleDatabaseError(log *slog.Logger, err error, op string, msg string) error {
 	if errors.Is(err, context.Canceled) {
 		log.Warn("context canceled", sl.Err(serviceerrors.ErrContextCanceled))
@@ -124,9 +712,30 @@ func handleDatabaseError(log *slog.Logger, err error, op string, msg string) err
 	} else if errors.Is(err, context.DeadlineExceeded) {
 		log.Warn("deadline exceeded", sl.Err(serviceerrors.ErrDeadlineExceeded))
 		return fmt.Errorf("%s: %w", op, serviceerrors.ErrDeadlineExceeded)
+	} else if errors.Is(err, databaseerrors.ErrItemNotFound) {
+		log.Warn("item not found", sl.Err(serviceerrors.ErrItemNotFound))
+		return fmt.Errorf("%s: %w", op, serviceerrors.ErrItemNotFound)
+	} else if errors.Is(err, databaseerrors.ErrCartNotFound) {
+		log.Warn("cart not found", sl.Err(serviceerrors.ErrCartNotFound))
+		return fmt.Errorf("%s: %w", op, serviceerrors.ErrCartNotFound)
 	} else if errors.Is(err, databaseerrors.ErrNotFound) {
 		log.Warn("cart not found", sl.Err(serviceerrors.ErrNotFound))
 		return fmt.Errorf("%s: %w", op, serviceerrors.ErrNotFound)
+	} else if errors.Is(err, databaseerrors.ErrCartFull) {
+		log.Warn("cart full", sl.Err(serviceerrors.ErrCartFull))
+		return fmt.Errorf("%s: %w", op, serviceerrors.ErrCartFull)
+	} else if errors.Is(err, databaseerrors.ErrCartNotEmpty) {
+		log.Warn("cart not empty", sl.Err(serviceerrors.ErrCartNotEmpty))
+		return fmt.Errorf("%s: %w", op, serviceerrors.ErrCartNotEmpty)
+	} else if errors.Is(err, databaseerrors.ErrQuantityOverflow) {
+		log.Warn("quantity overflow", sl.Err(serviceerrors.ErrQuantityOverflow))
+		return fmt.Errorf("%s: %w", op, serviceerrors.ErrQuantityOverflow)
+	} else if errors.Is(err, databaseerrors.ErrConflict) {
+		log.Warn("conflict", sl.Err(serviceerrors.ErrConflict))
+		return fmt.Errorf("%s: %w", op, serviceerrors.ErrConflict)
+	} else if databaseerrors.IsUnavailable(err) {
+		log.Error("storage unavailable", sl.Err(err))
+		return fmt.Errorf("%s: %w: %w", op, serviceerrors.ErrUnavailable, err)
 	} else {
 		log.Error(msg, sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
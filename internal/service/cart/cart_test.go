@@ -1,11 +1,19 @@
 package cartservice_test
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
 	"testing"
+	"time"
 
 	databaseerrors "cartapi/internal/database"
+	"cartapi/internal/database/memory"
+	"cartapi/internal/events"
 	"cartapi/internal/handlers/cart/mocks"
 	"cartapi/internal/models"
 	serviceerrors "cartapi/internal/service"
@@ -14,6 +22,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func newTestService(storage *mocks.Service) *cartservice.CartApiService {
@@ -32,7 +41,7 @@ func TestCreateCart(t *testing.T) {
 		{
 			name: "Success",
 			mockSetup: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{}, nil)
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, nil)
 			},
 			wantCart: models.Cart{},
 			wantErr:  false,
@@ -40,7 +49,7 @@ func TestCreateCart(t *testing.T) {
 		{
 			name: "Context canceled error",
 			mockSetup: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{}, serviceerrors.ErrContextCanceled)
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, serviceerrors.ErrContextCanceled)
 			},
 			wantErr: true,
 			errType: serviceerrors.ErrContextCanceled,
@@ -48,7 +57,7 @@ func TestCreateCart(t *testing.T) {
 		{
 			name: "Deadline exceeded error",
 			mockSetup: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{}, serviceerrors.ErrDeadlineExceeded)
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, serviceerrors.ErrDeadlineExceeded)
 			},
 			wantErr: true,
 			errType: serviceerrors.ErrDeadlineExceeded,
@@ -56,7 +65,7 @@ func TestCreateCart(t *testing.T) {
 		{
 			name: "Generic error",
 			mockSetup: func(s *mocks.Service) {
-				s.On("CreateCart", mock.Anything).Return(models.Cart{}, errors.New("error"))
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, errors.New("error"))
 			},
 			wantErr: true,
 		},
@@ -68,7 +77,7 @@ func TestCreateCart(t *testing.T) {
 			tc.mockSetup(mockStorage)
 			svc := newTestService(mockStorage)
 
-			got, err := svc.CreateCart(context.Background())
+			got, err := svc.CreateCart(context.Background(), "")
 			if tc.wantErr {
 				assert.Error(t, err)
 				if tc.errType != nil {
@@ -138,6 +147,53 @@ func TestAddToCart(t *testing.T) {
 			wantErr: true,
 			errType: serviceerrors.ErrNotFound,
 		},
+		{
+			name:   "Conflict error",
+			cartId: 1,
+			item:   models.CartItem{Id: 1, CartId: 1, Product: "item", Quantity: 10},
+			mockSetup: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 1, mock.Anything).Return(models.CartItem{}, databaseerrors.ErrConflict)
+			},
+			wantErr: true,
+			errType: serviceerrors.ErrConflict,
+		},
+		{
+			name:   "Cart full",
+			cartId: 1,
+			item:   models.CartItem{Product: "item", Quantity: 1},
+			mockSetup: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 1, mock.Anything).Return(models.CartItem{}, databaseerrors.ErrCartFull)
+			},
+			wantErr: true,
+			errType: serviceerrors.ErrCartFull,
+		},
+		{
+			name:   "Merged quantity overflows",
+			cartId: 1,
+			item:   models.CartItem{Product: "item", Quantity: models.MaxQuantity},
+			mockSetup: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 1, mock.Anything).Return(models.CartItem{}, databaseerrors.ErrQuantityOverflow)
+			},
+			wantErr: true,
+			errType: serviceerrors.ErrQuantityOverflow,
+		},
+		{
+			name:   "Max quantity accepted",
+			cartId: 1,
+			item:   models.CartItem{Product: "item", Quantity: models.MaxQuantity},
+			mockSetup: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 1, mock.Anything).Return(models.CartItem{Id: 1, CartId: 1, Product: "item", Quantity: models.MaxQuantity}, nil)
+			},
+			wantItem: models.CartItem{Id: 1, CartId: 1, Product: "item", Quantity: models.MaxQuantity},
+		},
+		{
+			name:      "Quantity overflow",
+			cartId:    1,
+			item:      models.CartItem{Product: "item", Quantity: models.MaxQuantity + 1},
+			mockSetup: func(s *mocks.Service) {},
+			wantErr:   true,
+			errType:   serviceerrors.ErrQuantityOverflow,
+		},
 	}
 
 	for _, tc := range tests {
@@ -209,6 +265,26 @@ func TestRemoveFromCart(t *testing.T) {
 			wantErr: true,
 			errType: serviceerrors.ErrNotFound,
 		},
+		{
+			name:   "ItemNotFound error",
+			cartId: 1,
+			itemId: 1,
+			mockSetup: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 1).Return(databaseerrors.ErrItemNotFound)
+			},
+			wantErr: true,
+			errType: serviceerrors.ErrItemNotFound,
+		},
+		{
+			name:   "Unavailable error",
+			cartId: 1,
+			itemId: 1,
+			mockSetup: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 1, 1).Return(fmt.Errorf("database.psql.RemoveFromCart: %w", sql.ErrConnDone))
+			},
+			wantErr: true,
+			errType: serviceerrors.ErrUnavailable,
+		},
 	}
 
 	for _, tc := range tests {
@@ -231,7 +307,41 @@ func TestRemoveFromCart(t *testing.T) {
 	}
 }
 
+func TestAuthorizeCart(t *testing.T) {
+	tests := []struct {
+		name     string
+		owner    string
+		userId   string
+		ownerErr error
+		wantErr  error
+	}{
+		{name: "Matching owner", owner: "user-1", userId: "user-1"},
+		{name: "Cart without owner", owner: "", userId: "user-2"},
+		{name: "Other owner", owner: "user-1", userId: "user-2", wantErr: serviceerrors.ErrForbidden},
+		{name: "Anonymous caller", owner: "user-1", userId: "", wantErr: serviceerrors.ErrForbidden},
+		{name: "Cart not found", ownerErr: databaseerrors.ErrCartNotFound, wantErr: serviceerrors.ErrCartNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStorage := new(mocks.Service)
+			mockStorage.On("CartOwner", mock.Anything, 1).Return(tc.owner, tc.ownerErr)
+			svc := newTestService(mockStorage)
+
+			err := svc.AuthorizeCart(context.Background(), 1, tc.userId)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
 func TestViewCart(t *testing.T) {
+	errQuery := errors.New("query error")
+
 	tests := []struct {
 		name      string
 		cartId    int
@@ -244,7 +354,7 @@ func TestViewCart(t *testing.T) {
 			name:   "Success",
 			cartId: 1,
 			mockSetup: func(s *mocks.Service) {
-				s.On("ViewCart", mock.Anything, 1).Return(models.Cart{
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{
 					Id: 1,
 					Items: []models.CartItem{
 						{Id: 2, CartId: 1, Product: "item", Quantity: 3},
@@ -263,7 +373,7 @@ func TestViewCart(t *testing.T) {
 			name:   "Context canceled error",
 			cartId: 1,
 			mockSetup: func(s *mocks.Service) {
-				s.On("ViewCart", mock.Anything, 1).Return(models.Cart{}, serviceerrors.ErrContextCanceled)
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{}, serviceerrors.ErrContextCanceled)
 			},
 			wantErr: true,
 			errType: serviceerrors.ErrContextCanceled,
@@ -272,7 +382,7 @@ func TestViewCart(t *testing.T) {
 			name:   "Deadline exceeded error",
 			cartId: 1,
 			mockSetup: func(s *mocks.Service) {
-				s.On("ViewCart", mock.Anything, 1).Return(models.Cart{}, serviceerrors.ErrDeadlineExceeded)
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{}, serviceerrors.ErrDeadlineExceeded)
 			},
 			wantErr: true,
 			errType: serviceerrors.ErrDeadlineExceeded,
@@ -281,11 +391,20 @@ func TestViewCart(t *testing.T) {
 			name:   "NotFound error",
 			cartId: 1,
 			mockSetup: func(s *mocks.Service) {
-				s.On("ViewCart", mock.Anything, 1).Return(models.Cart{}, databaseerrors.ErrNotFound)
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{}, databaseerrors.ErrNotFound)
 			},
 			wantErr: true,
 			errType: serviceerrors.ErrNotFound,
 		},
+		{
+			name:   "Query error",
+			cartId: 1,
+			mockSetup: func(s *mocks.Service) {
+				s.On("ViewCart", mock.Anything, 1, mock.Anything).Return(models.Cart{}, errQuery)
+			},
+			wantErr: true,
+			errType: errQuery,
+		},
 	}
 
 	for _, tc := range tests {
@@ -294,12 +413,15 @@ func TestViewCart(t *testing.T) {
 			tc.mockSetup(mockStorage)
 			svc := newTestService(mockStorage)
 
-			got, err := svc.ViewCart(context.Background(), tc.cartId)
+			got, err := svc.ViewCart(context.Background(), tc.cartId, models.ViewCartOptions{Limit: 50})
 			if tc.wantErr {
 				assert.Error(t, err)
 				if tc.errType != nil {
 					assert.ErrorIs(t, err, tc.errType)
 				}
+				if !errors.Is(tc.errType, serviceerrors.ErrNotFound) {
+					assert.NotErrorIs(t, err, serviceerrors.ErrNotFound)
+				}
 			} else {
 				assert.NoError(t, err)
 				assert.Equal(t, tc.wantCart, got)
@@ -308,3 +430,503 @@ func TestViewCart(t *testing.T) {
 		})
 	}
 }
+
+func TestMoveItem(t *testing.T) {
+	tests := []struct {
+		name      string
+		mockSetup func(s *mocks.Service)
+		wantItem  models.CartItem
+		wantErr   bool
+		errType   error
+	}{
+		{
+			name: "Success",
+			mockSetup: func(s *mocks.Service) {
+				s.On("MoveItem", mock.Anything, 1, 5, 2).Return(models.CartItem{Id: 5, CartId: 2, Product: "item", Quantity: 1}, nil)
+			},
+			wantItem: models.CartItem{Id: 5, CartId: 2, Product: "item", Quantity: 1},
+		},
+		{
+			name: "NotFound error",
+			mockSetup: func(s *mocks.Service) {
+				s.On("MoveItem", mock.Anything, 1, 5, 2).Return(models.CartItem{}, databaseerrors.ErrNotFound)
+			},
+			wantErr: true,
+			errType: serviceerrors.ErrNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStorage := new(mocks.Service)
+			tc.mockSetup(mockStorage)
+			svc := newTestService(mockStorage)
+
+			got, err := svc.MoveItem(context.Background(), 1, 5, 2)
+			if tc.wantErr {
+				assert.Error(t, err)
+				if tc.errType != nil {
+					assert.ErrorIs(t, err, tc.errType)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantItem, got)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestEventsPublished(t *testing.T) {
+	tests := []struct {
+		name       string
+		mockSetup  func(s *mocks.Service)
+		call       func(svc *cartservice.CartApiService) error
+		wantEvents []events.Event
+	}{
+		{
+			name: "Cart created",
+			mockSetup: func(s *mocks.Service) {
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{Id: 7}, nil)
+			},
+			call: func(svc *cartservice.CartApiService) error {
+				_, err := svc.CreateCart(context.Background(), "")
+				return err
+			},
+			wantEvents: []events.Event{{Type: events.CartCreated, CartId: 7}},
+		},
+		{
+			name: "Item added",
+			mockSetup: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 7, mock.Anything).Return(models.CartItem{Id: 3, CartId: 7}, nil)
+			},
+			call: func(svc *cartservice.CartApiService) error {
+				_, err := svc.AddToCart(context.Background(), 7, models.CartItem{Product: "item", Quantity: 1})
+				return err
+			},
+			wantEvents: []events.Event{{Type: events.ItemAdded, CartId: 7, ItemId: 3}},
+		},
+		{
+			name: "Item removed",
+			mockSetup: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 7, 3).Return(nil)
+			},
+			call: func(svc *cartservice.CartApiService) error {
+				return svc.RemoveFromCart(context.Background(), 7, 3)
+			},
+			wantEvents: []events.Event{{Type: events.ItemRemoved, CartId: 7, ItemId: 3}},
+		},
+		{
+			name: "No event on create error",
+			mockSetup: func(s *mocks.Service) {
+				s.On("CreateCart", mock.Anything, "").Return(models.Cart{}, errors.New("error"))
+			},
+			call: func(svc *cartservice.CartApiService) error {
+				_, err := svc.CreateCart(context.Background(), "")
+				return err
+			},
+		},
+		{
+			name: "No event on add error",
+			mockSetup: func(s *mocks.Service) {
+				s.On("AddToCart", mock.Anything, 7, mock.Anything).Return(models.CartItem{}, databaseerrors.ErrNotFound)
+			},
+			call: func(svc *cartservice.CartApiService) error {
+				_, err := svc.AddToCart(context.Background(), 7, models.CartItem{Product: "item", Quantity: 1})
+				return err
+			},
+		},
+		{
+			name: "No event on remove error",
+			mockSetup: func(s *mocks.Service) {
+				s.On("RemoveFromCart", mock.Anything, 7, 3).Return(errors.New("error"))
+			},
+			call: func(svc *cartservice.CartApiService) error {
+				return svc.RemoveFromCart(context.Background(), 7, 3)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStorage := new(mocks.Service)
+			tc.mockSetup(mockStorage)
+			publisher := events.NewMemoryPublisher()
+			svc := cartservice.New(slogdiscard.NewDiscardLogger(), mockStorage, cartservice.WithPublisher(publisher))
+
+			err := tc.call(svc)
+
+			got := publisher.Events()
+			if tc.wantEvents == nil {
+				assert.Error(t, err)
+				assert.Empty(t, got)
+			} else {
+				assert.NoError(t, err)
+				if assert.Len(t, got, len(tc.wantEvents)) {
+					for i, want := range tc.wantEvents {
+						assert.Equal(t, want.Type, got[i].Type)
+						assert.Equal(t, want.CartId, got[i].CartId)
+						assert.Equal(t, want.ItemId, got[i].ItemId)
+						assert.False(t, got[i].Timestamp.IsZero())
+					}
+				}
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestService_MemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	service := cartservice.New(slogdiscard.NewDiscardLogger(), memory.New(slogdiscard.NewDiscardLogger()))
+
+	cart, err := service.CreateCart(ctx, "")
+	assert.NoError(t, err)
+
+	viewed, err := service.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.NoError(t, err)
+	assert.True(t, viewed.Empty)
+	assert.Empty(t, viewed.Items)
+
+	item, err := service.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, cart.Id, item.CartId)
+
+	_, err = service.AddToCart(ctx, cart.Id, models.CartItem{Product: "pear", Quantity: 1})
+	assert.NoError(t, err)
+
+	viewed, err = service.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, viewed.Total)
+	assert.False(t, viewed.Empty)
+	assert.Equal(t, item, viewed.Items[0])
+
+	// A page past the last item has no items but isn't empty.
+	viewed, err = service.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50, Offset: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, viewed.Items)
+	assert.False(t, viewed.Empty)
+
+	assert.NoError(t, service.RemoveFromCart(ctx, cart.Id, item.Id))
+	assert.ErrorIs(t, service.RemoveFromCart(ctx, cart.Id, item.Id), serviceerrors.ErrNotFound)
+
+	viewed, err = service.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, viewed.Total)
+
+	_, err = service.ViewCart(ctx, cart.Id+1, models.ViewCartOptions{Limit: 50})
+	assert.ErrorIs(t, err, serviceerrors.ErrNotFound)
+}
+
+func TestAddItems_QuantityOverflow(t *testing.T) {
+	mockStorage := new(mocks.Service)
+	svc := newTestService(mockStorage)
+
+	_, err := svc.AddItems(context.Background(), 1, []models.CartItem{
+		{Product: "a", Quantity: models.MaxQuantity - 1},
+		{Product: "b", Quantity: models.MaxQuantity + 1},
+	})
+
+	assert.ErrorIs(t, err, serviceerrors.ErrQuantityOverflow)
+	mockStorage.AssertNotCalled(t, "AddItems", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRestoreItem(t *testing.T) {
+	tests := []struct {
+		name      string
+		mockSetup func(s *mocks.Service)
+		wantItem  models.CartItem
+		wantErr   bool
+		errType   error
+	}{
+		{
+			name: "Success",
+			mockSetup: func(s *mocks.Service) {
+				s.On("RestoreItem", mock.Anything, 1, 5).Return(models.CartItem{Id: 5, CartId: 1, Product: "item", Quantity: 1}, nil)
+			},
+			wantItem: models.CartItem{Id: 5, CartId: 1, Product: "item", Quantity: 1},
+		},
+		{
+			name: "NotFound error",
+			mockSetup: func(s *mocks.Service) {
+				s.On("RestoreItem", mock.Anything, 1, 5).Return(models.CartItem{}, databaseerrors.ErrNotFound)
+			},
+			wantErr: true,
+			errType: serviceerrors.ErrNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStorage := new(mocks.Service)
+			tc.mockSetup(mockStorage)
+			svc := newTestService(mockStorage)
+
+			got, err := svc.RestoreItem(context.Background(), 1, 5)
+			if tc.wantErr {
+				assert.Error(t, err)
+				if tc.errType != nil {
+					assert.ErrorIs(t, err, tc.errType)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantItem, got)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReplaceItems(t *testing.T) {
+	items := []models.CartItem{{Product: "a", Quantity: 1}}
+	tests := []struct {
+		name      string
+		items     []models.CartItem
+		mockSetup func(s *mocks.Service)
+		wantCart  models.Cart
+		wantErr   error
+	}{
+		{
+			name:  "Success",
+			items: items,
+			mockSetup: func(s *mocks.Service) {
+				s.On("ReplaceItems", mock.Anything, 1, items).Return(models.Cart{Id: 1, Items: []models.CartItem{{Id: 3, CartId: 1, Product: "a", Quantity: 1}}, Total: 1}, nil)
+			},
+			wantCart: models.Cart{Id: 1, Items: []models.CartItem{{Id: 3, CartId: 1, Product: "a", Quantity: 1}}, Total: 1},
+		},
+		{
+			name:  "Cart not found",
+			items: items,
+			mockSetup: func(s *mocks.Service) {
+				s.On("ReplaceItems", mock.Anything, 1, items).Return(models.Cart{}, databaseerrors.ErrCartNotFound)
+			},
+			wantErr: serviceerrors.ErrCartNotFound,
+		},
+		{
+			name:      "Quantity overflow",
+			items:     []models.CartItem{{Product: "a", Quantity: models.MaxQuantity + 1}},
+			mockSetup: func(s *mocks.Service) {},
+			wantErr:   serviceerrors.ErrQuantityOverflow,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStorage := new(mocks.Service)
+			tc.mockSetup(mockStorage)
+			svc := newTestService(mockStorage)
+
+			got, err := svc.ReplaceItems(context.Background(), 1, tc.items)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantCart, got)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCanceledMidTransaction(t *testing.T) {
+	// The driver reports its own error for a transaction aborted by the
+	// cancellation; the service still answers with ErrContextCanceled.
+	driverErr := errors.New("canceling query due to user request")
+
+	t.Run("AddToCart", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		mockStorage := new(mocks.Service)
+		mockStorage.On("AddToCart", mock.Anything, 1, mock.Anything).
+			Run(func(mock.Arguments) { cancel() }).
+			Return(models.CartItem{}, driverErr)
+
+		_, err := newTestService(mockStorage).AddToCart(ctx, 1, models.CartItem{Product: "item", Quantity: 1})
+		assert.ErrorIs(t, err, serviceerrors.ErrContextCanceled)
+	})
+
+	t.Run("RemoveFromCart", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		mockStorage := new(mocks.Service)
+		mockStorage.On("RemoveFromCart", mock.Anything, 1, 2).
+			Run(func(mock.Arguments) { cancel() }).
+			Return(driverErr)
+
+		err := newTestService(mockStorage).RemoveFromCart(ctx, 1, 2)
+		assert.ErrorIs(t, err, serviceerrors.ErrContextCanceled)
+	})
+}
+
+func TestProductNormalization(t *testing.T) {
+	products := []string{"Apple", " apple ", "apple", "APPLE"}
+	tests := []struct {
+		name      string
+		normalize cartservice.ProductNormalization
+		// groups counts the stored items per product.
+		groups map[string]int
+	}{
+		{
+			name:      "None",
+			normalize: cartservice.NormalizeNone,
+			groups:    map[string]int{"Apple": 1, " apple ": 1, "apple": 1, "APPLE": 1},
+		},
+		{
+			name:      "Trim",
+			normalize: cartservice.NormalizeTrim,
+			groups:    map[string]int{"Apple": 1, "apple": 2, "APPLE": 1},
+		},
+		{
+			name:      "Lowercase",
+			normalize: cartservice.NormalizeLowercase,
+			groups:    map[string]int{"apple": 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			log := slogdiscard.NewDiscardLogger()
+			service := cartservice.New(log, memory.New(log), cartservice.WithProductNormalization(tt.normalize))
+
+			cart, err := service.CreateCart(ctx, "")
+			require.NoError(t, err)
+			_, err = service.AddToCart(ctx, cart.Id, models.CartItem{Product: products[0], Quantity: 1})
+			require.NoError(t, err)
+			var batch []models.CartItem
+			for _, product := range products[1:] {
+				batch = append(batch, models.CartItem{Product: product, Quantity: 1})
+			}
+			_, err = service.AddItems(ctx, cart.Id, batch)
+			require.NoError(t, err)
+
+			viewed, err := service.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+			require.NoError(t, err)
+			groups := map[string]int{}
+			for _, item := range viewed.Items {
+				groups[item.Product]++
+			}
+			assert.Equal(t, tt.groups, groups)
+		})
+	}
+}
+
+func TestProductNormalization_WhiteSpaceOnly(t *testing.T) {
+	ctx := context.Background()
+	log := slogdiscard.NewDiscardLogger()
+	service := cartservice.New(log, memory.New(log), cartservice.WithProductNormalization(cartservice.NormalizeTrim))
+	cart, err := service.CreateCart(ctx, "")
+	require.NoError(t, err)
+	blank := models.CartItem{Product: "   ", Quantity: 1}
+
+	_, err = service.AddToCart(ctx, cart.Id, blank)
+	assert.ErrorIs(t, err, serviceerrors.ErrEmptyProduct)
+	_, err = service.AddItems(ctx, cart.Id, []models.CartItem{{Product: "apple", Quantity: 1}, blank})
+	assert.ErrorIs(t, err, serviceerrors.ErrEmptyProduct)
+	_, err = service.ReplaceItems(ctx, cart.Id, []models.CartItem{blank})
+	assert.ErrorIs(t, err, serviceerrors.ErrEmptyProduct)
+
+	item, err := service.AddToCart(ctx, cart.Id, models.CartItem{Product: " apple ", Quantity: 1})
+	require.NoError(t, err)
+	_, err = service.UpdateItem(ctx, cart.Id, item.Id, models.ItemPatch{Product: &blank.Product})
+	assert.ErrorIs(t, err, serviceerrors.ErrEmptyProduct)
+
+	viewed, err := service.ViewCart(ctx, cart.Id, models.ViewCartOptions{Limit: 50})
+	require.NoError(t, err)
+	require.Len(t, viewed.Items, 1)
+	assert.Equal(t, "apple", viewed.Items[0].Product)
+}
+
+func TestDeadlineRemainingLogged(t *testing.T) {
+	var logs bytes.Buffer
+	service := cartservice.New(slog.New(slog.NewJSONHandler(&logs, nil)), memory.New(slogdiscard.NewDiscardLogger()))
+
+	record := func(t *testing.T) map[string]any {
+		t.Helper()
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(logs.Bytes(), &got))
+		logs.Reset()
+		return got
+	}
+
+	t.Run("With deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		_, err := service.ViewCart(ctx, 999, models.ViewCartOptions{Limit: 50})
+		require.ErrorIs(t, err, serviceerrors.ErrNotFound)
+
+		got := record(t)
+		assert.Equal(t, "service.cartapi.ViewCart", got["op"])
+		remaining, ok := got["deadline_remaining"].(float64)
+		require.True(t, ok, "deadline_remaining missing from %v", got)
+		assert.Greater(t, time.Duration(remaining), time.Duration(0))
+		assert.LessOrEqual(t, time.Duration(remaining), time.Minute)
+	})
+
+	t.Run("Without deadline", func(t *testing.T) {
+		_, err := service.ViewCart(context.Background(), 999, models.ViewCartOptions{Limit: 50})
+		require.ErrorIs(t, err, serviceerrors.ErrNotFound)
+
+		assert.NotContains(t, record(t), "deadline_remaining")
+	})
+}
+
+// stubCatalog knows the products mapped to true and fails with err.
+type stubCatalog struct {
+	products map[string]bool
+	err      error
+}
+
+func (c stubCatalog) Exists(_ context.Context, product string) (bool, error) {
+	return c.products[product], c.err
+}
+
+func TestProductValidator(t *testing.T) {
+	ctx := context.Background()
+	log := slogdiscard.NewDiscardLogger()
+	storage := memory.New(log)
+	catalog := stubCatalog{products: map[string]bool{"apple": true, "pear": true}}
+	service := cartservice.New(log, storage,
+		cartservice.WithProductValidator(catalog),
+		cartservice.WithProductNormalization(cartservice.NormalizeLowercase),
+	)
+	cart, err := service.CreateCart(ctx, "")
+	require.NoError(t, err)
+
+	t.Run("Known product is added", func(t *testing.T) {
+		_, err := service.AddToCart(ctx, cart.Id, models.CartItem{Product: " Apple", Quantity: 1})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Unknown product is rejected", func(t *testing.T) {
+		_, err := service.AddToCart(ctx, cart.Id, models.CartItem{Product: "plum", Quantity: 1})
+		assert.ErrorIs(t, err, serviceerrors.ErrUnknownProduct)
+	})
+
+	t.Run("Batch with an unknown product adds nothing", func(t *testing.T) {
+		_, err := service.AddItems(ctx, cart.Id, []models.CartItem{{Product: "pear", Quantity: 1}, {Product: "plum", Quantity: 1}})
+		assert.ErrorIs(t, err, serviceerrors.ErrUnknownProduct)
+	})
+
+	t.Run("Update to an unknown product is rejected", func(t *testing.T) {
+		viewed, err := service.ViewCart(ctx, cart.Id, models.ViewCartOptions{})
+		require.NoError(t, err)
+		require.Len(t, viewed.Items, 1)
+		plum := "plum"
+		_, err = service.UpdateItem(ctx, cart.Id, viewed.Items[0].Id, models.ItemPatch{Product: &plum})
+		assert.ErrorIs(t, err, serviceerrors.ErrUnknownProduct)
+	})
+
+	t.Run("Catalog failure makes the service unavailable", func(t *testing.T) {
+		failing := cartservice.New(log, storage, cartservice.WithProductValidator(stubCatalog{err: errors.New("catalog down")}))
+		_, err := failing.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 1})
+		assert.ErrorIs(t, err, serviceerrors.ErrUnavailable)
+		assert.NotErrorIs(t, err, serviceerrors.ErrUnknownProduct)
+	})
+
+	t.Run("Every product is accepted by default", func(t *testing.T) {
+		_, err := cartservice.New(log, storage).AddToCart(ctx, cart.Id, models.CartItem{Product: "plum", Quantity: 1})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,60 @@
+package cartservice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"cartapi/internal/models"
+	serviceerrors "cartapi/internal/service"
+	"cartapi/pkg/lib/logger/sl"
+)
+
+// ProductValidator looks products up in an external catalog before they
+// are added to a cart.
+type ProductValidator interface {
+	Exists(ctx context.Context, product string) (bool, error)
+}
+
+// AcceptAllProducts is the ProductValidator used when no catalog is
+// configured; every product exists.
+type AcceptAllProducts struct{}
+
+func (AcceptAllProducts) Exists(context.Context, string) (bool, error) {
+	return true, nil
+}
+
+// WithProductValidator sets the catalog the products of added, replacing
+// and updated items must exist in.
+func WithProductValidator(validator ProductValidator) Option {
+	return func(c *CartApiService) {
+		c.catalog = validator
+	}
+}
+
+// checkProducts fails with ErrUnknownProduct for the first of products
+// missing from the catalog. A catalog that can't be queried makes the
+// service unavailable rather than the product unknown.
+func (c *CartApiService) checkProducts(ctx context.Context, log *slog.Logger, products ...string) error {
+	for _, product := range products {
+		exists, err := c.catalog.Exists(ctx, product)
+		if err != nil {
+			log.Error("Failed to look up the product", slog.String("product", product), sl.Err(err))
+			return fmt.Errorf("%w: %w", serviceerrors.ErrUnavailable, err)
+		}
+		if !exists {
+			log.Warn("Unknown product", slog.String("product", product))
+			return fmt.Errorf("%w: %q", serviceerrors.ErrUnknownProduct, product)
+		}
+	}
+	return nil
+}
+
+// products lists the product of every item, in order.
+func products(items []models.CartItem) []string {
+	products := make([]string, len(items))
+	for i, item := range items {
+		products[i] = item.Product
+	}
+	return products
+}
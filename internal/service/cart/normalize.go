@@ -0,0 +1,62 @@
+package cartservice
+
+import (
+	"fmt"
+	"strings"
+
+	"cartapi/internal/models"
+	serviceerrors "cartapi/internal/service"
+)
+
+// ProductNormalization rewrites product names before they are stored, so
+// that names differing only in surrounding space or case are the same
+// product.
+type ProductNormalization string
+
+const (
+	// NormalizeNone stores product names as sent.
+	NormalizeNone ProductNormalization = "none"
+	// NormalizeTrim drops leading and trailing white space.
+	NormalizeTrim ProductNormalization = "trim"
+	// NormalizeLowercase trims and lowercases product names.
+	NormalizeLowercase ProductNormalization = "lowercase"
+)
+
+func (n ProductNormalization) product(product string) string {
+	switch n {
+	case NormalizeTrim:
+		return strings.TrimSpace(product)
+	case NormalizeLowercase:
+		return strings.ToLower(strings.TrimSpace(product))
+	default:
+		return product
+	}
+}
+
+// checkNormalized rejects product names that normalization left empty, such
+// as a name of white space only under trim. The handler validates the names
+// as sent, before they are normalized, so there is nothing to check without
+// normalization.
+func (n ProductNormalization) checkNormalized(products ...string) error {
+	if n == NormalizeNone {
+		return nil
+	}
+	for i, product := range products {
+		if product == "" {
+			return fmt.Errorf("%w: item %d", serviceerrors.ErrEmptyProduct, i)
+		}
+	}
+	return nil
+}
+
+func (n ProductNormalization) items(items []models.CartItem) []models.CartItem {
+	if n == NormalizeNone {
+		return items
+	}
+	normalized := make([]models.CartItem, len(items))
+	for i, item := range items {
+		item.Product = n.product(item.Product)
+		normalized[i] = item
+	}
+	return normalized
+}
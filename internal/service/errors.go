@@ -1,9 +1,31 @@
 package serviceerrors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrNotFound         = errors.New("not found")
+	ErrNotFound = errors.New("not found")
+	// ErrCartNotFound and ErrItemNotFound tell which resource is missing;
+	// both still match ErrNotFound.
+	ErrCartNotFound     = fmt.Errorf("cart %w", ErrNotFound)
+	ErrItemNotFound     = fmt.Errorf("item %w", ErrNotFound)
+	ErrConflict         = errors.New("conflict")
 	ErrContextCanceled  = errors.New("context canceled")
 	ErrDeadlineExceeded = errors.New("deadline exceeded")
+	ErrQuantityOverflow = errors.New("quantity overflow")
+	// ErrUnavailable means the storage couldn't be reached; retrying later
+	// may succeed.
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrCartFull means the cart already holds the maximum number of items.
+	ErrCartFull = errors.New("cart full")
+	// ErrCartNotEmpty means the cart still holds items.
+	ErrCartNotEmpty = errors.New("cart not empty")
+	// ErrForbidden means the cart belongs to another user.
+	ErrForbidden = errors.New("forbidden")
+	// ErrUnknownProduct means the product isn't in the catalog.
+	ErrUnknownProduct = errors.New("unknown product")
+	// ErrEmptyProduct means the product name is empty once normalized.
+	ErrEmptyProduct = errors.New("empty product")
 )
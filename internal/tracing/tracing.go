@@ -0,0 +1,66 @@
+// Package tracing sets up the OpenTelemetry tracer provider the handler,
+// service and storage layers record their spans with.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Name is the instrumentation name every tracer of the API is created with.
+const Name = "cartapi"
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+)
+
+// Propagator reads and writes the W3C traceparent and tracestate headers.
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Noop is the tracer used until another one is injected; its spans are
+// never recorded.
+func Noop() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(Name)
+}
+
+// NewProvider builds the tracer provider for exporter. ExporterNone, or an
+// empty exporter, returns a no-op provider. The returned shutdown flushes
+// the pending spans.
+func NewProvider(exporter string, serviceName string) (trace.TracerProvider, func(context.Context) error, error) {
+	switch exporter {
+	case "", ExporterNone:
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, nil, fmt.Errorf("create stdout exporter: %w", err)
+		}
+		tp := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exp),
+			sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		)
+		return tp, tp.Shutdown, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+}
+
+// CartAttrs are the attributes identifying the cart and, when non-zero, the
+// item a span works on.
+func CartAttrs(cartId int, itemId int) trace.SpanStartOption {
+	attrs := []attribute.KeyValue{attribute.Int("cart.id", cartId)}
+	if itemId != 0 {
+		attrs = append(attrs, attribute.Int("item.id", itemId))
+	}
+	return trace.WithAttributes(attrs...)
+}
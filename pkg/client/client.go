@@ -0,0 +1,151 @@
+package client
+
+import (
+	"bytes"
+	"cartapi/internal/apierror"
+	"cartapi/internal/models"
+	serviceerrors "cartapi/internal/service"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StatusClientClosedRequest mirrors the status the API answers with when the
+// request context was canceled.
+const StatusClientClosedRequest = 499
+
+// Client calls the cart API over HTTP.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// New creates a client for the API at baseURL, e.g. "http://localhost:8080".
+// A nil httpClient uses http.DefaultClient.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// POST /carts
+func (c *Client) CreateCart(ctx context.Context) (models.Cart, error) {
+	const op = "client.CreateCart"
+
+	var cart models.Cart
+	if err := c.do(ctx, http.MethodPost, "/carts", nil, http.StatusCreated, &cart); err != nil {
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return cart, nil
+}
+
+// POST /carts/{cartId}/items
+func (c *Client) AddToCart(ctx context.Context, cartId int, item models.CartItem) (models.CartItem, error) {
+	const op = "client.AddToCart"
+
+	var inserted models.CartItem
+	path := fmt.Sprintf("/carts/%d/items", cartId)
+	if err := c.do(ctx, http.MethodPost, path, item, http.StatusCreated, &inserted); err != nil {
+		return models.CartItem{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return inserted, nil
+}
+
+// DELETE /carts/{cartId}/items/{itemId}
+func (c *Client) RemoveFromCart(ctx context.Context, cartId int, itemId int) error {
+	const op = "client.RemoveFromCart"
+
+	path := fmt.Sprintf("/carts/%d/items/%d", cartId, itemId)
+	if err := c.do(ctx, http.MethodDelete, path, nil, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// GET /carts/{cartId}
+func (c *Client) ViewCart(ctx context.Context, cartId int) (models.Cart, error) {
+	const op = "client.ViewCart"
+
+	var cart models.Cart
+	path := fmt.Sprintf("/carts/%d", cartId)
+	if err := c.do(ctx, http.MethodGet, path, nil, http.StatusOK, &cart); err != nil {
+		return models.Cart{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return cart, nil
+}
+
+// do sends body as JSON and decodes the response into out when the API
+// answers with want.
+func (c *Client) do(ctx context.Context, method, path string, body any, want int, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return statusError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// statusError maps an error response back to the service sentinel the
+// handler derived it from, using the code of the error envelope when the
+// body carries one and the status otherwise.
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	text := strings.TrimSpace(string(msg))
+
+	var envelope apierror.Response
+	if json.Unmarshal(msg, &envelope) == nil && envelope.Error.Code != "" {
+		text = envelope.Error.Message
+	}
+
+	var sentinel error
+	switch {
+	case envelope.Error.Code == apierror.ItemNotFound:
+		sentinel = serviceerrors.ErrItemNotFound
+	case envelope.Error.Code == apierror.CartNotFound:
+		sentinel = serviceerrors.ErrCartNotFound
+	case resp.StatusCode == http.StatusNotFound:
+		sentinel = serviceerrors.ErrNotFound
+	case resp.StatusCode == http.StatusConflict:
+		sentinel = serviceerrors.ErrConflict
+	case resp.StatusCode == http.StatusGatewayTimeout:
+		sentinel = serviceerrors.ErrDeadlineExceeded
+	case resp.StatusCode == StatusClientClosedRequest:
+		sentinel = serviceerrors.ErrContextCanceled
+	case resp.StatusCode == http.StatusServiceUnavailable && envelope.Error.Code == apierror.Unavailable:
+		sentinel = serviceerrors.ErrUnavailable
+	default:
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, text)
+	}
+	return fmt.Errorf("%w: %s", sentinel, text)
+}
@@ -0,0 +1,77 @@
+package client_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"cartapi/internal/database/memory"
+	carthandler "cartapi/internal/handlers/cart"
+	"cartapi/internal/models"
+	"cartapi/internal/routes"
+	serviceerrors "cartapi/internal/service"
+	cartservice "cartapi/internal/service/cart"
+	"cartapi/pkg/client"
+	"cartapi/pkg/lib/logger/slogdiscard"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) *client.Client {
+	log := slogdiscard.NewDiscardLogger()
+	router := routes.New(carthandler.New(log, cartservice.New(log, memory.New(log))), nil, nil, nil, "")
+	router.Register()
+
+	server := httptest.NewServer(router.Handler())
+	t.Cleanup(server.Close)
+
+	return client.New(server.URL, server.Client())
+}
+
+func TestClient(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	cart, err := c.CreateCart(ctx)
+	require.NoError(t, err)
+	assert.Positive(t, cart.Id)
+
+	item, err := c.AddToCart(ctx, cart.Id, models.CartItem{Product: "apple", Quantity: 2})
+	require.NoError(t, err)
+	assert.Equal(t, cart.Id, item.CartId)
+	assert.Equal(t, "apple", item.Product)
+
+	viewed, err := c.ViewCart(ctx, cart.Id)
+	require.NoError(t, err)
+	assert.Equal(t, []models.CartItem{item}, viewed.Items)
+
+	require.NoError(t, c.RemoveFromCart(ctx, cart.Id, item.Id))
+
+	viewed, err = c.ViewCart(ctx, cart.Id)
+	require.NoError(t, err)
+	assert.Empty(t, viewed.Items)
+}
+
+func TestClient_Errors(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := c.ViewCart(ctx, 42)
+	assert.ErrorIs(t, err, serviceerrors.ErrNotFound)
+
+	_, err = c.AddToCart(ctx, 42, models.CartItem{Product: "apple", Quantity: 1})
+	assert.ErrorIs(t, err, serviceerrors.ErrNotFound)
+
+	cart, err := c.CreateCart(ctx)
+	require.NoError(t, err)
+
+	err = c.RemoveFromCart(ctx, cart.Id, 42)
+	assert.ErrorIs(t, err, serviceerrors.ErrItemNotFound)
+	assert.NotErrorIs(t, err, serviceerrors.ErrCartNotFound)
+
+	_, err = c.AddToCart(ctx, cart.Id, models.CartItem{Product: "", Quantity: 1})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, serviceerrors.ErrNotFound)
+	assert.Contains(t, err.Error(), "400")
+}
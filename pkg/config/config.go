@@ -1,8 +1,12 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,16 +18,163 @@ type PsqlConfig struct {
 	Port     int    `mapstructure:"port"`
 	Database string `mapstructure:"database"`
 	Sslmode  string `mapstructure:"sslmode"`
+	// ConnectAttempts is how many times connecting is tried at startup.
+	ConnectAttempts int `mapstructure:"connect_attempts"`
+	// ConnectBackoff is the wait after the first failed attempt; it doubles
+	// after every further failure.
+	ConnectBackoff time.Duration `mapstructure:"connect_backoff"`
+}
+
+type SQLiteConfig struct {
+	// Path is the database file; ":memory:" keeps it in memory.
+	Path string `mapstructure:"path"`
 }
 
 type HTTPConfig struct {
-	Env  string `mapstructure:"env"`
-	Port int    `mapstructure:"port"`
+	Env string `mapstructure:"env"`
+	// Host restricts the listen address, e.g. to 127.0.0.1. Empty listens on
+	// all interfaces.
+	Host   string `mapstructure:"host"`
+	Port   int    `mapstructure:"port"`
+	Output string `mapstructure:"output"`
+	// LogLevel overrides the level derived from Env when set.
+	LogLevel        string        `mapstructure:"log_level"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	TLSCertFile     string        `mapstructure:"tls_cert_file"`
+	TLSKeyFile      string        `mapstructure:"tls_key_file"`
+	// ReadOnly starts the API rejecting cart writes with 503.
+	ReadOnly bool `mapstructure:"read_only"`
+	// BasePath mounts every route under a prefix such as /api/v1.
+	BasePath string `mapstructure:"base_path"`
+	// TrustedProxies lists the CIDR ranges of the reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers name the client. Requests from
+	// any other peer are attributed to the peer.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// MaxConcurrentRequests answers 503 to requests arriving while this
+	// many are being served. Zero means no limit.
+	MaxConcurrentRequests int `mapstructure:"max_concurrent_requests"`
+	// MaxPathLength answers 414 to cart requests with a longer URL path.
+	// Zero means no limit.
+	MaxPathLength int `mapstructure:"max_path_length"`
+}
+
+// Addr is the host:port address the server listens on.
+func (c HTTPConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+func (c HTTPConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// AuthConfig lists the bearer tokens accepted by the API. No tokens turns
+// authentication off.
+type AuthConfig struct {
+	Tokens []string `mapstructure:"tokens"`
+}
+
+// SignatureConfig turns on HMAC-SHA256 verification of request bodies
+// against the X-Signature header.
+type SignatureConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Secret  string `mapstructure:"secret"`
+}
+
+// TracingConfig picks where spans are exported. The none exporter, the
+// default, records nothing.
+type TracingConfig struct {
+	Exporter    string `mapstructure:"exporter"`
+	ServiceName string `mapstructure:"service_name"`
+}
+
+type AdminConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// CleanupConfig controls the job deleting abandoned carts. A zero TTL
+// disables it.
+type CleanupConfig struct {
+	CartTTL  time.Duration `mapstructure:"cart_ttl"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// WebhookConfig sends the cart events to URL as they happen. An empty URL
+// disables it; no events means CartCreated, ItemAdded and ItemRemoved.
+type WebhookConfig struct {
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"`
+	Events []string `mapstructure:"events"`
+	// Timeout bounds each delivery attempt; Backoff, doubled after every
+	// failed one, separates the Attempts. Events published while MaxPending
+	// deliveries are in flight are dropped.
+	Timeout    time.Duration `mapstructure:"timeout"`
+	Attempts   int           `mapstructure:"attempts"`
+	Backoff    time.Duration `mapstructure:"backoff"`
+	MaxPending int           `mapstructure:"max_pending"`
+}
+
+// SQLLogConfig controls logging of every SQL statement with its arguments,
+// which only happens when the log level is debug. RedactProducts hides the
+// text arguments, product names among them.
+type SQLLogConfig struct {
+	Enabled        bool `mapstructure:"enabled"`
+	RedactProducts bool `mapstructure:"redact_products"`
+}
+
+// BodyLogConfig controls logging of request and response bodies, which only
+// happens when the log level is debug.
+type BodyLogConfig struct {
+	MaxBytes     int      `mapstructure:"max_bytes"`
+	RedactFields []string `mapstructure:"redact_fields"`
 }
 
 type Config struct {
-	HTTP HTTPConfig `mapstructure:"http"`
-	Psql PsqlConfig `mapstructure:"psql_conn"`
+	Storage string `mapstructure:"storage"`
+	// QueryTimeout caps every database call, even for requests without a
+	// deadline. Zero leaves them unbounded.
+	QueryTimeout time.Duration `mapstructure:"query_timeout"`
+	// SlowQueryThreshold logs a warning for every database call lasting at
+	// least this long. Zero disables the warning.
+	SlowQueryThreshold time.Duration `mapstructure:"slow_query_threshold"`
+	// SoftDelete marks removed items with deleted_at instead of deleting them.
+	SoftDelete bool `mapstructure:"soft_delete"`
+	// MaxItemsPerCart caps the number of items a cart may hold. Zero means
+	// no limit.
+	MaxItemsPerCart int `mapstructure:"max_items_per_cart"`
+	// UniqueItems merges an added item into the live item of the same
+	// product instead of storing a duplicate. Batch additions, imports,
+	// replacements and restores that would store a duplicate answer 409.
+	UniqueItems bool `mapstructure:"unique_items"`
+	// DefaultQuantity fills in a missing or zero quantity of an added item.
+	// Zero keeps the quantity required.
+	DefaultQuantity int `mapstructure:"default_quantity"`
+	// IdempotentRemove answers 204 instead of 404 when the item to remove is
+	// already gone, so that retried removals succeed.
+	IdempotentRemove bool `mapstructure:"idempotent_remove"`
+	// JSONNaming is the case of the cart and item field names in responses:
+	// snake_case or camelCase.
+	JSONNaming string `mapstructure:"json_naming"`
+	// ProductNormalization rewrites product names before they are stored:
+	// none, trim or lowercase, which also trims.
+	ProductNormalization string `mapstructure:"product_normalization"`
+	// EnforceOwnership restricts carts created with an X-User-Id to that
+	// user. Carts without an owner stay open to everyone.
+	EnforceOwnership bool            `mapstructure:"enforce_ownership"`
+	HTTP             HTTPConfig      `mapstructure:"http"`
+	Psql             PsqlConfig      `mapstructure:"psql_conn"`
+	SQLite           SQLiteConfig    `mapstructure:"sqlite"`
+	Admin            AdminConfig     `mapstructure:"admin"`
+	Auth             AuthConfig      `mapstructure:"auth"`
+	Signature        SignatureConfig `mapstructure:"signature"`
+	Cleanup          CleanupConfig   `mapstructure:"cleanup"`
+	BodyLog          BodyLogConfig   `mapstructure:"body_log"`
+	SQLLog           SQLLogConfig    `mapstructure:"sql_log"`
+	Webhook          WebhookConfig   `mapstructure:"webhook"`
+	Tracing          TracingConfig   `mapstructure:"tracing"`
+	// Timeouts bounds requests per cart operation (create, view, patch,
+	// copy, add, remove, update, move, restore, replace, export, purge).
+	// Operations left out are unbounded.
+	Timeouts map[string]time.Duration `mapstructure:"timeouts"`
 }
 
 func Load() (*Config, error) {
@@ -31,6 +182,24 @@ func Load() (*Config, error) {
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 
+	viper.SetDefault("storage", StoragePostgres)
+	viper.SetDefault("json_naming", JSONNamingSnake)
+	viper.SetDefault("product_normalization", ProductNormalizationNone)
+	viper.SetDefault("psql_conn.connect_attempts", 5)
+	viper.SetDefault("psql_conn.connect_backoff", time.Second)
+	viper.SetDefault("sqlite.path", "cartapi.db")
+	viper.SetDefault("http.shutdown_timeout", 5*time.Second)
+	viper.SetDefault("http.max_path_length", 2048)
+	viper.SetDefault("cleanup.interval", time.Hour)
+	viper.SetDefault("body_log.max_bytes", 4096)
+	viper.SetDefault("sql_log.redact_products", true)
+	viper.SetDefault("webhook.timeout", 5*time.Second)
+	viper.SetDefault("webhook.attempts", 3)
+	viper.SetDefault("webhook.backoff", time.Second)
+	viper.SetDefault("webhook.max_pending", 64)
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("tracing.service_name", "cartapi")
+
 	err := viper.ReadInConfig()
 	if err != nil {
 		log.Printf("Error reading config file, %s\n", err)
@@ -44,6 +213,41 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	if cfg.Signature.Enabled && cfg.Signature.Secret == "" {
+		return nil, errors.New("signature.secret must be set when signature.enabled is true")
+	}
+
+	if cfg.JSONNaming != JSONNamingSnake && cfg.JSONNaming != JSONNamingCamel {
+		return nil, fmt.Errorf("json_naming must be %s or %s, got %q", JSONNamingSnake, JSONNamingCamel, cfg.JSONNaming)
+	}
+
+	if cfg.HTTP.MaxConcurrentRequests < 0 {
+		return nil, fmt.Errorf("http.max_concurrent_requests must not be negative, got %d", cfg.HTTP.MaxConcurrentRequests)
+	}
+
+	if cfg.HTTP.MaxPathLength < 0 {
+		return nil, fmt.Errorf("http.max_path_length must not be negative, got %d", cfg.HTTP.MaxPathLength)
+	}
+
+	if cfg.DefaultQuantity < 0 {
+		return nil, fmt.Errorf("default_quantity must not be negative, got %d", cfg.DefaultQuantity)
+	}
+
+	if cfg.Cleanup.CartTTL < 0 {
+		return nil, fmt.Errorf("cleanup.cart_ttl must not be negative, got %s", cfg.Cleanup.CartTTL)
+	}
+
+	if cfg.Cleanup.CartTTL > 0 && cfg.Cleanup.Interval <= 0 {
+		return nil, fmt.Errorf("cleanup.interval must be positive when cleanup.cart_ttl is set, got %s", cfg.Cleanup.Interval)
+	}
+
+	switch cfg.ProductNormalization {
+	case ProductNormalizationNone, ProductNormalizationTrim, ProductNormalizationLowercase:
+	default:
+		return nil, fmt.Errorf("product_normalization must be %s, %s or %s, got %q",
+			ProductNormalizationNone, ProductNormalizationTrim, ProductNormalizationLowercase, cfg.ProductNormalization)
+	}
+
 	return &cfg, nil
 }
 
@@ -0,0 +1,27 @@
+package config_test
+
+import (
+	"testing"
+
+	"cartapi/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPConfig_Addr(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.HTTPConfig
+		want string
+	}{
+		{name: "All interfaces", cfg: config.HTTPConfig{Port: 8080}, want: ":8080"},
+		{name: "Localhost", cfg: config.HTTPConfig{Host: "127.0.0.1", Port: 8080}, want: "127.0.0.1:8080"},
+		{name: "IPv6", cfg: config.HTTPConfig{Host: "::1", Port: 9000}, want: "[::1]:9000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cfg.Addr())
+		})
+	}
+}
@@ -4,4 +4,25 @@ var (
 	EnvLocal = "local"
 	EnvDev   = "dev"
 	EnvProd  = "prod"
+	// EnvText logs in logfmt via slog.TextHandler.
+	EnvText = "text"
+)
+
+var (
+	StoragePostgres = "postgres"
+	// StorageMemory keeps carts in process memory; nothing survives a restart.
+	StorageMemory = "memory"
+	// StorageSQLite stores carts in an embedded SQLite database file.
+	StorageSQLite = "sqlite"
+)
+
+var (
+	JSONNamingSnake = "snake_case"
+	JSONNamingCamel = "camelCase"
+)
+
+var (
+	ProductNormalizationNone      = "none"
+	ProductNormalizationTrim      = "trim"
+	ProductNormalizationLowercase = "lowercase"
 )
@@ -12,6 +12,8 @@ import (
 
 type PrettyHandlerOptions struct {
 	SlogOpts *slog.HandlerOptions
+	// NoColor disables ANSI color codes, e.g. when logs go to a file or CI.
+	NoColor bool
 }
 
 type PrettyHandler struct {
@@ -25,6 +27,7 @@ func (opts PrettyHandlerOptions) NewPrettyHandler(
 	out io.Writer,
 ) *PrettyHandler {
 	h := &PrettyHandler{
+		opts:    opts,
 		Handler: slog.NewJSONHandler(out, opts.SlogOpts),
 		l:       stdLog.New(out, "", 0),
 	}
@@ -37,13 +40,13 @@ func (h *PrettyHandler) Handle(_ context.Context, r slog.Record) error {
 
 	switch r.Level {
 	case slog.LevelDebug:
-		level = color.MagentaString(level)
+		level = h.colorize(color.FgMagenta, level)
 	case slog.LevelInfo:
-		level = color.BlueString(level)
+		level = h.colorize(color.FgBlue, level)
 	case slog.LevelWarn:
-		level = color.YellowString(level)
+		level = h.colorize(color.FgYellow, level)
 	case slog.LevelError:
-		level = color.RedString(level)
+		level = h.colorize(color.FgRed, level)
 	}
 
 	fields := make(map[string]interface{}, r.NumAttrs())
@@ -69,20 +72,28 @@ func (h *PrettyHandler) Handle(_ context.Context, r slog.Record) error {
 	}
 
 	timeStr := r.Time.Format("[15:05:05.000]")
-	msg := color.CyanString(r.Message)
+	msg := h.colorize(color.FgCyan, r.Message)
 
 	h.l.Println(
 		timeStr,
 		level,
 		msg,
-		color.WhiteString(string(b)),
+		h.colorize(color.FgWhite, string(b)),
 	)
 
 	return nil
 }
 
+func (h *PrettyHandler) colorize(attr color.Attribute, s string) string {
+	if h.opts.NoColor {
+		return s
+	}
+	return color.New(attr).Sprint(s)
+}
+
 func (h *PrettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &PrettyHandler{
+		opts:    h.opts,
 		Handler: h.Handler,
 		l:       h.l,
 		attrs:   attrs,
@@ -92,6 +103,7 @@ func (h *PrettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 func (h *PrettyHandler) WithGroup(name string) slog.Handler {
 	// TODO: implement
 	return &PrettyHandler{
+		opts:    h.opts,
 		Handler: h.Handler.WithGroup(name),
 		l:       h.l,
 	}
@@ -0,0 +1,43 @@
+package slogpretty_test
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"cartapi/pkg/lib/logger/handler/slogpretty"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrettyHandler_NoColor(t *testing.T) {
+	forcedColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = forcedColor }()
+
+	tests := []struct {
+		name       string
+		noColor    bool
+		wantEscape bool
+	}{
+		{name: "Colored", noColor: false, wantEscape: true},
+		{name: "NoColor", noColor: true, wantEscape: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := slogpretty.PrettyHandlerOptions{
+				SlogOpts: &slog.HandlerOptions{Level: slog.LevelDebug},
+				NoColor:  tt.noColor,
+			}
+			log := slog.New(opts.NewPrettyHandler(&buf)).With(slog.String("op", "test"))
+
+			log.Warn("message", slog.Int("cart_id", 1))
+
+			assert.Contains(t, buf.String(), "message")
+			assert.Equal(t, tt.wantEscape, bytes.Contains(buf.Bytes(), []byte("\x1b[")))
+		})
+	}
+}
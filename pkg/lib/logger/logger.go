@@ -1,43 +1,122 @@
 package logger
 
 import (
-	constants "cartapi/pkg/config"
+	"cartapi/pkg/config"
 	"cartapi/pkg/lib/logger/handler/slogpretty"
 	"errors"
+	"fmt"
+	"io"
 
 	"log/slog"
 	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
 )
 
-func SetupLogger(env string) (*slog.Logger, error) {
+func SetupLogger(cfg config.HTTPConfig) (*slog.Logger, error) {
+	out, err := OpenOutput(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLogger(cfg.Env, cfg.LogLevel, out)
+}
+
+// NewLogger builds the logger for env writing to out. A non-empty level
+// overrides the level derived from env.
+func NewLogger(env string, level string, out io.Writer) (*slog.Logger, error) {
 	var log *slog.Logger
 
+	var envLevel slog.Level
+	switch env {
+	case config.EnvLocal, config.EnvDev:
+		envLevel = slog.LevelDebug
+	case config.EnvProd, config.EnvText:
+		envLevel = slog.LevelInfo
+	default:
+		return nil, errors.New("failed to init logger: wrong env variable")
+	}
+
+	if level != "" {
+		parsed, err := ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		envLevel = parsed
+	}
+
 	switch env {
-	case constants.EnvLocal:
-		log = setupPrettySlog()
-	case constants.EnvDev:
+	case config.EnvLocal:
+		log = setupPrettySlog(out, envLevel)
+	case config.EnvText:
 		log = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
+			slog.NewTextHandler(out, &slog.HandlerOptions{Level: envLevel}),
 		)
-	case constants.EnvProd:
+	default:
 		log = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
+			slog.NewJSONHandler(out, &slog.HandlerOptions{Level: envLevel}),
 		)
-	default:
-		return nil, errors.New("failed to init logger: wrong env variable")
 	}
 
 	return log, nil
 }
 
-func setupPrettySlog() *slog.Logger {
+// ParseLevel converts a config log level (debug, info, warn or error) to a
+// slog level.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("failed to init logger: unknown log level %q, expected debug, info, warn or error", level)
+	}
+}
+
+// OpenOutput resolves the configured log destination: stdout (the default),
+// stderr or a file path that is created or appended to.
+func OpenOutput(output string) (io.Writer, error) {
+	switch output {
+	case "", OutputStdout:
+		return os.Stdout, nil
+	case OutputStderr:
+		return os.Stderr, nil
+	default:
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init logger: cannot open log output %q: %w", output, err)
+		}
+		return file, nil
+	}
+}
+
+func setupPrettySlog(out io.Writer, level slog.Level) *slog.Logger {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
-			Level: slog.LevelDebug,
+			Level: level,
 		},
+		NoColor: !isTerminal(out),
 	}
 
-	handler := opts.NewPrettyHandler(os.Stdout)
+	handler := opts.NewPrettyHandler(out)
 
 	return slog.New(handler)
 }
+
+// isTerminal reports whether out is a terminal, so colors are only emitted
+// where they can be rendered.
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
@@ -0,0 +1,113 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cartapi/pkg/config"
+	"cartapi/pkg/lib/logger"
+	"cartapi/pkg/lib/logger/handler/slogpretty"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    *os.File
+		wantErr bool
+	}{
+		{name: "Default", output: "", want: os.Stdout},
+		{name: "Stdout", output: logger.OutputStdout, want: os.Stdout},
+		{name: "Stderr", output: logger.OutputStderr, want: os.Stderr},
+		{name: "Unopenable file", output: filepath.Join(t.TempDir(), "missing", "app.log"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := logger.OpenOutput(tt.output)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+func TestSetupLogger_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	log, err := logger.SetupLogger(config.HTTPConfig{Env: config.EnvProd, Output: path})
+	assert.NoError(t, err)
+	log.Info("written to file")
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "written to file")
+}
+
+func TestNewLogger_Buffer(t *testing.T) {
+	for _, env := range []string{config.EnvLocal, config.EnvDev, config.EnvProd, config.EnvText} {
+		t.Run(env, func(t *testing.T) {
+			var buf bytes.Buffer
+			log, err := logger.NewLogger(env, "", &buf)
+			assert.NoError(t, err)
+
+			log.Info("hello")
+			assert.Contains(t, buf.String(), "hello")
+		})
+	}
+
+	_, err := logger.NewLogger("unknown", "", &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestNewLogger_LevelOverride(t *testing.T) {
+	log, err := logger.NewLogger(config.EnvProd, "", &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.False(t, log.Enabled(context.Background(), slog.LevelDebug))
+
+	log, err = logger.NewLogger(config.EnvProd, "debug", &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.True(t, log.Enabled(context.Background(), slog.LevelDebug))
+
+	log, err = logger.NewLogger(config.EnvDev, "error", &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.False(t, log.Enabled(context.Background(), slog.LevelWarn))
+
+	_, err = logger.NewLogger(config.EnvProd, "verbose", &bytes.Buffer{})
+	assert.ErrorContains(t, err, "unknown log level")
+}
+
+func TestNewLogger_HandlerType(t *testing.T) {
+	tests := []struct {
+		env  string
+		want slog.Handler
+	}{
+		{env: config.EnvLocal, want: &slogpretty.PrettyHandler{}},
+		{env: config.EnvDev, want: &slog.JSONHandler{}},
+		{env: config.EnvProd, want: &slog.JSONHandler{}},
+		{env: config.EnvText, want: &slog.TextHandler{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			log, err := logger.NewLogger(tt.env, "", &bytes.Buffer{})
+			assert.NoError(t, err)
+			assert.IsType(t, tt.want, log.Handler())
+		})
+	}
+
+	var buf bytes.Buffer
+	log, err := logger.NewLogger(config.EnvText, "", &buf)
+	assert.NoError(t, err)
+	log.Info("hello", slog.String("cart", "1"))
+	assert.Contains(t, buf.String(), `msg=hello cart=1`)
+}
@@ -0,0 +1,141 @@
+package urlparser
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotCartPath   = errors.New("not a cart path")
+	ErrEmptySegment  = errors.New("empty path segment")
+	ErrBadEscape     = errors.New("malformed percent-encoding in path")
+	ErrUnknownRoute  = errors.New("unknown cart route")
+	ErrInvalidCartID = errors.New("invalid cart id")
+	ErrInvalidItemID = errors.New("invalid item id")
+)
+
+// Kind identifies which cart resource a path addresses.
+type Kind int
+
+const (
+	KindUnknown Kind = iota
+	// KindCart is /carts/{cartId}.
+	KindCart
+	// KindCartCopy is /carts/{cartId}/copy.
+	KindCartCopy
+	// KindItems is /carts/{cartId}/items.
+	KindItems
+	// KindItemsBatch is /carts/{cartId}/items/batch.
+	KindItemsBatch
+	// KindItemsDelete is /carts/{cartId}/items/delete.
+	KindItemsDelete
+	// KindItem is /carts/{cartId}/items/{itemId}.
+	KindItem
+	// KindItemMove is /carts/{cartId}/items/{itemId}/move.
+	KindItemMove
+	// KindItemRestore is /carts/{cartId}/items/{itemId}/restore.
+	KindItemRestore
+	// KindCartExport is /carts/{cartId}/export.
+	KindCartExport
+	// KindCartImport is /carts/{cartId}/import.
+	KindCartImport
+)
+
+// CartPath is a parsed /carts/{cartId}/... path.
+type CartPath struct {
+	Kind   Kind
+	CartID int
+	// ItemID is only set for KindItem, KindItemMove and KindItemRestore.
+	ItemID int
+}
+
+// ParseCartPath parses path as /carts/{cartId} followed by one of the known
+// sub-resources. Ids must be positive integers and no segment may be empty,
+// so /carts//items and /carts/1/ are rejected.
+//
+// path is the escaped form (url.URL.EscapedPath) so that an encoded slash
+// stays inside its segment; each segment is unescaped before it is matched.
+//
+// When only an id is invalid the returned CartPath still carries the Kind,
+// so callers can tell a bad id on a real route from an unknown route.
+func ParseCartPath(path string) (CartPath, error) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if parts[0] != "carts" || len(parts) < 2 {
+		return CartPath{}, ErrNotCartPath
+	}
+
+	for i, part := range parts[1:] {
+		if part == "" {
+			return CartPath{}, ErrEmptySegment
+		}
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return CartPath{}, ErrBadEscape
+		}
+		parts[i+1] = unescaped
+	}
+
+	kind := kindOf(parts[2:])
+	if kind == KindUnknown {
+		return CartPath{}, ErrUnknownRoute
+	}
+
+	parsed := CartPath{Kind: kind}
+
+	cartId, ok := parseID(parts[1])
+	if !ok {
+		return parsed, ErrInvalidCartID
+	}
+	parsed.CartID = cartId
+
+	if kind == KindItem || kind == KindItemMove || kind == KindItemRestore {
+		itemId, ok := parseID(parts[3])
+		if !ok {
+			return parsed, ErrInvalidItemID
+		}
+		parsed.ItemID = itemId
+	}
+
+	return parsed, nil
+}
+
+func kindOf(rest []string) Kind {
+	switch {
+	case len(rest) == 0:
+		return KindCart
+	case len(rest) == 1 && rest[0] == "copy":
+		return KindCartCopy
+	case len(rest) == 1 && rest[0] == "export":
+		return KindCartExport
+	case len(rest) == 1 && rest[0] == "import":
+		return KindCartImport
+	case len(rest) == 1 && rest[0] == "items":
+		return KindItems
+	case len(rest) == 2 && rest[0] == "items" && rest[1] == "batch":
+		return KindItemsBatch
+	case len(rest) == 2 && rest[0] == "items" && rest[1] == "delete":
+		return KindItemsDelete
+	case len(rest) == 2 && rest[0] == "items":
+		return KindItem
+	case len(rest) == 3 && rest[0] == "items" && rest[2] == "move":
+		return KindItemMove
+	case len(rest) == 3 && rest[0] == "items" && rest[2] == "restore":
+		return KindItemRestore
+	}
+	return KindUnknown
+}
+
+// parseID accepts only plain digits, so signs and anything that decoded from
+// an escape such as "1/2" are rejected.
+func parseID(s string) (int, bool) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, false
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
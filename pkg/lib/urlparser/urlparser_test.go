@@ -0,0 +1,55 @@
+package urlparser_test
+
+import (
+	"testing"
+
+	"cartapi/pkg/lib/urlparser"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCartPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		expected    urlparser.CartPath
+		expectedErr error
+	}{
+		{name: "Cart", path: "/carts/1", expected: urlparser.CartPath{Kind: urlparser.KindCart, CartID: 1}},
+		{name: "Copy", path: "/carts/1/copy", expected: urlparser.CartPath{Kind: urlparser.KindCartCopy, CartID: 1}},
+		{name: "Export", path: "/carts/1/export", expected: urlparser.CartPath{Kind: urlparser.KindCartExport, CartID: 1}},
+		{name: "Import", path: "/carts/1/import", expected: urlparser.CartPath{Kind: urlparser.KindCartImport, CartID: 1}},
+		{name: "Items", path: "/carts/1/items", expected: urlparser.CartPath{Kind: urlparser.KindItems, CartID: 1}},
+		{name: "Items batch", path: "/carts/1/items/batch", expected: urlparser.CartPath{Kind: urlparser.KindItemsBatch, CartID: 1}},
+		{name: "Items delete", path: "/carts/1/items/delete", expected: urlparser.CartPath{Kind: urlparser.KindItemsDelete, CartID: 1}},
+		{name: "Item", path: "/carts/7/items/42", expected: urlparser.CartPath{Kind: urlparser.KindItem, CartID: 7, ItemID: 42}},
+		{name: "Item move", path: "/carts/7/items/42/move", expected: urlparser.CartPath{Kind: urlparser.KindItemMove, CartID: 7, ItemID: 42}},
+		{name: "Item restore", path: "/carts/7/items/42/restore", expected: urlparser.CartPath{Kind: urlparser.KindItemRestore, CartID: 7, ItemID: 42}},
+		{name: "Zero id", path: "/carts/0", expected: urlparser.CartPath{Kind: urlparser.KindCart}, expectedErr: urlparser.ErrInvalidCartID},
+		{name: "Negative id", path: "/carts/-1", expected: urlparser.CartPath{Kind: urlparser.KindCart}, expectedErr: urlparser.ErrInvalidCartID},
+		{name: "Non-numeric id", path: "/carts/abc/items", expected: urlparser.CartPath{Kind: urlparser.KindItems}, expectedErr: urlparser.ErrInvalidCartID},
+		{name: "Invalid item id", path: "/carts/1/items/0", expected: urlparser.CartPath{Kind: urlparser.KindItem, CartID: 1}, expectedErr: urlparser.ErrInvalidItemID},
+		{name: "Encoded digits", path: "/carts/%31%32/items/%34", expected: urlparser.CartPath{Kind: urlparser.KindItem, CartID: 12, ItemID: 4}},
+		{name: "Encoded slash in cart id", path: "/carts/1%2F2/items", expected: urlparser.CartPath{Kind: urlparser.KindItems}, expectedErr: urlparser.ErrInvalidCartID},
+		{name: "Encoded slash in item id", path: "/carts/1/items/2%2Fmove", expected: urlparser.CartPath{Kind: urlparser.KindItem, CartID: 1}, expectedErr: urlparser.ErrInvalidItemID},
+		{name: "Plus sign", path: "/carts/+1", expected: urlparser.CartPath{Kind: urlparser.KindCart}, expectedErr: urlparser.ErrInvalidCartID},
+		{name: "Bad escape", path: "/carts/%zz", expectedErr: urlparser.ErrBadEscape},
+		{name: "Empty id", path: "/carts//items", expectedErr: urlparser.ErrEmptySegment},
+		{name: "Trailing slash", path: "/carts/1/items/", expectedErr: urlparser.ErrEmptySegment},
+		{name: "Unknown sub-resource", path: "/carts/1/unknown", expectedErr: urlparser.ErrUnknownRoute},
+		{name: "Collection", path: "/carts", expectedErr: urlparser.ErrNotCartPath},
+		{name: "Other resource", path: "/admin/db/stats", expectedErr: urlparser.ErrNotCartPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := urlparser.ParseCartPath(tt.path)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, parsed)
+		})
+	}
+}